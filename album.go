@@ -0,0 +1,28 @@
+package benten
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// discSuffixPattern matches the disc numbers some rips append to album names,
+// such as " (Disc 2)", " [CD1]" and ", Disc 1 of 2".
+var discSuffixPattern = regexp.MustCompile(`(?i)[\s,:-]*[\(\[]?\s*\b(disc|disk|cd)\s*\d+(\s*of\s*\d+)?\s*[\)\]]?\s*$`)
+
+// SetAlbumKey sets `m.AlbumKey`, with the canonical name of the artist in
+// `aliases` so that the albums of aliased artists are grouped together.
+// Writers of Metadata call it after changing the album, the artists or the
+// year, and before writing with the aliases they have; nil uses the tags as
+// they are.
+func (m *Metadata) SetAlbumKey(aliases Aliases) {
+	album := Normalize(discSuffixPattern.ReplaceAllString(m.Album, ""))
+	if album == "" {
+		m.AlbumKey = ""
+		return
+	}
+	artist := aliases.AlbumArtist(m)
+	if artist == "" {
+		artist = aliases.Canonical(m.Artist)
+	}
+	m.AlbumKey = album + "\n" + Normalize(artist) + "\n" + strconv.Itoa(m.Year)
+}
@@ -0,0 +1,58 @@
+package benten
+
+import "testing"
+
+func TestSetAlbumKey(t *testing.T) {
+	disc1 := Metadata{Album: "The Wall (Disc 1)", Artist: "Pink Floyd", Year: 1979}
+	disc2 := Metadata{Album: "The Wall [CD2]", Artist: "Pink Floyd", AlbumArtist: "pink floyd", Year: 1979}
+	reissue := Metadata{Album: "The Wall", Artist: "Pink Floyd", Year: 2011}
+	for _, m := range []*Metadata{&disc1, &disc2, &reissue} {
+		m.SetAlbumKey(nil)
+	}
+	if disc1.AlbumKey != disc2.AlbumKey {
+		t.Errorf("the discs are split: %q, %q", disc1.AlbumKey, disc2.AlbumKey)
+	}
+	if disc1.AlbumKey == reissue.AlbumKey {
+		t.Errorf("the reissue is grouped with the original: %q", reissue.AlbumKey)
+	}
+
+	a := Metadata{Album: "Hits, Disc 1 of 2", Artist: "A", Compilation: true}
+	b := Metadata{Album: "Hits, Disc 2 of 2", Artist: "B", Compilation: true}
+	a.SetAlbumKey(nil)
+	b.SetAlbumKey(nil)
+	if a.AlbumKey != b.AlbumKey {
+		t.Errorf("the compilation is split: %q, %q", a.AlbumKey, b.AlbumKey)
+	}
+
+	unknown := Metadata{Artist: "A"}
+	unknown.SetAlbumKey(nil)
+	if unknown.AlbumKey != "" {
+		t.Errorf("AlbumKey = %q for an unknown album", unknown.AlbumKey)
+	}
+}
+
+func TestSetAlbumKeyWithAliases(t *testing.T) {
+	aliases := NewAliases([]*ArtistAlias{NewArtistAlias("Prince", []string{"The Artist Formerly Known As Prince"})})
+	disc1 := Metadata{Album: "The Hits (Disc 1)", Artist: "Prince", AlbumArtist: "Prince", Year: 1993}
+	disc2 := Metadata{Album: "The Hits (Disc 2)", Artist: "Prince", AlbumArtist: "The Artist Formerly Known As Prince", Year: 1993}
+	disc1.SetAlbumKey(aliases)
+	disc2.SetAlbumKey(aliases)
+	if disc1.AlbumKey != disc2.AlbumKey {
+		t.Errorf("the albums of the aliases are split: %q, %q", disc1.AlbumKey, disc2.AlbumKey)
+	}
+	disc2.SetAlbumKey(nil)
+	if disc1.AlbumKey == disc2.AlbumKey {
+		t.Errorf("the albums are grouped without the aliases: %q", disc2.AlbumKey)
+	}
+
+	// Browsing by the album artist finds both, in the backend and in Matches.
+	if !(Filter{AlbumArtist: "prince"}).Matches(&disc2, aliases) {
+		t.Errorf("AlbumArtist doesn't match the alias")
+	}
+	if (Filter{AlbumArtist: "prince"}).Matches(&disc2, nil) {
+		t.Errorf("AlbumArtist matches without the aliases")
+	}
+	if a, b := NewPieceIndex(&disc1, nil, aliases), NewPieceIndex(&disc2, nil, aliases); a.AlbumArtist != b.AlbumArtist {
+		t.Errorf("AlbumArtist = %q, %q", a.AlbumArtist, b.AlbumArtist)
+	}
+}
@@ -0,0 +1,100 @@
+package benten
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ArtistAlias declares that some artist names refer to the same artist, e.g.
+// "Prince" and "The Artist Formerly Known As Prince". It is keyed by
+// ArtistAliasKey(Name).
+type ArtistAlias struct {
+	// Name is the canonical name.
+	Name string
+	// Aliases are the other names.
+	Aliases []string
+	// Normalized are all the names normalized with Normalize.
+	Normalized []string
+}
+
+// NewArtistAlias creates an ArtistAlias.
+func NewArtistAlias(name string, aliases []string) *ArtistAlias {
+	alias := &ArtistAlias{Name: name, Aliases: aliases, Normalized: []string{Normalize(name)}}
+	for _, a := range aliases {
+		alias.Normalized = append(alias.Normalized, Normalize(a))
+	}
+	return alias
+}
+
+// Names returns the canonical name and the aliases.
+func (a *ArtistAlias) Names() []string {
+	return append([]string{a.Name}, a.Aliases...)
+}
+
+// ArtistAliasKey returns the key of the ArtistAlias whose canonical name is `name`.
+func ArtistAliasKey(name string) *datastore.Key {
+	return datastore.NameKey(ArtistAliasKind, Normalize(name), nil)
+}
+
+// Aliases maps normalized artist names to the ArtistAlias they belong to.
+// The nil Aliases has no aliases.
+type Aliases map[string]*ArtistAlias
+
+// NewAliases creates Aliases from ArtistAlias entities.
+func NewAliases(entities []*ArtistAlias) Aliases {
+	aliases := make(Aliases)
+	for _, entity := range entities {
+		for _, name := range entity.Normalized {
+			aliases[name] = entity
+		}
+	}
+	return aliases
+}
+
+// LoadAliases loads all the ArtistAlias entities.
+func LoadAliases(ctx context.Context, client *datastore.Client) (Aliases, error) {
+	var entities []*ArtistAlias
+	_, err := client.GetAll(ctx, datastore.NewQuery(ArtistAliasKind), &entities)
+	if err != nil {
+		return nil, err
+	}
+	return NewAliases(entities), nil
+}
+
+// Canonical returns the canonical name of the artist `name`, which is `name`
+// itself unless it has aliases.
+func (a Aliases) Canonical(name string) string {
+	if alias, ok := a[Normalize(name)]; ok {
+		return alias.Name
+	}
+	return name
+}
+
+// AlbumArtist returns the canonical name of m.GroupAlbumArtist(), which
+// browsing groups and filters the albums of `m` by.
+func (a Aliases) AlbumArtist(m *Metadata) string {
+	return a.Canonical(m.GroupAlbumArtist())
+}
+
+// Equivalents returns the names equivalent to `names`, excluding `names`.
+func (a Aliases) Equivalents(names ...string) []string {
+	given := make(map[string]struct{})
+	for _, name := range names {
+		given[Normalize(name)] = struct{}{}
+	}
+	var equivalents []string
+	for _, name := range names {
+		alias, ok := a[Normalize(name)]
+		if !ok {
+			continue
+		}
+		for _, n := range alias.Names() {
+			if _, ok := given[Normalize(n)]; !ok {
+				given[Normalize(n)] = struct{}{}
+				equivalents = append(equivalents, n)
+			}
+		}
+	}
+	return equivalents
+}
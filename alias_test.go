@@ -0,0 +1,26 @@
+package benten
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	aliases := NewAliases([]*ArtistAlias{NewArtistAlias("Prince", []string{"The Artist Formerly Known As Prince"})})
+
+	if got := aliases.Canonical("the artist formerly known as prince"); got != "Prince" {
+		t.Errorf("Canonical = %q, want %q", got, "Prince")
+	}
+	if got := aliases.Canonical("Madonna"); got != "Madonna" {
+		t.Errorf("Canonical = %q, want %q", got, "Madonna")
+	}
+	if got, want := aliases.Equivalents("PRINCE"), []string{"The Artist Formerly Known As Prince"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Equivalents = %v, want %v", got, want)
+	}
+	if got := aliases.Equivalents("Madonna"); len(got) != 0 {
+		t.Errorf("Equivalents = %v, want none", got)
+	}
+	if got := Aliases(nil).Equivalents("Prince"); len(got) != 0 {
+		t.Errorf("Equivalents = %v, want none", got)
+	}
+}
@@ -0,0 +1,42 @@
+package benten
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ArtistArtRetry is how long an artist without a photo is left until the
+// providers are asked again.
+var ArtistArtRetry = 30 * 24 * time.Hour
+
+// ArtistArt records the photo of an artist fetched from an external provider.
+// It is keyed by the normalized name of the artist; see ArtistArtKey.
+type ArtistArt struct {
+	// Name is the name of the artist as found in the pieces.
+	Name string
+	// Picture is the name of the object in AlbumPictureBucket, or the empty
+	// string if no provider had a photo.
+	Picture string
+	// Source is the provider the photo came from, such as "lastfm".
+	Source string
+	// Fetched is when the providers were asked.
+	Fetched time.Time
+}
+
+// ArtistArtKey returns the key of the ArtistArt of `name`, so that names
+// differing only in case or diacritics share a photo.
+func ArtistArtKey(name string) *datastore.Key {
+	return datastore.NameKey(ArtistArtKind, Normalize(name), nil)
+}
+
+// ArtistArtObject returns the name of the object of the photo of `name` in
+// AlbumPictureBucket.
+func ArtistArtObject(name string) string {
+	return "artists/" + Normalize(name)
+}
+
+// IsStale returns true if the providers should be asked again.
+func (a *ArtistArt) IsStale(now time.Time) bool {
+	return a.Picture == "" && now.Sub(a.Fetched) > ArtistArtRetry
+}
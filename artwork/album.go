@@ -0,0 +1,138 @@
+package artwork
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// AlbumProvider finds pictures of albums.
+type AlbumProvider interface {
+	// Name returns the name recorded as the source of the pictures.
+	Name() string
+	// FetchAlbum returns the front cover of the release `releaseID` (a
+	// MusicBrainz release ID), or of the album `album` by `artist` if
+	// releaseID is empty, or nil if the provider has none.
+	FetchAlbum(ctx context.Context, releaseID, album, artist string) (*Image, error)
+}
+
+// CoverArtArchive finds album pictures in the Cover Art Archive, which knows
+// albums by their MusicBrainz release IDs.
+type CoverArtArchive struct {
+	// BaseURL defaults to "https://coverartarchive.org/".
+	BaseURL string
+	// MusicBrainzURL defaults to "https://musicbrainz.org/ws/2/".
+	MusicBrainzURL string
+	Client         *http.Client
+}
+
+// Name implements AlbumProvider.
+func (p CoverArtArchive) Name() string { return "coverartarchive" }
+
+// FetchAlbum implements AlbumProvider.
+func (p CoverArtArchive) FetchAlbum(ctx context.Context, releaseID, album, artist string) (*Image, error) {
+	if releaseID == "" {
+		var err error
+		releaseID, err = musicBrainzReleaseID(ctx, p.Client, p.MusicBrainzURL, album, artist)
+		if releaseID == "" || err != nil {
+			return nil, err
+		}
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = "https://coverartarchive.org/"
+	}
+	// The 500px thumbnail, as the originals may be huge scans.
+	image, err := fetchImage(ctx, p.Client, base+"release/"+url.PathEscape(releaseID)+"/front-500")
+	if image != nil {
+		image.Source = p.Name()
+	}
+	return image, err
+}
+
+// musicBrainzReleaseID returns the MusicBrainz ID of the release `album` by
+// `artist`, or the empty string if no release matches well.
+func musicBrainzReleaseID(ctx context.Context, client *http.Client, base, album, artist string) (string, error) {
+	if album == "" {
+		return "", nil
+	}
+	if base == "" {
+		base = "https://musicbrainz.org/ws/2/"
+	}
+	query := `release:"` + escapeLucene(album) + `"`
+	if artist != "" {
+		query += ` AND artist:"` + escapeLucene(artist) + `"`
+	}
+	q := url.Values{"query": {query}, "fmt": {"json"}, "limit": {"1"}}
+	var response struct {
+		Releases []struct {
+			ID    string `json:"id"`
+			Score int    `json:"score"`
+		} `json:"releases"`
+	}
+	if err := waitMusicBrainz(ctx); err != nil {
+		return "", err
+	}
+	if ok, err := getJSON(ctx, client, base+"release/?"+q.Encode(), &response); !ok || err != nil {
+		return "", err
+	}
+	if len(response.Releases) == 0 || response.Releases[0].Score < minMusicBrainzScore {
+		return "", nil
+	}
+	return response.Releases[0].ID, nil
+}
+
+// escapeLucene escapes `s` for a quoted term of a MusicBrainz query.
+func escapeLucene(s string) string {
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+// MusicBrainzReleaseID returns the MusicBrainz release ID tagged by MusicBrainz
+// Picard and similar taggers, or the empty string.
+func MusicBrainzReleaseID(tags tag.Metadata) string {
+	for name, value := range tags.Raw() {
+		switch v := value.(type) {
+		case *tag.Comm:
+			// ID3 keeps it in a TXXX frame.
+			if strings.HasPrefix(name, "TXXX") && strings.EqualFold(v.Description, "MusicBrainz Album Id") {
+				return strings.TrimSpace(v.Text)
+			}
+		case string:
+			// Vorbis comments and MP4 freeform atoms.
+			if strings.EqualFold(name, "musicbrainz_albumid") || strings.HasSuffix(name, "MusicBrainz Album Id") {
+				return strings.TrimSpace(v)
+			}
+		}
+	}
+	return ""
+}
+
+// musicBrainzInterval is the interval between MusicBrainz requests, which
+// are limited to one per second per client.
+const musicBrainzInterval = time.Second
+
+var musicBrainzThrottle struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// waitMusicBrainz waits until the next MusicBrainz request is allowed.
+func waitMusicBrainz(ctx context.Context) error {
+	musicBrainzThrottle.mu.Lock()
+	defer musicBrainzThrottle.mu.Unlock()
+	wait := musicBrainzThrottle.last.Add(musicBrainzInterval).Sub(time.Now())
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	musicBrainzThrottle.last = time.Now()
+	return nil
+}
@@ -0,0 +1,166 @@
+package artwork
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LastFM finds artist photos with the artist.getInfo method of the Last.fm API.
+type LastFM struct {
+	APIKey string
+	// BaseURL defaults to "https://ws.audioscrobbler.com/2.0/".
+	BaseURL string
+	Client  *http.Client
+}
+
+// lastFMPlaceholder is in the URL of the image Last.fm returns for every
+// artist since it stopped serving artist photos through the API.
+const lastFMPlaceholder = "2a96cbd8b46e442fc41c2b86b821562f"
+
+// Name implements ArtistProvider.
+func (p LastFM) Name() string { return "lastfm" }
+
+// FetchArtist implements ArtistProvider.
+func (p LastFM) FetchArtist(ctx context.Context, name string) (*Image, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://ws.audioscrobbler.com/2.0/"
+	}
+	q := url.Values{"method": {"artist.getinfo"}, "artist": {name}, "api_key": {p.APIKey}, "format": {"json"}}
+	var response struct {
+		Artist struct {
+			Image []struct {
+				URL  string `json:"#text"`
+				Size string `json:"size"`
+			} `json:"image"`
+		} `json:"artist"`
+	}
+	if ok, err := getJSON(ctx, p.Client, base+"?"+q.Encode(), &response); !ok || err != nil {
+		return nil, err
+	}
+	// The images are listed from the smallest.
+	images := response.Artist.Image
+	for i := len(images) - 1; i >= 0; i-- {
+		if images[i].URL != "" && !strings.Contains(images[i].URL, lastFMPlaceholder) {
+			return fetchImage(ctx, p.Client, images[i].URL)
+		}
+	}
+	return nil, nil
+}
+
+// FanartTV finds artist photos on fanart.tv, which knows artists by their
+// MusicBrainz IDs.
+type FanartTV struct {
+	APIKey string
+	// BaseURL defaults to "https://webservice.fanart.tv/v3/music/".
+	BaseURL string
+	// MusicBrainzURL defaults to "https://musicbrainz.org/ws/2/".
+	MusicBrainzURL string
+	Client         *http.Client
+}
+
+// The minimum score of a MusicBrainz search result taken as the artist.
+const minMusicBrainzScore = 90
+
+// Name implements ArtistProvider.
+func (p FanartTV) Name() string { return "fanart" }
+
+// musicBrainzArtistID returns the MusicBrainz ID of the artist `name`, or the
+// empty string if no artist matches well.
+func musicBrainzArtistID(ctx context.Context, client *http.Client, base, name string) (string, error) {
+	if base == "" {
+		base = "https://musicbrainz.org/ws/2/"
+	}
+	q := url.Values{"query": {`artist:"` + escapeLucene(name) + `"`}, "fmt": {"json"}, "limit": {"1"}}
+	var response struct {
+		Artists []struct {
+			ID    string `json:"id"`
+			Score int    `json:"score"`
+		} `json:"artists"`
+	}
+	if err := waitMusicBrainz(ctx); err != nil {
+		return "", err
+	}
+	if ok, err := getJSON(ctx, client, base+"artist/?"+q.Encode(), &response); !ok || err != nil {
+		return "", err
+	}
+	if len(response.Artists) == 0 || response.Artists[0].Score < minMusicBrainzScore {
+		return "", nil
+	}
+	return response.Artists[0].ID, nil
+}
+
+// FetchArtist implements ArtistProvider.
+func (p FanartTV) FetchArtist(ctx context.Context, name string) (*Image, error) {
+	id, err := musicBrainzArtistID(ctx, p.Client, p.MusicBrainzURL, name)
+	if id == "" || err != nil {
+		return nil, err
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = "https://webservice.fanart.tv/v3/music/"
+	}
+	var response struct {
+		ArtistThumbs []struct {
+			URL string `json:"url"`
+		} `json:"artistthumb"`
+	}
+	if ok, err := getJSON(ctx, p.Client, base+url.PathEscape(id)+"?api_key="+url.QueryEscape(p.APIKey), &response); !ok || err != nil {
+		return nil, err
+	}
+	if len(response.ArtistThumbs) == 0 {
+		return nil, nil
+	}
+	return fetchImage(ctx, p.Client, response.ArtistThumbs[0].URL)
+}
+
+// Wikipedia takes the lead image of the English Wikipedia article titled by
+// the artist name, if the article looks like one about a musician.
+type Wikipedia struct {
+	// BaseURL defaults to "https://en.wikipedia.org/api/rest_v1/".
+	BaseURL string
+	Client  *http.Client
+}
+
+// musicWords are the words in the descriptions of articles about musicians.
+var musicWords = []string{"band", "singer", "musician", "rapper", "composer", "songwriter", "group", "duo", "dj", "producer", "guitarist", "pianist", "orchestra", "idol"}
+
+// Name implements ArtistProvider.
+func (p Wikipedia) Name() string { return "wikipedia" }
+
+// FetchArtist implements ArtistProvider.
+func (p Wikipedia) FetchArtist(ctx context.Context, name string) (*Image, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://en.wikipedia.org/api/rest_v1/"
+	}
+	var response struct {
+		Type          string `json:"type"`
+		Description   string `json:"description"`
+		OriginalImage struct {
+			Source string `json:"source"`
+		} `json:"originalimage"`
+	}
+	title := url.PathEscape(strings.Replace(name, " ", "_", -1))
+	if ok, err := getJSON(ctx, p.Client, base+"page/summary/"+title, &response); !ok || err != nil {
+		return nil, err
+	}
+	if response.Type != "standard" || response.OriginalImage.Source == "" || !isAboutMusic(response.Description) {
+		return nil, nil
+	}
+	return fetchImage(ctx, p.Client, response.OriginalImage.Source)
+}
+
+func isAboutMusic(description string) bool {
+	for _, word := range strings.Fields(strings.ToLower(description)) {
+		word = strings.Trim(word, ",.;()")
+		for _, musicWord := range musicWords {
+			if word == musicWord {
+				return true
+			}
+		}
+	}
+	return false
+}
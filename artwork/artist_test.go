@@ -0,0 +1,118 @@
+package artwork
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// png is the start of a PNG file, enough for http.DetectContentType.
+var png = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+func TestLastFM(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/photo.png":
+			w.Write(png)
+		case r.URL.Query().Get("artist") == "Bach":
+			fmt.Fprintf(w, `{"artist":{"image":[{"#text":"%s/small.png","size":"small"},{"#text":"%s/photo.png","size":"mega"}]}}`, server.URL, server.URL)
+		default:
+			fmt.Fprintf(w, `{"artist":{"image":[{"#text":"%s/%s.png","size":"mega"}]}}`, server.URL, lastFMPlaceholder)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := LastFM{APIKey: "key", BaseURL: server.URL + "/"}
+	image, err := FetchArtist(ctx, []ArtistProvider{provider}, "Bach")
+	if err != nil {
+		t.Fatalf("FetchArtist: %v", err)
+	}
+	if image == nil || image.MIMEType != "image/png" || image.Source != "lastfm" || string(image.Data) != string(png) {
+		t.Errorf("image = %+v", image)
+	}
+
+	image, err = provider.FetchArtist(ctx, "Nobody")
+	if image != nil || err != nil {
+		t.Errorf("FetchArtist(Nobody) = %+v, %v; want the placeholder to be skipped", image, err)
+	}
+}
+
+func TestWikipedia(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Write(png)
+		case "/page/summary/Johann_Sebastian_Bach":
+			fmt.Fprintf(w, `{"type":"standard","description":"German composer (1685-1750)","originalimage":{"source":"%s/photo.png"}}`, server.URL)
+		case "/page/summary/Mercury":
+			fmt.Fprintf(w, `{"type":"standard","description":"Planet in the Solar System","originalimage":{"source":"%s/photo.png"}}`, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := Wikipedia{BaseURL: server.URL + "/"}
+	for _, c := range []struct {
+		name  string
+		found bool
+	}{{"Johann Sebastian Bach", true}, {"Mercury", false}, {"Nobody", false}} {
+		image, err := provider.FetchArtist(ctx, c.name)
+		if err != nil {
+			t.Errorf("FetchArtist(%s): %v", c.name, err)
+		} else if (image != nil) != c.found {
+			t.Errorf("FetchArtist(%s) = %+v, want found = %v", c.name, image, c.found)
+		}
+	}
+}
+
+func TestFetchArtistFallsBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", 503)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	providers := []ArtistProvider{LastFM{BaseURL: server.URL + "/"}, Wikipedia{BaseURL: server.URL + "/"}}
+	image, err := FetchArtist(ctx, providers, "Bach")
+	if image != nil || err == nil {
+		t.Errorf("FetchArtist = %+v, %v; want an error", image, err)
+	}
+}
+
+func TestCoverArtArchive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release/":
+			if r.URL.Query().Get("query") != `release:"Abbey Road" AND artist:"The Beatles"` {
+				t.Errorf("query = %q", r.URL.Query().Get("query"))
+			}
+			fmt.Fprint(w, `{"releases":[{"id":"abbey","score":100}]}`)
+		case "/release/abbey/front-500":
+			w.Write(png)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := CoverArtArchive{BaseURL: server.URL + "/", MusicBrainzURL: server.URL + "/"}
+	image, err := provider.FetchAlbum(ctx, "", "Abbey Road", "The Beatles")
+	if err != nil {
+		t.Fatalf("FetchAlbum: %v", err)
+	}
+	if image == nil || image.Source != "coverartarchive" {
+		t.Errorf("image = %+v", image)
+	}
+	image, err = provider.FetchAlbum(ctx, "unknown", "", "")
+	if image != nil || err != nil {
+		t.Errorf("FetchAlbum(unknown) = %+v, %v", image, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package artwork fetches artist photos and album pictures from online
+// providers, for the pieces whose files have none.
+package artwork
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// The maximum size of a fetched image.
+const maxImageSize = 10 << 20
+
+// userAgent identifies benten to the providers, as MusicBrainz requires.
+const userAgent = "benten/1.0 (https://github.com/yutakahirano/benten)"
+
+// Image is an image fetched from a provider.
+type Image struct {
+	Data     []byte
+	MIMEType string
+	// Source is the name of the provider, such as "lastfm".
+	Source string
+}
+
+// ArtistProvider finds photos of artists.
+type ArtistProvider interface {
+	// Name returns the name recorded as the source of the photos.
+	Name() string
+	// FetchArtist returns the photo of the artist `name`, or nil if the
+	// provider has none.
+	FetchArtist(ctx context.Context, name string) (*Image, error)
+}
+
+// FetchArtist asks `providers` in order for the photo of `name`, and returns
+// the first found or nil. A provider failing doesn't stop the others, but the
+// error is returned if no photo is found, so that the caller can retry.
+func FetchArtist(ctx context.Context, providers []ArtistProvider, name string) (*Image, error) {
+	var lastErr error
+	for _, provider := range providers {
+		image, err := provider.FetchArtist(ctx, name)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", provider.Name(), err)
+			continue
+		}
+		if image != nil {
+			image.Source = provider.Name()
+			return image, nil
+		}
+	}
+	return nil, lastErr
+}
+
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+// getJSON gets `url` and decodes the JSON response into `v`. It returns false
+// for 404.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	res, err := httpClient(client).Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return false, nil
+	}
+	if res.StatusCode/100 != 2 {
+		return false, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// fetchImage downloads the image at `url`.
+func fetchImage(ctx context.Context, client *http.Client, url string) (*Image, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := httpClient(client).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxImageSize)
+	}
+	mimeType := res.Header.Get("Content-Type")
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("%s is not an image: %s", url, mimeType)
+	}
+	return &Image{Data: data, MIMEType: mimeType}, nil
+}
@@ -0,0 +1,101 @@
+package benten
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Actions recorded in AuditLog.
+const (
+	AuditPieceAdded         = "piece.added"
+	AuditPieceUpdated       = "piece.updated"
+	AuditPieceEdited        = "piece.edited"
+	AuditPieceTrashed       = "piece.trashed"
+	AuditPieceRestored      = "piece.restored"
+	AuditPieceReviewed      = "piece.reviewed"
+	AuditPlaylistImported   = "playlist.imported"
+	AuditAliasPut           = "alias.put"
+	AuditAliasDeleted       = "alias.deleted"
+	AuditJobStarted         = "job.started"
+	AuditJobCanceled        = "job.canceled"
+	AuditPodcastAdded       = "podcast.added"
+	AuditPodcastDeleted     = "podcast.deleted"
+	AuditProfilePut         = "profile.put"
+	AuditProfileDeleted     = "profile.deleted"
+	AuditTagRulesPut        = "tag-rules.put"
+	AuditMaintenancePut     = "maintenance.put"
+	AuditFeatureFlagPut     = "feature-flag.put"
+	AuditFeatureFlagDeleted = "feature-flag.deleted"
+)
+
+// AuditLog records a mutating operation.
+type AuditLog struct {
+	Time time.Time
+	// Actor is who did the operation, such as "admin" or "syncer".
+	Actor string
+	// Action is one of the Audit* constants.
+	Action string
+	// Target is what the operation changed, such as an encoded piece key or
+	// a playlist name.
+	Target string
+	// Before and After summarize the target before and after the operation.
+	Before string `datastore:",noindex"`
+	After  string `datastore:",noindex"`
+	// RequestID is the ID of the HTTP request which did the operation, if any.
+	RequestID string
+}
+
+// RecordAudit stores `entry`, with its Time set to now if unset.
+func RecordAudit(ctx context.Context, client *datastore.Client, entry AuditLog) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	_, err := client.Put(ctx, datastore.IncompleteKey(AuditLogKind, nil), &entry)
+	return err
+}
+
+// Summary returns a short description of `m` for audit logs.
+func (m *Metadata) Summary() string {
+	return fmt.Sprintf("%s - %s (%s)", m.Artist, m.Title, m.Album)
+}
+
+// DiffMetadata summarizes the tag fields which differ between `before` and
+// `after`, as "Field=value" pairs for each of them.
+func DiffMetadata(before, after *Metadata) (string, string) {
+	type field struct {
+		name          string
+		before, after interface{}
+	}
+	fields := []field{
+		{"Title", before.Title, after.Title},
+		{"Album", before.Album, after.Album},
+		{"Artist", before.Artist, after.Artist},
+		{"AlbumArtist", before.AlbumArtist, after.AlbumArtist},
+		{"Compilation", before.Compilation, after.Compilation},
+		{"Composer", before.Composer, after.Composer},
+		{"Genre", before.Genre, after.Genre},
+		{"Year", before.Year, after.Year},
+		{"OriginalYear", before.OriginalYear, after.OriginalYear},
+		{"Track", before.Track, after.Track},
+		{"TotalTracks", before.TotalTracks, after.TotalTracks},
+		{"Disc", before.Disc, after.Disc},
+		{"TotalDisks", before.TotalDisks, after.TotalDisks},
+		{"Comment", before.Comment, after.Comment},
+		{"BPM", before.BPM, after.BPM},
+		{"Key", before.Key, after.Key},
+		{"Picture", before.Picture, after.Picture},
+		{"Path", before.Path, after.Path},
+	}
+	var b, a []string
+	for _, f := range fields {
+		if f.before != f.after {
+			b = append(b, fmt.Sprintf("%s=%v", f.name, f.before))
+			a = append(a, fmt.Sprintf("%s=%v", f.name, f.after))
+		}
+	}
+	return strings.Join(b, ", "), strings.Join(a, ", ")
+}
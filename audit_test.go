@@ -0,0 +1,10 @@
+package benten
+
+import "testing"
+
+func TestDiffMetadata(t *testing.T) {
+	before, after := DiffMetadata(&Metadata{Title: "Tme", Artist: "Pink Floyd", Year: 1972}, &Metadata{Title: "Time", Artist: "Pink Floyd", Year: 1973})
+	if before != "Title=Tme, Year=1972" || after != "Title=Time, Year=1973" {
+		t.Errorf("DiffMetadata = %q, %q", before, after)
+	}
+}
@@ -0,0 +1,55 @@
+package benten
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// BandwidthUsage is the number of bytes streamed to an identity in a day
+// (UTC), keyed by BandwidthKey.
+type BandwidthUsage struct {
+	// Identity is who the bytes were streamed to, such as "admin" or
+	// "device:pixel".
+	Identity string
+	// Day is such as "2020-06-01".
+	Day   string
+	Bytes int64
+}
+
+// BandwidthDay returns the day of BandwidthUsage `t` belongs to.
+func BandwidthDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// BandwidthKey returns the key of the BandwidthUsage of `identity` on `day`.
+func BandwidthKey(identity, day string) *datastore.Key {
+	return datastore.NameKey(BandwidthKind, day+"/"+identity, nil)
+}
+
+// LoadBandwidth returns the bytes streamed to `identity` on `day`.
+func LoadBandwidth(ctx context.Context, client *datastore.Client, identity, day string) (int64, error) {
+	var usage BandwidthUsage
+	err := client.Get(ctx, BandwidthKey(identity, day), &usage)
+	if err == datastore.ErrNoSuchEntity {
+		return 0, nil
+	}
+	return usage.Bytes, err
+}
+
+// AddBandwidth adds `bytes` streamed to `identity` at `t`.
+func AddBandwidth(ctx context.Context, client *datastore.Client, identity string, t time.Time, bytes int64) error {
+	day := BandwidthDay(t)
+	key := BandwidthKey(identity, day)
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		usage := BandwidthUsage{Identity: identity, Day: day}
+		if err := tx.Get(key, &usage); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		usage.Bytes += bytes
+		_, err := tx.Put(key, &usage)
+		return err
+	})
+	return err
+}
@@ -0,0 +1,30 @@
+package benten_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestBandwidth(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	now := time.Date(2020, 6, 1, 23, 0, 0, 0, time.UTC)
+	for _, bytes := range []int64{100, 50} {
+		if err := benten.AddBandwidth(ctx, client, "admin", now, bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := benten.AddBandwidth(ctx, client, "admin", now.Add(2*time.Hour), 10); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := benten.LoadBandwidth(ctx, client, "admin", "2020-06-01"); used != 150 || err != nil {
+		t.Errorf("LoadBandwidth = %d, %v, want 150", used, err)
+	}
+	if used, err := benten.LoadBandwidth(ctx, client, "ip:192.0.2.1", "2020-06-01"); used != 0 || err != nil {
+		t.Errorf("LoadBandwidth = %d, %v for another identity", used, err)
+	}
+}
@@ -0,0 +1,19 @@
+package benten
+
+import (
+	"context"
+	"io"
+)
+
+// BlobStore stores objects by name, abstracting GCS so that objects can be
+// mirrored to other buckets or providers. See the blob package for the
+// implementations.
+type BlobStore interface {
+	// NewReader opens the object `name`.
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	// NewWriter creates or replaces the object `name`. The object is
+	// committed when the writer is closed without errors.
+	NewWriter(ctx context.Context, name string, contentType string) io.WriteCloser
+	// Delete deletes the object `name`.
+	Delete(ctx context.Context, name string) error
+}
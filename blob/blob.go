@@ -0,0 +1,109 @@
+// Package blob implements benten.BlobStore on GCS buckets and local directories.
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+)
+
+// GCS is a BlobStore backed by a GCS bucket.
+type GCS struct {
+	Bucket *storage.BucketHandle
+}
+
+// NewReader implements benten.BlobStore.
+func (g GCS) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return g.Bucket.Object(name).NewReader(ctx)
+}
+
+// NewWriter implements benten.BlobStore.
+func (g GCS) NewWriter(ctx context.Context, name string, contentType string) io.WriteCloser {
+	writer := g.Bucket.Object(name).NewWriter(ctx)
+	writer.ContentType = contentType
+	return writer
+}
+
+// Delete implements benten.BlobStore.
+func (g GCS) Delete(ctx context.Context, name string) error {
+	return g.Bucket.Object(name).Delete(ctx)
+}
+
+// Dir is a BlobStore storing objects as files under Root. Content types are
+// not kept.
+type Dir struct {
+	Root string
+}
+
+func (d Dir) path(name string) string {
+	return filepath.Join(d.Root, filepath.FromSlash(name))
+}
+
+// NewReader implements benten.BlobStore.
+func (d Dir) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+// dirWriter writes to a temporary file, which is renamed on Close so that
+// readers never see a partial object.
+type dirWriter struct {
+	file *os.File
+	path string
+	err  error
+}
+
+func (w *dirWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.file.Write(p)
+}
+
+func (w *dirWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// NewWriter implements benten.BlobStore.
+func (d Dir) NewWriter(ctx context.Context, name string, contentType string) io.WriteCloser {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &dirWriter{err: err}
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), ".blob-")
+	return &dirWriter{file: file, path: path, err: err}
+}
+
+// Delete implements benten.BlobStore.
+func (d Dir) Delete(ctx context.Context, name string) error {
+	return os.Remove(d.path(name))
+}
+
+// Open returns the BlobStore at `url`, which is either "gs://<bucket>" or
+// "file://<directory>".
+func Open(ctx context.Context, url string) (benten.BlobStore, error) {
+	switch {
+	case strings.HasPrefix(url, "gs://"):
+		client, err := storage.NewClient(ctx, benten.StorageOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		return GCS{Bucket: client.Bucket(strings.TrimPrefix(url, "gs://"))}, nil
+	case strings.HasPrefix(url, "file://"):
+		return Dir{Root: strings.TrimPrefix(url, "file://")}, nil
+	}
+	return nil, fmt.Errorf("unknown blob store: %s", url)
+}
@@ -0,0 +1,52 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func testStore(t *testing.T, store benten.BlobStore) {
+	ctx := context.Background()
+	writer := store.NewWriter(ctx, "a/b", "text/plain")
+	if _, err := io.WriteString(writer, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.NewReader(ctx, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("data = %q, %v", data, err)
+	}
+	if err := store.Delete(ctx, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.NewReader(ctx, "a/b"); err == nil {
+		t.Errorf("the object must be deleted")
+	}
+}
+
+func TestDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	testStore(t, Dir{Root: root})
+}
+
+func TestGCS(t *testing.T) {
+	client := testutil.Storage(t)
+	testStore(t, GCS{Bucket: client.Bucket(benten.PieceBucket)})
+}
@@ -0,0 +1,18 @@
+package benten
+
+import (
+	"context"
+	"time"
+)
+
+// SearchCache memoizes the keys of search results. See the cache package for
+// the implementations.
+type SearchCache interface {
+	// Get returns the keys cached for `query`, or false on a miss.
+	Get(ctx context.Context, query string) ([]string, bool, error)
+	// Set caches `keys` for `query` for `ttl`.
+	Set(ctx context.Context, query string, keys []string, ttl time.Duration) error
+	// Invalidate drops all the cached results. It is called when the library
+	// or the index changes.
+	Invalidate(ctx context.Context) error
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func testCache(t *testing.T, c benten.SearchCache) {
+	ctx := context.Background()
+	if _, ok, err := c.Get(ctx, "q"); ok || err != nil {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if err := c.Set(ctx, "q", []string{"a", "b"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	keys, ok, err := c.Get(ctx, "q")
+	if !ok || err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("Get = %v, %v, %v", keys, ok, err)
+	}
+	if err := c.Invalidate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := c.Get(ctx, "q"); ok || err != nil {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testCache(t, NewMemory(10))
+}
+
+// fakeRedis serves GET, SET and INCR on `listener`.
+func fakeRedis(listener net.Listener) {
+	values := make(map[string]string)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			var args []string
+			for i := 0; i < n; i++ {
+				reader.ReadString('\n')
+				arg, _ := reader.ReadString('\n')
+				args = append(args, strings.TrimSuffix(arg, "\r\n"))
+			}
+			switch args[0] {
+			case "GET":
+				if value, ok := values[args[1]]; ok {
+					conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+				} else {
+					conn.Write([]byte("$-1\r\n"))
+				}
+			case "SET":
+				values[args[1]] = args[2]
+				conn.Write([]byte("+OK\r\n"))
+			case "INCR":
+				values[args[1]] += "1"
+				conn.Write([]byte(":" + strconv.Itoa(len(values[args[1]])) + "\r\n"))
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestRedis(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go fakeRedis(listener)
+	testCache(t, NewRedis(listener.Addr().String()))
+}
@@ -0,0 +1,82 @@
+// Package cache implements benten.SearchCache in memory and on Redis.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+// Open returns the SearchCache for `url`, which is "memory" or
+// "redis://<host>:<port>".
+func Open(url string) (benten.SearchCache, error) {
+	switch {
+	case url == "memory":
+		return NewMemory(10 * 1000), nil
+	case strings.HasPrefix(url, "redis://"):
+		return NewRedis(strings.TrimSuffix(strings.TrimPrefix(url, "redis://"), "/")), nil
+	}
+	return nil, fmt.Errorf("unknown search cache: %s", url)
+}
+
+type memoryEntry struct {
+	keys    []string
+	expires time.Time
+}
+
+// Memory is a SearchCache in the process memory, for single-instance
+// deployments. It holds at most MaxEntries results.
+type Memory struct {
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemory creates a Memory holding at most `maxEntries` results.
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{MaxEntries: maxEntries, entries: make(map[string]memoryEntry)}
+}
+
+// Get implements benten.SearchCache.
+func (m *Memory) Get(ctx context.Context, query string) ([]string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[query]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false, nil
+	}
+	return entry.keys, true, nil
+}
+
+// Set implements benten.SearchCache. When full, expired entries are dropped,
+// and then everything if that is not enough.
+func (m *Memory) Set(ctx context.Context, query string, keys []string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) >= m.MaxEntries {
+		now := time.Now()
+		for q, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, q)
+			}
+		}
+		if len(m.entries) >= m.MaxEntries {
+			m.entries = make(map[string]memoryEntry)
+		}
+	}
+	m.entries[query] = memoryEntry{keys: keys, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Invalidate implements benten.SearchCache.
+func (m *Memory) Invalidate(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = make(map[string]memoryEntry)
+	return nil
+}
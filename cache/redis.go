@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generationKey holds a counter which is part of the keys of the cached
+// results, so that incrementing it invalidates all of them at once. The old
+// entries expire by themselves.
+const generationKey = "benten:search:generation"
+
+// errNil is returned by command for a nil reply.
+var errNil = errors.New("nil reply")
+
+// Redis is a SearchCache on Redis or Memorystore, shared by all the instances
+// and by the syncer. It speaks the Redis protocol directly and keeps a few
+// idle connections.
+type Redis struct {
+	Addr string
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedis creates a Redis cache for the server at `addr` ("host:port").
+func NewRedis(addr string) *Redis {
+	return &Redis{Addr: addr, idle: make(chan *redisConn, 4)}
+}
+
+func (r *Redis) connect(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// command sends a command and returns its reply, which is a string for
+// simple and bulk strings and an int64 for integers.
+func (r *Redis) command(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := r.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+	var request strings.Builder
+	fmt.Fprintf(&request, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&request, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(request.String())); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	reply, err := readReply(c.reader)
+	if err != nil && err != errNil {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	}
+	return nil, fmt.Errorf("unsupported reply: %q", line)
+}
+
+func (r *Redis) key(ctx context.Context, query string) (string, error) {
+	generation, err := r.command(ctx, "GET", generationKey)
+	if err == errNil {
+		generation = "0"
+	} else if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(query))
+	return fmt.Sprintf("benten:search:%v:%s", generation, hex.EncodeToString(sum[:])), nil
+}
+
+// Get implements benten.SearchCache.
+func (r *Redis) Get(ctx context.Context, query string) ([]string, bool, error) {
+	key, err := r.key(ctx, query)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := r.command(ctx, "GET", key)
+	if err == errNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var keys []string
+	if err := json.Unmarshal([]byte(value.(string)), &keys); err != nil {
+		return nil, false, err
+	}
+	return keys, true, nil
+}
+
+// Set implements benten.SearchCache.
+func (r *Redis) Set(ctx context.Context, query string, keys []string, ttl time.Duration) error {
+	key, err := r.key(ctx, query)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	_, err = r.command(ctx, "SET", key, string(value), "EX", strconv.FormatInt(seconds, 10))
+	return err
+}
+
+// Invalidate implements benten.SearchCache.
+func (r *Redis) Invalidate(ctx context.Context) error {
+	_, err := r.command(ctx, "INCR", generationKey)
+	return err
+}
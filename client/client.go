@@ -0,0 +1,468 @@
+// Package client is a client of the HTTP API of the benten server (cmd/gae).
+//
+//	c := client.New("https://benten.example.com", os.Getenv("BENTEN_TOKEN"))
+//	pieces, err := c.Search(ctx, "bohemian", nil)
+//
+// Requests are retried on network errors, 429 and 5xx responses. The
+// mutating ones carry an Idempotency-Key, so that the server applies them
+// once however many times they are sent.
+// Uploads are not part of the HTTP API: the syncer uploads files, and Exists
+// tells which files it can skip.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+// DefaultMaxRetries is the number of retries of a Client whose MaxRetries is
+// zero.
+const DefaultMaxRetries = 3
+
+// The delay before the first retry, doubled for each of the next ones.
+const retryBackoff = 500 * time.Millisecond
+
+// Client calls the API of a server. Its zero value is not usable; see New.
+type Client struct {
+	// BaseURL is the URL of the server, such as "https://benten.example.com".
+	BaseURL string
+	// Token is the admin token (ADMIN_TOKEN of the server), sent as a bearer
+	// token. The methods calling the admin API fail with 401 without it.
+	Token string
+	// HTTPClient makes the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// MaxRetries is the number of times a request is retried, or negative for
+	// none. Zero means DefaultMaxRetries.
+	MaxRetries int
+	// Session is a session token, which StreamURL carries for servers
+	// requiring authentication to stream (STREAM_AUTH); see NewSession.
+	Session string
+}
+
+// New returns a Client of the server at `baseURL`. `token` may be empty for
+// the methods which don't need the admin API.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token}
+}
+
+// Error is a response with an unexpected status.
+type Error struct {
+	StatusCode int
+	// Message is the body of the response, which the server writes as plain
+	// text.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound returns true if `err` is a 404 response.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == 404
+}
+
+// do sends a request to `path` with `query`, retrying as described in the
+// package comment, and returns the response if its status is 2xx. `body` is
+// sent again on retries.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = DefaultMaxRetries
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	idempotencyKey := header.Get("Idempotency-Key")
+	if idempotencyKey == "" && method != "GET" && method != "HEAD" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		idempotencyKey = hex.EncodeToString(b)
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u, reader)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		res, err := httpClient.Do(req.WithContext(ctx))
+		if err == nil && res.StatusCode/100 == 2 {
+			return res, nil
+		}
+		wait := time.Duration(-1)
+		retryable := err != nil && ctx.Err() == nil
+		if err == nil {
+			message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+			err = &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+			retryable = res.StatusCode == 429 || res.StatusCode/100 == 5
+			if seconds, parseErr := strconv.Atoi(res.Header.Get("Retry-After")); parseErr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		if !retryable || retries < 0 || attempt >= retries {
+			return nil, err
+		}
+		if wait < 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// getJSON gets `path` and decodes the JSON response into `value`.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, value interface{}) error {
+	res, err := c.do(ctx, "GET", path, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(value)
+}
+
+// SearchOptions restrict and order searches. Zero fields don't.
+type SearchOptions struct {
+	benten.Filter
+	// Limit is the maximum number of results; zero leaves it to the server.
+	Limit int
+	// Sort is "year", "originalyear", "artist", "album" or "title", or empty
+	// for the order of relevance.
+	Sort string
+	// Locale is the BCP 47 tag the names are sorted in, such as "ja"; empty
+	// leaves it to the server.
+	Locale string
+	// Phonetic searches for artists by sound; see benten.PhoneticSearcher.
+	Phonetic bool
+}
+
+// Search returns the pieces matching `text`.
+func (c *Client) Search(ctx context.Context, text string, opts *SearchOptions) ([]benten.Metadata, error) {
+	q := url.Values{"search": {text}}
+	if opts != nil {
+		set := func(name, value string, ok bool) {
+			if ok {
+				q.Set(name, value)
+			}
+		}
+		set("genre", opts.Genre, opts.Genre != "")
+		set("albumartist", opts.AlbumArtist, opts.AlbumArtist != "")
+		set("year", strconv.Itoa(opts.Year), opts.Year != 0)
+		set("originalyear", strconv.Itoa(opts.OriginalYear), opts.OriginalYear != 0)
+		set("bpmmin", strconv.FormatFloat(opts.MinBPM, 'f', -1, 64), opts.MinBPM != 0)
+		set("bpmmax", strconv.FormatFloat(opts.MaxBPM, 'f', -1, 64), opts.MaxBPM != 0)
+		set("key", opts.Key, opts.Key != "")
+		set("filetype", opts.FileType, opts.FileType != "")
+		set("lossless", "true", opts.Lossless)
+		set("bitrate_min", strconv.Itoa(opts.MinBitrate), opts.MinBitrate != 0)
+		set("limit", strconv.Itoa(opts.Limit), opts.Limit != 0)
+		set("sort", opts.Sort, opts.Sort != "")
+		set("locale", opts.Locale, opts.Locale != "")
+		set("phonetic", "1", opts.Phonetic)
+	}
+	var pieces []benten.Metadata
+	if err := c.getJSON(ctx, "/api/list", q, &pieces); err != nil {
+		return nil, err
+	}
+	return pieces, nil
+}
+
+// Piece is a piece with its key, an encoded datastore key.
+type Piece struct {
+	Key      string
+	Metadata benten.Metadata
+}
+
+// All returns up to `limit` pieces (zero for the server's default) from
+// `cursor`, which is empty for the first page, and the cursor of the next
+// page, which is empty after the last page. See AllSince for incremental
+// syncs.
+func (c *Client) All(ctx context.Context, cursor string, limit int) ([]Piece, string, error) {
+	return c.all(ctx, url.Values{}, cursor, limit)
+}
+
+// AllSince is All for the pieces updated after `since`, including the
+// trashed ones.
+func (c *Client) AllSince(ctx context.Context, since time.Time, cursor string, limit int) ([]Piece, string, error) {
+	return c.all(ctx, url.Values{"since": {since.UTC().Format(time.RFC3339)}}, cursor, limit)
+}
+
+// Album returns the pieces whose Metadata.AlbumKey is `albumKey`.
+func (c *Client) Album(ctx context.Context, albumKey string) ([]Piece, error) {
+	var pieces []Piece
+	cursor := ""
+	for {
+		page, next, err := c.all(ctx, url.Values{"album": {albumKey}}, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, page...)
+		if next == "" {
+			return pieces, nil
+		}
+		cursor = next
+	}
+}
+
+func (c *Client) all(ctx context.Context, q url.Values, cursor string, limit int) ([]Piece, string, error) {
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if limit != 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var response struct {
+		Pieces []Piece
+		Cursor string
+	}
+	if err := c.getJSON(ctx, "/api/all", q, &response); err != nil {
+		return nil, "", err
+	}
+	return response.Pieces, response.Cursor, nil
+}
+
+// Playlist returns the pieces of the playlist `name` in order.
+func (c *Client) Playlist(ctx context.Context, name string) ([]Piece, error) {
+	var response struct {
+		Pieces []Piece
+	}
+	if err := c.getJSON(ctx, "/api/playlist", url.Values{"name": {name}}, &response); err != nil {
+		return nil, err
+	}
+	return response.Pieces, nil
+}
+
+// GetPiece returns the metadata of the piece `key` and its ETag, which
+// editing it requires. It needs the admin token.
+func (c *Client) GetPiece(ctx context.Context, key string) (*benten.Metadata, string, error) {
+	res, err := c.do(ctx, "GET", "/api/admin/pieces", url.Values{"key": {key}}, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	var piece benten.Metadata
+	if err := json.NewDecoder(res.Body).Decode(&piece); err != nil {
+		return nil, "", err
+	}
+	return &piece, res.Header.Get("ETag"), nil
+}
+
+// StreamOptions select the part of a piece to stream.
+type StreamOptions struct {
+	// Start and End trim MP3 pieces; zero End is the end of the piece.
+	Start, End time.Duration
+	// Offset is the first byte to stream, for resuming. It is ignored with
+	// Start or End.
+	Offset int64
+	// Quality selects a rendition: benten.QualityLow, QualityHigh or
+	// QualityOriginal. Empty is the original, or what the profile of Device
+	// prefers.
+	Quality string
+	// Device is the ID of the device whose profile chooses the quality over
+	// Network (benten.NetworkWiFi or NetworkCellular); see PutProfile.
+	Device, Network string
+}
+
+// Stream returns the contents of the piece `key`, which the caller must close,
+// and its content type.
+func (c *Client) Stream(ctx context.Context, key string, opts *StreamOptions) (io.ReadCloser, string, error) {
+	q := url.Values{"key": {key}}
+	header := http.Header{}
+	if opts != nil {
+		for name, value := range map[string]string{"quality": opts.Quality, "device": opts.Device, "network": opts.Network} {
+			if value != "" {
+				q.Set(name, value)
+			}
+		}
+		if opts.Start != 0 || opts.End != 0 {
+			q.Set("start", strconv.FormatFloat(opts.Start.Seconds(), 'f', -1, 64))
+			if opts.End != 0 {
+				q.Set("end", strconv.FormatFloat(opts.End.Seconds(), 'f', -1, 64))
+			}
+		} else if opts.Offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", opts.Offset))
+		}
+	}
+	res, err := c.do(ctx, "GET", "/api/get", q, header, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
+
+// Picture returns the album picture `name` (see benten.Metadata.Picture),
+// which the caller must close, and its content type.
+func (c *Client) Picture(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	res, err := c.do(ctx, "GET", "/api/get", url.Values{"bucket": {benten.AlbumPictureBucket}, "name": {name}}, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Body, res.Header.Get("Content-Type"), nil
+}
+
+// StreamURL returns the URL of `piece`, for players which stream it
+// themselves. It carries Session rather than the token, which may be logged
+// with the URL.
+func (c *Client) StreamURL(piece *benten.Metadata) string {
+	q := url.Values{"bucket": {benten.PieceBucket}, "name": {piece.ObjectName()}}
+	if c.Session != "" {
+		q.Set("session", c.Session)
+	}
+	return c.BaseURL + "/api/get?" + q.Encode()
+}
+
+// NewSession issues a session token, with the admin token or by replacing
+// Session, and sets it as Session.
+func (c *Client) NewSession(ctx context.Context) (string, error) {
+	q := url.Values{}
+	if c.Session != "" {
+		q.Set("session", c.Session)
+	}
+	// Not retried, as the old session is revoked once a token is issued.
+	once := *c
+	once.MaxRetries = -1
+	res, err := once.do(ctx, "POST", "/api/session", q, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	token, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Session = strings.TrimSpace(string(token))
+	return c.Session, nil
+}
+
+// PutProfile stores the streaming preferences of `profile.Device`. It needs
+// the admin token.
+func (c *Client) PutProfile(ctx context.Context, profile *benten.ClientProfile) error {
+	body, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	res, err := c.do(ctx, "PUT", "/api/admin/profiles", nil, http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Exists returns the encoded keys of the pieces with each of `hashes` (see
+// benten.Metadata.Hash). Hashes not in the library are absent.
+func (c *Client) Exists(ctx context.Context, hashes []string) (map[string][]string, error) {
+	body, err := json.Marshal(struct{ Hashes []string }{hashes})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, "POST", "/api/exists", nil, http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	found := make(map[string][]string)
+	if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// CachedPiece is a piece kept offline, as it was when fetched.
+type CachedPiece struct {
+	Key     string
+	Hash    string
+	Updated time.Time
+}
+
+// ValidateResult is the result of Validate.
+type ValidateResult struct {
+	// Stale are the pieces changed since cached, with their current metadata.
+	Stale []Piece
+	// Deleted are the keys of the pieces removed from the library.
+	Deleted []string
+}
+
+// Validate tells which of `cached`, up to 1000 pieces, are stale or deleted.
+// The others are up to date.
+func (c *Client) Validate(ctx context.Context, cached []CachedPiece) (*ValidateResult, error) {
+	body, err := json.Marshal(struct{ Entries []CachedPiece }{cached})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, "POST", "/api/validate", nil, http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var result ValidateResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImportResult is the result of ImportPlaylist.
+type ImportResult struct {
+	// Imported is the number of pieces in the playlist.
+	Imported int
+	// Unresolved are the entries matching no piece.
+	Unresolved []benten.PlaylistEntry
+}
+
+// ImportPlaylist replaces the playlist `name` with the M3U, M3U8 or PLS file
+// read from `r`. It needs the admin token.
+func (c *Client) ImportPlaylist(ctx context.Context, name string, r io.Reader) (*ImportResult, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(ctx, "POST", "/api/playlists/import", url.Values{"name": {name}}, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var result ImportResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestSearch(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "busy", 503)
+			return
+		}
+		q := r.URL.Query()
+		if r.URL.Path != "/api/list" || q.Get("search") != "queen" || q.Get("year") != "1975" || q.Get("bpmmin") != "120.5" {
+			t.Errorf("request = %v", r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		json.NewEncoder(w).Encode([]benten.Metadata{{Title: "Bohemian Rhapsody"}})
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/", "secret")
+	pieces, err := c.Search(context.Background(), "queen", &SearchOptions{Filter: benten.Filter{Year: 1975, MinBPM: 120.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 1 || pieces[0].Title != "Bohemian Rhapsody" || attempts != 2 {
+		t.Errorf("pieces = %+v after %d attempts", pieces, attempts)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "Not found: abc", 404)
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "")
+	_, _, err := c.GetPiece(context.Background(), "abc")
+	if !IsNotFound(err) || attempts != 1 {
+		t.Errorf("err = %v after %d attempts", err, attempts)
+	}
+	if e, ok := err.(*Error); !ok || e.Message != "Not found: abc" {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("key") != "abc" || q.Get("start") != "90" || q.Get("end") != "" {
+			t.Errorf("request = %v", r.URL)
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("frames"))
+	}))
+	defer server.Close()
+
+	body, contentType, err := New(server.URL, "").Stream(context.Background(), "abc", &StreamOptions{Start: 90 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, _ := ioutil.ReadAll(body)
+	if string(data) != "frames" || contentType != "audio/mpeg" {
+		t.Errorf("streamed %q (%s)", data, contentType)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "busy", 503)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	if _, err := New(server.URL, "").Exists(context.Background(), []string{"h0"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Idempotency-Key = %q", keys)
+	}
+}
@@ -0,0 +1,253 @@
+// Command doctor checks that the service account of the syncer can do what
+// the syncer does: read and write both buckets, query and put entities in the
+// datastore, and consume the subscription of upload requests. Each failed
+// check tells the IAM role to grant.
+//
+//	doctor -config config.json
+//
+// It writes and deletes an object in each bucket and an entity of the kind
+// "benten-doctor", and consumes no messages.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The names of what doctor writes.
+const (
+	doctorObject = "benten-doctor"
+	doctorKind   = "benten-doctor"
+)
+
+// config is the part of the config of the syncer doctor needs.
+type config struct {
+	ProjectID          string
+	SubscriptionID     string
+	ServiceAccountKey  string
+	PieceBucket        string
+	AlbumPictureBucket string
+}
+
+// check is something the syncer needs to do.
+type check struct {
+	name string
+	// role is the IAM role granting it, and resource what to grant it on.
+	role, resource string
+	run            func(ctx context.Context) error
+}
+
+// errMissingPermissions is returned when TestPermissions doesn't grant all.
+var errMissingPermissions = errors.New("missing permissions")
+
+// denied returns true if `err` tells that the caller lacks permissions.
+func denied(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusUnauthorized
+	}
+	if errors.Is(err, errMissingPermissions) {
+		return true
+	}
+	code := status.Code(err)
+	return code == codes.PermissionDenied || code == codes.Unauthenticated
+}
+
+// credentials returns the options of the clients and the email of the
+// service account, or the empty string if unknown.
+func credentials(ctx context.Context, c config) ([]option.ClientOption, string, error) {
+	var key []byte
+	var opts []option.ClientOption
+	switch {
+	case c.ServiceAccountKey == "":
+		creds, err := google.FindDefaultCredentials(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		key = creds.JSON
+	case !benten.IsSecretReference(c.ServiceAccountKey) || strings.HasPrefix(c.ServiceAccountKey, "file://"):
+		path := strings.TrimPrefix(c.ServiceAccountKey, "file://")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		key = data
+		opts = append(opts, option.WithCredentialsFile(path))
+	default:
+		secret, err := benten.ResolveSecret(ctx, c.ProjectID, c.ServiceAccountKey)
+		if err != nil {
+			return nil, "", err
+		}
+		key = []byte(secret)
+		opts = append(opts, option.WithCredentialsJSON(key))
+	}
+	var account struct {
+		ClientEmail string `json:"client_email"`
+	}
+	json.Unmarshal(key, &account)
+	return opts, account.ClientEmail, nil
+}
+
+// bucketChecks returns the checks of the bucket `name`, which run in order.
+func bucketChecks(client *storage.Client, name string) []check {
+	object := client.Bucket(name).Object(doctorObject)
+	resource := "gs://" + name
+	return []check{
+		{"write gs://" + name, "roles/storage.objectAdmin", resource, func(ctx context.Context) error {
+			w := object.NewWriter(ctx)
+			if _, err := io.WriteString(w, "benten doctor\n"); err != nil {
+				w.Close()
+				return err
+			}
+			return w.Close()
+		}},
+		{"read gs://" + name, "roles/storage.objectViewer", resource, func(ctx context.Context) error {
+			r, err := object.NewReader(ctx)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			_, err = io.Copy(ioutil.Discard, r)
+			return err
+		}},
+		{"delete in gs://" + name, "roles/storage.objectAdmin", resource, func(ctx context.Context) error {
+			return object.Delete(ctx)
+		}},
+	}
+}
+
+// datastoreChecks returns the checks of the datastore, which run in order.
+func datastoreChecks(client *datastore.Client, projectID string) []check {
+	key := datastore.NameKey(doctorKind, "check", nil)
+	resource := "project " + projectID
+	return []check{
+		{"query the datastore", "roles/datastore.viewer", resource, func(ctx context.Context) error {
+			_, err := client.GetAll(ctx, datastore.NewQuery(benten.PieceKind).KeysOnly().Limit(1), nil)
+			return err
+		}},
+		{"put in the datastore", "roles/datastore.user", resource, func(ctx context.Context) error {
+			entity := struct{ Checked bool }{true}
+			if _, err := client.Put(ctx, key, &entity); err != nil {
+				return err
+			}
+			return client.Delete(ctx, key)
+		}},
+	}
+}
+
+// subscriptionCheck returns the check of consuming the subscription `name`.
+func subscriptionCheck(client *pubsub.Client, name string) check {
+	return check{"consume the subscription " + name, "roles/pubsub.subscriber", "the subscription " + name, func(ctx context.Context) error {
+		permissions := []string{"pubsub.subscriptions.consume"}
+		granted, err := client.Subscription(name).IAM().TestPermissions(ctx, permissions)
+		if err != nil {
+			return err
+		}
+		if len(granted) < len(permissions) {
+			return fmt.Errorf("%w: %s", errMissingPermissions, strings.Join(permissions, ", "))
+		}
+		return nil
+	}}
+}
+
+// run runs `checks` and prints the results to `w`. It returns the number of
+// the failed checks.
+func run(ctx context.Context, w io.Writer, checks []check) int {
+	failed := 0
+	for _, c := range checks {
+		err := c.run(ctx)
+		if err == nil {
+			fmt.Fprintf(w, "ok    %s\n", c.name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL  %s: %v\n", c.name, err)
+		if denied(err) {
+			fmt.Fprintf(w, "      grant %s on %s\n", c.role, c.resource)
+		}
+	}
+	return failed
+}
+
+func loadConfig(path string) (config, error) {
+	var c config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	if c.PieceBucket == "" {
+		c.PieceBucket = benten.PieceBucket
+	}
+	if c.AlbumPictureBucket == "" {
+		c.AlbumPictureBucket = benten.AlbumPictureBucket
+	}
+	return c, err
+}
+
+func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", "config.json", "the config of the syncer")
+	flag.Parse()
+
+	c, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", configPath, err)
+	}
+	ctx := context.Background()
+	opts, email, err := credentials(ctx, c)
+	if err != nil {
+		log.Fatalf("Failed to find the credentials: %v", err)
+	}
+	if email != "" {
+		fmt.Printf("Checking %s in %s\n", email, c.ProjectID)
+	}
+
+	storageClient, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create a storage client: %v", err)
+	}
+	defer storageClient.Close()
+	datastoreClient, err := datastore.NewClient(ctx, c.ProjectID, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer datastoreClient.Close()
+
+	var checks []check
+	for _, bucket := range []string{c.PieceBucket, c.AlbumPictureBucket} {
+		checks = append(checks, bucketChecks(storageClient, bucket)...)
+	}
+	checks = append(checks, datastoreChecks(datastoreClient, c.ProjectID)...)
+	if c.SubscriptionID != "" {
+		pubsubClient, err := pubsub.NewClient(ctx, c.ProjectID, opts...)
+		if err != nil {
+			log.Fatalf("Failed to create a pubsub client: %v", err)
+		}
+		defer pubsubClient.Close()
+		checks = append(checks, subscriptionCheck(pubsubClient, c.SubscriptionID))
+	}
+	if failed := run(ctx, os.Stdout, checks); failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+}
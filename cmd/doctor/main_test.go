@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDenied(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 403}, true},
+		{fmt.Errorf("write: %w", &googleapi.Error{Code: 403}), true},
+		{&googleapi.Error{Code: 404}, false},
+		{status.Error(codes.PermissionDenied, "denied"), true},
+		{status.Error(codes.NotFound, "not found"), false},
+		{fmt.Errorf("%w: pubsub.subscriptions.consume", errMissingPermissions), true},
+		{errors.New("timeout"), false},
+	}
+	for _, c := range cases {
+		if got := denied(c.err); got != c.want {
+			t.Errorf("denied(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	checks := []check{
+		{"read", "roles/viewer", "gs://b", func(ctx context.Context) error { return nil }},
+		{"write", "roles/editor", "gs://b", func(ctx context.Context) error { return &googleapi.Error{Code: 403} }},
+		{"delete", "roles/editor", "gs://b", func(ctx context.Context) error { return errors.New("timeout") }},
+	}
+	var out bytes.Buffer
+	if failed := run(context.Background(), &out, checks); failed != 2 {
+		t.Errorf("run() = %d, want 2", failed)
+	}
+	if got := strings.Count(out.String(), "grant roles/editor on gs://b"); got != 1 {
+		t.Errorf("the output has %d hints:\n%s", got, out.String())
+	}
+}
@@ -0,0 +1,47 @@
+// Command duplicates lists the pieces which look like the same track, either
+// because they have the same audio checksum or the same title, artist and
+// duration.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+func main() {
+	var projectID string
+	var jsonFlag bool
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.BoolVar(&jsonFlag, "json", false, "print the groups as JSON")
+	flag.Parse()
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+
+	groups, err := benten.LoadDuplicates(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to find duplicates: %v", err)
+	}
+	if jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(groups)
+		return
+	}
+	for _, group := range groups {
+		fmt.Printf("%s: %s / %s\n", group.Reason, group.Pieces[0].Artist, group.Pieces[0].Title)
+		for _, piece := range group.Pieces {
+			fmt.Printf("  %s\n", piece.Path)
+		}
+	}
+	log.Printf("Found %d groups.", len(groups))
+}
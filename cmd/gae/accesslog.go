@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severities understood by Cloud Logging.
+const (
+	severityInfo    = "INFO"
+	severityWarning = "WARNING"
+	severityError   = "ERROR"
+)
+
+// logEntry is a structured log line in the format Cloud Logging parses from
+// stdout.
+type logEntry struct {
+	Severity    string       `json:"severity"`
+	Message     string       `json:"message"`
+	HTTPRequest *httpRequest `json:"httpRequest,omitempty"`
+	RequestID   string       `json:"requestId,omitempty"`
+	Trace       string       `json:"logging.googleapis.com/trace,omitempty"`
+}
+
+// httpRequest is the httpRequest field of a Cloud Logging entry.
+type httpRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	ResponseSize  string `json:"responseSize"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	Latency       string `json:"latency"`
+	Protocol      string `json:"protocol"`
+}
+
+var logMu sync.Mutex
+var logOutput io.Writer = os.Stdout
+
+func writeLogEntry(e logEntry) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	json.NewEncoder(logOutput).Encode(e)
+}
+
+// requestInfo identifies a request in logs.
+type requestInfo struct {
+	id    string
+	trace string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info
+}
+
+// logf logs a message with the ID of the request `ctx` belongs to.
+func logf(ctx context.Context, severity string, format string, args ...interface{}) {
+	info := requestInfoFrom(ctx)
+	writeLogEntry(logEntry{
+		Severity:  severity,
+		Message:   fmt.Sprintf(format, args...),
+		RequestID: info.id,
+		Trace:     info.trace,
+	})
+}
+
+// newRequestInfo returns the info for `r`. The ID is the trace ID given by
+// the load balancer, or a random one.
+func newRequestInfo(r *http.Request) requestInfo {
+	if header := r.Header.Get("X-Cloud-Trace-Context"); header != "" {
+		id := strings.SplitN(header, "/", 2)[0]
+		if id != "" {
+			return requestInfo{id: id, trace: fmt.Sprintf("projects/%s/traces/%s", projectID, id)}
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return requestInfo{id: hex.EncodeToString(b)}
+}
+
+// loggingWriter records the status and the size of a response.
+type loggingWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	status int
+	bytes  int64
+}
+
+func (w *loggingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *loggingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestContext returns the context of the request `w` responds to, for
+// handlers which only have `w`.
+func requestContext(w http.ResponseWriter) context.Context {
+	if lw, ok := w.(*loggingWriter); ok {
+		return lw.ctx
+	}
+	if iw, ok := w.(*idempotentWriter); ok {
+		return requestContext(iw.ResponseWriter)
+	}
+	return context.Background()
+}
+
+// severityFor returns the severity of a response with the status `code`.
+func severityFor(code int) string {
+	if code >= 500 {
+		return severityError
+	}
+	if code >= 400 {
+		return severityWarning
+	}
+	return severityInfo
+}
+
+// secretParameters are the query parameters carrying credentials: the admin
+// token and the streaming sessions.
+var secretParameters = []string{"token", "session"}
+
+// redactQuery returns `u` with the values of secretParameters replaced, so
+// that they are not logged.
+func redactQuery(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for _, name := range secretParameters {
+		if _, ok := q[name]; ok {
+			q.Set(name, "redacted")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	copied := *u
+	copied.RawQuery = q.Encode()
+	return copied.String()
+}
+
+// redactReferer is redactQuery for the Referer header.
+func redactReferer(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return redactQuery(u)
+}
+
+// withAccessLog assigns an ID to each request, which is sent back in
+// X-Request-Id, and logs the request when it finishes.
+func withAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := newRequestInfo(r)
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		r = r.WithContext(ctx)
+		lw := &loggingWriter{ResponseWriter: w, ctx: ctx}
+		lw.Header().Set("X-Request-Id", info.id)
+		h.ServeHTTP(lw, r)
+
+		if lw.status == 0 {
+			lw.status = 200
+		}
+		remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteIP = r.RemoteAddr
+		}
+		writeLogEntry(logEntry{
+			Severity: severityFor(lw.status),
+			Message:  fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, lw.status),
+			HTTPRequest: &httpRequest{
+				RequestMethod: r.Method,
+				RequestURL:    redactQuery(r.URL),
+				Status:        lw.status,
+				ResponseSize:  strconv.FormatInt(lw.bytes, 10),
+				UserAgent:     r.UserAgent(),
+				RemoteIP:      remoteIP,
+				Referer:       redactReferer(r.Referer()),
+				Latency:       fmt.Sprintf("%.9fs", time.Since(start).Seconds()),
+				Protocol:      r.Proto,
+			},
+			RequestID: info.id,
+			Trace:     info.trace,
+		})
+	})
+}
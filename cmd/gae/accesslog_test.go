@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { logOutput = w }(logOutput)
+	logOutput = &buf
+	h := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, 404, "Not Found")
+	}))
+	r := httptest.NewRequest("GET", "/api/x?a=b", nil)
+	r.Header.Set("X-Cloud-Trace-Context", "0123abcd/1;o=1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if id := w.Header().Get("X-Request-Id"); id != "0123abcd" {
+		t.Errorf("X-Request-Id = %q", id)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var message, access logEntry
+	json.Unmarshal([]byte(lines[0]), &message)
+	json.Unmarshal([]byte(lines[1]), &access)
+	if message.Message != "Not Found" || message.RequestID != "0123abcd" || message.Severity != severityWarning {
+		t.Errorf("message = %+v", message)
+	}
+	if access.HTTPRequest == nil || access.HTTPRequest.Status != 404 || access.HTTPRequest.ResponseSize != "9" || access.HTTPRequest.RequestURL != "/api/x?a=b" {
+		t.Errorf("access = %+v", access.HTTPRequest)
+	}
+	if access.Trace != "projects/"+projectID+"/traces/0123abcd" {
+		t.Errorf("trace = %q", access.Trace)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	cases := map[string]string{
+		"/api/x?a=b":                     "/api/x?a=b",
+		"/api/admin/pieces?token=secret": "/api/admin/pieces?token=redacted",
+		"/api/get?key=k&session=s":       "/api/get?key=k&session=redacted",
+	}
+	for raw, want := range cases {
+		u, _ := url.Parse(raw)
+		if got := redactQuery(u); got != want {
+			t.Errorf("redactQuery(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	if got := redactReferer("https://example.com/player?session=s"); got != "https://example.com/player?session=redacted" {
+		t.Errorf("redactReferer() = %q", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// isAdmin returns true if `r` carries adminToken, either as a bearer token or as the `token` query
+// parameter (for Pub/Sub push endpoints, which cannot set headers).
+func isAdmin(r *http.Request) bool {
+	expected := adminToken
+	if expected == "" {
+		return false
+	}
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// requireAdmin responds with 403 and returns false unless `r` comes from an admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !isAdmin(r) {
+		respond(w, 403, "Forbidden")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/yutakahirano/benten"
+)
+
+// resolvedConfig is the configuration the server runs with, without secrets:
+// those are only told to be set.
+type resolvedConfig struct {
+	ProjectID          string
+	PieceBucket        string
+	AlbumPictureBucket string
+	Standalone         string
+	Index              benten.IndexConfig
+
+	AdminToken      bool
+	SessionKeys     int
+	SessionTTL      string
+	StreamAuth      bool
+	SignedURLSigner string
+	SignedURLTTL    string
+
+	Search          searchSettings
+	SearchCache     string
+	SearchCacheTTL  string
+	ArtistProviders []string
+	LyricsProviders []string
+	CollationLocale string
+
+	Deadlines            map[string]string
+	BandwidthQuota       int64
+	TrashRetention       string
+	IdempotencyRetention string
+	ReadOnly             bool
+	Warnings             []string
+}
+
+type searchSettings struct {
+	Backend string
+	Path    string
+	URL     string
+	Index   string
+	APIKey  bool
+}
+
+// redactURL removes the password from `raw`, such as of a Redis URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid)"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
+
+func currentConfig() resolvedConfig {
+	search := searchConfig()
+	c := resolvedConfig{
+		ProjectID:          projectID,
+		PieceBucket:        benten.PieceBucket,
+		AlbumPictureBucket: benten.AlbumPictureBucket,
+		Standalone:         os.Getenv("STANDALONE_DIR"),
+		Index:              benten.CurrentIndexConfig(),
+
+		AdminToken:   adminToken != "",
+		SessionKeys:  len(sessionKeys),
+		SessionTTL:   sessionTTL.String(),
+		StreamAuth:   streamAuth,
+		SignedURLTTL: signedURLTTL.String(),
+
+		Search: searchSettings{
+			Backend: search.Backend,
+			Path:    search.Path,
+			URL:     redactURL(search.URL),
+			Index:   search.Index,
+			APIKey:  searchAPIKey != "",
+		},
+		SearchCache:     redactURL(os.Getenv("SEARCH_CACHE")),
+		SearchCacheTTL:  searchCacheTTL.String(),
+		ArtistProviders: make([]string, 0),
+		LyricsProviders: make([]string, 0),
+		CollationLocale: defaultLocale,
+
+		Deadlines: map[string]string{
+			"METADATA_DEADLINE":     metadataDeadline.String(),
+			"STREAM_IDLE_TIMEOUT":   streamIdleTimeout.String(),
+			"LIST_DEADLINE":         listDeadline.String(),
+			"BROWSE_DEADLINE":       browseDeadline.String(),
+			"ADMIN_DEADLINE":        adminDeadline.String(),
+			"INDEX_FANOUT_DEADLINE": fanoutDeadline.String(),
+			"INDEX_WORKER_DEADLINE": indexWorkerDeadline.String(),
+		},
+		BandwidthQuota:       bandwidthQuota,
+		TrashRetention:       benten.TrashRetention.String(),
+		IdempotencyRetention: benten.IdempotencyRetention.String(),
+		ReadOnly:             readOnlyEnv,
+		Warnings:             configWarnings(),
+	}
+	if signedURLSigner != nil {
+		c.SignedURLSigner = signedURLSigner.email
+	}
+	for _, p := range artistProviders {
+		c.ArtistProviders = append(c.ArtistProviders, p.Name())
+	}
+	for _, p := range lyricsProviders {
+		c.LyricsProviders = append(c.LyricsProviders, p.Name())
+	}
+	if c.Warnings == nil {
+		c.Warnings = make([]string, 0)
+	}
+	return c
+}
+
+// adminConfig responds with the resolvedConfig, to tell what a deployment
+// actually runs with.
+func adminConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	respondJSON(w, 200, currentConfig())
+}
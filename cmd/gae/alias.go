@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/pubsub"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// How long loaded aliases are reused.
+const aliasCacheDuration = time.Minute
+
+var aliasCache struct {
+	mu       sync.Mutex
+	aliases  benten.Aliases
+	loadedAt time.Time
+}
+
+// cachedAliases returns the artist aliases, loading them at most once per aliasCacheDuration.
+func cachedAliases(ctx context.Context, client *datastore.Client) (benten.Aliases, error) {
+	aliasCache.mu.Lock()
+	defer aliasCache.mu.Unlock()
+	if aliasCache.aliases != nil && time.Since(aliasCache.loadedAt) < aliasCacheDuration {
+		return aliasCache.aliases, nil
+	}
+	aliases, err := benten.LoadAliases(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	aliasCache.aliases = aliases
+	aliasCache.loadedAt = time.Now()
+	return aliases, nil
+}
+
+// resetAliasCache makes the next cachedAliases load the aliases again.
+func resetAliasCache() {
+	aliasCache.mu.Lock()
+	defer aliasCache.mu.Unlock()
+	aliasCache.aliases = nil
+}
+
+// affectedNames returns the normalized names of `aliases`, without the empty
+// names of missing aliases.
+func affectedNames(aliases ...*benten.ArtistAlias) map[string]bool {
+	names := make(map[string]bool)
+	for _, alias := range aliases {
+		for _, name := range alias.Names() {
+			if normalized := benten.Normalize(name); normalized != "" {
+				names[normalized] = true
+			}
+		}
+	}
+	return names
+}
+
+// piecesByArtists returns the keys of the pieces not in the trash whose Artist
+// or AlbumArtist normalizes to one of `names`. Tags differ in case and
+// punctuation, so all the pieces are read.
+func piecesByArtists(ctx context.Context, client *datastore.Client, names map[string]bool) ([]*datastore.Key, error) {
+	var keys []*datastore.Key
+	iter := client.Run(ctx, datastore.NewQuery(benten.PieceKind))
+	for {
+		var m benten.Metadata
+		key, err := iter.Next(&m)
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !m.IsTrashed() && (names[benten.Normalize(m.Artist)] || names[benten.Normalize(m.AlbumArtist)]) {
+			keys = append(keys, key)
+		}
+	}
+}
+
+// adminAliases lists (GET), declares (POST) and deletes (DELETE) artist aliases.
+// POST takes a JSON object with Name and Aliases, and DELETE takes the `name`
+// query parameter. Pieces by the affected artists are enqueued for reindexing.
+func adminAliases(w http.ResponseWriter, r *http.Request) {
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	var names map[string]bool
+	switch r.Method {
+	case "GET":
+		aliases := make([]benten.ArtistAlias, 0)
+		_, err := client.GetAll(ctx, datastore.NewQuery(benten.ArtistAliasKind), &aliases)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get aliases: %v", err))
+			return
+		}
+		respondJSON(w, 200, aliases)
+		return
+	case "POST":
+		var request struct {
+			Name    string
+			Aliases []string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		if request.Name == "" || len(request.Aliases) == 0 {
+			respond(w, 400, "Name and Aliases are required")
+			return
+		}
+		alias := benten.NewArtistAlias(request.Name, request.Aliases)
+		var old benten.ArtistAlias
+		err := client.Get(ctx, benten.ArtistAliasKey(request.Name), &old)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			respond(w, 500, fmt.Sprintf("Failed to get the alias: %v", err))
+			return
+		}
+		if _, err := client.Put(ctx, benten.ArtistAliasKey(request.Name), alias); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to put the alias: %v", err))
+			return
+		}
+		names = affectedNames(&old, alias)
+		audit(ctx, client, r, benten.AuditAliasPut, request.Name, strings.Join(old.Aliases, ", "), strings.Join(alias.Aliases, ", "))
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		var old benten.ArtistAlias
+		err := client.Get(ctx, benten.ArtistAliasKey(name), &old)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", name))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the alias: %v", err))
+			return
+		}
+		if err := client.Delete(ctx, benten.ArtistAliasKey(name)); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to delete the alias: %v", err))
+			return
+		}
+		names = affectedNames(&old)
+		audit(ctx, client, r, benten.AuditAliasDeleted, name, strings.Join(old.Aliases, ", "), "")
+	default:
+		respond(w, 405, "Method not allowed")
+		return
+	}
+
+	// The workers reindex with cachedAliases.
+	resetAliasCache()
+	invalidateSearchCache(ctx)
+	keys, err := piecesByArtists(ctx, client, names)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to find affected pieces: %v", err))
+		return
+	}
+	pubsubClient, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a pubsub client: %v", err))
+		return
+	}
+	defer pubsubClient.Close()
+	topic := pubsubClient.Topic(benten.IndexRequestTopic)
+	defer topic.Stop()
+	if err := publishIndexRequests(ctx, topic, keys); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to publish: %v", err))
+		return
+	}
+	respond(w, 200, fmt.Sprintf("Enqueued %d pieces for reindexing", len(keys)))
+}
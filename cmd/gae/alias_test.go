@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestAffectedNames(t *testing.T) {
+	var missing benten.ArtistAlias
+	alias := benten.NewArtistAlias("Prince", []string{"The Artist Formerly Known As Prince", "P.R.I.N.C.E"})
+	want := map[string]bool{"prince": true, "the artist formerly known as prince": true}
+	if got := affectedNames(&missing, alias); !reflect.DeepEqual(got, want) {
+		t.Errorf("affectedNames() = %v, want %v", got, want)
+	}
+	if got := affectedNames(&missing); len(got) != 0 {
+		t.Errorf("affectedNames() of a missing alias = %v", got)
+	}
+}
+
+func TestPiecesByArtists(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	tagged := testutil.PutPiece(t, client, benten.Metadata{Title: "Kiss", Artist: "PRINCE"})
+	album := testutil.PutPiece(t, client, benten.Metadata{Title: "Purple Rain", Artist: "Prince & The Revolution", AlbumArtist: "Prince."})
+	testutil.PutPiece(t, client, benten.Metadata{Title: "1999", Artist: "Prince", Deleted: time.Now()})
+	testutil.PutPiece(t, client, benten.Metadata{Title: "Untitled"})
+
+	keys, err := piecesByArtists(ctx, client, map[string]bool{"prince": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, key := range keys {
+		found[key.Encode()] = true
+	}
+	if len(keys) != 2 || !found[tagged.Encode()] || !found[album.Encode()] {
+		t.Errorf("piecesByArtists() = %v", keys)
+	}
+}
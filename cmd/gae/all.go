@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// all enumerates the whole library ordered by key, at most `limit` pieces
+// per request starting from `cursor`. With `since` (RFC 3339), only the
+// pieces updated after it are returned, ordered by update time. It responds
+// with the cursor to continue from, which is empty at the end. Deleted
+// pieces are not reported, and trashed ones are reported only with `since`,
+// so that incremental clients learn about them. With `album` (a
+// Metadata.AlbumKey) instead, only the pieces of the album are returned.
+// `filetype`, `lossless` and `bitrate_min` restrict the pieces as in list,
+// except with `since`. When streaming NDJSON, the last line is an object
+// holding only the cursor.
+func all(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 1000
+	if limitString := q.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 || limit > 10*1000 {
+			respond(w, 400, fmt.Sprintf("limit (%v) is invalid", limitString))
+			return
+		}
+	}
+	query := datastore.NewQuery(benten.PieceKind).Order("__key__").Limit(limit)
+	incremental := false
+	if sinceString := q.Get("since"); sinceString != "" {
+		since, err := time.Parse(time.RFC3339, sinceString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("since (%v) is invalid", sinceString))
+			return
+		}
+		query = datastore.NewQuery(benten.PieceKind).Filter("Updated >", since).Order("Updated").Limit(limit)
+		incremental = true
+	}
+	if album := q.Get("album"); album != "" {
+		if incremental {
+			// It would need a composite index for little use.
+			respond(w, 400, "album and since are exclusive")
+			return
+		}
+		query = query.Filter("AlbumKey =", album)
+	}
+	var quality benten.Filter
+	if err := parseQualityFilter(q, &quality); err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	if incremental && !quality.IsEmpty() {
+		respond(w, 400, "since can't be used with the quality filters")
+		return
+	}
+	if cursorString := q.Get("cursor"); cursorString != "" {
+		cursor, err := datastore.DecodeCursor(cursorString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", cursorString))
+			return
+		}
+		query = query.Start(cursor)
+	}
+
+	deadline := browseDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	pieces := newListWriter(w, r)
+	count := 0
+	t := client.Run(ctx, query)
+	for {
+		var piece benten.Metadata
+		key, err := t.Next(&piece)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			pieces.fail(500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+		count++
+		if (piece.IsTrashed() && !incremental) || !quality.Matches(&piece, nil) {
+			continue
+		}
+		pieces.add(entry{key.Encode(), piece})
+	}
+	next := ""
+	if count == limit {
+		cursor, err := t.Cursor()
+		if err != nil {
+			pieces.fail(500, fmt.Sprintf("Failed to get a cursor: %v", err))
+			return
+		}
+		next = cursor.String()
+	}
+	pieces.close(func(items []interface{}) interface{} {
+		if items == nil {
+			return struct{ Cursor string }{next}
+		}
+		return struct {
+			Pieces []interface{}
+			Cursor string
+		}{items, next}
+	})
+}
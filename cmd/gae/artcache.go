@@ -0,0 +1,164 @@
+package main
+
+import (
+	lists "container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+)
+
+// cachedObject is an object held by objectCache.
+type cachedObject struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// call is a load in progress, which concurrent requests for the same object wait for.
+type call struct {
+	done   chan struct{}
+	object *cachedObject
+	err    error
+}
+
+// objectCache is an LRU cache of small objects bounded by their total size.
+// Concurrent misses for the same object are coalesced into one load.
+type objectCache struct {
+	maxBytes       int64
+	maxObjectBytes int64
+
+	mu       sync.Mutex
+	bytes    int64
+	order    *lists.List // of *cachedObject, the most recently used first
+	elements map[string]*lists.Element
+	calls    map[string]*call
+}
+
+func newObjectCache(maxBytes, maxObjectBytes int64) *objectCache {
+	return &objectCache{
+		maxBytes:       maxBytes,
+		maxObjectBytes: maxObjectBytes,
+		order:          lists.New(),
+		elements:       make(map[string]*lists.Element),
+		calls:          make(map[string]*call),
+	}
+}
+
+// get returns the object `name`, calling `load` on a miss.
+func (c *objectCache) get(name string, load func() (*cachedObject, error)) (*cachedObject, error) {
+	c.mu.Lock()
+	if element, ok := c.elements[name]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cachedObject), nil
+	}
+	if pending, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		<-pending.done
+		return pending.object, pending.err
+	}
+	pending := &call{done: make(chan struct{})}
+	c.calls[name] = pending
+	c.mu.Unlock()
+
+	pending.object, pending.err = load()
+
+	c.mu.Lock()
+	delete(c.calls, name)
+	if pending.err == nil {
+		c.add(pending.object)
+	}
+	c.mu.Unlock()
+	close(pending.done)
+	return pending.object, pending.err
+}
+
+// add adds `object` and evicts the least recently used objects. c.mu must be held.
+func (c *objectCache) add(object *cachedObject) {
+	size := int64(len(object.data))
+	if size > c.maxObjectBytes || size > c.maxBytes {
+		return
+	}
+	c.elements[object.name] = c.order.PushFront(object)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		evicted := oldest.Value.(*cachedObject)
+		c.order.Remove(oldest)
+		delete(c.elements, evicted.name)
+		c.bytes -= int64(len(evicted.data))
+	}
+}
+
+func envBytes(name string, defaultValue int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || n < 0 {
+		return defaultValue
+	}
+	return n
+}
+
+// artCache caches album pictures, which never change as they are named by
+// their hashes. ART_CACHE_BYTES bounds the total size (64 MiB by default,
+// zero disables the cache) and ART_CACHE_MAX_OBJECT_BYTES the size of a
+// cached picture (2 MiB by default).
+var artCache = newArtCache()
+
+func newArtCache() *objectCache {
+	maxBytes := envBytes("ART_CACHE_BYTES", 64<<20)
+	if maxBytes == 0 {
+		return nil
+	}
+	return newObjectCache(maxBytes, envBytes("ART_CACHE_MAX_OBJECT_BYTES", 2<<20))
+}
+
+func loadArt(name string) (*cachedObject, error) {
+	// Not bound to a request, since other requests may be waiting for it.
+	ctx, cancel := context.WithTimeout(context.Background(), metadataDeadline)
+	defer cancel()
+	client, err := storage.NewClient(ctx, benten.StorageOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	reader, err := client.Bucket(benten.AlbumPictureBucket).Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedObject{name: name, contentType: reader.Attrs.ContentType, data: data}, nil
+}
+
+// serveArt responds with the album picture `name` from artCache.
+func serveArt(w http.ResponseWriter, name string) {
+	object, err := artCache.get(name, func() (*cachedObject, error) { return loadArt(name) })
+	if err == storage.ErrObjectNotExist {
+		respond(w, 404, fmt.Sprintf("Not found: %s", name))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get %s: %v", name, err))
+		return
+	}
+	writeHead(w, 200, header{
+		ContentType:   object.contentType,
+		ContentLength: int64(len(object.data)),
+		Disposition:   "inline",
+		Filename:      downloadFilename(name, "", object.contentType),
+		CacheControl:  "public, max-age=31536000, immutable",
+	})
+	if _, err := w.Write(object.data); err != nil {
+		logf(requestContext(w), severityError, "Failed to write data to response: %v", err)
+	}
+}
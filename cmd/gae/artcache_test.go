@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestObjectCache(t *testing.T) {
+	c := newObjectCache(10, 6)
+	loads := 0
+	load := func(name string, size int) func() (*cachedObject, error) {
+		return func() (*cachedObject, error) {
+			loads++
+			return &cachedObject{name: name, data: make([]byte, size)}, nil
+		}
+	}
+	c.get("a", load("a", 4))
+	c.get("b", load("b", 4))
+	c.get("a", load("a", 4))
+	if loads != 2 {
+		t.Errorf("loads = %d", loads)
+	}
+	// "b" is the least recently used.
+	c.get("c", load("c", 4))
+	c.get("a", load("a", 4))
+	c.get("b", load("b", 4))
+	if loads != 4 {
+		t.Errorf("loads = %d", loads)
+	}
+	// Too large to be cached.
+	c.get("d", load("d", 7))
+	c.get("d", load("d", 7))
+	if loads != 6 {
+		t.Errorf("loads = %d", loads)
+	}
+}
+
+func TestObjectCacheCoalescing(t *testing.T) {
+	c := newObjectCache(10, 10)
+	var loads int32
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	load := func() (*cachedObject, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			close(loading)
+		}
+		<-release
+		return &cachedObject{name: "a"}, nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.get("a", load)
+	}()
+	<-loading
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.get("a", load)
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("loads = %d", loads)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/artwork"
+	"google.golang.org/api/iterator"
+)
+
+// artistProviders are asked in order for artist photos; see configure.
+var artistProviders []artwork.ArtistProvider
+
+// artistArtFetchesPerStep is the number of artists an artist-art step asks the
+// providers about, which keeps the step well within jobLease.
+const artistArtFetchesPerStep = 10
+
+// artistArtStep fetches the photos of the artists of the next pieces that
+// have no ArtistArt yet, or one to retry.
+func artistArtStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	storageClient, err := storage.NewClient(ctx, benten.StorageOptions()...)
+	if err != nil {
+		return false, err
+	}
+	defer storageClient.Close()
+	bucket := storageClient.Bucket(benten.AlbumPictureBucket)
+
+	query := datastore.NewQuery(benten.PieceKind)
+	if job.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(job.Cursor)
+		if err != nil {
+			return false, err
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	seen := make(map[string]bool)
+	fetches := 0
+	for fetches < artistArtFetchesPerStep {
+		var piece benten.Metadata
+		_, err := t.Next(&piece)
+		if err == iterator.Done {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, name := range []string{piece.Artist, piece.AlbumArtist} {
+			normalized := benten.Normalize(name)
+			if normalized == "" || seen[normalized] {
+				continue
+			}
+			seen[normalized] = true
+			var art benten.ArtistArt
+			err := client.Get(ctx, benten.ArtistArtKey(name), &art)
+			if err == nil && !art.IsStale(time.Now()) {
+				continue
+			}
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				return false, err
+			}
+			if err := fetchArtistArt(ctx, client, bucket, name); err != nil {
+				return false, err
+			}
+			fetches++
+			job.Processed++
+		}
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return false, err
+	}
+	job.Cursor = cursor.String()
+	return false, nil
+}
+
+// fetchArtistArt asks the providers for the photo of `name`, and records the
+// answer even if none had one, so that the artist is left until
+// ArtistArtRetry passes.
+func fetchArtistArt(ctx context.Context, client *datastore.Client, bucket *storage.BucketHandle, name string) error {
+	image, err := artwork.FetchArtist(ctx, artistProviders, name)
+	if err != nil {
+		// Providers being down shouldn't fail the job; the artist is asked
+		// about again by the next one.
+		logf(ctx, severityWarning, "Failed to fetch the photo of %s: %v", name, err)
+		return nil
+	}
+	art := benten.ArtistArt{Name: name, Fetched: time.Now()}
+	if image != nil {
+		art.Picture = benten.ArtistArtObject(name)
+		art.Source = image.Source
+		writer := bucket.Object(art.Picture).NewWriter(ctx)
+		writer.ContentType = image.MIMEType
+		if _, err := writer.Write(image.Data); err != nil {
+			writer.Close()
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	}
+	_, err = client.Put(ctx, benten.ArtistArtKey(name), &art)
+	return err
+}
+
+// artistArt responds with the photo of the artist `name`.
+func artistArt(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if benten.Normalize(name) == "" {
+		respond(w, 400, fmt.Sprintf("name (%v) is invalid", name))
+		return
+	}
+	deadline := metadataDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	var art benten.ArtistArt
+	err = client.Get(ctx, benten.ArtistArtKey(name), &art)
+	if err == datastore.ErrNoSuchEntity || (err == nil && art.Picture == "") {
+		respond(w, 404, fmt.Sprintf("Not found: %s", name))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the artist art: %v", err))
+		return
+	}
+	if artCache != nil {
+		serveArt(w, art.Picture)
+		return
+	}
+	r.URL.RawQuery = url.Values{"bucket": {benten.AlbumPictureBucket}, "name": {art.Picture}}.Encode()
+	get(w, r)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// assistantSearchLimit is the number of search candidates an utterance is
+// resolved from.
+const assistantSearchLimit = 200
+
+// Types of play intents.
+const (
+	intentPiece    = "piece"
+	intentWork     = "work"
+	intentAlbum    = "album"
+	intentPlaylist = "playlist"
+)
+
+// intentTrack is a piece to play with the URL to stream it from.
+type intentTrack struct {
+	Key       string
+	Title     string
+	Artist    string
+	Album     string
+	StreamURL string
+}
+
+// playIntent is a candidate of what an utterance asks to play.
+type playIntent struct {
+	// Type is "piece", "work" (the movements of a work), "album" or
+	// "playlist".
+	Type   string
+	Name   string
+	Artist string
+	// Score is how well the intent matches, higher first.
+	Score  float64
+	Tracks []intentTrack
+
+	// albumKey and work are of the works and the albums, and pieces are the
+	// pieces of the playlists or the piece.
+	albumKey string
+	work     string
+	pieces   []*datastore.Key
+}
+
+// assistantResolve responds with up to `limit` play intents for the
+// utterance `q`, such as "play the second Brandenburg concerto", best first,
+// with the URLs to stream their pieces. It is the glue for voice assistants:
+// the utterance is parsed by benten.ParseUtterance, matched with the
+// playlists and searched with the aliases, and works are recognized from the
+// titles of their movements (see benten.ParseWork). An utterance finding
+// nothing with "by" is retried as a whole, for titles like "Stand by Me".
+func assistantResolve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := parseLimit(q, 3, 10)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	utterance := benten.ParseUtterance(q.Get("q"))
+	if utterance.Query == "" && utterance.By == "" {
+		respond(w, 400, "q has nothing to play")
+		return
+	}
+	deadline := listDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to load aliases: %v", err))
+		return
+	}
+
+	intents, err := resolveUtterance(ctx, client, aliases, utterance)
+	if err == nil && len(intents) == 0 && utterance.By != "" {
+		utterance.Query = strings.TrimSpace(utterance.Query + " by " + utterance.By)
+		utterance.By = ""
+		intents, err = resolveUtterance(ctx, client, aliases, utterance)
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to resolve the utterance: %v", err))
+		return
+	}
+	if len(intents) > limit {
+		intents = intents[:limit]
+	}
+	for i := range intents {
+		if err := loadTracks(ctx, client, r, &intents[i], utterance.Shuffle); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the pieces: %v", err))
+			return
+		}
+	}
+	respondJSON(w, 200, struct {
+		Utterance benten.Utterance
+		Intents   []playIntent
+	}{utterance, intents})
+}
+
+// resolveUtterance returns the intents matching `u`, best first, without
+// their tracks.
+func resolveUtterance(ctx context.Context, client *datastore.Client, aliases benten.Aliases, u benten.Utterance) ([]playIntent, error) {
+	var intents []playIntent
+	bonus := func(t string) float64 {
+		if u.Kind == t {
+			return 0.5
+		}
+		return 0
+	}
+	// numbered returns false if `u` asks for another number than `name`'s.
+	numbered := func(name string) bool {
+		return u.Number == 0 || benten.WorkNumber(name) == u.Number
+	}
+
+	if u.Kind == "" || u.Kind == intentPlaylist {
+		keys, err := client.GetAll(ctx, datastore.NewQuery(benten.PlaylistKind).KeysOnly(), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if score := benten.MatchScore(u.Query, key.Name); score > 0 {
+				intents = append(intents, playIntent{Type: intentPlaylist, Name: key.Name, Score: score + bonus(intentPlaylist)})
+			}
+		}
+	}
+
+	text := u.Query
+	if text == "" {
+		text = u.By
+	}
+	results, err := benten.DatastoreIndex{Client: client, Aliases: aliases}.Search(ctx, text, assistantSearchLimit)
+	if err == benten.ErrQueryTooShort {
+		results = nil
+	} else if err != nil {
+		return nil, err
+	}
+	pieces, keys, err := resultPieces(ctx, client, results)
+	if err != nil {
+		return nil, err
+	}
+	albums := make(map[string]*playIntent)
+	works := make(map[string]*playIntent)
+	for i, piece := range pieces {
+		if u.By != "" && !byArtist(piece, u.By, aliases) {
+			continue
+		}
+		artist := piece.Artist
+		if a := piece.GroupAlbumArtist(); a != "" {
+			artist = a
+		}
+		work, _ := benten.ParseWork(piece.Title)
+		title := piece.Title
+		if work != "" {
+			title = work
+		}
+		if numbered(title) {
+			if score := benten.MatchScore(u.Query, piece.Title); score > 0 || u.Query == "" {
+				intents = append(intents, playIntent{Type: intentPiece, Name: piece.Title, Artist: piece.Artist, Score: score, pieces: []*datastore.Key{keys[i]}})
+			}
+		}
+		if work != "" && numbered(work) {
+			id := piece.AlbumKey + "\n" + work
+			if _, ok := works[id]; !ok {
+				score := benten.MatchScore(u.Query, work)
+				if u.Number > 0 {
+					score += 0.5
+				}
+				works[id] = &playIntent{Type: intentWork, Name: work, Artist: artist, Score: score, albumKey: piece.AlbumKey, work: work}
+			}
+		}
+		if piece.AlbumKey != "" {
+			if _, ok := albums[piece.AlbumKey]; !ok {
+				score := benten.MatchScore(u.Query, piece.Album)
+				if u.Query == "" {
+					score = 0.5
+				}
+				albums[piece.AlbumKey] = &playIntent{Type: intentAlbum, Name: piece.Album, Artist: artist, Score: score + bonus(intentAlbum), albumKey: piece.AlbumKey}
+			}
+		}
+	}
+	for _, intent := range works {
+		if intent.Score > 0 {
+			intents = append(intents, *intent)
+		}
+	}
+	for _, intent := range albums {
+		if intent.Score > 0 && (u.Kind == "" || u.Kind == intentAlbum) {
+			intents = append(intents, *intent)
+		}
+	}
+	if u.Kind == intentAlbum || u.Kind == intentPlaylist {
+		// Only the asked kind.
+		kept := intents[:0]
+		for _, intent := range intents {
+			if intent.Type == u.Kind {
+				kept = append(kept, intent)
+			}
+		}
+		intents = kept
+	}
+	sort.SliceStable(intents, func(i, j int) bool {
+		if intents[i].Score != intents[j].Score {
+			return intents[i].Score > intents[j].Score
+		}
+		return intents[i].Name < intents[j].Name
+	})
+	return intents, nil
+}
+
+// resultPieces returns the live pieces of `results` and their keys.
+func resultPieces(ctx context.Context, client *datastore.Client, results []benten.SearchResult) ([]*benten.Metadata, []*datastore.Key, error) {
+	var pieces []*benten.Metadata
+	var keys, missing []*datastore.Key
+	for _, result := range results {
+		if result.Metadata == nil {
+			missing = append(missing, result.Key)
+		} else if !result.Metadata.IsTrashed() {
+			pieces = append(pieces, result.Metadata)
+			keys = append(keys, result.Key)
+		}
+	}
+	if len(missing) == 0 {
+		return pieces, keys, nil
+	}
+	loaded := make([]benten.Metadata, len(missing))
+	err := client.GetMulti(ctx, missing, loaded)
+	errs, _ := err.(datastore.MultiError)
+	if err != nil && errs == nil {
+		return nil, nil, err
+	}
+	for i := range loaded {
+		if errs != nil && errs[i] == datastore.ErrNoSuchEntity {
+			// The index is stale.
+			continue
+		}
+		if errs != nil && errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+		if !loaded[i].IsTrashed() {
+			pieces = append(pieces, &loaded[i])
+			keys = append(keys, missing[i])
+		}
+	}
+	return pieces, keys, nil
+}
+
+// byArtist returns true if `by`, normalized, is the artist, the album artist
+// or the composer of `piece`, or an alias of them.
+func byArtist(piece *benten.Metadata, by string, aliases benten.Aliases) bool {
+	names := append([]string{piece.Artist, piece.AlbumArtist, piece.Composer}, aliases.Equivalents(piece.Artist, piece.AlbumArtist, piece.Composer)...)
+	for _, name := range names {
+		if name != "" && strings.Contains(benten.Normalize(name), by) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTracks fills intent.Tracks: the pieces of the album in the order of
+// the discs and the tracks, the movements of the work in order, or the
+// pieces of the playlist, shuffled if `shuffle`.
+func loadTracks(ctx context.Context, client *datastore.Client, r *http.Request, intent *playIntent, shuffle bool) error {
+	var pieces []benten.Metadata
+	var keys []*datastore.Key
+	switch intent.Type {
+	case intentAlbum, intentWork:
+		var err error
+		keys, err = client.GetAll(ctx, datastore.NewQuery(benten.PieceKind).Filter("AlbumKey =", intent.albumKey), &pieces)
+		if err != nil {
+			return err
+		}
+		movements := make([]int, len(pieces))
+		kept := 0
+		for i := range pieces {
+			work, movement := benten.ParseWork(pieces[i].Title)
+			if pieces[i].IsTrashed() || (intent.Type == intentWork && work != intent.work) {
+				continue
+			}
+			pieces[kept], keys[kept], movements[kept] = pieces[i], keys[i], movement
+			kept++
+		}
+		pieces, keys, movements = pieces[:kept], keys[:kept], movements[:kept]
+		sort.Sort(albumOrder{pieces, keys, movements})
+	case intentPlaylist:
+		var playlist benten.Playlist
+		if err := client.Get(ctx, benten.PlaylistKey(intent.Name), &playlist); err != nil {
+			return err
+		}
+		intent.pieces = playlist.Pieces
+		fallthrough
+	case intentPiece:
+		loaded := make([]benten.Metadata, len(intent.pieces))
+		err := client.GetMulti(ctx, intent.pieces, loaded)
+		errs, _ := err.(datastore.MultiError)
+		if err != nil && errs == nil {
+			return err
+		}
+		for i := range loaded {
+			if (errs == nil || errs[i] == nil) && !loaded[i].IsTrashed() {
+				pieces = append(pieces, loaded[i])
+				keys = append(keys, intent.pieces[i])
+			}
+		}
+	}
+	if shuffle {
+		rand.Shuffle(len(pieces), func(i, j int) {
+			pieces[i], pieces[j] = pieces[j], pieces[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+	}
+	intent.Tracks = make([]intentTrack, len(pieces))
+	for i := range pieces {
+		intent.Tracks[i] = intentTrack{keys[i].Encode(), pieces[i].Title, pieces[i].Artist, pieces[i].Album, streamURL(r, keys[i])}
+	}
+	return nil
+}
+
+// albumOrder sorts pieces by disc, track, and movement.
+type albumOrder struct {
+	pieces    []benten.Metadata
+	keys      []*datastore.Key
+	movements []int
+}
+
+func (o albumOrder) Len() int { return len(o.pieces) }
+func (o albumOrder) Less(i, j int) bool {
+	a, b := &o.pieces[i], &o.pieces[j]
+	if a.Disc != b.Disc {
+		return a.Disc < b.Disc
+	}
+	if a.Track != b.Track {
+		return a.Track < b.Track
+	}
+	return o.movements[i] < o.movements[j]
+}
+func (o albumOrder) Swap(i, j int) {
+	o.pieces[i], o.pieces[j] = o.pieces[j], o.pieces[i]
+	o.keys[i], o.keys[j] = o.keys[j], o.keys[i]
+	o.movements[i], o.movements[j] = o.movements[j], o.movements[i]
+}
+
+// streamURL returns the absolute URL streaming the piece at `key`, carrying
+// the session of `r` if any, since the assistant plays it without cookies.
+func streamURL(r *http.Request, key *datastore.Key) string {
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if r.TLS == nil {
+		scheme = "http"
+	}
+	q := url.Values{"key": {key.Encode()}}
+	if token := r.URL.Query().Get("session"); token != "" {
+		q.Set("session", token)
+	} else if cookie, err := r.Cookie(sessionCookie); err == nil {
+		q.Set("session", cookie.Value)
+	}
+	return scheme + "://" + r.Host + "/api/get?" + q.Encode()
+}
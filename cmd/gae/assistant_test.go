@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+func TestStreamURL(t *testing.T) {
+	key := datastore.IDKey(benten.PieceKind, 1, nil)
+	r := httptest.NewRequest("GET", "/api/assistant/resolve?q=play+dogs&session=token", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got, want := streamURL(r, key), "https://example.com/api/get?key="+key.Encode()+"&session=token"; got != want {
+		t.Errorf("streamURL = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// audit records `action` on `target` done by the admin request `r`. Failures
+// are only logged, since the operation has already been done.
+func audit(ctx context.Context, client *datastore.Client, r *http.Request, action, target, before, after string) {
+	entry := benten.AuditLog{
+		Actor:     "admin",
+		Action:    action,
+		Target:    target,
+		Before:    before,
+		After:     after,
+		RequestID: requestInfoFrom(r.Context()).id,
+	}
+	if err := benten.RecordAudit(ctx, client, entry); err != nil {
+		logf(r.Context(), severityError, "Failed to record %s on %s: %v", action, target, err)
+	}
+}
+
+// adminAudit responds with the audit logs, newest first, optionally filtered
+// by `action` or `target`.
+func adminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit, err := parseLimit(q, 100, 1000)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	query := datastore.NewQuery(benten.AuditLogKind).Order("-Time").Limit(limit)
+	if action := q.Get("action"); action != "" {
+		query = query.Filter("Action =", action)
+	}
+	if target := q.Get("target"); target != "" {
+		query = query.Filter("Target =", target)
+	}
+
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	logs := make([]benten.AuditLog, 0)
+	if _, err := client.GetAll(ctx, query, &logs); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get audit logs: %v", err))
+		return
+	}
+	respondJSON(w, 200, logs)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// bandwidthQuota is the number of bytes of pieces an identity may stream per
+// day (BANDWIDTH_QUOTA), or zero for no quota. With a quota, pieces are
+// streamed through the server instead of redirected to signed URLs, so that
+// every byte is counted.
+var bandwidthQuota = envBytes("BANDWIDTH_QUOTA", 0)
+
+// identity returns who `r` streams to: "admin" with the admin token,
+// "session:<id>" with a session, or the IP address of the client otherwise, as
+// the front end reports it. Only the entry the front end appends to
+// X-Forwarded-For is used, as the client may send any earlier ones.
+func identity(r *http.Request) string {
+	if isAdmin(r) {
+		return "admin"
+	}
+	if s, ok := requestSession(r); ok {
+		return "session:" + s.id
+	}
+	if ip := r.Header.Get("X-Appengine-User-IP"); ip != "" {
+		return "ip:" + ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		entries := strings.Split(forwarded, ",")
+		return "ip:" + strings.TrimSpace(entries[len(entries)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// checkQuota responds with 429 and returns false if `id` has used up today's
+// bandwidthQuota.
+func checkQuota(ctx context.Context, w http.ResponseWriter, id string) bool {
+	if bandwidthQuota == 0 {
+		return true
+	}
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return false
+	}
+	defer client.Close()
+	now := time.Now()
+	used, err := benten.LoadBandwidth(ctx, client, id, benten.BandwidthDay(now))
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the bandwidth usage: %v", err))
+		return false
+	}
+	if used < bandwidthQuota {
+		return true
+	}
+	tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	w.Header().Set("retry-after", strconv.Itoa(int(tomorrow.Sub(now).Seconds())+1))
+	respond(w, 429, fmt.Sprintf("The bandwidth quota of %d bytes per day is used up", bandwidthQuota))
+	return false
+}
+
+// recordBandwidth adds `bytes` streamed to `id`. Failures are only logged, as
+// the response has been sent.
+func recordBandwidth(r *http.Request, id string, bytes int64) {
+	if bytes == 0 {
+		return
+	}
+	// The request context may be done when the client went away.
+	ctx, cancel := context.WithTimeout(context.Background(), metadataDeadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err == nil {
+		err = benten.AddBandwidth(ctx, client, id, time.Now(), bytes)
+		client.Close()
+	}
+	if err != nil {
+		logf(r.Context(), severityError, "Failed to record %d bytes streamed to %s: %v", bytes, id, err)
+	}
+}
+
+// countingWriter counts the bytes written to `w`.
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// stats responds with the bandwidth used on `day` (today by default) and the
+// quota, which is zero if there is none. Admins get the usage of every
+// identity, and the others their own.
+func stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = benten.BandwidthDay(time.Now())
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		respond(w, 400, fmt.Sprintf("day (%v) is invalid", day))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), adminDeadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	usage := make([]benten.BandwidthUsage, 0)
+	if isAdmin(r) {
+		_, err = client.GetAll(ctx, datastore.NewQuery(benten.BandwidthKind).Filter("Day =", day), &usage)
+	} else {
+		id := identity(r)
+		var bytes int64
+		bytes, err = benten.LoadBandwidth(ctx, client, id, day)
+		usage = append(usage, benten.BandwidthUsage{Identity: id, Day: day, Bytes: bytes})
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the bandwidth usage: %v", err))
+		return
+	}
+	respondJSON(w, 200, struct {
+		Day       string
+		Quota     int64
+		Bandwidth []benten.BandwidthUsage
+	}{day, bandwidthQuota, usage})
+}
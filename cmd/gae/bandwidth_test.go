@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdentity(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/get", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if got := identity(r); got != "ip:192.0.2.1" {
+		t.Errorf("identity = %q", got)
+	}
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	if got := identity(r); got != "ip:198.51.100.7" {
+		t.Errorf("identity = %q behind a load balancer", got)
+	}
+	r.Header.Set("X-Appengine-User-IP", "198.51.100.8")
+	if got := identity(r); got != "ip:198.51.100.8" {
+		t.Errorf("identity = %q on App Engine", got)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// carTemplate is the page of the car mode: large touch targets, no scripts
+// but to advance on ended, and a direct link to the audio for the players
+// without <audio>.
+var carTemplate = template.Must(template.New("car").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Playlist}}{{.Playlist}}{{else}}Playlists{{end}}</title>
+<style>
+body { margin: 0; font: 24px sans-serif; background: #000; color: #fff; }
+a { display: block; padding: 24px 16px; border-bottom: 1px solid #333; color: #fff; text-decoration: none; }
+a.current { background: #1a3a5a; }
+small { color: #aaa; }
+h1 { margin: 16px; font-size: 28px; }
+p { margin: 16px; }
+audio { width: 100%; height: 64px; }
+table { width: 100%; border-collapse: collapse; }
+td { width: 50%; text-align: center; font-size: 32px; }
+</style>
+</head>
+<body>
+{{if .Playlist}}
+<a href="{{.Home}}">&larr; Playlists</a>
+<h1>{{.Playlist}}</h1>
+{{with .Current}}
+<p>{{.Title}}<br><small>{{.Artist}}</small></p>
+<audio src="{{.StreamURL}}" controls autoplay data-next="{{$.Next}}" onended="location.href = this.getAttribute('data-next')"></audio>
+<a href="{{.StreamURL}}">Open the audio</a>
+{{end}}
+<table><tr><td><a href="{{.Prev}}">&#9664;&#9664; Prev</a></td><td><a href="{{.Next}}">Next &#9654;&#9654;</a></td></tr></table>
+{{range .Tracks}}<a href="{{.Link}}"{{if .Current}} class="current"{{end}}>{{.Title}}<br><small>{{.Artist}}</small></a>
+{{end}}
+{{else}}
+<h1>Playlists</h1>
+{{range .Playlists}}<a href="{{.Link}}">{{.Name}}</a>
+{{else}}<p>No playlists</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// carPage is the data of carTemplate.
+type carPage struct {
+	Home      string
+	Playlists []carLink
+	// Playlist is the name of the playlist being played, or empty for the
+	// list of the playlists.
+	Playlist   string
+	Current    *intentTrack
+	Prev, Next string
+	Tracks     []carTrack
+}
+
+type carLink struct {
+	Name string
+	Link string
+}
+
+type carTrack struct {
+	Title, Artist string
+	Link          string
+	Current       bool
+}
+
+// car serves the car mode, a minimal HTML page for the browsers of cars and
+// in-dash systems: the playlists, or the queue of the playlist `playlist`
+// playing its `i`th piece. Links keep the `session` of the request, as some
+// of those browsers lose cookies.
+func car(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	link := func(params url.Values) string {
+		if session := q.Get("session"); session != "" {
+			params.Set("session", session)
+		}
+		if len(params) == 0 {
+			return "/api/car"
+		}
+		return "/api/car?" + params.Encode()
+	}
+	deadline := browseDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	page := carPage{Home: link(url.Values{})}
+	name := q.Get("playlist")
+	if name == "" {
+		keys, err := client.GetAll(ctx, datastore.NewQuery(benten.PlaylistKind).KeysOnly(), nil)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get playlists: %v", err))
+			return
+		}
+		for _, key := range keys {
+			page.Playlists = append(page.Playlists, carLink{key.Name, link(url.Values{"playlist": {key.Name}})})
+		}
+		respondHTML(w, &page)
+		return
+	}
+
+	intent := playIntent{Type: intentPlaylist, Name: name}
+	err = loadTracks(ctx, client, r, &intent, false)
+	if err == datastore.ErrNoSuchEntity {
+		respond(w, 404, fmt.Sprintf("Not found: playlist %s", name))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the playlist: %v", err))
+		return
+	}
+	current := 0
+	if i := q.Get("i"); i != "" {
+		current, err = strconv.Atoi(i)
+		if err != nil || current < 0 || (current >= len(intent.Tracks) && len(intent.Tracks) > 0) {
+			respond(w, 400, fmt.Sprintf("i (%v) is invalid", i))
+			return
+		}
+	}
+	at := func(i int) string {
+		return link(url.Values{"playlist": {name}, "i": {strconv.Itoa(i)}})
+	}
+	page.Playlist = name
+	if n := len(intent.Tracks); n > 0 {
+		page.Current = &intent.Tracks[current]
+		page.Prev = at((current + n - 1) % n)
+		page.Next = at((current + 1) % n)
+	}
+	for i, track := range intent.Tracks {
+		page.Tracks = append(page.Tracks, carTrack{track.Title, track.Artist, at(i), i == current})
+	}
+	respondHTML(w, &page)
+}
+
+// respondHTML responds with carTemplate rendered with `page`.
+func respondHTML(w http.ResponseWriter, page *carPage) {
+	var b bytes.Buffer
+	if err := carTemplate.Execute(&b, page); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to render the page: %v", err))
+		return
+	}
+	writeHead(w, 200, header{ContentType: "text/html; charset=utf-8", ContentLength: int64(b.Len()), CacheControl: "no-store"})
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRespondHTML(t *testing.T) {
+	page := carPage{
+		Home:     "/api/car",
+		Playlist: "Drive <Home>",
+		Current:  &intentTrack{Title: "Road", Artist: "Nick Drake", StreamURL: "https://example.com/api/get?key=k0"},
+		Prev:     "/api/car?i=1&playlist=Drive",
+		Next:     "/api/car?i=1&playlist=Drive",
+		Tracks: []carTrack{
+			{Title: "Road", Artist: "Nick Drake", Link: "/api/car?i=0&playlist=Drive", Current: true},
+			{Title: "Hazey Jane", Artist: "Nick Drake", Link: "/api/car?i=1&playlist=Drive"},
+		},
+	}
+	w := httptest.NewRecorder()
+	respondHTML(w, &page)
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("code = %d, header = %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"<h1>Drive &lt;Home&gt;</h1>",
+		`<audio src="https://example.com/api/get?key=k0"`,
+		`data-next="/api/car?i=1&amp;playlist=Drive"`,
+		`<a href="/api/car?i=0&amp;playlist=Drive" class="current">Road`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q is not in %s", want, body)
+		}
+	}
+}
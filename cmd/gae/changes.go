@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// removedPiece is a piece removed from the library.
+type removedPiece struct {
+	Key  string
+	Hash string
+	Path string
+	Time time.Time
+	// Purged is true if the piece was deleted, and false if it is in the
+	// trash, from which it may be restored as an update.
+	Purged bool
+}
+
+// changes responds with the pieces added, updated and removed after `from`
+// and up to `to` (now by default), both RFC 3339, so that clients can sync
+// deltas. Pieces are classified by their last change in the window and
+// listed in the order of it, followed by the purged ones; at most `limit` of
+// them a page, up to the returned cursor, which is empty at the end. `from`
+// must be within benten.TombstoneRetention, as the older deletions are lost.
+func changes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, q.Get("from"))
+	if err != nil {
+		respond(w, 400, fmt.Sprintf("from (%v) is invalid", q.Get("from")))
+		return
+	}
+	if time.Since(from) > benten.TombstoneRetention {
+		respond(w, 410, "from is older than the tombstones; list the whole library instead")
+		return
+	}
+	to := time.Now()
+	if toString := q.Get("to"); toString != "" {
+		to, err = time.Parse(time.RFC3339, toString)
+		if err != nil || to.Before(from) {
+			respond(w, 400, fmt.Sprintf("to (%v) is invalid", toString))
+			return
+		}
+	}
+	limit, err := parseLimit(q, 1000, 10*1000)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	// The cursor is "pieces:<cursor>" or "tombstones:<cursor>", as the
+	// pieces are listed first.
+	phase, cursorString := "pieces", ""
+	if c := q.Get("cursor"); c != "" {
+		parts := strings.SplitN(c, ":", 2)
+		if len(parts) != 2 || (parts[0] != "pieces" && parts[0] != "tombstones") {
+			respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", c))
+			return
+		}
+		phase, cursorString = parts[0], parts[1]
+	}
+	var cursor datastore.Cursor
+	if cursorString != "" {
+		cursor, err = datastore.DecodeCursor(cursorString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", q.Get("cursor")))
+			return
+		}
+	}
+
+	deadline := browseDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	response := struct {
+		Added   []entry
+		Updated []entry
+		Removed []removedPiece
+		Cursor  string
+	}{Added: []entry{}, Updated: []entry{}, Removed: []removedPiece{}}
+	count := 0
+	var t *datastore.Iterator
+	if phase == "pieces" {
+		query := datastore.NewQuery(benten.PieceKind).Filter("Updated >", from).Filter("Updated <=", to).Order("Updated").Limit(limit)
+		if cursorString != "" {
+			query = query.Start(cursor)
+		}
+		t = client.Run(ctx, query)
+		for {
+			var piece benten.Metadata
+			key, err := t.Next(&piece)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+				return
+			}
+			count++
+			switch {
+			case piece.IsTrashed():
+				response.Removed = append(response.Removed, removedPiece{Key: key.Encode(), Hash: piece.Hash, Path: piece.Path, Time: piece.Deleted})
+			case piece.Created.After(from):
+				response.Added = append(response.Added, entry{key.Encode(), piece})
+			default:
+				response.Updated = append(response.Updated, entry{key.Encode(), piece})
+			}
+		}
+		if count < limit {
+			// The tombstones follow on the same page.
+			phase, cursorString = "tombstones", ""
+		}
+	}
+	if phase == "tombstones" {
+		query := datastore.NewQuery(benten.TombstoneKind).Filter("Deleted >", from).Filter("Deleted <=", to).Order("Deleted").Limit(limit - count)
+		if cursorString != "" {
+			query = query.Start(cursor)
+		}
+		t = client.Run(ctx, query)
+		n := 0
+		for {
+			var tombstone benten.Tombstone
+			_, err := t.Next(&tombstone)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to get tombstones: %v", err))
+				return
+			}
+			n++
+			response.Removed = append(response.Removed, removedPiece{Key: tombstone.Key, Hash: tombstone.Hash, Path: tombstone.Path, Time: tombstone.Deleted, Purged: true})
+		}
+		count += n
+	}
+	if count == limit {
+		next, err := t.Cursor()
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get a cursor: %v", err))
+			return
+		}
+		response.Cursor = phase + ":" + next.String()
+	}
+	respondJSON(w, 200, response)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The deployment-level configuration of the clients, given by LIBRARY_NAME,
+// DEFAULT_ARTWORK (the URL of the picture of pieces without one) and
+// THEME_COLOR ("#rrggbb").
+var (
+	libraryName    = os.Getenv("LIBRARY_NAME")
+	defaultArtwork = os.Getenv("DEFAULT_ARTWORK")
+	themeColor     = os.Getenv("THEME_COLOR")
+)
+
+var themeColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// clientConfig is what the clients adapt to rather than hardcoding it.
+type clientConfig struct {
+	Name           string
+	DefaultArtwork string
+	ThemeColor     string
+	// Features are the feature flags enabled for the client.
+	Features     []string
+	Capabilities clientCapabilities
+	Limits       clientLimits
+}
+
+type clientCapabilities struct {
+	// Standalone means only get, list, all and config are available.
+	Standalone bool
+	// ReadOnly means the mutating requests are rejected for maintenance.
+	ReadOnly   bool
+	Sessions   bool
+	StreamAuth bool
+	SignedURLs bool
+	// Search is the search backend, such as "datastore".
+	Search string
+}
+
+type clientLimits struct {
+	Search           int
+	QueryLength      int
+	ExistsHashes     int
+	CachedEntries    int
+	PlaylistFileSize int
+	// BandwidthQuota is the bytes a client may stream per day, or zero if
+	// unlimited.
+	BandwidthQuota int64
+}
+
+// checkClientConfig returns an error for each malformed client configuration.
+func checkClientConfig() []error {
+	var errs []error
+	if defaultArtwork != "" {
+		u, err := url.Parse(defaultArtwork)
+		if err != nil || !(u.Scheme == "https" || u.Scheme == "http" || (u.Scheme == "" && strings.HasPrefix(u.Path, "/"))) {
+			errs = append(errs, fmt.Errorf("DEFAULT_ARTWORK (%q) is not an http(s) URL nor an absolute path", defaultArtwork))
+		}
+	}
+	if themeColor != "" && !themeColorPattern.MatchString(themeColor) {
+		errs = append(errs, fmt.Errorf("THEME_COLOR (%q) is not a color such as \"#336699\"", themeColor))
+	}
+	return errs
+}
+
+// newClientConfig returns the configuration with no features nor
+// maintenance, which are up to the caller.
+func newClientConfig() clientConfig {
+	name := libraryName
+	if name == "" {
+		name = "Benten"
+	}
+	backend := searchConfig().Backend
+	if backend == "" {
+		backend = "datastore"
+	}
+	return clientConfig{
+		Name:           name,
+		DefaultArtwork: defaultArtwork,
+		ThemeColor:     themeColor,
+		Features:       make([]string, 0),
+		Capabilities: clientCapabilities{
+			Standalone: standaloneStore != nil,
+			Sessions:   len(sessionKeys) > 0,
+			StreamAuth: streamAuth,
+			SignedURLs: signedURLSigner != nil,
+			Search:     backend,
+		},
+		Limits: clientLimits{
+			Search:           maxSearchLimit,
+			QueryLength:      maxQueryLength,
+			ExistsHashes:     maxExistsHashes,
+			CachedEntries:    maxCachedEntries,
+			PlaylistFileSize: maxPlaylistFileSize,
+			BandwidthQuota:   bandwidthQuota,
+		},
+	}
+}
+
+// getClientConfig responds with the clientConfig. The features and the
+// maintenance mode are left out when they can't be loaded, as the rest is
+// still of use.
+func getClientConfig(w http.ResponseWriter, r *http.Request) {
+	config := newClientConfig()
+	if config.Capabilities.Standalone {
+		config.Capabilities.ReadOnly = true
+		respondJSON(w, 200, config)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+	defer cancel()
+	if m, err := currentMaintenance(ctx); err != nil {
+		logf(r.Context(), severityError, "Failed to load the maintenance mode: %v", err)
+	} else {
+		config.Capabilities.ReadOnly = m.ReadOnly
+	}
+	if features, err := enabledFeatures(ctx, r); err != nil {
+		logf(r.Context(), severityError, "Failed to load the feature flags: %v", err)
+	} else {
+		config.Features = features
+	}
+	respondJSON(w, 200, config)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestCheckClientConfig(t *testing.T) {
+	defer func(artwork, color string) { defaultArtwork, themeColor = artwork, color }(defaultArtwork, themeColor)
+	cases := []struct {
+		artwork, color string
+		errs           int
+	}{
+		{"", "", 0},
+		{"https://example.com/art.png", "#336699", 0},
+		{"/static/art.png", "", 0},
+		{"art.png", "", 1},
+		{"ftp://example.com/art.png", "blue", 2},
+	}
+	for _, c := range cases {
+		defaultArtwork, themeColor = c.artwork, c.color
+		if errs := checkClientConfig(); len(errs) != c.errs {
+			t.Errorf("checkClientConfig() with %q, %q = %v", c.artwork, c.color, errs)
+		}
+	}
+}
+
+func TestGetClientConfig(t *testing.T) {
+	defer func(old bool) { readOnlyEnv = old }(readOnlyEnv)
+	readOnlyEnv = true
+	featureFlagCache.flags = map[string]benten.FeatureFlag{
+		"b": {Name: "b", Enabled: true},
+		"a": {Name: "a", Enabled: true},
+		"c": {Name: "c"},
+	}
+	featureFlagCache.loadedAt = time.Now()
+	defer func() { featureFlagCache.flags = nil }()
+
+	w := httptest.NewRecorder()
+	getClientConfig(w, httptest.NewRequest("GET", "/api/config", nil))
+	if w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var config clientConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "Benten" || !config.Capabilities.ReadOnly || config.Limits.Search != maxSearchLimit {
+		t.Errorf("config = %+v", config)
+	}
+	if len(config.Features) != 2 || config.Features[0] != "a" || config.Features[1] != "b" {
+		t.Errorf("Features = %v", config.Features)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// defaultLocale is the locale names are sorted in without `locale`, given by
+// COLLATION_LOCALE, such as "ja". The root collation is used without both,
+// which already orders most scripts better than the byte order.
+var defaultLocale = os.Getenv("COLLATION_LOCALE")
+
+// parseCollator returns the collator of `locale`, a BCP 47 tag such as
+// "ja" or "ru", or of defaultLocale. Collators are not safe for concurrent
+// use, so each request makes its own.
+func parseCollator(q url.Values) (*collate.Collator, error) {
+	name := q.Get("locale")
+	if name == "" {
+		name = defaultLocale
+	}
+	if name == "" {
+		return collate.New(language.Und, collate.IgnoreCase), nil
+	}
+	tag, err := language.Parse(name)
+	if err != nil {
+		return nil, fmt.Errorf("locale (%v) is invalid", name)
+	}
+	return collate.New(tag, collate.IgnoreCase), nil
+}
+
+// compareNames compares `a` and `b` with `c`, the empty names last.
+func compareNames(c *collate.Collator, a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	return c.CompareString(a, b)
+}
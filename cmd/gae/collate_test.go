@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestSortPiecesByArtist(t *testing.T) {
+	collator, err := parseCollator(url.Values{"locale": {"ru"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieces := []*benten.Metadata{
+		{Artist: "Ёлка"},
+		{Artist: "Жанна Агузарова"},
+		{},
+		{Artist: "абба", Track: 2},
+		{Artist: "Алла Пугачёва", Album: "Зеркало души"},
+		{Artist: "Абба", Track: 1},
+	}
+	sortPieces(pieces, "artist", collator, nil)
+	var artists []string
+	for _, piece := range pieces {
+		artists = append(artists, piece.Artist)
+	}
+	want := []string{"Абба", "абба", "Алла Пугачёва", "Ёлка", "Жанна Агузарова", ""}
+	for i := range want {
+		if artists[i] != want[i] {
+			t.Fatalf("artists = %q, want %q", artists, want)
+		}
+	}
+
+	if _, err := parseCollator(url.Values{"locale": {"not a locale"}}); err == nil {
+		t.Errorf("an invalid locale is parsed")
+	}
+}
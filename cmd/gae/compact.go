@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/yutakahirano/benten"
+)
+
+// compactPiece is a piece of the compact list format, for clients on slow
+// connections: only what a player shows, with one-letter keys. The URLs are
+// relative to the server. Durations are not included because the syncer
+// doesn't record them.
+type compactPiece struct {
+	ID     string `json:"i"`
+	Title  string `json:"t,omitempty"`
+	Artist string `json:"a,omitempty"`
+	// Art is the URL of the album picture, if any.
+	Art    string `json:"p,omitempty"`
+	Stream string `json:"s"`
+}
+
+// compact returns the compact form of `piece`, whose key (the encoded
+// datastore key, or the path in the standalone mode) is `id`.
+func compact(id string, piece *benten.Metadata) compactPiece {
+	c := compactPiece{
+		ID:     id,
+		Title:  piece.Title,
+		Artist: piece.Artist,
+		Stream: "/api/get?" + url.Values{"key": {id}}.Encode(),
+	}
+	if piece.Picture != "" {
+		c.Art = "/api/get?" + url.Values{"bucket": {benten.AlbumPictureBucket}, "name": {piece.Picture}}.Encode()
+	}
+	return c
+}
+
+// parseFormat parses the `format` parameter, which is "compact" or empty. The
+// compact format can't be combined with `fields`.
+func parseFormat(q url.Values, fields []string) (bool, error) {
+	switch format := q.Get("format"); format {
+	case "":
+		return false, nil
+	case "compact":
+		if fields != nil {
+			return false, errors.New("fields can't be used with format=compact")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("format (%v) is invalid", format)
+	}
+}
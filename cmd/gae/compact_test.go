@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestCompact(t *testing.T) {
+	piece := &benten.Metadata{Title: "Help!", Artist: "The Beatles", Album: "Help!", Picture: "abc"}
+	got := compact("k1", piece)
+	want := compactPiece{
+		ID:     "k1",
+		Title:  "Help!",
+		Artist: "The Beatles",
+		Art:    "/api/get?bucket=" + benten.AlbumPictureBucket + "&name=abc",
+		Stream: "/api/get?key=k1",
+	}
+	if got != want {
+		t.Errorf("compact = %+v, want %+v", got, want)
+	}
+	data, err := json.Marshal(compactPiece{ID: "k1", Stream: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"i":"k1","s":"s"}` {
+		t.Errorf("JSON = %s", data)
+	}
+
+	if _, err := parseFormat(url.Values{"format": {"compact"}}, []string{"Title"}); err == nil {
+		t.Errorf("format=compact with fields must be rejected")
+	}
+	if _, err := parseFormat(url.Values{"format": {"tiny"}}, nil); err == nil {
+		t.Errorf("unknown formats must be rejected")
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/artwork"
+	"github.com/yutakahirano/benten/lyrics"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/text/language"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// The environment variables read as durations and sizes. Invalid values are
+// reported at startup rather than silently replaced with the defaults.
+var (
+	durationVariables = []string{
+		"HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_IDLE_TIMEOUT", "SHUTDOWN_TIMEOUT",
+		"METADATA_DEADLINE", "STREAM_IDLE_TIMEOUT", "LIST_DEADLINE", "BROWSE_DEADLINE",
+		"ADMIN_DEADLINE", "INDEX_FANOUT_DEADLINE", "INDEX_WORKER_DEADLINE",
+		"JOB_POLL_INTERVAL", "SEARCH_CACHE_TTL", "TRASH_RETENTION", "SIGNED_URL_TTL", "SESSION_TTL",
+		"MAINTENANCE_RETRY_AFTER",
+	}
+	byteVariables = []string{"ART_CACHE_BYTES", "ART_CACHE_MAX_OBJECT_BYTES", "BANDWIDTH_QUOTA"}
+)
+
+// The minimum length of ADMIN_TOKEN.
+const minAdminTokenLength = 16
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+
+// adminToken is the token required by the admin API, or empty to disable it.
+var adminToken string
+
+// signedURLSigner signs URLs of pieces, so that clients download them from
+// GCS directly, or is nil to stream pieces through the server.
+var signedURLSigner *urlSigner
+
+// signedURLTTL is how long signed URLs are valid.
+var signedURLTTL = envDuration("SIGNED_URL_TTL", 15*time.Minute)
+
+// searchAPIKey is the API key of the external search backend.
+var searchAPIKey string
+
+// urlSigner is the service account signing URLs.
+type urlSigner struct {
+	email      string
+	privateKey []byte
+}
+
+// secretEnv returns the secret in the environment variable `name`, which may
+// be a secret reference such as "sm://admin-token" (see
+// benten.ResolveSecret), or the contents of the file named by `name`_FILE,
+// such as a secret mounted by Cloud Run.
+func secretEnv(name string) (string, error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%s_FILE: %v", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secret, err := benten.ResolveSecret(ctx, projectID, os.Getenv(name))
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return secret, nil
+}
+
+// checkEnv returns an error for each malformed environment variable.
+func checkEnv() []error {
+	var errs []error
+	for _, name := range durationVariables {
+		if value := os.Getenv(name); value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				errs = append(errs, fmt.Errorf("%s (%q) is not a positive duration such as \"30s\"", name, value))
+			}
+		}
+	}
+	for _, name := range byteVariables {
+		if value := os.Getenv(name); value != "" {
+			if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 0 {
+				errs = append(errs, fmt.Errorf("%s (%q) is not a number of bytes", name, value))
+			}
+		}
+	}
+	if value := os.Getenv("INDEX_WORKER_RATE"); value != "" {
+		if rate, err := strconv.ParseFloat(value, 64); err != nil || rate <= 0 {
+			errs = append(errs, fmt.Errorf("INDEX_WORKER_RATE (%q) is not a positive number", value))
+		}
+	}
+	if locale := os.Getenv("COLLATION_LOCALE"); locale != "" {
+		if _, err := language.Parse(locale); err != nil {
+			errs = append(errs, fmt.Errorf("COLLATION_LOCALE (%q) is not a BCP 47 language tag", locale))
+		}
+	}
+	errs = append(errs, checkClientConfig()...)
+	if port := os.Getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			errs = append(errs, fmt.Errorf("PORT (%q) is not a port number", port))
+		}
+	}
+	return errs
+}
+
+// configure reads the configuration of the server from the environment,
+// which works both on GAE and on Cloud Run. All the problems found are
+// reported together.
+func configure() error {
+	errs := checkEnv()
+
+	projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if projectID == "" && metadata.OnGCE() {
+		// Cloud Run doesn't set GOOGLE_CLOUD_PROJECT.
+		id, err := metadata.ProjectID()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("GOOGLE_CLOUD_PROJECT is not set and the metadata server failed: %v", err))
+		}
+		projectID = id
+	}
+	if projectID == "" && os.Getenv("STANDALONE_DIR") == "" {
+		errs = append(errs, errors.New("GOOGLE_CLOUD_PROJECT is not set"))
+	}
+
+	for _, bucket := range []struct {
+		name string
+		dest *string
+	}{{"PIECE_BUCKET", &benten.PieceBucket}, {"ALBUM_PICTURE_BUCKET", &benten.AlbumPictureBucket}} {
+		value := os.Getenv(bucket.name)
+		if value == "" {
+			continue
+		}
+		if !bucketNamePattern.MatchString(value) {
+			errs = append(errs, fmt.Errorf("%s (%q) is not a valid bucket name", bucket.name, value))
+			continue
+		}
+		*bucket.dest = value
+	}
+
+	token, err := secretEnv("ADMIN_TOKEN")
+	if err != nil {
+		errs = append(errs, err)
+	} else if token != "" && len(token) < minAdminTokenLength {
+		errs = append(errs, fmt.Errorf("ADMIN_TOKEN must be at least %d characters long", minAdminTokenLength))
+	} else {
+		adminToken = token
+	}
+
+	key, err := secretEnv("SIGNED_URL_KEY")
+	if err != nil {
+		errs = append(errs, err)
+	} else if key != "" {
+		config, err := google.JWTConfigFromJSON([]byte(key))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SIGNED_URL_KEY is not a service account key: %v", err))
+		} else {
+			signedURLSigner = &urlSigner{email: config.Email, privateKey: config.PrivateKey}
+		}
+	}
+
+	sessionKeys = nil
+	if keys, err := secretEnv("SESSION_KEYS"); err != nil {
+		errs = append(errs, err)
+	} else if keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			if len(key) < minAdminTokenLength {
+				errs = append(errs, fmt.Errorf("SESSION_KEYS must be at least %d characters long each", minAdminTokenLength))
+				break
+			}
+			sessionKeys = append(sessionKeys, []byte(key))
+		}
+	}
+	streamAuth = os.Getenv("STREAM_AUTH") == "1"
+	if streamAuth && adminToken == "" && sessionKeys == nil {
+		errs = append(errs, errors.New("STREAM_AUTH needs ADMIN_TOKEN or SESSION_KEYS"))
+	}
+
+	searchAPIKey, err = secretEnv("SEARCH_API_KEY")
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Artist photos come from Last.fm and fanart.tv when their API keys are
+	// given, and from Wikipedia, which needs none.
+	artistProviders = nil
+	if key, err := secretEnv("LASTFM_API_KEY"); err != nil {
+		errs = append(errs, err)
+	} else if key != "" {
+		artistProviders = append(artistProviders, artwork.LastFM{APIKey: key})
+	}
+	if key, err := secretEnv("FANART_API_KEY"); err != nil {
+		errs = append(errs, err)
+	} else if key != "" {
+		artistProviders = append(artistProviders, artwork.FanartTV{APIKey: key})
+	}
+	artistProviders = append(artistProviders, artwork.Wikipedia{})
+
+	// Lyrics come from LRCLIB, which has synced ones, and from Genius when
+	// its access token is given.
+	lyricsProviders = []lyrics.Provider{lyrics.LRCLIB{}}
+	if token, err := secretEnv("GENIUS_ACCESS_TOKEN"); err != nil {
+		errs = append(errs, err)
+	} else if token != "" {
+		lyricsProviders = append(lyricsProviders, lyrics.Genius{AccessToken: token})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = "  " + err.Error()
+	}
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// configWarnings returns what is allowed but likely unintended.
+func configWarnings() []string {
+	var warnings []string
+	if adminToken == "" {
+		warnings = append(warnings, "ADMIN_TOKEN is not set, so the admin API is disabled")
+	}
+	if !streamAuth {
+		warnings = append(warnings, "STREAM_AUTH is not 1, so anyone who knows the URL can stream pieces")
+	}
+	return warnings
+}
+
+// checkBuckets returns an error for each bucket the server can't list, so that
+// a wrong PIECE_BUCKET or missing permissions fail the startup rather than
+// every request.
+func checkBuckets(ctx context.Context) error {
+	client, err := storage.NewClient(ctx, benten.StorageOptions()...)
+	if err != nil {
+		return fmt.Errorf("  failed to create a storage client: %v", err)
+	}
+	defer client.Close()
+	var messages []string
+	for _, bucket := range []struct{ variable, name string }{
+		{"PIECE_BUCKET", benten.PieceBucket}, {"ALBUM_PICTURE_BUCKET", benten.AlbumPictureBucket},
+	} {
+		_, err := client.Bucket(bucket.name).Objects(ctx, nil).Next()
+		if err == nil || err == iterator.Done {
+			continue
+		}
+		var message string
+		apiErr, ok := err.(*googleapi.Error)
+		switch {
+		case err == storage.ErrBucketNotExist || ok && apiErr.Code == 404:
+			message = fmt.Sprintf("%s: the bucket %q doesn't exist; create it, such as with cmd/init, or set %s", bucket.variable, bucket.name, bucket.variable)
+		case ok && apiErr.Code == 403:
+			message = fmt.Sprintf("%s: the service account can't read the bucket %q; grant it roles/storage.objectAdmin on the bucket", bucket.variable, bucket.name)
+		default:
+			message = fmt.Sprintf("%s: failed to read the bucket %q: %v", bucket.variable, bucket.name, err)
+		}
+		messages = append(messages, "  "+message)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "\n"))
+}
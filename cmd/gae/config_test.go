@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestConfigure(t *testing.T) {
+	env := map[string]string{
+		"GOOGLE_CLOUD_PROJECT": "project",
+		"LIST_DEADLINE":        "10",
+		"PIECE_BUCKET":         "Pieces",
+		"ADMIN_TOKEN":          "short",
+	}
+	defer func(bucket string) { benten.PieceBucket = bucket }(benten.PieceBucket)
+	for name, value := range env {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+	err := configure()
+	if err == nil {
+		t.Fatal("configure succeeded")
+	}
+	for _, name := range []string{"LIST_DEADLINE", "PIECE_BUCKET", "ADMIN_TOKEN"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s is not reported: %v", name, err)
+		}
+	}
+
+	os.Setenv("LIST_DEADLINE", "10s")
+	os.Setenv("PIECE_BUCKET", "my-pieces")
+	os.Setenv("ADMIN_TOKEN", "")
+	os.Setenv("ADMIN_TOKEN_FILE", "/nonexistent")
+	defer os.Unsetenv("ADMIN_TOKEN_FILE")
+	if err := configure(); err == nil || !strings.Contains(err.Error(), "ADMIN_TOKEN_FILE") {
+		t.Errorf("err = %v for a missing ADMIN_TOKEN_FILE", err)
+	}
+}
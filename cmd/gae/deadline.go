@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Deadlines of the handlers, each of which can be overridden by the
+// environment variable named next to it.
+var (
+	// metadataDeadline bounds looking up an object before streaming it, and
+	// loading an album picture into the cache.
+	metadataDeadline = envDuration("METADATA_DEADLINE", 10*time.Second)
+	// streamIdleTimeout aborts streaming an object when no data is copied for
+	// that long. Streams have no total deadline other than HTTP_WRITE_TIMEOUT.
+	streamIdleTimeout   = envDuration("STREAM_IDLE_TIMEOUT", 30*time.Second)
+	listDeadline        = envDuration("LIST_DEADLINE", 10*time.Second)
+	browseDeadline      = envDuration("BROWSE_DEADLINE", time.Minute) // /api/all and /api/duplicates
+	adminDeadline       = envDuration("ADMIN_DEADLINE", time.Minute)  // aliases and playlist imports
+	fanoutDeadline      = envDuration("INDEX_FANOUT_DEADLINE", 5*time.Minute)
+	indexWorkerDeadline = envDuration("INDEX_WORKER_DEADLINE", 30*time.Second)
+)
+
+// idleTimer cancels a context when it is not reset for a while.
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// withIdleTimeout returns a context derived from `parent` which is canceled
+// when the returned timer is not reset for `timeout`.
+func withIdleTimeout(parent context.Context, timeout time.Duration) (context.Context, *idleTimer, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	t := &idleTimer{timer: time.AfterFunc(timeout, cancel), timeout: timeout}
+	return ctx, t, func() {
+		t.timer.Stop()
+		cancel()
+	}
+}
+
+func (t *idleTimer) reset() {
+	t.timer.Reset(t.timeout)
+}
+
+// idleWriter resets `timer` on every write.
+type idleWriter struct {
+	w     io.Writer
+	timer *idleTimer
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.timer.reset()
+	return n, err
+}
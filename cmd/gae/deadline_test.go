@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, timer, cancel := withIdleTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	w := idleWriter{ioutil.Discard, timer}
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("x"))
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("canceled while writing")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("not canceled after stalling")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// metadataEdit is the body of an edit request. Absent fields are kept.
+type metadataEdit struct {
+	Title        *string
+	Album        *string
+	Artist       *string
+	AlbumArtist  *string
+	Compilation  *bool
+	Composer     *string
+	Genre        *string
+	Year         *int
+	OriginalYear *int
+	Track        *int
+	TotalTracks  *int
+	Disc         *int
+	TotalDisks   *int
+	Comment      *string
+	BPM          *float64
+	Key          *string
+}
+
+func (e *metadataEdit) apply(m *benten.Metadata, aliases benten.Aliases) {
+	setString := func(dest *string, src *string) {
+		if src != nil {
+			*dest = *src
+		}
+	}
+	setInt := func(dest *int, src *int) {
+		if src != nil {
+			*dest = *src
+		}
+	}
+	setString(&m.Title, e.Title)
+	setString(&m.Album, e.Album)
+	setString(&m.Artist, e.Artist)
+	setString(&m.AlbumArtist, e.AlbumArtist)
+	if e.Compilation != nil {
+		m.Compilation = *e.Compilation
+	}
+	setString(&m.Composer, e.Composer)
+	setString(&m.Genre, e.Genre)
+	setInt(&m.Year, e.Year)
+	setInt(&m.OriginalYear, e.OriginalYear)
+	setInt(&m.Track, e.Track)
+	setInt(&m.TotalTracks, e.TotalTracks)
+	setInt(&m.Disc, e.Disc)
+	setInt(&m.TotalDisks, e.TotalDisks)
+	setString(&m.Comment, e.Comment)
+	if e.BPM != nil {
+		m.BPM = *e.BPM
+	}
+	if e.Key != nil {
+		m.Key = benten.NormalizeKey(*e.Key)
+	}
+	// The edited fields have been reviewed.
+	for field, edited := range map[string]bool{
+		"Title":       e.Title != nil,
+		"Album":       e.Album != nil,
+		"Artist":      e.Artist != nil,
+		"AlbumArtist": e.AlbumArtist != nil,
+		"Composer":    e.Composer != nil,
+		"Genre":       e.Genre != nil,
+		"Year":        e.Year != nil,
+		"Track":       e.Track != nil,
+		"Disc":        e.Disc != nil,
+	} {
+		if edited {
+			m.ClearInferred(field)
+		}
+	}
+	m.SetAlbumKey(aliases)
+	m.SetNeedsReview()
+}
+
+// etag returns the ETag of `m`.
+func etag(m *benten.Metadata) string {
+	return strconv.Quote(strconv.Itoa(m.Revision))
+}
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// editPiece edits the piece at `key` if its ETag is `ifMatch`, and returns
+// the metadata before and after the edit.
+func editPiece(ctx context.Context, client *datastore.Client, key *datastore.Key, ifMatch string, edit *metadataEdit, aliases benten.Aliases) (*benten.Metadata, *benten.Metadata, error) {
+	var before, piece benten.Metadata
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, &piece); err != nil {
+			return err
+		}
+		if strings.TrimPrefix(ifMatch, "W/") != etag(&piece) {
+			return errPreconditionFailed
+		}
+		before = piece
+		edit.apply(&piece, aliases)
+		now := time.Now()
+		piece.Revision++
+		piece.Edited = now
+		piece.Updated = now
+		_, err := tx.Put(key, &piece)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &before, &piece, nil
+}
+
+// adminPieces responds with the metadata of the piece `key` with its ETag
+// (GET), edits it (PATCH), or moves it to the trash (DELETE). Edits require
+// If-Match with the ETag so that concurrent edits, including the syncer's,
+// are not lost.
+func adminPieces(w http.ResponseWriter, r *http.Request) {
+	keyString := r.URL.Query().Get("key")
+	key, err := datastore.DecodeKey(keyString)
+	if err != nil || key.Kind != benten.PieceKind {
+		respond(w, 400, fmt.Sprintf("key (%v) is invalid", keyString))
+		return
+	}
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		var piece benten.Metadata
+		err := client.Get(ctx, key, &piece)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", keyString))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+		w.Header().Set("ETag", etag(&piece))
+		respondJSON(w, 200, piece)
+	case "PATCH":
+		patchPiece(ctx, w, r, client, key)
+	case "DELETE":
+		err := trashPiece(ctx, client, key)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", keyString))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to trash %s: %v", keyString, err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditPieceTrashed, keyString, "", "")
+		respond(w, 200, "OK")
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// patchPiece edits the piece at `key` with the edit in the body of `r`, and
+// reindexes it.
+func patchPiece(ctx context.Context, w http.ResponseWriter, r *http.Request, client *datastore.Client, key *datastore.Key) {
+	keyString := key.Encode()
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respond(w, 428, "If-Match is required")
+		return
+	}
+	var edit metadataEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+		return
+	}
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to load aliases: %v", err))
+		return
+	}
+	before, piece, err := editPiece(ctx, client, key, ifMatch, &edit, aliases)
+	if err == datastore.ErrNoSuchEntity {
+		respond(w, 404, fmt.Sprintf("Not found: %s", keyString))
+		return
+	}
+	if err == errPreconditionFailed {
+		respond(w, 412, "The piece has been modified")
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to edit metadata: %v", err))
+		return
+	}
+	b, a := benten.DiffMetadata(before, piece)
+	audit(ctx, client, r, benten.AuditPieceEdited, keyString, b, a)
+	// Trashed pieces are not indexed.
+	if !piece.IsTrashed() {
+		if err := benten.RespanIndex(ctx, client, piece, key, aliases); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to respan the index for %v: %v", key, err))
+			return
+		}
+		if externalSearchIndex != nil {
+			if err := externalSearchIndex.Index(ctx, key, piece, aliases); err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to update the search index for %v: %v", key, err))
+				return
+			}
+		}
+	}
+	invalidateSearchCache(ctx)
+	w.Header().Set("ETag", etag(piece))
+	respondJSON(w, 200, piece)
+}
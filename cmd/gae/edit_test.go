@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestEditPiece(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	key := testutil.PutPiece(t, client, benten.Metadata{Title: "Tme", Artist: "Pink Floyd", Revision: 1})
+
+	title := "Time"
+	_, edited, err := editPiece(ctx, client, key, `"1"`, &metadataEdit{Title: &title}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Title != "Time" || edited.Artist != "Pink Floyd" || edited.Revision != 2 || edited.Edited.IsZero() {
+		t.Errorf("edited = %+v", edited)
+	}
+	if _, _, err := editPiece(ctx, client, key, `"1"`, &metadataEdit{Title: &title}, nil); err != errPreconditionFailed {
+		t.Errorf("err = %v with a stale ETag", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// maxExistsHashes is the maximum number of hashes in a batch request.
+const maxExistsHashes = 1000
+
+// exists tells whether pieces with the given content hashes (see
+// benten.Metadata.Hash) are in the library, so that clients can skip
+// uploading them. GET takes a single `hash`, and POST takes a JSON object
+// with Hashes. It responds with the encoded keys of the pieces by hash, which
+// lacks the hashes not in the library.
+func exists(w http.ResponseWriter, r *http.Request) {
+	var hashes []string
+	switch r.Method {
+	case "GET":
+		hash := r.URL.Query().Get("hash")
+		if hash == "" {
+			respond(w, 400, "hash is required")
+			return
+		}
+		hashes = []string{hash}
+	case "POST":
+		var request struct {
+			Hashes []string
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		if len(request.Hashes) > maxExistsHashes {
+			respond(w, 400, fmt.Sprintf("Too many hashes (%d > %d)", len(request.Hashes), maxExistsHashes))
+			return
+		}
+		hashes = request.Hashes
+	default:
+		respond(w, 405, "Method not allowed")
+		return
+	}
+
+	deadline := listDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	found, err := benten.FindByHashes(ctx, client, hashes)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to find pieces: %v", err))
+		return
+	}
+	response := make(map[string][]string, len(found))
+	for hash, keys := range found {
+		for _, key := range keys {
+			response[hash] = append(response[hash], key.Encode())
+		}
+	}
+	respondJSON(w, 200, response)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/yutakahirano/benten"
+)
+
+// maxFacetValues is the number of values reported per facet.
+const maxFacetValues = 10
+
+// facetCount is a value of a facet and the number of pieces having it.
+type facetCount struct {
+	Value string
+	Count int
+}
+
+// facets are the most common values of the pieces in a response, most
+// common first, for clients to render filters.
+type facets struct {
+	Genre    []facetCount
+	Decade   []facetCount
+	FileType []facetCount
+	Artist   []facetCount
+}
+
+// facetCounter counts the values of the facets. Artists are counted by their
+// canonical names in `aliases`.
+type facetCounter struct {
+	genre, decade, fileType, artist map[string]int
+	aliases                         benten.Aliases
+}
+
+func newFacetCounter(aliases benten.Aliases) *facetCounter {
+	return &facetCounter{
+		aliases:  aliases,
+		genre:    make(map[string]int),
+		decade:   make(map[string]int),
+		fileType: make(map[string]int),
+		artist:   make(map[string]int),
+	}
+}
+
+// add counts `m`. Empty values and unknown years are not counted.
+func (c *facetCounter) add(m *benten.Metadata) {
+	count := func(values map[string]int, value string) {
+		if value != "" {
+			values[value]++
+		}
+	}
+	count(c.genre, m.Genre)
+	if m.Year > 0 {
+		count(c.decade, strconv.Itoa(m.Year/10*10)+"s")
+	}
+	count(c.fileType, m.FileType)
+	count(c.artist, c.aliases.Canonical(m.Artist))
+}
+
+func (c *facetCounter) facets() facets {
+	top := func(values map[string]int) []facetCount {
+		counts := make([]facetCount, 0, len(values))
+		for value, count := range values {
+			counts = append(counts, facetCount{value, count})
+		}
+		sort.Slice(counts, func(i, j int) bool {
+			if counts[i].Count != counts[j].Count {
+				return counts[i].Count > counts[j].Count
+			}
+			return counts[i].Value < counts[j].Value
+		})
+		if len(counts) > maxFacetValues {
+			counts = counts[:maxFacetValues]
+		}
+		return counts
+	}
+	return facets{top(c.genre), top(c.decade), top(c.fileType), top(c.artist)}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestFacets(t *testing.T) {
+	counter := newFacetCounter(nil)
+	for _, m := range []benten.Metadata{
+		{Genre: "Rock", Year: 1973, FileType: "FLAC", Artist: "Pink Floyd"},
+		{Genre: "Rock", Year: 1979, FileType: "MP3", Artist: "Pink Floyd"},
+		{Genre: "Jazz", Year: 1959, FileType: "MP3", Artist: "Miles Davis"},
+		{FileType: "MP3"},
+	} {
+		counter.add(&m)
+	}
+	want := facets{
+		Genre:    []facetCount{{"Rock", 2}, {"Jazz", 1}},
+		Decade:   []facetCount{{"1970s", 2}, {"1950s", 1}},
+		FileType: []facetCount{{"MP3", 3}, {"FLAC", 1}},
+		Artist:   []facetCount{{"Pink Floyd", 2}, {"Miles Davis", 1}},
+	}
+	if got := counter.facets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("facets = %+v, want %+v", got, want)
+	}
+}
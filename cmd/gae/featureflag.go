@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// featureFlagCacheDuration is how long the feature flags are cached, which is
+// how long the other instances take to follow a change.
+const featureFlagCacheDuration = 30 * time.Second
+
+var featureFlagNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var featureFlagCache struct {
+	mu       sync.Mutex
+	flags    map[string]benten.FeatureFlag
+	loadedAt time.Time
+}
+
+// cachedFeatureFlags returns the feature flags by name, loading them at most
+// once per featureFlagCacheDuration.
+func cachedFeatureFlags(ctx context.Context) (map[string]benten.FeatureFlag, error) {
+	featureFlagCache.mu.Lock()
+	defer featureFlagCache.mu.Unlock()
+	if featureFlagCache.flags != nil && time.Since(featureFlagCache.loadedAt) < featureFlagCacheDuration {
+		return featureFlagCache.flags, nil
+	}
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	flags, err := benten.LoadFeatureFlags(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	featureFlagCache.flags = make(map[string]benten.FeatureFlag, len(flags))
+	for _, flag := range flags {
+		featureFlagCache.flags[flag.Name] = flag
+	}
+	featureFlagCache.loadedAt = time.Now()
+	return featureFlagCache.flags, nil
+}
+
+// featureEnabled returns true if the feature `name` is enabled for the
+// identity of `r`. Unknown features are disabled, and so are all of them when
+// the flags can't be loaded, so that a failure falls back to the old code.
+func featureEnabled(r *http.Request, name string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+	defer cancel()
+	flags, err := cachedFeatureFlags(ctx)
+	if err != nil {
+		logf(r.Context(), severityError, "Failed to load the feature flags: %v", err)
+		return false
+	}
+	flag, ok := flags[name]
+	return ok && flag.EnabledFor(identity(r))
+}
+
+// enabledFeatures returns the sorted names of the features enabled for the
+// identity of `r`.
+func enabledFeatures(ctx context.Context, r *http.Request) ([]string, error) {
+	flags, err := cachedFeatureFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user := identity(r)
+	enabled := make([]string, 0)
+	for name, flag := range flags {
+		if flag.EnabledFor(user) {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled, nil
+}
+
+// features responds with the names of the features enabled for the client, so
+// that it can follow them too.
+func features(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+	defer cancel()
+	enabled, err := enabledFeatures(ctx, r)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to load the feature flags: %v", err))
+		return
+	}
+	respondJSON(w, 200, struct{ Enabled []string }{enabled})
+}
+
+// adminFeatureFlags lists (GET), puts (PUT) and deletes (DELETE) the feature
+// flags. PUT takes a benten.FeatureFlag, and DELETE takes the `name` query
+// parameter.
+func adminFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		flags, err := benten.LoadFeatureFlags(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the feature flags: %v", err))
+			return
+		}
+		if flags == nil {
+			flags = make([]benten.FeatureFlag, 0)
+		}
+		respondJSON(w, 200, flags)
+		return
+	case "PUT":
+		var flag benten.FeatureFlag
+		if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		if !featureFlagNamePattern.MatchString(flag.Name) {
+			respond(w, 400, fmt.Sprintf("Invalid name: %q", flag.Name))
+			return
+		}
+		var old benten.FeatureFlag
+		err := client.Get(ctx, benten.FeatureFlagKey(flag.Name), &old)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			respond(w, 500, fmt.Sprintf("Failed to get the feature flag: %v", err))
+			return
+		}
+		before := ""
+		if err == nil {
+			before = old.Summary()
+		}
+		flag.Updated = time.Now()
+		if _, err := client.Put(ctx, benten.FeatureFlagKey(flag.Name), &flag); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to put the feature flag: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditFeatureFlagPut, flag.Name, before, flag.Summary())
+		respondJSON(w, 200, flag)
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		var old benten.FeatureFlag
+		err := client.Get(ctx, benten.FeatureFlagKey(name), &old)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", name))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the feature flag: %v", err))
+			return
+		}
+		if err := client.Delete(ctx, benten.FeatureFlagKey(name)); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to delete the feature flag: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditFeatureFlagDeleted, name, old.Summary(), "")
+		respond(w, 200, "OK")
+	default:
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	// This instance follows at once; the others within featureFlagCacheDuration.
+	featureFlagCache.mu.Lock()
+	featureFlagCache.flags = nil
+	featureFlagCache.mu.Unlock()
+}
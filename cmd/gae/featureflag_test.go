@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestFeatureEnabled(t *testing.T) {
+	featureFlagCache.flags = map[string]benten.FeatureFlag{
+		searchBackendFeature: {Name: searchBackendFeature, Users: []string{"ip:192.0.2.1"}},
+	}
+	featureFlagCache.loadedAt = time.Now()
+	defer func() { featureFlagCache.flags = nil }()
+
+	r := httptest.NewRequest("GET", "/api/list", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if !featureEnabled(r, searchBackendFeature) {
+		t.Errorf("%s is disabled for %s", searchBackendFeature, identity(r))
+	}
+	if featureEnabled(r, "unknown") {
+		t.Errorf("unknown is enabled")
+	}
+	r.RemoteAddr = "192.0.2.2:1234"
+	if featureEnabled(r, searchBackendFeature) {
+		t.Errorf("%s is enabled for %s", searchBackendFeature, identity(r))
+	}
+}
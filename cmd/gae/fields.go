@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// parseFields parses the `fields` parameter, a comma-separated list of
+// Metadata field names. It returns nil for the empty string.
+func parseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	metadataType := reflect.TypeOf(benten.Metadata{})
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := metadataType.FieldByName(field); !ok {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// project returns the `fields` of `piece`, or `piece` itself when `fields` is nil.
+func project(piece *benten.Metadata, fields []string) interface{} {
+	if fields == nil {
+		return piece
+	}
+	value := reflect.ValueOf(piece).Elem()
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		projected[field] = value.FieldByName(field).Interface()
+	}
+	return projected
+}
+
+// getProjected gets the `fields` of the piece at `key` with a projection query,
+// which reads only the index entries. Deleted is projected too, so that the
+// caller can skip trashed pieces. It returns false when the projection isn't
+// possible, e.g. because index.yaml has no composite index for `fields` or
+// the piece lacks some of them, having been synced before they existed, and
+// the caller needs to get the whole entity.
+func getProjected(ctx context.Context, client *datastore.Client, key *datastore.Key, fields []string) (*benten.Metadata, bool) {
+	projection := append([]string{"Deleted"}, fields...)
+	for _, field := range fields {
+		if field == "Deleted" {
+			projection = fields
+		}
+	}
+	query := datastore.NewQuery(benten.PieceKind).Filter("__key__ =", key).Project(projection...)
+	var piece benten.Metadata
+	_, err := client.Run(ctx, query).Next(&piece)
+	if err != nil {
+		return nil, false
+	}
+	return &piece, true
+}
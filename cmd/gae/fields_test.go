@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestProject(t *testing.T) {
+	fields, err := parseFields("Title, Artist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	piece := &benten.Metadata{Title: "Help!", Artist: "The Beatles", Album: "Help!"}
+	want := map[string]interface{}{"Title": "Help!", "Artist": "The Beatles"}
+	if got := project(piece, fields); !reflect.DeepEqual(got, want) {
+		t.Errorf("project = %v", got)
+	}
+	if _, err := parseFields("Title,Password"); err == nil {
+		t.Errorf("unknown fields must be rejected")
+	}
+}
+
+func TestGetProjected(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	key := testutil.PutPiece(t, client, benten.Metadata{Title: "Help!", Artist: "The Beatles"})
+	trashed := testutil.PutPiece(t, client, benten.Metadata{Title: "Yesterday", Deleted: time.Now()})
+
+	piece, ok := getProjected(ctx, client, key, []string{"Title"})
+	if !ok || piece.Title != "Help!" || piece.IsTrashed() {
+		t.Errorf("getProjected() = %v, %v", piece, ok)
+	}
+	if piece, ok := getProjected(ctx, client, trashed, []string{"Title"}); !ok || !piece.IsTrashed() {
+		t.Errorf("getProjected() = %v, %v for a trashed piece", piece, ok)
+	}
+	// The caller gets the entity when it is missing, or lacks the property.
+	if _, ok := getProjected(ctx, client, datastore.IDKey(benten.PieceKind, 12345, nil), []string{"Title"}); ok {
+		t.Errorf("getProjected() succeeded for a missing piece")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// folders responds with the subfolders and the pieces of the folder `path`
+// (a Metadata.PathDir, the root if empty), for browsing the library by the
+// directories of the files. The subfolders are sorted in `locale`; see
+// parseCollator. Trashed pieces are not reported, nor the folders
+// holding only them.
+func folders(w http.ResponseWriter, r *http.Request) {
+	folder := strings.Trim(r.URL.Query().Get("path"), "/")
+	collator, err := parseCollator(r.URL.Query())
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	deadline := browseDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	type folderEntry struct {
+		Path string
+		Name string
+	}
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	response := struct {
+		Folders []folderEntry
+		Pieces  []entry
+	}{Folders: []folderEntry{}, Pieces: []entry{}}
+
+	// The directories under the folder, once per deletion time.
+	query := datastore.NewQuery(benten.PieceKind).Project("PathDir", "Deleted").Distinct()
+	if folder == "" {
+		query = query.Filter("PathDir >", "")
+	} else {
+		// "0" follows "/".
+		query = query.Filter("PathDir >", folder+"/").Filter("PathDir <", folder+"0")
+	}
+	seen := make(map[string]bool)
+	t := client.Run(ctx, query)
+	for {
+		var dir struct {
+			PathDir string
+			Deleted time.Time
+		}
+		_, err := t.Next(&dir)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get folders: %v", err))
+			return
+		}
+		child, ok := benten.ChildFolder(folder, dir.PathDir)
+		if !ok || !dir.Deleted.IsZero() || seen[child] {
+			continue
+		}
+		seen[child] = true
+		response.Folders = append(response.Folders, folderEntry{child, path.Base(child)})
+	}
+
+	sort.Slice(response.Folders, func(i, j int) bool {
+		return compareNames(collator, response.Folders[i].Name, response.Folders[j].Name) < 0
+	})
+
+	var pieces []benten.Metadata
+	keys, err := client.GetAll(ctx, datastore.NewQuery(benten.PieceKind).Filter("PathDir =", folder), &pieces)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+		return
+	}
+	for i := range pieces {
+		if !pieces[i].IsTrashed() {
+			response.Pieces = append(response.Pieces, entry{keys[i].Encode(), pieces[i]})
+		}
+	}
+	sort.Slice(response.Pieces, func(i, j int) bool {
+		a, b := &response.Pieces[i].Metadata, &response.Pieces[j].Metadata
+		if a.Disc != b.Disc {
+			return a.Disc < b.Disc
+		}
+		if a.Track != b.Track {
+			return a.Track < b.Track
+		}
+		return compareNames(collator, a.Path, b.Path) < 0
+	})
+	respondJSON(w, 200, response)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// The maximum length of an Idempotency-Key.
+const maxIdempotencyKeyLength = 255
+
+// The maximum size of the body of a request with an Idempotency-Key, which
+// is read to tell its retries from other requests.
+const maxIdempotentRequestSize = 8 << 20
+
+// The maximum size of a response kept for the retries. Larger ones are not
+// kept, and the retries are handled again.
+const maxIdempotentResponseSize = 512 << 10
+
+// purgeIdempotencyBatchSize is the number of keys a purge-idempotency-keys
+// step deletes.
+const purgeIdempotencyBatchSize = 500
+
+// withIdempotency deduplicates the retries of the mutating requests (not GET
+// nor HEAD) with an Idempotency-Key header: the response to the first request
+// with a key is replayed to the later ones from the same client with the same
+// method, URL and body, for IDEMPOTENCY_TTL. Requests reusing a key otherwise
+// are rejected with 422, and the retries while the first is handled with 409.
+// Responses with 5xx are not kept, so that the retries are handled again.
+// Only the admin and sessions have keys: anonymous requests are handled as if
+// they had none, so that they can't make the server read, store and replay
+// their rejections.
+func withIdempotency(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" || r.Method == "GET" || r.Method == "HEAD" || strings.HasPrefix(identity(r), "ip:") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			respond(w, 400, fmt.Sprintf("Idempotency-Key is too long (%d > %d)", len(idempotencyKey), maxIdempotencyKeyLength))
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestSize))
+		if err != nil {
+			respond(w, 413, fmt.Sprintf("Failed to read the request: %v", err))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+		defer cancel()
+		client, err := datastore.NewClient(ctx, projectID)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+			return
+		}
+		defer client.Close()
+		key := benten.IdempotencyKey(identity(r), idempotencyKey)
+		previous, err := benten.BeginIdempotentRequest(ctx, client, key, fingerprint)
+		switch {
+		case err == benten.ErrIdempotencyKeyReused:
+			respond(w, 422, err.Error())
+			return
+		case err == benten.ErrIdempotentRequestInProgress:
+			respond(w, 409, err.Error())
+			return
+		case err != nil:
+			respond(w, 500, fmt.Sprintf("Failed to check the idempotency key: %v", err))
+			return
+		case previous != nil:
+			writeHead(w, previous.Status, header{ContentType: previous.ContentType, ContentLength: int64(len(previous.Body))})
+			w.Write(previous.Body)
+			return
+		}
+
+		rw := &idempotentWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		if rw.status == 0 {
+			rw.status = 200
+		}
+		// The handler may have used up the deadline.
+		ctx, cancel = context.WithTimeout(context.Background(), metadataDeadline)
+		defer cancel()
+		if rw.status >= 500 || rw.overflow {
+			err = benten.CancelIdempotentRequest(ctx, client, key)
+		} else {
+			err = benten.FinishIdempotentRequest(ctx, client, key, fingerprint, rw.status, rw.Header().Get("Content-Type"), rw.body.Bytes())
+		}
+		if err != nil {
+			// The retries see the pending request until it times out.
+			logf(r.Context(), severityError, "Failed to store the response for the idempotency key: %v", err)
+		}
+	})
+}
+
+// requestFingerprint returns a hash of the method, the URL and `body` of `r`.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%d\n", r.Method, r.URL.RequestURI(), len(body))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotentWriter keeps a copy of a response, up to
+// maxIdempotentResponseSize, while writing it.
+type idempotentWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotentWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotentWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	if !w.overflow {
+		if w.body.Len()+len(p) > maxIdempotentResponseSize {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *idempotentWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// purgeIdempotencyKeysStep deletes a batch of the idempotency keys older than
+// IDEMPOTENCY_TTL.
+func purgeIdempotencyKeysStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	n, err := benten.PurgeIdempotentRequests(ctx, client, time.Now().Add(-benten.IdempotencyRetention), purgeIdempotencyBatchSize)
+	if err != nil {
+		return false, err
+	}
+	job.Processed += n
+	return n < purgeIdempotencyBatchSize, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithIdempotencyIgnoresAnonymousKeys(t *testing.T) {
+	handled := false
+	h := withIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+		respond(w, 403, "Forbidden")
+	}))
+	// Larger than maxIdempotentRequestSize, which anonymous requests must
+	// not make the server read.
+	r := httptest.NewRequest("POST", "/api/admin/reindex", strings.NewReader(strings.Repeat("x", maxIdempotentRequestSize+1)))
+	r.Header.Set("Idempotency-Key", "key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !handled || w.Code != 403 {
+		t.Errorf("handled = %v, code = %d", handled, w.Code)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// jobStep runs a step of `job`, which must finish well within jobLease, and
+// advances its Cursor and Processed. It returns true when the job is done.
+type jobStep func(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error)
+
+// jobSteps are the steps of the job types.
+var jobSteps = map[string]jobStep{
+	"reindex":     reindexStep,
+	"purge-trash": purgeTrashStep,
+	"artist-art":  artistArtStep,
+	"lyrics":      lyricsStep,
+	"podcasts":    podcastsStep,
+	// Should be started regularly, like purge-trash.
+	"purge-search-log":       purgeSearchLogStep,
+	"purge-tombstones":       purgeTombstonesStep,
+	"purge-idempotency-keys": purgeIdempotencyKeysStep,
+	"purge-revoked-sessions": purgeRevokedSessionsStep,
+	"repair-index":           repairIndexStep,
+	"tag-rules":              tagRulesStep,
+}
+
+// jobLease is how long a job is owned by the worker running it after each
+// step. Jobs whose workers went away are resumed by another worker after it.
+const jobLease = 2 * time.Minute
+
+// jobPollInterval is how often the worker looks for jobs to run, given by
+// JOB_POLL_INTERVAL.
+var jobPollInterval = envDuration("JOB_POLL_INTERVAL", 30*time.Second)
+
+// jobWakeup makes the worker look for jobs immediately.
+var jobWakeup = make(chan struct{}, 1)
+
+// jobResponse is a job with its ID.
+type jobResponse struct {
+	ID int64
+	benten.Job
+}
+
+// runJobWorker runs the runnable jobs until `ctx` is done. Every instance
+// runs a worker unless JOB_WORKER is "0".
+func runJobWorker(ctx context.Context) {
+	if os.Getenv("JOB_WORKER") == "0" {
+		return
+	}
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		logf(ctx, severityError, "Failed to create a datastore client for jobs: %v", err)
+		return
+	}
+	defer client.Close()
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		keys, err := benten.RunnableJobs(ctx, client)
+		if err != nil {
+			logf(ctx, severityError, "Failed to find jobs: %v", err)
+		}
+		for _, key := range keys {
+			job, err := benten.ClaimJob(ctx, client, key, jobLease)
+			if err == benten.ErrJobNotRunnable {
+				continue
+			}
+			if err != nil {
+				logf(ctx, severityError, "Failed to claim job %d: %v", key.ID, err)
+				continue
+			}
+			runJob(ctx, client, key, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-jobWakeup:
+		}
+	}
+}
+
+// runJob runs the steps of `job` until it finishes or is canceled.
+func runJob(ctx context.Context, client *datastore.Client, key *datastore.Key, job *benten.Job) {
+	step, ok := jobSteps[job.Type]
+	if !ok {
+		job.Status = benten.JobFailed
+		job.Error = fmt.Sprintf("Unknown job type: %s", job.Type)
+	}
+	for job.Status == benten.JobRunning {
+		done, err := step(ctx, client, job)
+		if err != nil {
+			job.Status = benten.JobFailed
+			job.Error = err.Error()
+			logf(ctx, severityError, "Job %d (%s) failed: %v", key.ID, job.Type, err)
+		} else if done {
+			job.Status = benten.JobSucceeded
+			logf(ctx, severityInfo, "Job %d (%s) processed %d items", key.ID, job.Type, job.Processed)
+		}
+		err = benten.SaveJob(ctx, client, key, job, jobLease)
+		if err == benten.ErrJobNotRunnable {
+			logf(ctx, severityInfo, "Job %d (%s) was canceled", key.ID, job.Type)
+			return
+		}
+		if err != nil {
+			// Another worker resumes the job from the last saved step.
+			logf(ctx, severityError, "Failed to save job %d: %v", key.ID, err)
+			return
+		}
+	}
+}
+
+// adminJobs lists jobs (GET), optionally filtered by `type` and `status` or
+// given by `id`, starts a job of `type` (POST), and cancels the job `id`
+// (DELETE).
+func adminJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	var key *datastore.Key
+	if idString := q.Get("id"); idString != "" {
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("id (%v) is invalid", idString))
+			return
+		}
+		key = datastore.IDKey(benten.JobKind, id, nil)
+	}
+
+	switch {
+	case r.Method == "POST":
+		jobType := q.Get("type")
+		if _, ok := jobSteps[jobType]; !ok {
+			respond(w, 400, fmt.Sprintf("Unknown job type: %s", jobType))
+			return
+		}
+		key, job, err := benten.CreateJob(ctx, client, jobType)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to create a job: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditJobStarted, strconv.FormatInt(key.ID, 10), "", jobType)
+		select {
+		case jobWakeup <- struct{}{}:
+		default:
+		}
+		respondJSON(w, 202, jobResponse{key.ID, *job})
+	case r.Method == "DELETE" && key != nil:
+		err := benten.CancelJob(ctx, client, key)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: job %d", key.ID))
+			return
+		}
+		if err == benten.ErrJobNotRunnable {
+			respond(w, 409, fmt.Sprintf("Job %d has finished", key.ID))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to cancel the job: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditJobCanceled, strconv.FormatInt(key.ID, 10), "", "")
+		respond(w, 200, "OK")
+	case r.Method == "GET" && key != nil:
+		var job benten.Job
+		err := client.Get(ctx, key, &job)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: job %d", key.ID))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the job: %v", err))
+			return
+		}
+		respondJSON(w, 200, jobResponse{key.ID, job})
+	case r.Method == "GET":
+		query := datastore.NewQuery(benten.JobKind)
+		if jobType := q.Get("type"); jobType != "" {
+			query = query.Filter("Type =", jobType)
+		}
+		if status := q.Get("status"); status != "" {
+			query = query.Filter("Status =", status)
+		}
+		var jobs []benten.Job
+		keys, err := client.GetAll(ctx, query, &jobs)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get jobs: %v", err))
+			return
+		}
+		responses := make([]jobResponse, 0, len(jobs))
+		for i, job := range jobs {
+			responses = append(responses, jobResponse{keys[i].ID, job})
+		}
+		respondJSON(w, 200, responses)
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// adminReindex starts a reindex job (POST) or lists the reindex jobs (GET).
+func adminReindex(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("type", "reindex")
+	r.URL.RawQuery = q.Encode()
+	adminJobs(w, r)
+}
+
+// reindexBatchSize is the number of pieces a reindex step handles.
+const reindexBatchSize = 100
+
+// reindexStep respans the index of the next batch of pieces.
+func reindexStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	query := datastore.NewQuery(benten.PieceKind).Limit(reindexBatchSize)
+	if job.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(job.Cursor)
+		if err != nil {
+			return false, err
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	count := 0
+	for {
+		var piece benten.Metadata
+		key, err := t.Next(&piece)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		count++
+		if piece.IsTrashed() {
+			// Trashed pieces are not indexed.
+			continue
+		}
+		if err := refreshAlbumKey(ctx, client, key, &piece, aliases); err != nil {
+			return false, err
+		}
+		if err := benten.RespanIndex(ctx, client, &piece, key, aliases); err != nil {
+			return false, err
+		}
+		if externalSearchIndex != nil {
+			if err := externalSearchIndex.Index(ctx, key, &piece, aliases); err != nil {
+				return false, err
+			}
+		}
+	}
+	job.Processed += count
+	if count < reindexBatchSize {
+		invalidateSearchCache(ctx)
+		return true, nil
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return false, err
+	}
+	job.Cursor = cursor.String()
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestRunJob(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	for _, title := range []string{"The Wall", "Money", "Time"} {
+		if _, err := client.Put(ctx, datastore.IncompleteKey(benten.PieceKind, nil), &benten.Metadata{Title: title, Artist: "Pink Floyd"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Trashed pieces are counted but not indexed.
+	if _, err := client.Put(ctx, datastore.IncompleteKey(benten.PieceKind, nil), &benten.Metadata{Title: "Money", Artist: "Pink Floyd", Deleted: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := benten.CreateJob(ctx, client, "reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := benten.ClaimJob(ctx, client, key, jobLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := benten.ClaimJob(ctx, client, key, jobLease); err != benten.ErrJobNotRunnable {
+		t.Errorf("claimed a leased job: %v", err)
+	}
+	runJob(ctx, client, key, job)
+
+	var saved benten.Job
+	if err := client.Get(ctx, key, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != benten.JobSucceeded || saved.Processed != 4 {
+		t.Errorf("job = %+v", saved)
+	}
+	results, err := benten.DatastoreIndex{Client: client}.Search(ctx, "money", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("results = %v", results)
+	}
+}
+
+func TestSaveJobAfterTakeover(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	key, _, err := benten.CreateJob(ctx, client, "reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled, err := benten.ClaimJob(ctx, client, key, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lease has expired, so another worker takes the job over.
+	current, err := benten.ClaimJob(ctx, client, key, jobLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled.Cursor = "stale"
+	if err := benten.SaveJob(ctx, client, key, stalled, jobLease); err != benten.ErrJobNotRunnable {
+		t.Errorf("SaveJob() = %v for a stalled worker", err)
+	}
+	current.Processed = 1
+	if err := benten.SaveJob(ctx, client, key, current, jobLease); err != nil {
+		t.Errorf("SaveJob() = %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/lyrics"
+	"google.golang.org/api/iterator"
+)
+
+// lyricsProviders are asked in order for lyrics; see configure.
+var lyricsProviders []lyrics.Provider
+
+// lyricsFetchesPerStep is the number of pieces a lyrics step asks the
+// providers about, which keeps the step well within jobLease.
+const lyricsFetchesPerStep = 20
+
+// lyricsStep fetches the lyrics of the next pieces that have no Lyrics yet,
+// or one to retry.
+func lyricsStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	query := datastore.NewQuery(benten.PieceKind)
+	if job.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(job.Cursor)
+		if err != nil {
+			return false, err
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	fetches := 0
+	for fetches < lyricsFetchesPerStep {
+		var piece benten.Metadata
+		key, err := t.Next(&piece)
+		if err == iterator.Done {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if piece.Title == "" || !piece.Deleted.IsZero() {
+			continue
+		}
+		var existing benten.Lyrics
+		err = client.Get(ctx, benten.LyricsKey(key), &existing)
+		if err == nil && !existing.IsStale(time.Now()) {
+			continue
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return false, err
+		}
+		if err := fetchLyrics(ctx, client, key, &piece); err != nil {
+			return false, err
+		}
+		fetches++
+		job.Processed++
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return false, err
+	}
+	job.Cursor = cursor.String()
+	return false, nil
+}
+
+// fetchLyrics asks the providers for the lyrics of `piece` stored at `key`,
+// and records the answer even if none had them, so that the piece is left
+// until LyricsRetry passes.
+func fetchLyrics(ctx context.Context, client *datastore.Client, key *datastore.Key, piece *benten.Metadata) error {
+	artist := piece.Artist
+	if artist == "" {
+		artist = piece.AlbumArtist
+	}
+	result, err := lyrics.Fetch(ctx, lyricsProviders, lyrics.Query{Title: piece.Title, Artist: artist, Album: piece.Album, Duration: piece.Duration})
+	if err != nil {
+		// Providers being down shouldn't fail the job; the piece is asked
+		// about again by the next one.
+		logf(ctx, severityWarning, "Failed to fetch the lyrics of %s: %v", piece.Path, err)
+		return nil
+	}
+	entity := benten.Lyrics{Fetched: time.Now()}
+	if result != nil {
+		entity.Synced = result.Synced
+		entity.Plain = result.Plain
+		entity.Instrumental = result.Instrumental
+		entity.Source = result.Source
+		if entity.Plain == "" && entity.Synced != "" {
+			var lines []string
+			for _, line := range benten.ParseLRC(entity.Synced) {
+				lines = append(lines, line.Text)
+			}
+			entity.Plain = strings.Join(lines, "\n")
+		}
+	}
+	_, err = client.Put(ctx, benten.LyricsKey(key), &entity)
+	return err
+}
+
+// lyricsLine is a line of synced lyrics in responses.
+type lyricsLine struct {
+	// Time is when the line starts, in milliseconds.
+	Time int64
+	Text string
+}
+
+// lyricsResponse is the lyrics of a piece. Lines is empty unless the lyrics
+// are synced.
+type lyricsResponse struct {
+	Plain        string
+	Lines        []lyricsLine
+	Instrumental bool
+	Source       string
+}
+
+// getLyrics responds with the lyrics of the piece `key` (an encoded datastore
+// key).
+func getLyrics(w http.ResponseWriter, r *http.Request) {
+	encodedKey := r.URL.Query().Get("key")
+	key, err := datastore.DecodeKey(encodedKey)
+	if err != nil || key.Kind != benten.PieceKind {
+		respond(w, 400, fmt.Sprintf("key (%v) is invalid", encodedKey))
+		return
+	}
+	deadline := metadataDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	var entity benten.Lyrics
+	err = client.Get(ctx, benten.LyricsKey(key), &entity)
+	if err == datastore.ErrNoSuchEntity || (err == nil && entity.Plain == "" && !entity.Instrumental) {
+		respond(w, 404, fmt.Sprintf("Not found: %s", encodedKey))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the lyrics: %v", err))
+		return
+	}
+	response := lyricsResponse{Plain: entity.Plain, Lines: []lyricsLine{}, Instrumental: entity.Instrumental, Source: entity.Source}
+	for _, line := range benten.ParseLRC(entity.Synced) {
+		response.Lines = append(response.Lines, lyricsLine{int64(line.Time / time.Millisecond), line.Text})
+	}
+	respondJSON(w, 200, response)
+}
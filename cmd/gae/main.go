@@ -2,30 +2,69 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
 
 	"cloud.google.com/go/datastore"
 	"cloud.google.com/go/storage"
 	"github.com/yutakahirano/benten"
-	"google.golang.org/api/iterator"
+	"github.com/yutakahirano/benten/search"
+	"golang.org/x/text/collate"
 )
 
 var projectID string
 
+// externalSearchIndex is the search index configured by SEARCH_BACKEND, or nil
+// when the datastore n-gram index is used.
+var externalSearchIndex benten.SearchIndex
+
+// searchBackendFeature is the feature flag rolling out externalSearchIndex.
+// The datastore n-gram index is kept up to date either way, and searches use
+// it for the users the feature is disabled for.
+const searchBackendFeature = "search-backend"
+
+func searchConfig() search.Config {
+	return search.Config{
+		Backend: os.Getenv("SEARCH_BACKEND"),
+		Path:    os.Getenv("SEARCH_PATH"),
+		URL:     os.Getenv("SEARCH_URL"),
+		Index:   os.Getenv("SEARCH_INDEX"),
+		APIKey:  searchAPIKey,
+	}
+}
+
+// loadIndexConfig loads the index config stored by migrate-index. Instances
+// need to be restarted after it changes.
+func loadIndexConfig() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = benten.LoadIndexConfig(ctx, client)
+	return err
+}
+
 func respond(w http.ResponseWriter, code int, message string) {
 	if code/100 != 2 {
-		log.Print(message)
+		logf(requestContext(w), severityFor(code), "%s", message)
 	}
-	w.WriteHeader(code)
+	writeHead(w, code, header{ContentType: "text/plain; charset=utf-8", ContentLength: int64(len(message))})
 	_, err := w.Write([]byte(message))
 	if err != nil {
 		// 500 internal server error
@@ -33,16 +72,146 @@ func respond(w http.ResponseWriter, code int, message string) {
 	}
 }
 
+// downloadFilename returns the file name offered for the object `name`: the
+// `filename` parameter if given, or the base name of the object with an
+// extension for `contentType`.
+func downloadFilename(name, filename, contentType string) string {
+	if filename != "" {
+		return path.Base(filename)
+	}
+	filename = path.Base(name)
+	if path.Ext(filename) == "" {
+		if extensions, err := mime.ExtensionsByType(contentType); err == nil && len(extensions) > 0 {
+			filename += extensions[0]
+		}
+	}
+	return filename
+}
+
+// pieceObject returns the name of the object of the piece at `key` in
+// `quality` (see benten.Metadata.SelectRendition), and the file name to offer
+// for it. Without `quality`, the profile of `device`, if any, chooses it for
+// `network`.
+func pieceObject(ctx context.Context, key *datastore.Key, quality, device, network string) (string, string, error) {
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return "", "", err
+	}
+	defer client.Close()
+	var piece benten.Metadata
+	if err := client.Get(ctx, key, &piece); err != nil {
+		return "", "", err
+	}
+	if quality == "" && device != "" {
+		profile, err := cachedProfile(ctx, client, device)
+		if err != nil {
+			return "", "", err
+		}
+		if profile != nil {
+			quality = profile.Quality(&piece, network)
+		}
+	}
+	name, codec, err := piece.SelectRendition(quality)
+	if err != nil {
+		return "", "", err
+	}
+	filename := path.Base(filepath.ToSlash(piece.Path))
+	if codec != "" {
+		filename = strings.TrimSuffix(filename, path.Ext(filename)) + "." + codec
+	}
+	return name, filename, nil
+}
+
+// get streams the object `name` in `bucket`, or the piece `key` (an encoded
+// datastore key) whichever naming scheme its object has.
+// With `start` and `end` (in seconds), only that part of an MP3 is streamed,
+// for pieces which are tracks of a longer file; see trimMP3. With `key`,
+// `quality` ("low", "high" or "original") selects a rendition, or else the
+// profile of `device` does for `network` ("wifi" or "cellular"); see
+// adminProfiles.
 func get(w http.ResponseWriter, r *http.Request) {
+	if !authorizeStream(w, r) {
+		return
+	}
 	q := r.URL.Query()
 	name := q.Get("name")
 	bucketName := q.Get("bucket")
+	filename := q.Get("filename")
+	trimStart, trimEnd, trim, err := parseTrim(q)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	quality := q.Get("quality")
+	if quality != "" && quality != benten.QualityLow && quality != benten.QualityHigh && quality != benten.QualityOriginal {
+		respond(w, 400, fmt.Sprintf("quality (%v) is invalid", quality))
+		return
+	}
+	device, network := q.Get("device"), q.Get("network")
+	if network != "" && network != benten.NetworkWiFi && network != benten.NetworkCellular {
+		respond(w, 400, fmt.Sprintf("network (%v) is invalid", network))
+		return
+	}
+	if (quality != "" || device != "") && q.Get("key") == "" {
+		respond(w, 400, "quality and device need key")
+		return
+	}
+	if encodedKey := q.Get("key"); encodedKey != "" {
+		key, err := datastore.DecodeKey(encodedKey)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("key (%v) is invalid", encodedKey))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+		defer cancel()
+		var pieceFilename string
+		name, pieceFilename, err = pieceObject(ctx, key, quality, device, network)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", encodedKey))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to resolve %s: %v", encodedKey, err))
+			return
+		}
+		bucketName = benten.PieceBucket
+		if filename == "" {
+			filename = pieceFilename
+		}
+	}
+	if bucketName == benten.AlbumPictureBucket && artCache != nil && q.Get("download") != "1" {
+		serveArt(w, name)
+		return
+	}
 
-	deadline := 10 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	id := identity(r)
+	if bucketName == benten.PieceBucket && !checkQuota(r.Context(), w, id) {
+		return
+	}
+	if signedURLSigner != nil && bucketName == benten.PieceBucket && q.Get("download") != "1" && !trim && bandwidthQuota == 0 {
+		// Let the client download the piece from GCS directly.
+		url, err := storage.SignedURL(bucketName, name, &storage.SignedURLOptions{
+			GoogleAccessID: signedURLSigner.email,
+			PrivateKey:     signedURLSigner.privateKey,
+			Method:         "GET",
+			Expires:        time.Now().Add(signedURLTTL),
+			Scheme:         storage.SigningSchemeV4,
+		})
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to sign a URL: %v", err))
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	// The request context is done when the client disconnects, which aborts
+	// reading from the storage and so the copy below. Streaming a long piece
+	// may take any time, so it is only aborted when it stalls.
+	ctx, timer, cancel := withIdleTimeout(r.Context(), streamIdleTimeout)
 	defer cancel()
 
-	client, err := storage.NewClient(ctx)
+	client, err := storage.NewClient(ctx, benten.StorageOptions()...)
 	if err != nil {
 		respond(w, 500, fmt.Sprintf("Failed to create client: %v", err))
 		return
@@ -50,7 +219,9 @@ func get(w http.ResponseWriter, r *http.Request) {
 	bucket := client.Bucket(bucketName)
 
 	object := bucket.Object(name)
-	attrs, err := object.Attrs(ctx)
+	metadataCtx, metadataCancel := context.WithTimeout(ctx, metadataDeadline)
+	defer metadataCancel()
+	attrs, err := object.Attrs(metadataCtx)
 	if err == storage.ErrObjectNotExist {
 		respond(w, 404, fmt.Sprintf("Not found: %s", name))
 		return
@@ -59,59 +230,146 @@ func get(w http.ResponseWriter, r *http.Request) {
 		respond(w, 500, fmt.Sprintf("Failed to get attrs: %v", err))
 		return
 	}
-	reader, err := object.NewReader(ctx)
+	if trim && attrs.ContentType != "audio/mpeg" && path.Ext(downloadFilename(name, filename, attrs.ContentType)) != ".mp3" {
+		respond(w, 400, "start and end are supported only for MP3")
+		return
+	}
+	// Players seek in long pieces and videos with ranges. Trimmed streams
+	// are not seekable, as their sizes are unknown.
+	rangeHeader := r.Header.Get("Range")
+	if trim {
+		rangeHeader = ""
+	}
+	start, length, err := parseRange(rangeHeader, attrs.Size)
+	if err == errRangeNotSatisfiable {
+		w.Header().Set("content-range", fmt.Sprintf("bytes */%d", attrs.Size))
+		respond(w, 416, fmt.Sprintf("Range (%v) is not satisfiable", r.Header.Get("Range")))
+		return
+	}
+	reader, err := object.NewRangeReader(ctx, start, length)
 
 	if err != nil {
 		respond(w, 500, fmt.Sprintf("Failed to get reader: %v", err))
 		return
 	}
-	w.WriteHeader(200)
-	w.Header().Add("content-type", attrs.ContentType)
-	_, err = io.Copy(w, reader)
-	if err != nil {
-		log.Printf("Failed to write data to response: %v", err)
+	defer reader.Close()
+	h := header{
+		ContentType:   attrs.ContentType,
+		ContentLength: reader.Remain(),
+		Disposition:   "inline",
+		Filename:      downloadFilename(name, filename, attrs.ContentType),
+		CacheControl:  "private, max-age=3600",
+		AcceptRanges:  !trim,
+	}
+	if trim {
+		h.ContentLength = -1
+	}
+	if q.Get("download") == "1" {
+		h.Disposition = "attachment"
+	}
+	if bucketName == benten.AlbumPictureBucket {
+		// Album pictures are named by their hashes, so they never change.
+		h.CacheControl = "public, max-age=31536000, immutable"
+	}
+	code := 200
+	if length >= 0 {
+		code = 206
+		h.ContentRange = contentRange(start, length, attrs.Size)
+	}
+	writeHead(w, code, h)
+	counter := &countingWriter{w: idleWriter{w, timer}}
+	if trim {
+		err = trimMP3(counter, reader, trimStart, trimEnd)
+	} else {
+		_, err = io.Copy(counter, reader)
+	}
+	if bucketName == benten.PieceBucket {
+		recordBandwidth(r, id, counter.bytes)
+	}
+	if err != nil && r.Context().Err() != nil {
+		logf(ctx, severityInfo, "The client went away while streaming %s", name)
+	} else if err != nil && ctx.Err() != nil {
+		logf(ctx, severityWarning, "Streaming %s stalled for %v", name, streamIdleTimeout)
+	} else if err != nil {
+		logf(ctx, severityError, "Failed to write data to response: %v", err)
 	}
 }
 
 func list(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	q := r.URL.Query()
-	search := benten.Normalize(q.Get("search"))
-	var err error
-	limit := 10
-	limitString := q.Get("limit")
-	if limitString != "" {
-		limit, err = strconv.Atoi(limitString)
-
+	text := q.Get("search")
+	if err := validateQuery(text); err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	limit, err := parseLimit(q, 10, maxSearchLimit)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	filter := benten.Filter{Genre: q.Get("genre"), AlbumArtist: q.Get("albumartist")}
+	if yearString := q.Get("year"); yearString != "" {
+		filter.Year, err = strconv.Atoi(yearString)
 		if err != nil {
-			respond(w, 400, fmt.Sprintf("limit (%v) is not a valid number", limitString))
+			respond(w, 400, fmt.Sprintf("year (%v) is not a valid number", yearString))
 			return
 		}
-		if limit < 0 || limit > 1000*1000 {
-			respond(w, 400, fmt.Sprintf("limit (%v) is out of range", limit))
+	}
+	if yearString := q.Get("originalyear"); yearString != "" {
+		filter.OriginalYear, err = strconv.Atoi(yearString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("originalyear (%v) is not a valid number", yearString))
 			return
 		}
 	}
-	if len(search) < benten.GramSizeForAscii {
-		respond(w, 400, fmt.Sprintf("The query is too small"))
+	for _, bound := range []struct {
+		name string
+		dest *float64
+	}{{"bpmmin", &filter.MinBPM}, {"bpmmax", &filter.MaxBPM}} {
+		if s := q.Get(bound.name); s != "" {
+			*bound.dest, err = strconv.ParseFloat(s, 64)
+			if err != nil || *bound.dest <= 0 {
+				respond(w, 400, fmt.Sprintf("%s (%v) is invalid", bound.name, s))
+				return
+			}
+		}
+	}
+	if key := q.Get("key"); key != "" {
+		filter.Key = benten.NormalizeKey(key)
+		if filter.Key == "" {
+			respond(w, 400, fmt.Sprintf("key (%v) is invalid", key))
+			return
+		}
+	}
+	if err := parseQualityFilter(q, &filter); err != nil {
+		respond(w, 400, err.Error())
 		return
 	}
-	isASCII := true
-	for i := 0; i < benten.GramSizeForAscii; i++ {
-		isASCII = isASCII && search[i] <= unicode.MaxASCII
+	sortBy := q.Get("sort")
+	switch sortBy {
+	case "", "year", "originalyear", "artist", "album", "title":
+	default:
+		respond(w, 400, fmt.Sprintf("sort (%v) is invalid", sortBy))
+		return
 	}
-	var bytes []byte
-	if isASCII {
-		bytes = []byte(search[0:benten.GramSizeForAscii])
-	} else if len(search) < benten.GramSizeForNonAscii {
-		respond(w, 404, fmt.Sprintf("The query is too small"))
+	collator, err := parseCollator(q)
+	if err != nil {
+		respond(w, 400, err.Error())
 		return
-	} else {
-		bytes = []byte(search[0:benten.GramSizeForNonAscii])
 	}
-
-	ctx := context.Background()
-	deadline := 10 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	fields, err := parseFields(q.Get("fields"))
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	compactFormat, err := parseFormat(q, fields)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	deadline := listDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
 	defer cancel()
 	client, err := datastore.NewClient(ctx, projectID)
 	if err != nil {
@@ -119,45 +377,222 @@ func list(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := datastore.NewQuery(benten.PieceIndexKind).Filter("Key =", bytes).Order("Value").Limit(limit)
-	t := client.Run(ctx, query)
-	pieces := make([]benten.Metadata, 0)
-	var lastKey *datastore.Key = nil
-	for {
-		var index benten.PieceIndex
-		_, err := t.Next(&index)
-		if err == iterator.Done {
-			break
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to load aliases: %v", err))
+		return
+	}
+	// With facets=1, the results are wrapped into an object with their
+	// facets, which are the last line when streaming.
+	var counter *facetCounter
+	if q.Get("facets") == "1" {
+		counter = newFacetCounter(aliases)
+	}
+	var index benten.SearchIndex = benten.DatastoreIndex{Client: client, Aliases: aliases}
+	external := externalSearchIndex != nil && featureEnabled(r, searchBackendFeature)
+	if external {
+		index = externalSearchIndex
+	}
+	phonetic := q.Get("phonetic") == "1"
+	if _, ok := index.(benten.PhoneticSearcher); phonetic && !ok {
+		respond(w, 400, "The search backend doesn't support phonetic search")
+		return
+	}
+	key := searchCacheKey(text, filter, phonetic, external, limit)
+	results, err := cachedSearch(ctx, key, func() ([]benten.SearchResult, error) {
+		if phonetic {
+			return index.(benten.PhoneticSearcher).SearchPhonetic(ctx, text, limit)
 		}
-		if err != nil {
-			respond(w, 500, fmt.Sprintf("Failed to get key: %v", err))
-			return
+		if filtered, ok := index.(benten.FilteredSearcher); ok && !filter.IsEmpty() {
+			return filtered.SearchFiltered(ctx, text, filter, limit)
+		}
+		return index.Search(ctx, text, limit)
+	})
+	if err == benten.ErrQueryTooShort {
+		respond(w, 400, fmt.Sprintf("The query is too small"))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to search: %v", err))
+		return
+	}
+	pieces := newListWriter(w, r)
+	count := 0
+	output := func(key *datastore.Key, piece *benten.Metadata) {
+		count++
+		if counter != nil {
+			counter.add(piece)
+		}
+		if compactFormat {
+			pieces.add(compact(key.Encode(), piece))
+		} else {
+			pieces.add(project(piece, fields))
 		}
-		if lastKey != nil && index.Value.ID == lastKey.ID {
+	}
+	// Sorted results are added at the end.
+	var sorted []*benten.Metadata
+	sortedKeys := make(map[*benten.Metadata]*datastore.Key)
+	add := func(key *datastore.Key, piece *benten.Metadata) {
+		if sortBy != "" {
+			sorted = append(sorted, piece)
+			sortedKeys[piece] = key
+		} else {
+			output(key, piece)
+		}
+	}
+	for _, result := range results {
+		if result.Metadata != nil {
+			if filter.Matches(result.Metadata, aliases) && !result.Metadata.IsTrashed() {
+				add(result.Key, result.Metadata)
+			}
 			continue
 		}
-		lastKey = index.Value
+		if fields != nil && filter.IsEmpty() && sortBy == "" && counter == nil {
+			projected, ok := getProjected(ctx, client, result.Key, fields)
+			if ok && projected.IsTrashed() {
+				continue
+			}
+			if ok {
+				count++
+				pieces.add(project(projected, fields))
+				continue
+			}
+		}
 		var piece benten.Metadata
-		err = client.Get(ctx, index.Value, &piece)
+		err = client.Get(ctx, result.Key, &piece)
+		if err == datastore.ErrNoSuchEntity || (err == nil && piece.IsTrashed()) {
+			// The index is stale.
+			continue
+		}
 		if err != nil {
-			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			pieces.fail(500, fmt.Sprintf("Failed to get metadata: %v", err))
 			return
 		}
-		if strings.Contains(strings.ToLower(piece.Title), search) ||
-			strings.Contains(strings.ToLower(piece.Album), search) ||
-			strings.Contains(strings.ToLower(piece.Artist), search) ||
-			strings.Contains(strings.ToLower(piece.AlbumArtist), search) {
-			pieces = append(pieces, piece)
+		if filter.Matches(&piece, aliases) {
+			add(result.Key, &piece)
 		}
 	}
-	w.WriteHeader(200)
-	w.Header().Add("content-type", "application/json")
-	json.NewEncoder(w).Encode(pieces)
+	sortPieces(sorted, sortBy, collator, aliases)
+	for _, piece := range sorted {
+		output(sortedKeys[piece], piece)
+	}
+	if counter == nil {
+		pieces.close(nil)
+	} else {
+		pieces.close(func(items []interface{}) interface{} {
+			if items == nil {
+				return struct{ Facets facets }{counter.facets()}
+			}
+			return struct {
+				Pieces []interface{}
+				Facets facets
+			}{items, counter.facets()}
+		})
+	}
+	logSearch(r, client, text, count, time.Since(start))
 }
 
-func handle(w http.ResponseWriter, r *http.Request) {
-	log.Printf("request: %s", r.URL)
+// sortPieces sorts `pieces` by "year" or by "originalyear" (ReleaseYear), the
+// oldest first and the unknown last, or by "artist", "album" or "title" with
+// `collator`, keeping the order of the search otherwise. Artists are sorted
+// by their canonical names in `aliases`. Pieces of the same artist are sorted
+// by album, and those of the same album by disc and track.
+func sortPieces(pieces []*benten.Metadata, by string, collator *collate.Collator, aliases benten.Aliases) {
+	year := func(piece *benten.Metadata) int {
+		y := piece.Year
+		if by == "originalyear" {
+			y = piece.ReleaseYear()
+		}
+		if y == 0 {
+			return math.MaxInt32
+		}
+		return y
+	}
+	artist := func(piece *benten.Metadata) string {
+		if a := aliases.AlbumArtist(piece); a != "" {
+			return a
+		}
+		return aliases.Canonical(piece.Artist)
+	}
+	inAlbum := func(a, b *benten.Metadata) bool {
+		if a.Disc != b.Disc {
+			return a.Disc < b.Disc
+		}
+		return a.Track < b.Track
+	}
+	switch by {
+	case "year", "originalyear":
+		sort.SliceStable(pieces, func(i, j int) bool { return year(pieces[i]) < year(pieces[j]) })
+	case "artist":
+		sort.SliceStable(pieces, func(i, j int) bool {
+			if c := compareNames(collator, artist(pieces[i]), artist(pieces[j])); c != 0 {
+				return c < 0
+			}
+			if c := compareNames(collator, pieces[i].Album, pieces[j].Album); c != 0 {
+				return c < 0
+			}
+			return inAlbum(pieces[i], pieces[j])
+		})
+	case "album":
+		sort.SliceStable(pieces, func(i, j int) bool {
+			if c := compareNames(collator, pieces[i].Album, pieces[j].Album); c != 0 {
+				return c < 0
+			}
+			return inAlbum(pieces[i], pieces[j])
+		})
+	case "title":
+		sort.SliceStable(pieces, func(i, j int) bool {
+			return compareNames(collator, pieces[i].Title, pieces[j].Title) < 0
+		})
+	}
+}
+
+// parseQualityFilter sets the file quality filters of `filter` from
+// `filetype`, `lossless` and `bitrate_min`.
+func parseQualityFilter(q url.Values, filter *benten.Filter) error {
+	filter.FileType = q.Get("filetype")
+	if lossless := q.Get("lossless"); lossless != "" {
+		var err error
+		filter.Lossless, err = strconv.ParseBool(lossless)
+		if err != nil {
+			return fmt.Errorf("lossless (%v) is invalid", lossless)
+		}
+	}
+	if bitrate := q.Get("bitrate_min"); bitrate != "" {
+		var err error
+		filter.MinBitrate, err = strconv.Atoi(bitrate)
+		if err != nil || filter.MinBitrate <= 0 {
+			return fmt.Errorf("bitrate_min (%v) is invalid", bitrate)
+		}
+	}
+	return nil
+}
+
+// duplicates responds with the groups of pieces which look like the same
+// track. It reads the whole library, so it is for admins only.
+func duplicates(w http.ResponseWriter, r *http.Request) {
+	deadline := browseDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
 
+	groups, err := benten.LoadDuplicates(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to find duplicates: %v", err))
+		return
+	}
+	if groups == nil {
+		groups = make([]benten.DuplicateGroup, 0)
+	}
+	respondJSON(w, 200, groups)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/api/get" {
 		get(w, r)
 		return
@@ -166,27 +601,254 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		list(w, r)
 		return
 	}
+	if r.URL.Path == "/api/all" {
+		all(w, r)
+		return
+	}
+	if r.URL.Path == "/api/exists" {
+		exists(w, r)
+		return
+	}
+	if r.URL.Path == "/api/validate" {
+		validateCache(w, r)
+		return
+	}
+	if r.URL.Path == "/api/duplicates" {
+		if requireAdmin(w, r) {
+			duplicates(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/artist-art" {
+		artistArt(w, r)
+		return
+	}
+	if r.URL.Path == "/api/session" {
+		sessions(w, r)
+		return
+	}
+	if r.URL.Path == "/api/assistant/resolve" {
+		assistantResolve(w, r)
+		return
+	}
+	if r.URL.Path == "/api/car" {
+		car(w, r)
+		return
+	}
+	if r.URL.Path == "/api/folders" {
+		folders(w, r)
+		return
+	}
+	if r.URL.Path == "/api/changes" {
+		changes(w, r)
+		return
+	}
+	if r.URL.Path == "/api/stats" {
+		stats(w, r)
+		return
+	}
+	if r.URL.Path == "/api/config" {
+		getClientConfig(w, r)
+		return
+	}
+	if r.URL.Path == "/api/features" {
+		features(w, r)
+		return
+	}
+	if r.URL.Path == "/api/lyrics" {
+		getLyrics(w, r)
+		return
+	}
+	if r.URL.Path == "/api/playlist" {
+		getPlaylist(w, r)
+		return
+	}
+	if r.URL.Path == "/api/playlists/import" {
+		if requireAdmin(w, r) {
+			importPlaylist(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/cache/invalidate" {
+		if requireAdmin(w, r) {
+			invalidateCache(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/aliases" {
+		if requireAdmin(w, r) {
+			adminAliases(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/pieces" {
+		if requireAdmin(w, r) {
+			adminPieces(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/audit" {
+		if requireAdmin(w, r) {
+			adminAudit(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/trash" {
+		if requireAdmin(w, r) {
+			adminTrash(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/search-report" {
+		if requireAdmin(w, r) {
+			adminSearchReport(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/review" {
+		if requireAdmin(w, r) {
+			adminReview(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/tag-rules" {
+		if requireAdmin(w, r) {
+			adminTagRules(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/profiles" {
+		if requireAdmin(w, r) {
+			adminProfiles(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/podcasts" {
+		if requireAdmin(w, r) {
+			adminPodcasts(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/config" {
+		if requireAdmin(w, r) {
+			adminConfig(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/feature-flags" {
+		if requireAdmin(w, r) {
+			adminFeatureFlags(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/maintenance" {
+		if requireAdmin(w, r) {
+			adminMaintenance(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/jobs" {
+		if requireAdmin(w, r) {
+			adminJobs(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/reindex" {
+		if requireAdmin(w, r) {
+			adminReindex(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/index/fanout" {
+		if requireAdmin(w, r) {
+			reindexFanout(w, r)
+		}
+		return
+	}
+	if r.URL.Path == "/api/admin/index/worker" {
+		if requireAdmin(w, r) {
+			reindexWorker(w, r)
+		}
+		return
+	}
 
-	w.WriteHeader(404)
-	w.Header().Add("content-type", "text/plain")
-	w.Write([]byte("Not Found"))
+	respond(w, 404, "Not Found")
 }
 
 func main() {
-	http.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
-		handle(w, r)
-	})
-
+	local := flag.Bool("local", os.Getenv("LOCAL") == "1", "use the emulators given by DATASTORE_EMULATOR_HOST, PUBSUB_EMULATOR_HOST and STORAGE_EMULATOR_HOST")
+	flag.Parse()
 	port := os.Getenv("PORT")
-	projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if *local && os.Getenv("GOOGLE_CLOUD_PROJECT") == "" {
+		os.Setenv("GOOGLE_CLOUD_PROJECT", benten.LocalProjectID)
+	}
+	if err := configure(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *local {
+		benten.UseEmulators(benten.Emulators{})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := benten.CreateLocalResources(ctx, projectID, benten.IndexRequestTopic)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to set up the emulators: %v", err)
+		}
+		log.Printf("Using the emulators with project %s", projectID)
+	}
+
+	if stopwords := os.Getenv("STOPWORDS"); stopwords != "" {
+		benten.SetStopwords(strings.Split(stopwords, ","))
+	}
+	benten.TrashRetention = envDuration("TRASH_RETENTION", benten.TrashRetention)
+	benten.IdempotencyRetention = envDuration("IDEMPOTENCY_TTL", benten.IdempotencyRetention)
+	standaloneDir := os.Getenv("STANDALONE_DIR")
+	if standaloneDir != "" {
+		if err := openStandalone(standaloneDir); err != nil {
+			log.Fatalf("Failed to open the standalone directory: %v", err)
+		}
+		log.Printf("Serving %s", standaloneDir)
+	} else {
+		if err := loadIndexConfig(); err != nil {
+			log.Fatalf("Failed to load the index config: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := checkBuckets(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+	}
+	for _, warning := range configWarnings() {
+		log.Printf("Warning: %s", warning)
+	}
+	if err := openSearchCache(); err != nil {
+		log.Fatalf("Failed to open the search cache: %v", err)
+	}
+	if config := searchConfig(); config.Backend != "" && config.Backend != "datastore" {
+		index, err := search.New(config, nil)
+		if err != nil {
+			log.Fatalf("Failed to open the search index: %v", err)
+		}
+		externalSearchIndex = index
+	}
 
 	if port == "" {
 		port = "8080"
 		log.Printf("Defaulting to port %s", port)
 	}
 
+	var handler http.Handler = http.HandlerFunc(handle)
+	if standaloneDir != "" {
+		handler = http.HandlerFunc(handleStandalone)
+	} else {
+		handler = withReadOnly(withIdempotency(handler))
+		go runJobWorker(context.Background())
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", withAccessLog(handler))
 	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := serve(newServer(":"+port, mux)); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed : %v", err)
 	}
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// readOnlyEnv makes the server read-only regardless of the stored
+// benten.Maintenance, such as for a deployment restoring a backup.
+var readOnlyEnv = os.Getenv("READ_ONLY") == "1"
+
+// maintenanceRetryAfter is the Retry-After of the rejected requests when the
+// end of the maintenance is unknown.
+var maintenanceRetryAfter = envDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute)
+
+// maintenanceCacheDuration is how long the stored benten.Maintenance is
+// cached, which is how long the other instances take to follow a change.
+const maintenanceCacheDuration = 10 * time.Second
+
+// maintenanceFailureCacheDuration is how long a failure to load the
+// benten.Maintenance is cached, so that an outage of the datastore doesn't
+// make every mutating request wait for it.
+const maintenanceFailureCacheDuration = time.Second
+
+// readOnlyEnvMaintenance is the maintenance mode given by READ_ONLY=1.
+var readOnlyEnvMaintenance = benten.Maintenance{ReadOnly: true, Reason: "READ_ONLY is set"}
+
+var maintenanceCache struct {
+	mu          sync.Mutex
+	maintenance benten.Maintenance
+	err         error
+	loadedAt    time.Time
+	// loading is closed when the load in progress finishes, or nil.
+	loading chan struct{}
+}
+
+// readOnlyPaths are the paths taking POST without writing anything.
+var readOnlyPaths = map[string]bool{
+	"/api/exists":   true,
+	"/api/validate": true,
+}
+
+// maintenancePaths are the admin paths let through in the read-only mode:
+// the mode itself, so that it can be turned off, and the jobs index rebuilds
+// are run with.
+var maintenancePaths = map[string]bool{
+	"/api/admin/maintenance":  true,
+	"/api/admin/jobs":         true,
+	"/api/admin/reindex":      true,
+	"/api/admin/index/fanout": true,
+	"/api/admin/index/worker": true,
+}
+
+// mutates returns true if `r` is rejected in the read-only mode. The writes
+// GET makes in passing, such as the bandwidth and the search logs, are kept.
+func mutates(r *http.Request) bool {
+	if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+		return false
+	}
+	return !readOnlyPaths[r.URL.Path] && !maintenancePaths[r.URL.Path]
+}
+
+// currentMaintenance returns the effective maintenance mode, loading the
+// stored one at most once per maintenanceCacheDuration.
+func currentMaintenance(ctx context.Context) (benten.Maintenance, error) {
+	if readOnlyEnv {
+		return readOnlyEnvMaintenance, nil
+	}
+	return cachedMaintenance(ctx, loadMaintenance)
+}
+
+// loadMaintenance loads the stored maintenance mode.
+func loadMaintenance(ctx context.Context) (benten.Maintenance, error) {
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return benten.Maintenance{}, err
+	}
+	defer client.Close()
+	return benten.LoadMaintenance(ctx, client)
+}
+
+// cachedMaintenance returns the maintenance mode `load` returned within
+// maintenanceCacheDuration, or its error within
+// maintenanceFailureCacheDuration. Otherwise it calls `load` without holding
+// the lock, and the concurrent calls wait for it instead of loading again.
+func cachedMaintenance(ctx context.Context, load func(context.Context) (benten.Maintenance, error)) (benten.Maintenance, error) {
+	for {
+		maintenanceCache.mu.Lock()
+		ttl := maintenanceCacheDuration
+		if maintenanceCache.err != nil {
+			ttl = maintenanceFailureCacheDuration
+		}
+		if !maintenanceCache.loadedAt.IsZero() && time.Since(maintenanceCache.loadedAt) < ttl {
+			m, err := maintenanceCache.maintenance, maintenanceCache.err
+			maintenanceCache.mu.Unlock()
+			return m, err
+		}
+		if loading := maintenanceCache.loading; loading != nil {
+			maintenanceCache.mu.Unlock()
+			select {
+			case <-loading:
+				continue
+			case <-ctx.Done():
+				return benten.Maintenance{}, ctx.Err()
+			}
+		}
+		loading := make(chan struct{})
+		maintenanceCache.loading = loading
+		maintenanceCache.mu.Unlock()
+
+		m, err := load(ctx)
+		maintenanceCache.mu.Lock()
+		maintenanceCache.maintenance, maintenanceCache.err = m, err
+		maintenanceCache.loadedAt = time.Now()
+		maintenanceCache.loading = nil
+		maintenanceCache.mu.Unlock()
+		close(loading)
+		return m, err
+	}
+}
+
+// effectiveMaintenance returns the stored maintenance mode `m` with
+// READ_ONLY=1, which overrides it, applied.
+func effectiveMaintenance(m benten.Maintenance) benten.Maintenance {
+	if readOnlyEnv {
+		return readOnlyEnvMaintenance
+	}
+	return m
+}
+
+// retryAfter returns the seconds until the end of `m` for Retry-After.
+func retryAfter(m benten.Maintenance, now time.Time) int {
+	d := maintenanceRetryAfter
+	if m.Until.After(now) {
+		d = m.Until.Sub(now)
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// withReadOnly rejects the mutating requests (see mutates) with 503 while the
+// server is read-only, given by READ_ONLY=1 or the stored
+// benten.Maintenance. The requests are let through when the maintenance mode
+// can't be loaded, as their writes would tell the same error.
+func withReadOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mutates(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), metadataDeadline)
+		m, err := currentMaintenance(ctx)
+		cancel()
+		if err != nil {
+			logf(r.Context(), severityError, "Failed to load the maintenance mode: %v", err)
+		}
+		if !m.ReadOnly {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(m, time.Now())))
+		message := "The server is read-only for maintenance"
+		if m.Reason != "" {
+			message += ": " + m.Reason
+		}
+		respond(w, 503, message)
+	})
+}
+
+// adminMaintenance responds with the effective maintenance mode (GET) and
+// replaces the stored one (PUT). READ_ONLY=1 overrides it, so that the
+// responses tell it while it is set.
+func adminMaintenance(w http.ResponseWriter, r *http.Request) {
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		m, err := benten.LoadMaintenance(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to load the maintenance mode: %v", err))
+			return
+		}
+		respondJSON(w, 200, effectiveMaintenance(m))
+	case "PUT":
+		var m benten.Maintenance
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		before, err := benten.LoadMaintenance(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to load the maintenance mode: %v", err))
+			return
+		}
+		m.Updated = time.Now()
+		if _, err := client.Put(ctx, benten.MaintenanceKey(), &m); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to store the maintenance mode: %v", err))
+			return
+		}
+		// This instance follows at once; the others within maintenanceCacheDuration.
+		maintenanceCache.mu.Lock()
+		maintenanceCache.maintenance, maintenanceCache.err = m, nil
+		maintenanceCache.loadedAt = time.Now()
+		maintenanceCache.mu.Unlock()
+		audit(ctx, client, r, benten.AuditMaintenancePut, "", before.Summary(), m.Summary())
+		respondJSON(w, 200, effectiveMaintenance(m))
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestMutates(t *testing.T) {
+	cases := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/api/get", false},
+		{"HEAD", "/api/get", false},
+		{"POST", "/api/exists", false},
+		{"POST", "/api/validate", false},
+		{"POST", "/api/playlists", true},
+		{"PATCH", "/api/pieces", true},
+		{"PUT", "/api/admin/maintenance", false},
+		{"POST", "/api/admin/reindex", false},
+		{"POST", "/api/admin/jobs", false},
+		{"PATCH", "/api/admin/pieces", true},
+		{"POST", "/api/admin/aliases", true},
+		{"DELETE", "/api/admin/trash", true},
+		{"PUT", "/api/admin/config", true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if got := mutates(r); got != c.want {
+			t.Errorf("mutates(%s %s) = %v, want %v", c.method, c.path, got, c.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	if got := retryAfter(benten.Maintenance{ReadOnly: true}, now); got != int(maintenanceRetryAfter/time.Second) {
+		t.Errorf("retryAfter() = %d without Until", got)
+	}
+	m := benten.Maintenance{ReadOnly: true, Until: now.Add(90*time.Second + time.Millisecond)}
+	if got := retryAfter(m, now); got != 91 {
+		t.Errorf("retryAfter() = %d, want 91", got)
+	}
+	m.Until = now.Add(-time.Minute)
+	if got := retryAfter(m, now); got != int(maintenanceRetryAfter/time.Second) {
+		t.Errorf("retryAfter() = %d after Until", got)
+	}
+}
+
+func TestWithReadOnly(t *testing.T) {
+	defer func(old bool) { readOnlyEnv = old }(readOnlyEnv)
+	readOnlyEnv = true
+	h := withReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/api/playlists", nil))
+	if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("POST: %d, Retry-After: %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/list", nil))
+	if w.Code != 204 {
+		t.Errorf("GET: %d", w.Code)
+	}
+}
+
+func TestCachedMaintenance(t *testing.T) {
+	defer func() {
+		maintenanceCache.maintenance, maintenanceCache.err, maintenanceCache.loadedAt = benten.Maintenance{}, nil, time.Time{}
+	}()
+	ctx := context.Background()
+	loads := 0
+	failing := func(context.Context) (benten.Maintenance, error) {
+		loads++
+		return benten.Maintenance{}, errors.New("unavailable")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cachedMaintenance(ctx, failing); err == nil {
+			t.Errorf("no error from a failing load")
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loaded %d times, want the failure cached", loads)
+	}
+
+	// The concurrent calls share a load, which doesn't hold the lock.
+	maintenanceCache.loadedAt = time.Time{}
+	loads = 0
+	release := make(chan struct{})
+	slow := func(context.Context) (benten.Maintenance, error) {
+		loads++
+		<-release
+		return benten.Maintenance{ReadOnly: true}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m, err := cachedMaintenance(ctx, slow); !m.ReadOnly || err != nil {
+				t.Errorf("cachedMaintenance = %v, %v", m, err)
+			}
+		}()
+	}
+	for {
+		maintenanceCache.mu.Lock()
+		loading := maintenanceCache.loading != nil
+		maintenanceCache.mu.Unlock()
+		if loading {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("loaded %d times, want one load shared", loads)
+	}
+}
+
+func TestEffectiveMaintenance(t *testing.T) {
+	defer func(old bool) { readOnlyEnv = old }(readOnlyEnv)
+	readOnlyEnv = true
+	if m := effectiveMaintenance(benten.Maintenance{}); !m.ReadOnly || m.Reason == "" {
+		t.Errorf("effectiveMaintenance = %v with READ_ONLY", m)
+	}
+}
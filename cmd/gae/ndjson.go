@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// listWriter writes the items of a list response. By default they are buffered
+// and written as a JSON array, but when the client accepts
+// application/x-ndjson they are streamed one JSON value per line as they are
+// added.
+type listWriter struct {
+	w       http.ResponseWriter
+	ndjson  bool
+	started bool
+	items   []interface{}
+}
+
+func newListWriter(w http.ResponseWriter, r *http.Request) *listWriter {
+	return &listWriter{
+		w:      w,
+		ndjson: strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"),
+		items:  make([]interface{}, 0),
+	}
+}
+
+func (l *listWriter) start() {
+	if l.started {
+		return
+	}
+	l.started = true
+	writeHead(l.w, 200, header{ContentType: "application/x-ndjson", ContentLength: -1})
+}
+
+// add writes or buffers `item`.
+func (l *listWriter) add(item interface{}) {
+	if !l.ndjson {
+		l.items = append(l.items, item)
+		return
+	}
+	l.start()
+	if err := json.NewEncoder(l.w).Encode(item); err != nil {
+		logf(requestContext(l.w), severityError, "Failed to write data to response: %v", err)
+	}
+	if flusher, ok := l.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// fail responds with an error, or only logs it when streaming has started.
+func (l *listWriter) fail(code int, message string) {
+	if l.started {
+		logf(requestContext(l.w), severityError, "Aborted a streamed response: %s", message)
+		return
+	}
+	respond(l.w, code, message)
+}
+
+// close finishes the response. `wrap`, if non-nil, wraps the JSON array into
+// an object; when streaming, the value it returns for a nil array is written
+// as the last line, e.g. to tell the cursor to continue from.
+func (l *listWriter) close(wrap func(items []interface{}) interface{}) {
+	if l.ndjson {
+		l.start()
+		if wrap != nil {
+			l.add(wrap(nil))
+		}
+		return
+	}
+	var body interface{} = l.items
+	if wrap != nil {
+		body = wrap(l.items)
+	}
+	respondJSON(l.w, 200, body)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListWriter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/list", nil)
+	w := httptest.NewRecorder()
+	l := newListWriter(w, r)
+	l.add(1)
+	l.add(2)
+	l.close(nil)
+	if got := w.Body.String(); got != "[1,2]\n" || w.Header().Get("content-type") != "application/json" {
+		t.Errorf("body = %q", got)
+	}
+
+	r.Header.Set("Accept", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	l = newListWriter(w, r)
+	l.add(1)
+	l.add(2)
+	l.close(func(items []interface{}) interface{} { return "end" })
+	if got := w.Body.String(); got != "1\n2\n\"end\"\n" || w.Header().Get("content-type") != "application/x-ndjson" {
+		t.Errorf("body = %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// The maximum size of an imported playlist file.
+const maxPlaylistFileSize = 4 << 20
+
+// importPlaylist imports the M3U, M3U8 or PLS file in the request body as the
+// playlist `name`. `format` ("m3u" or "pls") is optional, as the format is
+// detected from the contents. It responds with the number of imported entries
+// and the unresolved ones.
+func importPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		respond(w, 400, "name is required")
+		return
+	}
+	entries, err := benten.ParsePlaylistFile("playlist."+q.Get("format"), http.MaxBytesReader(w, r.Body, maxPlaylistFileSize))
+	if err != nil {
+		respond(w, 400, fmt.Sprintf("Failed to parse the playlist: %v", err))
+		return
+	}
+
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get pieces: %v", err))
+		return
+	}
+	playlist, unresolved, err := benten.ImportPlaylist(ctx, client, matcher, name, entries)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to import the playlist: %v", err))
+		return
+	}
+	audit(ctx, client, r, benten.AuditPlaylistImported, name, "", fmt.Sprintf("%d pieces", len(playlist.Pieces)))
+	respondJSON(w, 200, struct {
+		Imported   int
+		Unresolved []benten.PlaylistEntry
+	}{len(playlist.Pieces), unresolved})
+}
+
+// getPlaylist responds with the playlist `name` and its pieces in order, each
+// with its key. The pieces deleted or in the trash are left out.
+func getPlaylist(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		respond(w, 400, "name is required")
+		return
+	}
+
+	deadline := listDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	var playlist benten.Playlist
+	if err := client.Get(ctx, benten.PlaylistKey(name), &playlist); err == datastore.ErrNoSuchEntity {
+		respond(w, 404, fmt.Sprintf("Not found: playlist %s", name))
+		return
+	} else if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the playlist: %v", err))
+		return
+	}
+	pieces := make([]benten.Metadata, len(playlist.Pieces))
+	var errs datastore.MultiError
+	if err := client.GetMulti(ctx, playlist.Pieces, pieces); err != nil {
+		var ok bool
+		if errs, ok = err.(datastore.MultiError); !ok {
+			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+	}
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	response := struct {
+		Name    string
+		Updated time.Time
+		Pieces  []entry
+	}{Name: playlist.Name, Updated: playlist.Updated, Pieces: []entry{}}
+	for i, key := range playlist.Pieces {
+		if errs != nil && errs[i] != nil {
+			if errs[i] != datastore.ErrNoSuchEntity {
+				respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", errs[i]))
+				return
+			}
+			continue
+		}
+		if pieces[i].IsTrashed() {
+			continue
+		}
+		response.Pieces = append(response.Pieces, entry{key.Encode(), pieces[i]})
+	}
+	respondJSON(w, 200, response)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/dhowden/tag"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/podcast"
+)
+
+// The maximum size of an episode.
+const maxEpisodeSize = 1 << 30
+
+// episodeDownloadTimeout bounds downloading an episode, which must finish
+// well within jobLease.
+const episodeDownloadTimeout = 90 * time.Second
+
+// The maximum length of the description kept in Metadata.Comment, which is
+// indexed and so limited to 1500 bytes by the datastore.
+const maxEpisodeCommentBytes = 1000
+
+// episodeTypes are the extensions and the file types of the audio MIME types.
+var episodeTypes = map[string]struct {
+	ext      string
+	fileType tag.FileType
+}{
+	"audio/mpeg":  {".mp3", tag.MP3},
+	"audio/mp3":   {".mp3", tag.MP3},
+	"audio/mp4":   {".m4a", tag.M4A},
+	"audio/x-m4a": {".m4a", tag.M4A},
+	"audio/aac":   {".m4a", tag.M4A},
+	"audio/ogg":   {".ogg", tag.OGG},
+	"audio/flac":  {".flac", tag.FLAC},
+}
+
+// podcastResponse is a podcast with its ID.
+type podcastResponse struct {
+	ID int64
+	benten.Podcast
+}
+
+// adminPodcasts lists the subscribed podcasts (GET), subscribes to the feed
+// `url` (POST), downloading its latest `episodes` (PodcastEpisodesOnSubscribe
+// by default), and unsubscribes from the podcast `id` (DELETE), keeping the
+// downloaded episodes. New episodes are downloaded by "podcasts" jobs, which
+// should be started regularly, e.g. by Cloud Scheduler.
+func adminPodcasts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		var podcasts []benten.Podcast
+		keys, err := client.GetAll(ctx, datastore.NewQuery(benten.PodcastKind), &podcasts)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get podcasts: %v", err))
+			return
+		}
+		responses := make([]podcastResponse, 0, len(podcasts))
+		for i, p := range podcasts {
+			responses = append(responses, podcastResponse{keys[i].ID, p})
+		}
+		respondJSON(w, 200, responses)
+	case "POST":
+		feedURL := q.Get("url")
+		if u, err := url.Parse(feedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			respond(w, 400, fmt.Sprintf("url (%v) is invalid", feedURL))
+			return
+		}
+		episodes := benten.PodcastEpisodesOnSubscribe
+		if episodesString := q.Get("episodes"); episodesString != "" {
+			episodes, err = strconv.Atoi(episodesString)
+			if err != nil || episodes < 0 {
+				respond(w, 400, fmt.Sprintf("episodes (%v) is invalid", episodesString))
+				return
+			}
+		}
+		existing, err := client.GetAll(ctx, datastore.NewQuery(benten.PodcastKind).Filter("FeedURL =", feedURL).KeysOnly(), nil)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get podcasts: %v", err))
+			return
+		}
+		if len(existing) > 0 {
+			respond(w, 409, fmt.Sprintf("Already subscribed: %s (%d)", feedURL, existing[0].ID))
+			return
+		}
+		feed, err := podcast.Fetch(ctx, nil, feedURL)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to read the feed: %v", err))
+			return
+		}
+		now := time.Now()
+		p := benten.Podcast{FeedURL: feedURL, Title: feed.Title, Subscribed: now, Latest: now, Checked: now}
+		if episodes > 0 {
+			p.Latest = feed.Latest(episodes + 1)
+		}
+		key, err := client.Put(ctx, datastore.IncompleteKey(benten.PodcastKind, nil), &p)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to put the podcast: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditPodcastAdded, strconv.FormatInt(key.ID, 10), "", feedURL)
+		if episodes > 0 {
+			if _, _, err := benten.CreateJob(ctx, client, "podcasts"); err != nil {
+				logf(ctx, severityWarning, "Failed to start a podcasts job: %v", err)
+			}
+			select {
+			case jobWakeup <- struct{}{}:
+			default:
+			}
+		}
+		respondJSON(w, 201, podcastResponse{key.ID, p})
+	case "DELETE":
+		idString := q.Get("id")
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("id (%v) is invalid", idString))
+			return
+		}
+		key := datastore.IDKey(benten.PodcastKind, id, nil)
+		var p benten.Podcast
+		if err := client.Get(ctx, key, &p); err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: podcast %d", id))
+			return
+		} else if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the podcast: %v", err))
+			return
+		}
+		if err := client.Delete(ctx, key); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to delete the podcast: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditPodcastDeleted, idString, p.FeedURL, "")
+		respond(w, 200, "OK")
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// podcastsStep checks the podcast after the one in the cursor, and downloads
+// its oldest new episode. The cursor stays until the podcast has no new
+// episodes. A feed failing is recorded in its Podcast and doesn't fail the
+// job.
+func podcastsStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	query := datastore.NewQuery(benten.PodcastKind).Order("__key__").Limit(1)
+	if job.Cursor != "" {
+		last, err := datastore.DecodeKey(job.Cursor)
+		if err != nil {
+			return false, err
+		}
+		query = query.Filter("__key__ >", last)
+	}
+	var podcasts []benten.Podcast
+	keys, err := client.GetAll(ctx, query, &podcasts)
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 {
+		return true, nil
+	}
+	key, p := keys[0], &podcasts[0]
+
+	more := false
+	p.Checked = time.Now()
+	p.Error = ""
+	feed, err := podcast.Fetch(ctx, nil, p.FeedURL)
+	if err == nil {
+		p.Title = feed.Title
+		if episodes := feed.NewEpisodes(p.Latest); len(episodes) > 0 {
+			err = downloadEpisode(ctx, client, key, feed, &episodes[0])
+			if err == nil {
+				p.Latest = episodes[0].Published
+				job.Processed++
+				more = len(episodes) > 1
+			}
+		}
+	}
+	if err != nil {
+		logf(ctx, severityWarning, "Failed to check podcast %d (%s): %v", key.ID, p.FeedURL, err)
+		p.Error = err.Error()
+	}
+	if _, err := client.Put(ctx, key, p); err != nil {
+		return false, err
+	}
+	if !more {
+		job.Cursor = key.Encode()
+	}
+	return false, nil
+}
+
+// downloadEpisode copies `episode` of `feed` to PieceBucket, and stores its
+// Metadata and index. Episodes already downloaded, e.g. by a step whose
+// worker went away, are skipped.
+func downloadEpisode(ctx context.Context, client *datastore.Client, podcastKey *datastore.Key, feed *podcast.Feed, episode *podcast.Episode) error {
+	query := datastore.NewQuery(benten.PieceKind).Filter("Podcast =", podcastKey.ID).Filter("EpisodeGUID =", episode.GUID).KeysOnly()
+	existing, err := client.GetAll(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, episodeDownloadTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", episode.URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to download %s: %s", episode.URL, res.Status)
+	}
+	mimeType := episode.MIMEType
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "audio/") {
+		mimeType = mediaType
+	}
+	episodeType, ok := episodeTypes[mimeType]
+	if !ok {
+		u, _ := url.Parse(episode.URL)
+		episodeType.ext = strings.ToLower(path.Ext(u.Path))
+	}
+
+	// Episodes are named by their GUIDs, which are stable while the files
+	// may be re-encoded.
+	guidSum := sha256.Sum256([]byte(episode.GUID))
+	object := fmt.Sprintf("podcasts/%d/%s%s", podcastKey.ID, hex.EncodeToString(guidSum[:16]), episodeType.ext)
+	storageClient, err := storage.NewClient(ctx, benten.StorageOptions()...)
+	if err != nil {
+		return err
+	}
+	defer storageClient.Close()
+	writer := storageClient.Bucket(benten.PieceBucket).Object(object).NewWriter(ctx)
+	writer.ContentType = mimeType
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(writer, h), io.LimitReader(res.Body, maxEpisodeSize+1))
+	if err == nil && n > maxEpisodeSize {
+		err = fmt.Errorf("%s is larger than %d bytes", episode.URL, maxEpisodeSize)
+	}
+	if err != nil {
+		// Closing the writer would commit the partial object.
+		cancel()
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	artist := feed.Author
+	if artist == "" {
+		artist = feed.Title
+	}
+	now := time.Now()
+	metadata := benten.Metadata{
+		FileType:    string(episodeType.fileType),
+		MediaType:   benten.MediaAudio,
+		Title:       episode.Title,
+		Album:       feed.Title,
+		Artist:      artist,
+		AlbumArtist: artist,
+		Genre:       "Podcast",
+		Year:        episode.Published.Year(),
+		Duration:    episode.Duration,
+		Comment:     truncate(episode.Description, maxEpisodeCommentBytes),
+		// The whole file, like tag.SumAll.
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		Path:        object,
+		Naming:      benten.NamingPath,
+		Object:      object,
+		Created:     now,
+		Updated:     now,
+		Revision:    1,
+		Podcast:     podcastKey.ID,
+		EpisodeGUID: episode.GUID,
+		Published:   episode.Published,
+	}
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		return err
+	}
+	metadata.SetAlbumKey(aliases)
+	key, err := client.Put(ctx, datastore.IncompleteKey(benten.PieceKind, nil), &metadata)
+	if err != nil {
+		return err
+	}
+	if err := benten.SpanIndex(ctx, client, &metadata, key, aliases); err != nil {
+		return err
+	}
+	if externalSearchIndex != nil {
+		if err := externalSearchIndex.Index(ctx, key, &metadata, aliases); err != nil {
+			return err
+		}
+	}
+	invalidateSearchCache(ctx)
+	entry := benten.AuditLog{Actor: "podcast", Action: benten.AuditPieceAdded, Target: key.Encode(), After: metadata.Title}
+	if err := benten.RecordAudit(ctx, client, entry); err != nil {
+		logf(ctx, severityWarning, "Failed to record %s on %s: %v", entry.Action, entry.Target, err)
+	}
+	return nil
+}
+
+// truncate returns the first `n` bytes of `s`, without breaking characters.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
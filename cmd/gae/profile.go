@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// How long loaded client profiles are reused.
+const profileCacheDuration = time.Minute
+
+type cachedProfileEntry struct {
+	// profile is nil for a device without a profile.
+	profile  *benten.ClientProfile
+	loadedAt time.Time
+}
+
+var profileCache struct {
+	mu       sync.Mutex
+	profiles map[string]cachedProfileEntry
+}
+
+// cachedProfile returns the profile of `device`, or nil if it has none,
+// loading it at most once per profileCacheDuration.
+func cachedProfile(ctx context.Context, client *datastore.Client, device string) (*benten.ClientProfile, error) {
+	profileCache.mu.Lock()
+	defer profileCache.mu.Unlock()
+	if entry, ok := profileCache.profiles[device]; ok && time.Since(entry.loadedAt) < profileCacheDuration {
+		return entry.profile, nil
+	}
+	var profile benten.ClientProfile
+	err := client.Get(ctx, benten.ClientProfileKey(device), &profile)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	entry := cachedProfileEntry{loadedAt: time.Now()}
+	if err == nil {
+		entry.profile = &profile
+	}
+	if profileCache.profiles == nil {
+		profileCache.profiles = make(map[string]cachedProfileEntry)
+	}
+	profileCache.profiles[device] = entry
+	return entry.profile, nil
+}
+
+// forgetProfile drops the cached profile of `device` of this instance. The
+// other instances see the change within profileCacheDuration.
+func forgetProfile(device string) {
+	profileCache.mu.Lock()
+	defer profileCache.mu.Unlock()
+	delete(profileCache.profiles, device)
+}
+
+// adminProfiles lists the client profiles (GET), puts one (PUT) taking a JSON
+// benten.ClientProfile, and deletes the profile of `device` (DELETE). get
+// streams the pieces asked for with `device` in the quality its profile
+// prefers.
+func adminProfiles(w http.ResponseWriter, r *http.Request) {
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		profiles := make([]benten.ClientProfile, 0)
+		if _, err := client.GetAll(ctx, datastore.NewQuery(benten.ClientProfileKind), &profiles); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get profiles: %v", err))
+			return
+		}
+		respondJSON(w, 200, profiles)
+	case "PUT":
+		var profile benten.ClientProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		if profile.Device == "" {
+			respond(w, 400, "Device is required")
+			return
+		}
+		if profile.WiFi.MaxBitrate < 0 || profile.Cellular.MaxBitrate < 0 {
+			respond(w, 400, "MaxBitrate must not be negative")
+			return
+		}
+		var old benten.ClientProfile
+		err := client.Get(ctx, benten.ClientProfileKey(profile.Device), &old)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			respond(w, 500, fmt.Sprintf("Failed to get the profile: %v", err))
+			return
+		}
+		before := ""
+		if err == nil {
+			before = old.Summary()
+		}
+		profile.Updated = time.Now()
+		if _, err := client.Put(ctx, benten.ClientProfileKey(profile.Device), &profile); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to put the profile: %v", err))
+			return
+		}
+		forgetProfile(profile.Device)
+		audit(ctx, client, r, benten.AuditProfilePut, profile.Device, before, profile.Summary())
+		respondJSON(w, 200, profile)
+	case "DELETE":
+		device := r.URL.Query().Get("device")
+		var old benten.ClientProfile
+		err := client.Get(ctx, benten.ClientProfileKey(device), &old)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", device))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get the profile: %v", err))
+			return
+		}
+		if err := client.Delete(ctx, benten.ClientProfileKey(device)); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to delete the profile: %v", err))
+			return
+		}
+		forgetProfile(device)
+		audit(ctx, client, r, benten.AuditProfileDeleted, device, old.Summary(), "")
+		respond(w, 200, "OK")
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/pubsub"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// indexRequest is the message published to benten.IndexRequestTopic. It asks
+// the worker to respan the index of a piece.
+type indexRequest struct {
+	// Key is the encoded datastore key of the piece.
+	Key string
+}
+
+// pushRequest is the body of a request from a Pub/Sub push subscription.
+type pushRequest struct {
+	Message struct {
+		Data []byte
+		ID   string `json:"messageId"`
+	}
+	Subscription string
+}
+
+// rateLimiter allows up to `rate` events per second with bursts of the same size.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+var indexWorkerLimiter = newRateLimiter(indexWorkerRate())
+
+// indexWorkerRate returns the maximum number of pieces indexed per second per
+// instance, given by INDEX_WORKER_RATE.
+func indexWorkerRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("INDEX_WORKER_RATE"), 64)
+	if err != nil || rate <= 0 {
+		return 10
+	}
+	return rate
+}
+
+// publishIndexRequests publishes an index request for each of `keys` to `topic`.
+func publishIndexRequests(ctx context.Context, topic *pubsub.Topic, keys []*datastore.Key) error {
+	var results []*pubsub.PublishResult
+	for _, key := range keys {
+		data, err := json.Marshal(indexRequest{Key: key.Encode()})
+		if err != nil {
+			return err
+		}
+		results = append(results, topic.Publish(ctx, &pubsub.Message{Data: data}))
+	}
+	for _, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexFanout publishes an index request for each piece. It handles at most
+// `limit` pieces starting from `cursor`, and responds with the cursor to
+// continue from, which is empty when all the pieces have been enqueued.
+func reindexFanout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := 1000
+	if limitString := q.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 || limit > 100*1000 {
+			respond(w, 400, fmt.Sprintf("limit (%v) is invalid", limitString))
+			return
+		}
+	}
+
+	deadline := fanoutDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+	pubsubClient, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a pubsub client: %v", err))
+		return
+	}
+	defer pubsubClient.Close()
+	topic := pubsubClient.Topic(benten.IndexRequestTopic)
+	defer topic.Stop()
+
+	query := datastore.NewQuery(benten.PieceKind).KeysOnly().Limit(limit)
+	if cursorString := q.Get("cursor"); cursorString != "" {
+		cursor, err := datastore.DecodeCursor(cursorString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", cursorString))
+			return
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	var keys []*datastore.Key
+	for {
+		key, err := t.Next(nil)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get key: %v", err))
+			return
+		}
+		keys = append(keys, key)
+	}
+	if err := publishIndexRequests(ctx, topic, keys); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to publish: %v", err))
+		return
+	}
+
+	next := ""
+	if len(keys) == limit {
+		cursor, err := t.Cursor()
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get a cursor: %v", err))
+			return
+		}
+		next = cursor.String()
+	}
+	respondJSON(w, 200, struct {
+		Enqueued int
+		Cursor   string
+	}{len(keys), next})
+}
+
+// reindexWorker respans the index of the piece named in a Pub/Sub push
+// message. Failures are reported with non-2xx statuses so that Pub/Sub
+// retries the message, and 429 makes it back off when the rate is exceeded.
+func reindexWorker(w http.ResponseWriter, r *http.Request) {
+	if !indexWorkerLimiter.allow() {
+		respond(w, 429, "Too many requests")
+		return
+	}
+	var push pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		respond(w, 400, fmt.Sprintf("Failed to parse the push request: %v", err))
+		return
+	}
+	var request indexRequest
+	if err := json.Unmarshal(push.Message.Data, &request); err != nil {
+		// Retrying doesn't help, so acknowledge the message.
+		logf(r.Context(), severityWarning, "Dropping a malformed message %s: %v", push.Message.ID, err)
+		respond(w, 200, "Dropped")
+		return
+	}
+	key, err := datastore.DecodeKey(request.Key)
+	if err != nil {
+		logf(r.Context(), severityWarning, "Dropping a message %s with an invalid key: %v", push.Message.ID, err)
+		respond(w, 200, "Dropped")
+		return
+	}
+
+	deadline := indexWorkerDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	var piece benten.Metadata
+	err = client.Get(ctx, key, &piece)
+	if err == datastore.ErrNoSuchEntity {
+		respond(w, 200, fmt.Sprintf("%v no longer exists", key))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+		return
+	}
+	if piece.IsTrashed() {
+		// Trashed pieces are not indexed.
+		respond(w, 200, fmt.Sprintf("%v is in the trash", key))
+		return
+	}
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to load aliases: %v", err))
+		return
+	}
+	if err := refreshAlbumKey(ctx, client, key, &piece, aliases); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to update the album of %v: %v", key, err))
+		return
+	}
+	if err := benten.RespanIndex(ctx, client, &piece, key, aliases); err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to respan the index for %v: %v", key, err))
+		return
+	}
+	if externalSearchIndex != nil {
+		if err := externalSearchIndex.Index(ctx, key, &piece, aliases); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to update the search index for %v: %v", key, err))
+			return
+		}
+	}
+	invalidateSearchCache(ctx)
+	respond(w, 200, "OK")
+}
+
+// refreshAlbumKey sets the AlbumKey of `piece` stored at `key` with `aliases`,
+// and writes the piece if that changes it, so that the albums of the artists
+// whose aliases changed are regrouped by reindexing.
+func refreshAlbumKey(ctx context.Context, client *datastore.Client, key *datastore.Key, piece *benten.Metadata, aliases benten.Aliases) error {
+	albumKey := piece.AlbumKey
+	piece.SetAlbumKey(aliases)
+	if piece.AlbumKey == albumKey {
+		return nil
+	}
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, piece); err != nil {
+			return err
+		}
+		albumKey := piece.AlbumKey
+		piece.SetAlbumKey(aliases)
+		if piece.AlbumKey == albumKey {
+			return nil
+		}
+		piece.Revision++
+		piece.Updated = time.Now()
+		_, err := tx.Put(key, piece)
+		return err
+	})
+	return err
+}
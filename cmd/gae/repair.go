@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// repairBatchSize is the number of entities a repair-index step checks.
+const repairBatchSize = 100
+
+// repairIndexStep checks the next batch of pieces for missing index entities,
+// and then the index for entities whose pieces are gone, repairing them; see
+// benten.RepairIndex. The cursor is "pieces:<cursor>" or "index:<cursor>".
+func repairIndexStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	phase, cursorString := "pieces", ""
+	if job.Cursor != "" {
+		parts := strings.SplitN(job.Cursor, ":", 2)
+		if len(parts) != 2 || (parts[0] != "pieces" && parts[0] != "index") {
+			return false, fmt.Errorf("cursor (%v) is invalid", job.Cursor)
+		}
+		phase, cursorString = parts[0], parts[1]
+	}
+	var query *datastore.Query
+	if phase == "pieces" {
+		query = datastore.NewQuery(benten.PieceKind).Limit(repairBatchSize)
+	} else {
+		query = datastore.NewQuery(benten.PieceIndexKind).KeysOnly().Limit(repairBatchSize)
+	}
+	if cursorString != "" {
+		cursor, err := datastore.DecodeCursor(cursorString)
+		if err != nil {
+			return false, err
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	var keys []*datastore.Key
+	var pieces []benten.Metadata
+	for {
+		var piece benten.Metadata
+		var key *datastore.Key
+		var err error
+		if phase == "pieces" {
+			key, err = t.Next(&piece)
+		} else {
+			key, err = t.Next(nil)
+		}
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, key)
+		pieces = append(pieces, piece)
+	}
+
+	var repaired int
+	if len(keys) == 0 {
+		// Nothing to check.
+	} else if phase == "pieces" {
+		aliases, err := cachedAliases(ctx, client)
+		if err != nil {
+			return false, err
+		}
+		if repaired, err = benten.RepairIndex(ctx, client, keys, pieces, aliases); err != nil {
+			return false, err
+		}
+	} else {
+		var err error
+		if repaired, err = benten.DeleteOrphanIndexes(ctx, client, keys); err != nil {
+			return false, err
+		}
+	}
+	job.Processed += len(keys)
+	job.Repaired += repaired
+	if len(keys) < repairBatchSize {
+		if phase == "index" {
+			if job.Repaired > 0 {
+				invalidateSearchCache(ctx)
+			}
+			return true, nil
+		}
+		job.Cursor = "index:"
+		return false, nil
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return false, err
+	}
+	job.Cursor = phase + ":" + cursor.String()
+	return false, nil
+}
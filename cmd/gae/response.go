@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// header describes the headers of a response. writeHead sets them all before
+// writing the status, as headers set afterwards are silently dropped.
+type header struct {
+	// ContentType is the media type of the body.
+	ContentType string
+	// ContentLength is the size of the body, or negative if unknown.
+	ContentLength int64
+	// Disposition is "inline" or "attachment", or empty for no
+	// Content-Disposition header.
+	Disposition string
+	// Filename is the file name offered with Disposition.
+	Filename string
+	// CacheControl is the value of Cache-Control, if any.
+	CacheControl string
+	// AcceptRanges advertises that Range requests are served.
+	AcceptRanges bool
+	// ContentRange is the value of Content-Range, if any.
+	ContentRange string
+}
+
+func writeHead(w http.ResponseWriter, code int, h header) {
+	if h.ContentType != "" {
+		w.Header().Set("content-type", h.ContentType)
+	}
+	if h.ContentLength >= 0 {
+		w.Header().Set("content-length", strconv.FormatInt(h.ContentLength, 10))
+	}
+	if h.Disposition != "" {
+		params := map[string]string{}
+		if h.Filename != "" {
+			params["filename"] = h.Filename
+		}
+		w.Header().Set("content-disposition", mime.FormatMediaType(h.Disposition, params))
+	}
+	if h.CacheControl != "" {
+		w.Header().Set("cache-control", h.CacheControl)
+	}
+	if h.AcceptRanges {
+		w.Header().Set("accept-ranges", "bytes")
+	}
+	if h.ContentRange != "" {
+		w.Header().Set("content-range", h.ContentRange)
+	}
+	w.WriteHeader(code)
+}
+
+// respondJSON responds with `value` encoded in JSON.
+func respondJSON(w http.ResponseWriter, code int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		respond(w, 500, "Failed to encode the response")
+		return
+	}
+	data = append(data, '\n')
+	writeHead(w, code, header{ContentType: "application/json", ContentLength: int64(len(data))})
+	w.Write(data)
+}
+
+// errRangeNotSatisfiable is returned by parseRange for ranges outside the
+// body, which are answered with 416.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange parses the Range header `s` of a request for a body of `size`
+// bytes, and returns the first byte and the length of the range. The length
+// is negative for the whole body, which is served for no, malformed and
+// multiple ranges, as RFC 7233 allows.
+func parseRange(s string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, -1, nil
+	}
+	dash := strings.Index(s, "-")
+	if dash < 0 {
+		return 0, -1, nil
+	}
+	first, last := strings.TrimSpace(s[len("bytes="):dash]), strings.TrimSpace(s[dash+1:])
+	if first == "" {
+		// "bytes=-n" is the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, -1, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, -1, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// contentRange returns the Content-Range of the range parsed by parseRange.
+func contentRange(start, length, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size)
+}
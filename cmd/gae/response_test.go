@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteHead(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeHead(w, 200, header{ContentType: "audio/mpeg", ContentLength: 42, Disposition: "attachment", Filename: "Café.mp3"})
+	if got := w.Header().Get("content-type"); got != "audio/mpeg" {
+		t.Errorf("content-type = %q", got)
+	}
+	if got := w.Header().Get("content-length"); got != "42" {
+		t.Errorf("content-length = %q", got)
+	}
+	if got := w.Header().Get("content-disposition"); got != "attachment; filename*=utf-8''Caf%C3%A9.mp3" {
+		t.Errorf("content-disposition = %q", got)
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	if got := downloadFilename("a/b.flac", "", "audio/flac"); got != "b.flac" {
+		t.Errorf("filename = %q", got)
+	}
+	if got := downloadFilename("abc", "../x.mp3", "audio/mpeg"); got != "x.mp3" {
+		t.Errorf("filename = %q", got)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, c := range []struct {
+		header        string
+		start, length int64
+		err           error
+	}{
+		{"", 0, -1, nil},
+		{"bytes=0-99", 0, 100, nil},
+		{"bytes=100-", 100, 900, nil},
+		{"bytes=900-2000", 900, 100, nil},
+		{"bytes=-100", 900, 100, nil},
+		{"bytes=-2000", 0, 1000, nil},
+		{"bytes=0-1,5-9", 0, -1, nil},
+		{"bytes=9-1", 0, -1, nil},
+		{"items=0-1", 0, -1, nil},
+		{"bytes=abc", 0, -1, nil},
+		{"bytes=1000-", 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 0, 0, errRangeNotSatisfiable},
+	} {
+		start, length, err := parseRange(c.header, 1000)
+		if start != c.start || length != c.length || err != c.err {
+			t.Errorf("parseRange(%q) = %d, %d, %v, want %d, %d, %v", c.header, start, length, err, c.start, c.length, c.err)
+		}
+	}
+	if got := contentRange(900, 100, 1000); got != "bytes 900-999/1000" {
+		t.Errorf("contentRange = %q", got)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// reviewEntry is a piece in the review queue.
+type reviewEntry struct {
+	Key      string
+	ETag     string
+	Reasons  []string
+	Metadata benten.Metadata
+}
+
+// adminReview responds with up to `limit` pieces in the review queue from
+// `cursor` (GET), which are those missing Title, Artist or Album or with
+// fields inferred from their paths, and the cursor of the next page, which is
+// empty at the end. POST with `key` and `action` leaves the queue: "accept"
+// keeps the metadata as it is, and "edit" edits it like PATCH on
+// /api/admin/pieces. Both require If-Match with the ETag.
+func adminReview(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		limit, err := parseLimit(q, 100, 1000)
+		if err != nil {
+			respond(w, 400, err.Error())
+			return
+		}
+		query := datastore.NewQuery(benten.PieceKind).Filter("NeedsReview =", true).Limit(limit)
+		if cursorString := q.Get("cursor"); cursorString != "" {
+			cursor, err := datastore.DecodeCursor(cursorString)
+			if err != nil {
+				respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", cursorString))
+				return
+			}
+			query = query.Start(cursor)
+		}
+		response := struct {
+			Pieces []reviewEntry
+			Cursor string
+		}{Pieces: []reviewEntry{}}
+		t := client.Run(ctx, query)
+		for {
+			var piece benten.Metadata
+			key, err := t.Next(&piece)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+				return
+			}
+			response.Pieces = append(response.Pieces, reviewEntry{key.Encode(), etag(&piece), piece.ReviewReasons(), piece})
+		}
+		if len(response.Pieces) == limit {
+			cursor, err := t.Cursor()
+			if err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to get a cursor: %v", err))
+				return
+			}
+			response.Cursor = cursor.String()
+		}
+		respondJSON(w, 200, response)
+	case "POST":
+		keyString := q.Get("key")
+		key, err := datastore.DecodeKey(keyString)
+		if err != nil || key.Kind != benten.PieceKind {
+			respond(w, 400, fmt.Sprintf("key (%v) is invalid", keyString))
+			return
+		}
+		switch action := q.Get("action"); action {
+		case "accept":
+			acceptReview(ctx, w, r, client, key)
+		case "edit":
+			patchPiece(ctx, w, r, client, key)
+		default:
+			respond(w, 400, fmt.Sprintf("action (%v) is invalid", action))
+		}
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// acceptReview takes the piece at `key` out of the review queue as it is.
+func acceptReview(ctx context.Context, w http.ResponseWriter, r *http.Request, client *datastore.Client, key *datastore.Key) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respond(w, 428, "If-Match is required")
+		return
+	}
+	var piece benten.Metadata
+	var reasons []string
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, &piece); err != nil {
+			return err
+		}
+		if strings.TrimPrefix(ifMatch, "W/") != etag(&piece) {
+			return errPreconditionFailed
+		}
+		reasons = piece.ReviewReasons()
+		now := time.Now()
+		piece.Inferred = ""
+		piece.Reviewed = now
+		piece.SetNeedsReview()
+		piece.Revision++
+		piece.Updated = now
+		_, err := tx.Put(key, &piece)
+		return err
+	})
+	if err == datastore.ErrNoSuchEntity {
+		respond(w, 404, fmt.Sprintf("Not found: %s", key.Encode()))
+		return
+	}
+	if err == errPreconditionFailed {
+		respond(w, 412, "The piece has been modified")
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to accept the piece: %v", err))
+		return
+	}
+	audit(ctx, client, r, benten.AuditPieceReviewed, key.Encode(), strings.Join(reasons, ","), "")
+	w.Header().Set("ETag", etag(&piece))
+	respondJSON(w, 200, piece)
+}
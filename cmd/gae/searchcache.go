@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/cache"
+)
+
+// searchCache is the cache configured by SEARCH_CACHE ("memory" or
+// "redis://<host>:<port>"), or nil. Results live for SEARCH_CACHE_TTL,
+// one minute by default.
+var searchCache benten.SearchCache
+var searchCacheTTL = time.Minute
+
+func openSearchCache() error {
+	url := os.Getenv("SEARCH_CACHE")
+	if url == "" {
+		return nil
+	}
+	searchCacheTTL = envDuration("SEARCH_CACHE_TTL", searchCacheTTL)
+	c, err := cache.Open(url)
+	if err != nil {
+		return err
+	}
+	searchCache = c
+	return nil
+}
+
+// searchCacheKey identifies a search by everything which affects its results.
+// `external` is whether externalSearchIndex is searched.
+func searchCacheKey(text string, filter benten.Filter, phonetic, external bool, limit int) string {
+	return fmt.Sprintf("%q|%q|%q|%d|%d|%v|%v|%q|%q|%v|%d|%v|%v|%d",
+		benten.Normalize(text), benten.Normalize(filter.Genre), benten.Normalize(filter.AlbumArtist), filter.Year, filter.OriginalYear,
+		filter.MinBPM, filter.MaxBPM, benten.NormalizeKey(filter.Key), strings.ToUpper(filter.FileType), filter.Lossless, filter.MinBitrate,
+		phonetic, external, limit)
+}
+
+// cachedSearch returns the results for `key` from searchCache, or calls
+// `search` and caches its results. Cached results have no Metadata. Cache
+// failures are logged and otherwise ignored.
+func cachedSearch(ctx context.Context, key string, search func() ([]benten.SearchResult, error)) ([]benten.SearchResult, error) {
+	if searchCache == nil {
+		return search()
+	}
+	encoded, ok, err := searchCache.Get(ctx, key)
+	if err != nil {
+		logf(ctx, severityWarning, "Failed to get cached results: %v", err)
+	}
+	if ok {
+		results := make([]benten.SearchResult, 0, len(encoded))
+		for _, e := range encoded {
+			k, err := datastore.DecodeKey(e)
+			if err != nil {
+				return search()
+			}
+			results = append(results, benten.SearchResult{Key: k})
+		}
+		return results, nil
+	}
+	results, err := search()
+	if err != nil {
+		return nil, err
+	}
+	encoded = make([]string, 0, len(results))
+	for _, result := range results {
+		encoded = append(encoded, result.Key.Encode())
+	}
+	if err := searchCache.Set(ctx, key, encoded, searchCacheTTL); err != nil {
+		logf(ctx, severityWarning, "Failed to cache results: %v", err)
+	}
+	return results, nil
+}
+
+// invalidateSearchCache drops the cached results after the library or the
+// index changed.
+func invalidateSearchCache(ctx context.Context) {
+	if searchCache == nil {
+		return
+	}
+	if err := searchCache.Invalidate(ctx); err != nil {
+		logf(ctx, severityWarning, "Failed to invalidate the search cache: %v", err)
+	}
+}
+
+// invalidateCache handles /api/admin/cache/invalidate, which the syncer or
+// an operator calls after changing the library.
+func invalidateCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	invalidateSearchCache(r.Context())
+	respond(w, 200, "OK")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/cache"
+)
+
+func TestCachedSearch(t *testing.T) {
+	defer func(c benten.SearchCache) { searchCache = c }(searchCache)
+	searchCache = cache.NewMemory(10)
+	ctx := context.Background()
+	searches := 0
+	search := func() ([]benten.SearchResult, error) {
+		searches++
+		return []benten.SearchResult{{Key: datastore.IDKey(benten.PieceKind, 3, nil), Metadata: &benten.Metadata{Title: "x"}}}, nil
+	}
+	key := searchCacheKey("Foo", benten.Filter{}, false, false, 10)
+	if key != searchCacheKey("foo", benten.Filter{}, false, false, 10) {
+		t.Errorf("keys differ by case")
+	}
+	if key == searchCacheKey("foo", benten.Filter{}, false, false, 20) {
+		t.Errorf("keys don't depend on the limit")
+	}
+	if key == searchCacheKey("foo", benten.Filter{}, false, true, 10) {
+		t.Errorf("keys don't depend on the search backend")
+	}
+	cachedSearch(ctx, key, search)
+	results, err := cachedSearch(ctx, key, search)
+	if err != nil || searches != 1 {
+		t.Fatalf("searches = %d, err = %v", searches, err)
+	}
+	if len(results) != 1 || results[0].Key.ID != 3 || results[0].Metadata != nil {
+		t.Errorf("results = %v", results)
+	}
+	invalidateSearchCache(ctx)
+	cachedSearch(ctx, key, search)
+	if searches != 2 {
+		t.Errorf("searches = %d after invalidation", searches)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// searchLogEnabled records searches unless SEARCH_LOG=0.
+var searchLogEnabled = os.Getenv("SEARCH_LOG") != "0"
+
+// purgeSearchLogBatchSize is the number of logs a purge-search-log step
+// deletes.
+const purgeSearchLogBatchSize = 500
+
+// hashUser returns the benten.SearchLog User of the identity `id`.
+func hashUser(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// logSearch records a search for `text` by `r` which returned `results`
+// pieces. Failures are only logged.
+func logSearch(r *http.Request, client *datastore.Client, text string, results int, latency time.Duration) {
+	query := benten.Normalize(text)
+	if !searchLogEnabled || query == "" {
+		return
+	}
+	// The deadline of the search may have passed.
+	ctx, cancel := context.WithTimeout(context.Background(), metadataDeadline)
+	defer cancel()
+	entry := benten.SearchLog{User: hashUser(identity(r)), Query: query, Results: results, Latency: latency}
+	if err := benten.RecordSearch(ctx, client, entry); err != nil {
+		logf(r.Context(), severityError, "Failed to record the search for %q: %v", query, err)
+	}
+}
+
+// adminSearchReport responds with the `limit` (50 by default) most frequent
+// queries which found nothing in the last `days` (7 by default).
+func adminSearchReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	days := 7
+	if daysString := q.Get("days"); daysString != "" {
+		var err error
+		days, err = strconv.Atoi(daysString)
+		if err != nil || days <= 0 {
+			respond(w, 400, fmt.Sprintf("days (%v) is invalid", daysString))
+			return
+		}
+	}
+	limit, err := parseLimit(q, 50, 1000)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	queries, err := benten.ZeroResultQueries(ctx, client, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get the search logs: %v", err))
+		return
+	}
+	respondJSON(w, 200, queries)
+}
+
+// purgeSearchLogStep deletes the next batch of search logs older than
+// benten.SearchLogRetention.
+func purgeSearchLogStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	n, err := benten.PurgeSearchLogs(ctx, client, time.Now().Add(-benten.SearchLogRetention), purgeSearchLogBatchSize)
+	if err != nil {
+		return false, err
+	}
+	job.Processed += n
+	return n < purgeSearchLogBatchSize, nil
+}
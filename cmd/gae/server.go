@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// envDuration returns the duration in the environment variable `name`, or
+// `def` if it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// newServer returns the server listening on `addr`. The write timeout bounds
+// whole responses including streamed pieces, so it is long; handlers bound
+// their own work with deadlines.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Minute),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    64 << 10,
+	}
+}
+
+// serve runs `server` until SIGTERM or SIGINT, and then waits up to
+// SHUTDOWN_TIMEOUT for in-flight requests, including streams, to finish.
+func serve(server *http.Server) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case s := <-signals:
+		log.Printf("Received %v, shutting down", s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 25*time.Second))
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		// Cut the remaining connections.
+		server.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// sessionCookie is the name of the cookie carrying a session token.
+const sessionCookie = "benten_session"
+
+// How long a revocation check is reused.
+const revocationCacheDuration = time.Minute
+
+// sessionKeys are the keys of session tokens (SESSION_KEYS, comma-separated).
+// The first signs new tokens and all verify them, so that a key can be
+// rotated by prepending the new one and dropping the old one after
+// sessionTTL. Sessions are disabled without keys.
+var sessionKeys [][]byte
+
+// sessionTTL is how long a session token is valid.
+var sessionTTL = envDuration("SESSION_TTL", 12*time.Hour)
+
+// streamAuth requires the admin token or a session to stream (STREAM_AUTH=1).
+var streamAuth bool
+
+// session is a verified session token.
+type session struct {
+	id      string
+	expires time.Time
+}
+
+// revokedSession is a revoked session, keyed by its ID. Expires is kept to
+// purge it once the token is invalid anyway; see purgeRevokedSessionsStep.
+type revokedSession struct {
+	Revoked time.Time
+	Expires time.Time
+}
+
+var revocationCache struct {
+	mu sync.Mutex
+	// checked maps session IDs to whether they are revoked and when that
+	// was checked. Entries older than revocationCacheDuration are dropped
+	// when another is added, at most once per revocationCacheDuration.
+	checked map[string]revocationEntry
+	swept   time.Time
+}
+
+type revocationEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+// signSession returns the token "<id>.<expiry in Unix seconds>.<HMAC>".
+func signSession(key []byte, s session) string {
+	payload := s.id + "." + strconv.FormatInt(s.expires.Unix(), 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSession verifies `token` with `keys` and returns its session, or false
+// if it is forged or expired at `now`.
+func parseSession(keys [][]byte, token string, now time.Time) (session, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return session{}, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return session{}, false
+	}
+	s := session{id: parts[0], expires: time.Unix(expires, 0)}
+	if !now.Before(s.expires) {
+		return session{}, false
+	}
+	for _, key := range keys {
+		if hmac.Equal([]byte(signSession(key, s)), []byte(token)) {
+			return s, true
+		}
+	}
+	return session{}, false
+}
+
+// requestSession returns the session `r` carries in the cookie or in the
+// `session` query parameter, for players which can't send cookies. It
+// doesn't check revocation; see isRevoked.
+func requestSession(r *http.Request) (session, bool) {
+	if len(sessionKeys) == 0 {
+		return session{}, false
+	}
+	token := r.URL.Query().Get("session")
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return session{}, false
+	}
+	return parseSession(sessionKeys, token, time.Now())
+}
+
+// isRevoked returns true if the session `id` has been revoked, checking the
+// datastore at most once per revocationCacheDuration. Revocations take up to
+// that long to reach the other instances.
+func isRevoked(ctx context.Context, id string) (bool, error) {
+	revocationCache.mu.Lock()
+	entry, ok := revocationCache.checked[id]
+	revocationCache.mu.Unlock()
+	if ok && time.Since(entry.checkedAt) < revocationCacheDuration {
+		return entry.revoked, nil
+	}
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	var revoked revokedSession
+	err = client.Get(ctx, datastore.NameKey(benten.RevokedSessionKind, id, nil), &revoked)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return false, err
+	}
+	cacheRevocation(id, err == nil, time.Now())
+	return err == nil, nil
+}
+
+// cacheRevocation records whether the session `id` is revoked as of `now`.
+func cacheRevocation(id string, revoked bool, now time.Time) {
+	revocationCache.mu.Lock()
+	defer revocationCache.mu.Unlock()
+	if revocationCache.checked == nil {
+		revocationCache.checked = make(map[string]revocationEntry)
+	}
+	if now.Sub(revocationCache.swept) >= revocationCacheDuration {
+		for id, entry := range revocationCache.checked {
+			if now.Sub(entry.checkedAt) >= revocationCacheDuration {
+				delete(revocationCache.checked, id)
+			}
+		}
+		revocationCache.swept = now
+	}
+	revocationCache.checked[id] = revocationEntry{revoked: revoked, checkedAt: now}
+}
+
+// authorizeStream responds with 401 and returns false if streaming needs
+// authentication and `r` has neither the admin token nor a live session.
+func authorizeStream(w http.ResponseWriter, r *http.Request) bool {
+	if !streamAuth || isAdmin(r) {
+		return true
+	}
+	s, ok := requestSession(r)
+	if !ok {
+		respond(w, 401, "A session or the admin token is required")
+		return false
+	}
+	revoked, err := isRevoked(r.Context(), s.id)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to check the session: %v", err))
+		return false
+	}
+	if revoked {
+		respond(w, 401, "The session has been revoked")
+		return false
+	}
+	return true
+}
+
+// sessions issues a session (POST) to a client with the admin token or with
+// a live session, which is replaced, and revokes the session `r` carries
+// (DELETE). The token is set as a cookie for the web player, and is also the
+// body for the other players.
+func sessions(w http.ResponseWriter, r *http.Request) {
+	if len(sessionKeys) == 0 {
+		respond(w, 404, "Sessions are disabled")
+		return
+	}
+	current, hasSession := requestSession(r)
+	if hasSession {
+		revoked, err := isRevoked(r.Context(), current.id)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to check the session: %v", err))
+			return
+		}
+		hasSession = !revoked
+	}
+	if !hasSession && !isAdmin(r) {
+		respond(w, 403, "Forbidden")
+		return
+	}
+	switch r.Method {
+	case "POST":
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to generate a session ID: %v", err))
+			return
+		}
+		if hasSession {
+			// Rotation: the old token stops working.
+			if err := revokeSession(r.Context(), current); err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to revoke the session: %v", err))
+				return
+			}
+		}
+		s := session{id: hex.EncodeToString(id), expires: time.Now().Add(sessionTTL)}
+		token := signSession(sessionKeys[0], s)
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    token,
+			Path:     "/api/",
+			Expires:  s.expires,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		respond(w, 200, token)
+	case "DELETE":
+		if !hasSession {
+			respond(w, 400, "No session to revoke")
+			return
+		}
+		if err := revokeSession(r.Context(), current); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to revoke the session: %v", err))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/api/", MaxAge: -1})
+		respond(w, 200, "OK")
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// revokeSession records the revocation of `s`.
+func revokeSession(ctx context.Context, s session) error {
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	revoked := revokedSession{Revoked: time.Now(), Expires: s.expires}
+	if _, err := client.Put(ctx, datastore.NameKey(benten.RevokedSessionKind, s.id, nil), &revoked); err != nil {
+		return err
+	}
+	cacheRevocation(s.id, true, time.Now())
+	return nil
+}
+
+// purgeRevokedSessionsStep deletes a batch of the revocations of sessions
+// which have expired, as their tokens are rejected anyway.
+func purgeRevokedSessionsStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	q := datastore.NewQuery(benten.RevokedSessionKind).Filter("Expires <", time.Now()).KeysOnly().Limit(purgeBatchSize)
+	keys, err := client.GetAll(ctx, q, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
+		return false, err
+	}
+	job.Processed += len(keys)
+	return len(keys) < purgeBatchSize, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSession(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	oldKey, newKey := []byte("old-key-0123456789"), []byte("new-key-0123456789")
+	s := session{id: "abc", expires: now.Add(time.Hour)}
+	token := signSession(oldKey, s)
+
+	// Rotated: the old key still verifies.
+	if got, ok := parseSession([][]byte{newKey, oldKey}, token, now); !ok || got != s {
+		t.Errorf("parseSession = %v, %v", got, ok)
+	}
+	if _, ok := parseSession([][]byte{newKey}, token, now); ok {
+		t.Errorf("a token of a dropped key is accepted")
+	}
+	if _, ok := parseSession([][]byte{oldKey}, token, now.Add(time.Hour)); ok {
+		t.Errorf("an expired token is accepted")
+	}
+	forged := signSession(oldKey, session{id: "abc", expires: now.Add(2 * time.Hour)})
+	forged = forged[:len(forged)-43] + token[len(token)-43:]
+	if _, ok := parseSession([][]byte{oldKey}, forged, now); ok {
+		t.Errorf("an extended token is accepted")
+	}
+	if _, ok := parseSession([][]byte{oldKey}, "garbage", now); ok {
+		t.Errorf("garbage is accepted")
+	}
+}
+
+func TestCacheRevocation(t *testing.T) {
+	defer func() { revocationCache.checked, revocationCache.swept = nil, time.Time{} }()
+	now := time.Unix(1600000000, 0)
+	cacheRevocation("old", true, now)
+	cacheRevocation("recent", false, now.Add(revocationCacheDuration/2))
+	cacheRevocation("new", false, now.Add(revocationCacheDuration))
+	if _, ok := revocationCache.checked["old"]; ok {
+		t.Errorf("a stale entry is kept")
+	}
+	if len(revocationCache.checked) != 2 {
+		t.Errorf("checked = %v", revocationCache.checked)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// maxCachedEntries is the maximum number of entries in a /api/validate
+// request, which is also the limit of a GetMulti.
+const maxCachedEntries = 1000
+
+// cachedEntry is a piece which a client keeps offline, as it was when the
+// client fetched it.
+type cachedEntry struct {
+	Key     string
+	Hash    string
+	Updated time.Time
+}
+
+// isStale returns true if `entry` differs from `piece`, the piece currently
+// stored.
+func (entry *cachedEntry) isStale(piece *benten.Metadata) bool {
+	return entry.Hash != piece.Hash || piece.Updated.After(entry.Updated)
+}
+
+// validateCache takes a JSON object with Entries, the cachedEntry list of a
+// client, and responds with the entries which are Stale along with their
+// current metadata, and the keys of the ones Deleted (including trashed), so
+// that offline clients revalidate thousands of pieces in one request. Entries
+// which are up to date are omitted.
+func validateCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, 405, "Method not allowed")
+		return
+	}
+	var request struct {
+		Entries []cachedEntry
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+		return
+	}
+	if len(request.Entries) > maxCachedEntries {
+		respond(w, 400, fmt.Sprintf("Too many entries (%d > %d)", len(request.Entries), maxCachedEntries))
+		return
+	}
+	keys := make([]*datastore.Key, len(request.Entries))
+	for i, entry := range request.Entries {
+		key, err := datastore.DecodeKey(entry.Key)
+		if err != nil || key.Kind != benten.PieceKind {
+			respond(w, 400, fmt.Sprintf("Key (%v) is invalid", entry.Key))
+			return
+		}
+		keys[i] = key
+	}
+
+	deadline := listDeadline
+	ctx, cancel := context.WithTimeout(r.Context(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	pieces := make([]benten.Metadata, len(keys))
+	var errs datastore.MultiError
+	if err := client.GetMulti(ctx, keys, pieces); err != nil {
+		var ok bool
+		if errs, ok = err.(datastore.MultiError); !ok {
+			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+	}
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	response := struct {
+		Stale   []entry
+		Deleted []string
+	}{Stale: []entry{}, Deleted: []string{}}
+	for i := range request.Entries {
+		cached := &request.Entries[i]
+		if errs != nil && errs[i] != nil {
+			if errs[i] != datastore.ErrNoSuchEntity {
+				respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", errs[i]))
+				return
+			}
+			response.Deleted = append(response.Deleted, cached.Key)
+			continue
+		}
+		if pieces[i].IsTrashed() {
+			response.Deleted = append(response.Deleted, cached.Key)
+			continue
+		}
+		if cached.isStale(&pieces[i]) {
+			response.Stale = append(response.Stale, entry{cached.Key, pieces[i]})
+		}
+	}
+	respondJSON(w, 200, response)
+}
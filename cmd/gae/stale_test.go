@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestIsStale(t *testing.T) {
+	updated := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	entry := cachedEntry{Key: "k", Hash: "h0", Updated: updated}
+	cases := []struct {
+		piece benten.Metadata
+		want  bool
+	}{
+		{benten.Metadata{Hash: "h0", Updated: updated}, false},
+		{benten.Metadata{Hash: "h0", Updated: updated.Add(-time.Hour)}, false},
+		{benten.Metadata{Hash: "h0", Updated: updated.Add(time.Second)}, true},
+		{benten.Metadata{Hash: "h1", Updated: updated}, true},
+	}
+	for _, c := range cases {
+		if got := entry.isStale(&c.piece); got != c.want {
+			t.Errorf("isStale(%v) = %v", c.piece, got)
+		}
+	}
+}
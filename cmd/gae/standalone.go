@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/blob"
+	"github.com/yutakahirano/benten/sqlite"
+)
+
+// In the standalone mode, given by STANDALONE_DIR, the server serves the
+// directory written by a standalone syncer instead of the cloud. Only get,
+// list, all and config are available then.
+var (
+	standaloneStore benten.MetadataStore
+	standaloneBlobs benten.BlobStore
+)
+
+func openStandalone(dir string) error {
+	store, err := sqlite.Open(filepath.Join(dir, sqlite.DatabaseName))
+	if err != nil {
+		return err
+	}
+	standaloneStore = store
+	standaloneBlobs = blob.Dir{Root: dir}
+	return nil
+}
+
+// standaloneGet streams the object `name` in `bucket`, or the piece whose
+// path is `key`.
+func standaloneGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bucketName, name, filename := q.Get("bucket"), q.Get("name"), q.Get("filename")
+	if key := q.Get("key"); key != "" {
+		piece, err := standaloneStore.Get(r.Context(), key)
+		if err == benten.ErrNotFound {
+			respond(w, 404, fmt.Sprintf("Not found: %s", key))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+		bucketName, name = benten.PieceBucket, piece.ObjectName()
+		if filename == "" {
+			filename = filepath.Base(piece.Path)
+		}
+	}
+	if bucketName != benten.PieceBucket && bucketName != benten.AlbumPictureBucket {
+		respond(w, 400, fmt.Sprintf("bucket (%v) is invalid", bucketName))
+		return
+	}
+	// The objects are files, which must not be outside the bucket.
+	if name == "" || path.Clean("/"+name) != "/"+name {
+		respond(w, 400, fmt.Sprintf("name (%v) is invalid", name))
+		return
+	}
+	reader, err := standaloneBlobs.NewReader(r.Context(), bucketName+"/"+name)
+	if os.IsNotExist(err) {
+		respond(w, 404, fmt.Sprintf("Not found: %s", name))
+		return
+	}
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get reader: %v", err))
+		return
+	}
+	defer reader.Close()
+	size := int64(-1)
+	if file, ok := reader.(*os.File); ok {
+		if fi, err := file.Stat(); err == nil {
+			size = fi.Size()
+		}
+	}
+	// Content types are not kept in the directory.
+	buffered := bufio.NewReader(reader)
+	head, _ := buffered.Peek(512)
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	h := header{
+		ContentType:   contentType,
+		ContentLength: size,
+		Disposition:   "inline",
+		Filename:      downloadFilename(name, filename, contentType),
+		CacheControl:  "private, max-age=3600",
+	}
+	if q.Get("download") == "1" {
+		h.Disposition = "attachment"
+	}
+	code := 200
+	var body io.Reader = buffered
+	if file, ok := reader.(*os.File); ok && size >= 0 {
+		h.AcceptRanges = true
+		start, length, err := parseRange(r.Header.Get("Range"), size)
+		if err == errRangeNotSatisfiable {
+			w.Header().Set("content-range", fmt.Sprintf("bytes */%d", size))
+			respond(w, 416, fmt.Sprintf("Range (%v) is not satisfiable", r.Header.Get("Range")))
+			return
+		}
+		if length >= 0 {
+			if _, err := file.Seek(start, io.SeekStart); err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to seek: %v", err))
+				return
+			}
+			code = 206
+			h.ContentLength = length
+			h.ContentRange = contentRange(start, length, size)
+			body = io.LimitReader(file, length)
+		}
+	}
+	writeHead(w, code, h)
+	if _, err := io.Copy(w, body); err != nil {
+		logf(r.Context(), severityInfo, "Failed to write data to response: %v", err)
+	}
+}
+
+// standaloneList responds with the pieces matching `search`.
+func standaloneList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	text := q.Get("search")
+	if err := validateQuery(text); err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	limit, err := parseLimit(q, 10, maxSearchLimit)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	fields, err := parseFields(q.Get("fields"))
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	compactFormat, err := parseFormat(q, fields)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	found, err := standaloneStore.Search(r.Context(), text, limit)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to search: %v", err))
+		return
+	}
+	pieces := newListWriter(w, r)
+	for i := range found {
+		if compactFormat {
+			pieces.add(compact(found[i].Path, &found[i]))
+		} else {
+			pieces.add(project(&found[i], fields))
+		}
+	}
+	pieces.close(nil)
+}
+
+// standaloneAll responds with the pieces in the order of their paths, which
+// are their keys and cursors.
+func standaloneAll(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 1000
+	if limitString := q.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 || limit > 10*1000 {
+			respond(w, 400, fmt.Sprintf("limit (%v) is invalid", limitString))
+			return
+		}
+	}
+	found, err := standaloneStore.All(r.Context(), q.Get("cursor"), limit)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+		return
+	}
+	type entry struct {
+		Key      string
+		Metadata benten.Metadata
+	}
+	pieces := newListWriter(w, r)
+	for _, piece := range found {
+		pieces.add(entry{piece.Path, piece})
+	}
+	next := ""
+	if len(found) == limit {
+		next = found[len(found)-1].Path
+	}
+	pieces.close(func(items []interface{}) interface{} {
+		if items == nil {
+			return struct{ Cursor string }{next}
+		}
+		return struct {
+			Pieces []interface{}
+			Cursor string
+		}{items, next}
+	})
+}
+
+func handleStandalone(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/get":
+		standaloneGet(w, r)
+	case "/api/list":
+		standaloneList(w, r)
+	case "/api/all":
+		standaloneAll(w, r)
+	case "/api/config":
+		getClientConfig(w, r)
+	default:
+		respond(w, 404, "Not Found")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestStandaloneGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standalone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := openStandalone(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer standaloneStore.Close()
+	os.Mkdir(filepath.Join(dir, benten.PieceBucket), 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, benten.PieceBucket, "hash"), []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	piece := benten.Metadata{Path: "/music/a.mp3", Hash: "hash"}
+	if err := standaloneStore.Put(context.Background(), &piece); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleStandalone(w, httptest.NewRequest("GET", "/api/get?key=/music/a.mp3", nil))
+	if w.Code != 200 || w.Body.String() != "mp3" {
+		t.Errorf("%d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	w = httptest.NewRecorder()
+	handleStandalone(w, httptest.NewRequest("GET", "/api/get?bucket=pieces&name=../benten.db", nil))
+	if w.Code != 400 {
+		t.Errorf("code = %d for a name outside the bucket", w.Code)
+	}
+}
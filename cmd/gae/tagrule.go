@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// tagRulesBatchSize is the number of pieces a tag-rules step checks.
+const tagRulesBatchSize = 100
+
+// tagRuleChange is a change the tag rules make to a piece, summarized like
+// in the audit log.
+type tagRuleChange struct {
+	Key    string
+	Before string
+	After  string
+}
+
+// adminTagRules responds with the tag rules (GET) and replaces them (PUT).
+// With `dry_run=1`, PUT doesn't store the rules but responds with the changes
+// they would make to the pieces from `cursor`, checking `limit` pieces, and
+// the cursor of the next page, which is empty at the end. Storing the rules
+// makes the syncer apply them; the tag-rules job applies them to the pieces
+// already synced.
+func adminTagRules(w http.ResponseWriter, r *http.Request) {
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		rules, err := benten.LoadTagRules(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to load the tag rules: %v", err))
+			return
+		}
+		respondJSON(w, 200, rules)
+	case "PUT":
+		var rules benten.TagRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			respond(w, 400, fmt.Sprintf("Failed to parse the request: %v", err))
+			return
+		}
+		rewriter, err := rules.Compile()
+		if err != nil {
+			respond(w, 400, err.Error())
+			return
+		}
+		if r.URL.Query().Get("dry_run") == "1" {
+			previewTagRules(ctx, w, r, client, rewriter)
+			return
+		}
+		before, err := benten.LoadTagRules(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to load the tag rules: %v", err))
+			return
+		}
+		rules.Updated = time.Now()
+		if _, err := client.Put(ctx, benten.TagRulesKey(), &rules); err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to store the tag rules: %v", err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditTagRulesPut, "", before.Summary(), rules.Summary())
+		respondJSON(w, 200, rules)
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// previewTagRules responds with the changes `rewriter` would make to a page
+// of pieces.
+func previewTagRules(ctx context.Context, w http.ResponseWriter, r *http.Request, client *datastore.Client, rewriter *benten.TagRewriter) {
+	q := r.URL.Query()
+	limit, err := parseLimit(q, 1000, 10*1000)
+	if err != nil {
+		respond(w, 400, err.Error())
+		return
+	}
+	query := datastore.NewQuery(benten.PieceKind).Limit(limit)
+	if cursorString := q.Get("cursor"); cursorString != "" {
+		cursor, err := datastore.DecodeCursor(cursorString)
+		if err != nil {
+			respond(w, 400, fmt.Sprintf("cursor (%v) is invalid", cursorString))
+			return
+		}
+		query = query.Start(cursor)
+	}
+	response := struct {
+		Changes []tagRuleChange
+		Cursor  string
+	}{Changes: []tagRuleChange{}}
+	t := client.Run(ctx, query)
+	count := 0
+	for {
+		var piece benten.Metadata
+		key, err := t.Next(&piece)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get metadata: %v", err))
+			return
+		}
+		count++
+		before := piece
+		if rewriter.Apply(&piece) {
+			b, a := benten.DiffMetadata(&before, &piece)
+			response.Changes = append(response.Changes, tagRuleChange{key.Encode(), b, a})
+		}
+	}
+	if count == limit {
+		cursor, err := t.Cursor()
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to get a cursor: %v", err))
+			return
+		}
+		response.Cursor = cursor.String()
+	}
+	respondJSON(w, 200, response)
+}
+
+// tagRulesStep applies the tag rules to the next batch of pieces, and
+// reindexes the changed ones. The changes are recorded in the audit log as
+// edits by "tag-rules".
+func tagRulesStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	rules, err := benten.LoadTagRules(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	rewriter, err := rules.Compile()
+	if err != nil {
+		return false, err
+	}
+	aliases, err := cachedAliases(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	query := datastore.NewQuery(benten.PieceKind).KeysOnly().Limit(tagRulesBatchSize)
+	if job.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(job.Cursor)
+		if err != nil {
+			return false, err
+		}
+		query = query.Start(cursor)
+	}
+	t := client.Run(ctx, query)
+	count := 0
+	for {
+		key, err := t.Next(nil)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		count++
+		var before, piece benten.Metadata
+		changed := false
+		_, err = client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			if err := tx.Get(key, &piece); err != nil {
+				return err
+			}
+			before = piece
+			rewriter.Apply(&piece)
+			piece.SetAlbumKey(aliases)
+			changed = piece != before
+			if !changed {
+				return nil
+			}
+			piece.Revision++
+			piece.Updated = time.Now()
+			_, err := tx.Put(key, &piece)
+			return err
+		})
+		if err == datastore.ErrNoSuchEntity {
+			// Purged meanwhile.
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !changed {
+			continue
+		}
+		b, a := benten.DiffMetadata(&before, &piece)
+		entry := benten.AuditLog{Actor: "tag-rules", Action: benten.AuditPieceEdited, Target: key.Encode(), Before: b, After: a}
+		if err := benten.RecordAudit(ctx, client, entry); err != nil {
+			logf(ctx, severityError, "Failed to record %s on %s: %v", entry.Action, entry.Target, err)
+		}
+		if piece.IsTrashed() {
+			// Trashed pieces are not indexed.
+			continue
+		}
+		if err := benten.RespanIndex(ctx, client, &piece, key, aliases); err != nil {
+			return false, err
+		}
+		if externalSearchIndex != nil {
+			if err := externalSearchIndex.Index(ctx, key, &piece, aliases); err != nil {
+				return false, err
+			}
+		}
+	}
+	job.Processed += count
+	if count < tagRulesBatchSize {
+		invalidateSearchCache(ctx)
+		return true, nil
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return false, err
+	}
+	job.Cursor = cursor.String()
+	return false, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// purgeBatchSize is the number of pieces a purge-trash step deletes.
+const purgeBatchSize = 500
+
+// adminTrash lists the trashed pieces (GET), restores the piece `key` (POST),
+// or starts a job purging the pieces trashed more than TRASH_RETENTION ago
+// (DELETE).
+func adminTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "DELETE" {
+		q := r.URL.Query()
+		q.Set("type", "purge-trash")
+		r.URL.RawQuery = q.Encode()
+		r.Method = "POST"
+		adminJobs(w, r)
+		return
+	}
+	deadline := adminDeadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		respond(w, 500, fmt.Sprintf("Failed to create a datastore client: %v", err))
+		return
+	}
+	defer client.Close()
+
+	switch r.Method {
+	case "GET":
+		limit, err := parseLimit(r.URL.Query(), 100, 1000)
+		if err != nil {
+			respond(w, 400, err.Error())
+			return
+		}
+		type entry struct {
+			Key      string
+			Metadata benten.Metadata
+		}
+		pieces := newListWriter(w, r)
+		t := client.Run(ctx, benten.TrashQuery().Limit(limit))
+		for {
+			var piece benten.Metadata
+			key, err := t.Next(&piece)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				pieces.fail(500, fmt.Sprintf("Failed to get metadata: %v", err))
+				return
+			}
+			pieces.add(entry{key.Encode(), piece})
+		}
+		pieces.close(nil)
+	case "POST":
+		keyString := r.URL.Query().Get("key")
+		key, err := datastore.DecodeKey(keyString)
+		if err != nil || key.Kind != benten.PieceKind {
+			respond(w, 400, fmt.Sprintf("key (%v) is invalid", keyString))
+			return
+		}
+		aliases, err := cachedAliases(ctx, client)
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to load aliases: %v", err))
+			return
+		}
+		piece, err := benten.RestorePiece(ctx, client, key, aliases)
+		if err == datastore.ErrNoSuchEntity {
+			respond(w, 404, fmt.Sprintf("Not found: %s", keyString))
+			return
+		}
+		if err != nil {
+			respond(w, 500, fmt.Sprintf("Failed to restore %s: %v", keyString, err))
+			return
+		}
+		audit(ctx, client, r, benten.AuditPieceRestored, keyString, "", piece.Summary())
+		if externalSearchIndex != nil {
+			if err := externalSearchIndex.Index(ctx, key, piece, aliases); err != nil {
+				respond(w, 500, fmt.Sprintf("Failed to update the search index for %v: %v", key, err))
+				return
+			}
+		}
+		invalidateSearchCache(ctx)
+		respondJSON(w, 200, piece)
+	default:
+		respond(w, 405, "Method not allowed")
+	}
+}
+
+// trashPiece moves the piece at `key` to the trash and removes it from the
+// search index.
+func trashPiece(ctx context.Context, client *datastore.Client, key *datastore.Key) error {
+	if err := benten.TrashPiece(ctx, client, key); err != nil {
+		return err
+	}
+	if externalSearchIndex != nil {
+		if err := externalSearchIndex.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	invalidateSearchCache(ctx)
+	return nil
+}
+
+// purgeTrashStep deletes the next batch of pieces trashed before the
+// retention window.
+func purgeTrashStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	n, err := benten.PurgeTrash(ctx, client, time.Now().Add(-benten.TrashRetention), purgeBatchSize)
+	if err != nil {
+		return false, err
+	}
+	job.Processed += n
+	return n < purgeBatchSize, nil
+}
+
+// purgeTombstonesStep deletes the next batch of tombstones older than
+// benten.TombstoneRetention.
+func purgeTombstonesStep(ctx context.Context, client *datastore.Client, job *benten.Job) (bool, error) {
+	n, err := benten.PurgeTombstones(ctx, client, time.Now().Add(-benten.TombstoneRetention), purgeBatchSize)
+	if err != nil {
+		return false, err
+	}
+	job.Processed += n
+	return n < purgeBatchSize, nil
+}
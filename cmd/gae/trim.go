@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+// The server doesn't transcode, so trimmed streams are cut at MPEG frames:
+// they start at the frame containing `start` and end after the one
+// containing `end`. Only MP3 can be cut so without decoding.
+
+// parseTrim parses the `start` and `end` parameters of /api/get, in seconds.
+// `end` is zero for the end of the piece. It returns false if neither is
+// given.
+func parseTrim(q url.Values) (time.Duration, time.Duration, bool, error) {
+	startString, endString := q.Get("start"), q.Get("end")
+	if startString == "" && endString == "" {
+		return 0, 0, false, nil
+	}
+	var start, end time.Duration
+	for _, p := range []struct {
+		name, value string
+		dest        *time.Duration
+	}{{"start", startString, &start}, {"end", endString, &end}} {
+		if p.value == "" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(p.value, 64)
+		if err != nil || seconds < 0 || seconds > 1e6 {
+			return 0, 0, false, fmt.Errorf("%s (%v) is invalid", p.name, p.value)
+		}
+		*p.dest = time.Duration(seconds * float64(time.Second))
+	}
+	if end != 0 && end <= start {
+		return 0, 0, false, fmt.Errorf("end (%v) must be after start (%v)", endString, startString)
+	}
+	return start, end, true, nil
+}
+
+var errNotMPEG = errors.New("not an MPEG audio stream")
+
+// trimMP3 copies the frames of the MP3 `src` between `start` and `end` (zero
+// for the end) to `dst`. The ID3 tags and the Xing/Info frame, whose counts
+// would be wrong, are dropped, and bytes between frames are skipped.
+func trimMP3(dst io.Writer, src io.Reader, start, end time.Duration) error {
+	r := bufio.NewReaderSize(src, 64*1024)
+	if h, err := r.Peek(10); err == nil && string(h[:3]) == "ID3" {
+		size := int(h[6])<<21 | int(h[7])<<14 | int(h[8])<<7 | int(h[9])
+		if h[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := r.Discard(10 + size); err != nil {
+			return errNotMPEG
+		}
+	}
+	var position time.Duration
+	frames := 0
+	for end == 0 || position < end {
+		h, err := r.Peek(4)
+		if len(h) < 4 {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		header, ok := benten.ParseMPEGFrame(h)
+		if !ok {
+			// Junk between frames, or the ID3v1 tag at the end.
+			if _, err := r.Discard(1); err != nil {
+				return err
+			}
+			continue
+		}
+		frame, err := r.Peek(header.Size)
+		if err != nil {
+			// A truncated last frame.
+			break
+		}
+		frames++
+		if frames == 1 && benten.HasXingHeader(frame) {
+			r.Discard(header.Size)
+			continue
+		}
+		duration := header.Duration()
+		if position+duration > start {
+			if _, err := dst.Write(frame); err != nil {
+				return err
+			}
+		}
+		r.Discard(header.Size)
+		position += duration
+	}
+	if frames == 0 {
+		return errNotMPEG
+	}
+	return nil
+}
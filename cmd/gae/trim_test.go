@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mp3Frame returns an MPEG-1 Layer III frame at 128kbps and 44.1kHz, which
+// is 417 bytes and 1152 samples long, filled with `fill`.
+func mp3Frame(fill byte) []byte {
+	frame := bytes.Repeat([]byte{fill}, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+	return frame
+}
+
+func TestTrimMP3(t *testing.T) {
+	var src bytes.Buffer
+	src.WriteString("ID3\x03\x00\x00\x00\x00\x00\x02xx")
+	info := mp3Frame(0)
+	copy(info[36:], "Info")
+	src.Write(info)
+	for i := 0; i < 100; i++ {
+		src.Write(mp3Frame(byte(i)))
+	}
+	src.WriteString("TAG")
+
+	// A frame is 1152 / 44100 seconds, about 26ms.
+	var dst bytes.Buffer
+	if err := trimMP3(&dst, bytes.NewReader(src.Bytes()), 100*time.Millisecond, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Frames 3 to 7 overlap with the range.
+	if dst.Len() != 5*417 || dst.Bytes()[4] != 3 || dst.Bytes()[dst.Len()-1] != 7 {
+		t.Errorf("trimmed to %d bytes starting with frame %d", dst.Len(), dst.Bytes()[4])
+	}
+
+	dst.Reset()
+	if err := trimMP3(&dst, bytes.NewReader(src.Bytes()), 2500*time.Millisecond, 0); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != 5*417 || dst.Bytes()[dst.Len()-1] != 99 {
+		t.Errorf("trimmed to %d bytes ending with frame %d", dst.Len(), dst.Bytes()[dst.Len()-1])
+	}
+
+	if err := trimMP3(&dst, bytes.NewReader([]byte("fLaC and more")), 0, time.Second); err != errNotMPEG {
+		t.Errorf("err = %v for FLAC", err)
+	}
+}
+
+func TestParseTrim(t *testing.T) {
+	start, end, ok, err := parseTrim(url.Values{"start": {"12.5"}, "end": {"20"}})
+	if start != 12500*time.Millisecond || end != 20*time.Second || !ok || err != nil {
+		t.Errorf("parseTrim = %v, %v, %v, %v", start, end, ok, err)
+	}
+	if _, _, ok, err := parseTrim(url.Values{}); ok || err != nil {
+		t.Errorf("parseTrim = %v, %v without parameters", ok, err)
+	}
+	if _, _, _, err := parseTrim(url.Values{"start": {"20"}, "end": {"10"}}); err == nil {
+		t.Errorf("parseTrim accepted end before start")
+	}
+}
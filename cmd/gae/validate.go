@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+)
+
+// maxSearchLimit is the largest `limit` accepted by /api/list.
+const maxSearchLimit = 1000
+
+// maxQueryLength is the maximum length of a search query in characters.
+const maxQueryLength = 200
+
+// parseLimit returns the `limit` parameter in `q`, which must be in
+// [1, max], or `def` if it is absent.
+func parseLimit(q url.Values, def, max int) (int, error) {
+	s := q.Get("limit")
+	if s == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("limit (%v) is not a valid number", s)
+	}
+	if limit <= 0 || limit > max {
+		return 0, fmt.Errorf("limit (%v) is out of range", limit)
+	}
+	return limit, nil
+}
+
+// validateQuery returns an error if `text` is not a valid search query.
+func validateQuery(text string) error {
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("The query is not valid UTF-8")
+	}
+	if n := utf8.RuneCountInString(text); n > maxQueryLength {
+		return fmt.Errorf("The query is too long (%d > %d characters)", n, maxQueryLength)
+	}
+	return nil
+}
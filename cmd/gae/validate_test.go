@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		query string
+		limit int
+		ok    bool
+	}{
+		{"", 10, true},
+		{"limit=5", 5, true},
+		{"limit=1000", 1000, true},
+		{"limit=0", 0, false},
+		{"limit=1001", 0, false},
+		{"limit=-1", 0, false},
+		{"limit=x", 0, false},
+	}
+	for _, c := range cases {
+		q, _ := url.ParseQuery(c.query)
+		limit, err := parseLimit(q, 10, 1000)
+		if (err == nil) != c.ok || (c.ok && limit != c.limit) {
+			t.Errorf("parseLimit(%q) = %d, %v", c.query, limit, err)
+		}
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	if err := validateQuery(strings.Repeat("あ", maxQueryLength)); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	if err := validateQuery(strings.Repeat("a", maxQueryLength+1)); err == nil {
+		t.Errorf("a long query is accepted")
+	}
+	if err := validateQuery("\xff"); err == nil {
+		t.Errorf("an invalid query is accepted")
+	}
+}
@@ -0,0 +1,204 @@
+// Command import-itunes imports the ratings, play counts and playlists in an
+// iTunes or Music.app library XML file (File > Library > Export Library) into
+// benten. Tracks are matched with pieces by path, or by title, artist and
+// album. Running it again overwrites what it imported before.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// track is a track in the library.
+type track struct {
+	Name, Artist, Album, Location string
+	// Rating is from 0 to 100 in steps of 20.
+	Rating    int64
+	PlayCount int64
+	PlayDate  time.Time
+	Loved     bool
+}
+
+// playlist is a playlist in the library, referring to the tracks by ID.
+type playlist struct {
+	Name     string
+	TrackIDs []int64
+}
+
+// library is the contents of an iTunes library XML file.
+type library struct {
+	Tracks    map[int64]track
+	Playlists []playlist
+}
+
+func stringOf(dict map[string]interface{}, key string) string {
+	s, _ := dict[key].(string)
+	return s
+}
+
+func intOf(dict map[string]interface{}, key string) int64 {
+	n, _ := dict[key].(int64)
+	return n
+}
+
+func parseLibrary(root interface{}) (*library, error) {
+	dict, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the library is not a dictionary")
+	}
+	lib := &library{Tracks: make(map[int64]track)}
+	tracks, _ := dict["Tracks"].(map[string]interface{})
+	for _, value := range tracks {
+		t, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		location := stringOf(t, "Location")
+		if u, err := url.Parse(location); err == nil && u.Scheme == "file" {
+			location = u.Path
+		}
+		playDate, _ := t["Play Date UTC"].(time.Time)
+		loved, _ := t["Loved"].(bool)
+		lib.Tracks[intOf(t, "Track ID")] = track{
+			Name:      stringOf(t, "Name"),
+			Artist:    stringOf(t, "Artist"),
+			Album:     stringOf(t, "Album"),
+			Location:  location,
+			Rating:    intOf(t, "Rating"),
+			PlayCount: intOf(t, "Play Count"),
+			PlayDate:  playDate,
+			Loved:     loved,
+		}
+	}
+	playlists, _ := dict["Playlists"].([]interface{})
+	for _, value := range playlists {
+		p, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Skip the whole library and the built-in playlists such as "Music".
+		if master, _ := p["Master"].(bool); master || stringOf(p, "Distinguished Kind") != "" || intOf(p, "Distinguished Kind") != 0 {
+			continue
+		}
+		pl := playlist{Name: stringOf(p, "Name")}
+		items, _ := p["Playlist Items"].([]interface{})
+		for _, item := range items {
+			if i, ok := item.(map[string]interface{}); ok {
+				pl.TrackIDs = append(pl.TrackIDs, intOf(i, "Track ID"))
+			}
+		}
+		lib.Playlists = append(lib.Playlists, pl)
+	}
+	return lib, nil
+}
+
+// match returns the piece `t` refers to, or nil.
+func match(m *benten.PieceMatcher, t track) *datastore.Key {
+	if t.Location != "" {
+		if key := m.ByPath(t.Location); key != nil {
+			return key
+		}
+	}
+	return m.ByTags(t.Name, t.Artist, t.Album)
+}
+
+func (t track) rating() benten.Rating {
+	return benten.Rating{
+		Stars:      int(t.Rating / 20),
+		Starred:    t.Loved,
+		PlayCount:  int(t.PlayCount),
+		LastPlayed: t.PlayDate,
+	}
+}
+
+func main() {
+	var projectID string
+	var dryRun bool
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what would be imported")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: import-itunes [flags] <Library.xml>")
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open the library: %v", err)
+	}
+	root, err := parsePlist(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Failed to parse the library: %v", err)
+	}
+	lib, err := parseLibrary(root)
+	if err != nil {
+		log.Fatalf("Failed to parse the library: %v", err)
+	}
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+
+	pieces := make(map[int64]*datastore.Key)
+	var keys []*datastore.Key
+	var ratings []*benten.Rating
+	for id, t := range lib.Tracks {
+		key := match(matcher, t)
+		if key == nil {
+			log.Printf("No piece for %s / %s / %s", t.Artist, t.Album, t.Name)
+			continue
+		}
+		pieces[id] = key
+		if rating := t.rating(); rating != (benten.Rating{}) {
+			keys = append(keys, benten.RatingKey(key))
+			ratings = append(ratings, &rating)
+		}
+	}
+	log.Printf("Matched %d of %d tracks.", len(pieces), len(lib.Tracks))
+
+	var playlists []*benten.Playlist
+	for _, p := range lib.Playlists {
+		playlist := &benten.Playlist{Name: p.Name, Source: "itunes", Updated: time.Now()}
+		for _, id := range p.TrackIDs {
+			if key, ok := pieces[id]; ok {
+				playlist.Pieces = append(playlist.Pieces, key)
+			}
+		}
+		log.Printf("Playlist %s: %d of %d tracks", p.Name, len(playlist.Pieces), len(p.TrackIDs))
+		playlists = append(playlists, playlist)
+	}
+	if dryRun {
+		return
+	}
+
+	for i := 0; i < len(keys); i += 500 {
+		end := i + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if _, err := client.PutMulti(ctx, keys[i:end], ratings[i:end]); err != nil {
+			log.Fatalf("Failed to put ratings: %v", err)
+		}
+	}
+	for _, playlist := range playlists {
+		if _, err := client.Put(ctx, benten.PlaylistKey(playlist.Name), playlist); err != nil {
+			log.Fatalf("Failed to put playlist %s: %v", playlist.Name, err)
+		}
+	}
+	log.Printf("Imported %d ratings and %d playlists.", len(ratings), len(playlists))
+}
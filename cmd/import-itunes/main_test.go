@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const libraryXML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Major Version</key><integer>1</integer>
+	<key>Tracks</key>
+	<dict>
+		<key>42</key>
+		<dict>
+			<key>Track ID</key><integer>42</integer>
+			<key>Name</key><string>Help!</string>
+			<key>Artist</key><string>The Beatles</string>
+			<key>Rating</key><integer>80</integer>
+			<key>Play Count</key><integer>7</integer>
+			<key>Play Date UTC</key><date>2020-05-01T10:00:00Z</date>
+			<key>Loved</key><true/>
+			<key>Location</key><string>file:///Users/me/Music/Beatles/Help%21.mp3</string>
+		</dict>
+	</dict>
+	<key>Playlists</key>
+	<array>
+		<dict>
+			<key>Name</key><string>Library</string>
+			<key>Master</key><true/>
+			<key>Playlist Items</key><array><dict><key>Track ID</key><integer>42</integer></dict></array>
+		</dict>
+		<dict>
+			<key>Name</key><string>Favorites</string>
+			<key>Playlist Items</key><array><dict><key>Track ID</key><integer>42</integer></dict></array>
+		</dict>
+	</array>
+</dict>
+</plist>`
+
+func TestParseLibrary(t *testing.T) {
+	root, err := parsePlist(strings.NewReader(libraryXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := parseLibrary(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := lib.Tracks[42]
+	if track.Name != "Help!" || track.Location != "/Users/me/Music/Beatles/Help!.mp3" || !track.Loved {
+		t.Errorf("track = %+v", track)
+	}
+	if rating := track.rating(); rating.Stars != 4 || rating.PlayCount != 7 || rating.LastPlayed.Year() != 2020 {
+		t.Errorf("rating = %+v", rating)
+	}
+	if len(lib.Playlists) != 1 || lib.Playlists[0].Name != "Favorites" || len(lib.Playlists[0].TrackIDs) != 1 {
+		t.Errorf("playlists = %+v", lib.Playlists)
+	}
+}
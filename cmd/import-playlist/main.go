@@ -0,0 +1,63 @@
+// Command import-playlist imports M3U, M3U8 and PLS playlist files as benten
+// playlists. Entries are matched with pieces by path suffix, or by title and
+// artist. Each file becomes a playlist named after it unless -name is given,
+// and the entries which matched no piece are printed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+func main() {
+	var projectID, name string
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.StringVar(&name, "name", "", "the name of the playlist, when importing a single file")
+	flag.Parse()
+	if flag.NArg() == 0 || (name != "" && flag.NArg() > 1) {
+		log.Fatalf("Usage: import-playlist [flags] <file>...")
+	}
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+
+	for _, path := range flag.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		entries, err := benten.ParsePlaylistFile(path, file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		playlistName := name
+		if playlistName == "" {
+			playlistName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		playlist, unresolved, err := benten.ImportPlaylist(ctx, client, matcher, playlistName, entries)
+		if err != nil {
+			log.Fatalf("Failed to import %s: %v", path, err)
+		}
+		log.Printf("Imported %s with %d of %d entries.", playlistName, len(playlist.Pieces), len(entries))
+		for _, entry := range unresolved {
+			fmt.Printf("%s: unresolved: %s\n", path, entry.Path)
+		}
+	}
+}
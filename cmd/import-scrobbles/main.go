@@ -0,0 +1,96 @@
+// Command import-scrobbles imports plays from a ListenBrainz export, a Last.fm
+// CSV export or a Rockbox .scrobbler.log into the play history (benten.Play),
+// and updates the play counts of the pieces. Plays are matched with pieces by
+// title, artist and album, or by title and artist. Importing the same plays
+// again doesn't count them twice.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// parse parses `r` in `format`, or in the format guessed from `name` when
+// `format` is empty.
+func parse(name, format string, r io.Reader) ([]scrobble, string, error) {
+	if format == "" {
+		switch {
+		case strings.HasSuffix(name, ".scrobbler.log") || filepath.Base(name) == ".scrobbler.log":
+			format = "rockbox"
+		case strings.HasSuffix(name, ".csv"):
+			format = "lastfm"
+		default:
+			format = "listenbrainz"
+		}
+	}
+	var scrobbles []scrobble
+	var err error
+	switch format {
+	case "rockbox":
+		scrobbles, err = parseRockbox(r)
+	case "lastfm":
+		scrobbles, err = parseLastFM(r)
+	default:
+		scrobbles, err = parseListenBrainz(r)
+	}
+	return scrobbles, format, err
+}
+
+func main() {
+	var projectID, format string
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.StringVar(&format, "format", "", "listenbrainz, lastfm or rockbox; guessed from the file name by default")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: import-scrobbles [flags] <file>")
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", flag.Arg(0), err)
+	}
+	scrobbles, format, err := parse(flag.Arg(0), format, file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Failed to parse %s: %v", flag.Arg(0), err)
+	}
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+
+	var pieces []*datastore.Key
+	var plays []benten.Play
+	unmatched := 0
+	for _, s := range scrobbles {
+		key := matcher.ByTags(s.Title, s.Artist, s.Album)
+		if key == nil {
+			key = matcher.ByTitleArtist(s.Title, s.Artist)
+		}
+		if key == nil {
+			unmatched++
+			continue
+		}
+		pieces = append(pieces, key)
+		plays = append(plays, benten.Play{Time: s.Time, Source: format})
+	}
+	if err := benten.RecordPlays(ctx, client, pieces, plays); err != nil {
+		log.Fatalf("Failed to record plays: %v", err)
+	}
+	log.Printf("Imported %d plays; %d matched no piece.", len(plays), unmatched)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scrobble is a play recorded by a scrobbler.
+type scrobble struct {
+	Artist, Album, Title string
+	Time                 time.Time
+}
+
+// parseListenBrainz parses a ListenBrainz export, which is either a JSON array
+// of listens or one listen per line.
+func parseListenBrainz(r io.Reader) ([]scrobble, error) {
+	type listen struct {
+		ListenedAt    int64 `json:"listened_at"`
+		TrackMetadata struct {
+			ArtistName  string `json:"artist_name"`
+			TrackName   string `json:"track_name"`
+			ReleaseName string `json:"release_name"`
+		} `json:"track_metadata"`
+	}
+	reader := bufio.NewReader(r)
+	var listens []listen
+	if head, _ := reader.Peek(1); len(head) == 1 && head[0] == '[' {
+		if err := json.NewDecoder(reader).Decode(&listens); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(reader)
+		for {
+			var l listen
+			err := decoder.Decode(&l)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			listens = append(listens, l)
+		}
+	}
+	scrobbles := make([]scrobble, 0, len(listens))
+	for _, l := range listens {
+		scrobbles = append(scrobbles, scrobble{
+			Artist: l.TrackMetadata.ArtistName,
+			Album:  l.TrackMetadata.ReleaseName,
+			Title:  l.TrackMetadata.TrackName,
+			Time:   time.Unix(l.ListenedAt, 0).UTC(),
+		})
+	}
+	return scrobbles, nil
+}
+
+// parseLastFM parses a Last.fm export in the common CSV form of
+// "artist,album,title,date" with dates like "31 Jan 2021 12:34".
+func parseLastFM(r io.Reader) ([]scrobble, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var scrobbles []scrobble
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return scrobbles, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 4 {
+			continue
+		}
+		t, err := time.Parse("2 Jan 2006 15:04", strings.TrimSpace(record[3]))
+		if err != nil {
+			// The header or a play "now playing" without a date.
+			continue
+		}
+		scrobbles = append(scrobbles, scrobble{Artist: record[0], Album: record[1], Title: record[2], Time: t})
+	}
+}
+
+// parseRockbox parses a .scrobbler.log written by Rockbox: tab-separated
+// artist, album, title, track number, duration, rating ("L" for listened, "S"
+// for skipped) and UNIX time. Skipped tracks are ignored.
+func parseRockbox(r io.Reader) ([]scrobble, error) {
+	var scrobbles []scrobble
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 || fields[5] != "L" {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+		scrobbles = append(scrobbles, scrobble{Artist: fields[0], Album: fields[1], Title: fields[2], Time: time.Unix(unix, 0).UTC()})
+	}
+	return scrobbles, scanner.Err()
+}
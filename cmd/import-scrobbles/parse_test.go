@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name, data string
+	}{
+		{"listens.json", `[{"listened_at": 1600000000, "track_metadata": {"artist_name": "A", "track_name": "T", "release_name": "R"}}]`},
+		{"listens.jsonl", `{"listened_at": 1600000000, "track_metadata": {"artist_name": "A", "track_name": "T", "release_name": "R"}}` + "\n"},
+		{"lastfm.csv", "artist,album,title,date\nA,R,T,13 Sep 2020 12:26\n"},
+		{".scrobbler.log", "#AUDIOSCROBBLER/1.1\nA\tR\tT\t1\t180\tL\t1600000000\t\nA\tR\tSkipped\t2\t180\tS\t1600000100\t\n"},
+	}
+	for _, c := range cases {
+		scrobbles, _, err := parse(c.name, "", strings.NewReader(c.data))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(scrobbles) != 1 {
+			t.Errorf("%s: scrobbles = %+v", c.name, scrobbles)
+			continue
+		}
+		s := scrobbles[0]
+		if s.Artist != "A" || s.Album != "R" || s.Title != "T" || s.Time.Year() != 2020 {
+			t.Errorf("%s: scrobble = %+v", c.name, s)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Command import-subsonic imports the starred songs, ratings, play counts and
+// playlists of a Subsonic-compatible server such as Navidrome or Airsonic.
+// Songs are matched with pieces by path, or by title, artist and album.
+// Ratings are merged into the existing ones (see benten.Rating.Merge), and
+// playlists replace the ones imported before; a playlist whose name is taken
+// by a playlist from elsewhere gets a " (subsonic)" suffix.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+const source = "subsonic"
+
+func (s song) rating() benten.Rating {
+	return benten.Rating{
+		Stars:      s.UserRating,
+		Starred:    !s.Starred.IsZero(),
+		PlayCount:  s.PlayCount,
+		LastPlayed: s.Played,
+	}
+}
+
+func match(m *benten.PieceMatcher, s song) *datastore.Key {
+	if s.Path != "" {
+		if key := m.ByPath(s.Path); key != nil {
+			return key
+		}
+	}
+	return m.ByTags(s.Title, s.Artist, s.Album)
+}
+
+// playlistName returns the name to store `name` with, avoiding playlists
+// which were not imported from Subsonic.
+func playlistName(ctx context.Context, client *datastore.Client, name string) (string, error) {
+	var existing benten.Playlist
+	err := client.Get(ctx, benten.PlaylistKey(name), &existing)
+	if err == datastore.ErrNoSuchEntity || (err == nil && existing.Source == source) {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return name + " (subsonic)", nil
+}
+
+func main() {
+	var projectID string
+	var c client
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.StringVar(&c.URL, "url", "", "the URL of the Subsonic server")
+	flag.StringVar(&c.User, "user", "", "the user name")
+	flag.StringVar(&c.Password, "password", os.Getenv("SUBSONIC_PASSWORD"), "the password, or a secret reference such as sm://subsonic-password")
+	flag.Parse()
+	if c.URL == "" || c.User == "" {
+		log.Fatalf("-url and -user are required")
+	}
+	c.HTTP = &http.Client{Timeout: time.Minute}
+
+	ctx := context.Background()
+	if err := benten.ResolveSecrets(ctx, projectID, &c.Password); err != nil {
+		log.Fatalf("Failed to get the password: %v", err)
+	}
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+
+	songs, err := c.songs(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get songs: %v", err)
+	}
+	starred, err := c.starred(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get starred songs: %v", err)
+	}
+	ratings := make(map[string]benten.Rating)
+	pieces := make(map[string]*datastore.Key)
+	for _, s := range append(songs, starred...) {
+		key := match(matcher, s)
+		if key == nil {
+			log.Printf("No piece for %s / %s / %s", s.Artist, s.Album, s.Title)
+			continue
+		}
+		pieces[s.ID] = key
+		rating := ratings[key.Encode()]
+		rating.Merge(s.rating())
+		ratings[key.Encode()] = rating
+	}
+	var keys []*datastore.Key
+	var values []benten.Rating
+	for encoded, rating := range ratings {
+		if rating == (benten.Rating{}) {
+			continue
+		}
+		key, _ := datastore.DecodeKey(encoded)
+		keys = append(keys, key)
+		values = append(values, rating)
+	}
+	if err := benten.MergeRatings(ctx, client, keys, values); err != nil {
+		log.Fatalf("Failed to merge ratings: %v", err)
+	}
+	log.Printf("Merged %d ratings from %d songs.", len(keys), len(songs))
+
+	playlists, err := c.playlists(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get playlists: %v", err)
+	}
+	for _, p := range playlists {
+		name, err := playlistName(ctx, client, p.Name)
+		if err != nil {
+			log.Fatalf("Failed to get playlist %s: %v", p.Name, err)
+		}
+		playlist := &benten.Playlist{Name: name, Source: source, Updated: time.Now()}
+		for _, s := range p.Entry {
+			key, ok := pieces[s.ID]
+			if !ok {
+				key = match(matcher, s)
+			}
+			if key != nil {
+				playlist.Pieces = append(playlist.Pieces, key)
+			}
+		}
+		if _, err := client.Put(ctx, benten.PlaylistKey(name), playlist); err != nil {
+			log.Fatalf("Failed to put playlist %s: %v", name, err)
+		}
+		log.Printf("Playlist %s: %d of %d songs", name, len(playlist.Pieces), len(p.Entry))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// song is a song of the Subsonic API.
+type song struct {
+	ID         string
+	Title      string
+	Artist     string
+	Album      string
+	Path       string
+	UserRating int
+	PlayCount  int
+	Played     time.Time
+	Starred    time.Time
+}
+
+// playlist is a playlist of the Subsonic API.
+type playlist struct {
+	ID    string
+	Name  string
+	Entry []song
+}
+
+// response is the body of a Subsonic API response.
+type response struct {
+	Response struct {
+		Status string
+		Error  struct {
+			Code    int
+			Message string
+		}
+		AlbumList2 struct {
+			Album []struct{ ID string }
+		}
+		Album struct {
+			Song []song
+		}
+		Starred2 struct {
+			Song []song
+		}
+		Playlists struct {
+			Playlist []playlist
+		}
+		Playlist playlist
+	} `json:"subsonic-response"`
+}
+
+// client is a client of a Subsonic-compatible server.
+type client struct {
+	URL      string
+	User     string
+	Password string
+	HTTP     *http.Client
+}
+
+// call calls the API `method` with token authentication.
+func (c *client) call(ctx context.Context, method string, params url.Values) (*response, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	s := hex.EncodeToString(salt)
+	token := md5.Sum([]byte(c.Password + s))
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("u", c.User)
+	params.Set("t", hex.EncodeToString(token[:]))
+	params.Set("s", s)
+	params.Set("v", "1.16.1")
+	params.Set("c", "benten")
+	params.Set("f", "json")
+
+	request, err := http.NewRequest("GET", strings.TrimSuffix(c.URL, "/")+"/rest/"+method+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.HTTP.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: status %d", method, res.StatusCode)
+	}
+	var body response
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Response.Status != "ok" {
+		return nil, fmt.Errorf("%s: %s (%d)", method, body.Response.Error.Message, body.Response.Error.Code)
+	}
+	return &body, nil
+}
+
+// songs returns all the songs, album by album.
+func (c *client) songs(ctx context.Context) ([]song, error) {
+	const pageSize = 500
+	var songs []song
+	for offset := 0; ; offset += pageSize {
+		params := url.Values{"type": {"alphabeticalByName"}, "size": {strconv.Itoa(pageSize)}, "offset": {strconv.Itoa(offset)}}
+		list, err := c.call(ctx, "getAlbumList2", params)
+		if err != nil {
+			return nil, err
+		}
+		for _, album := range list.Response.AlbumList2.Album {
+			res, err := c.call(ctx, "getAlbum", url.Values{"id": {album.ID}})
+			if err != nil {
+				return nil, err
+			}
+			songs = append(songs, res.Response.Album.Song...)
+		}
+		if len(list.Response.AlbumList2.Album) < pageSize {
+			return songs, nil
+		}
+	}
+}
+
+// starred returns the starred songs.
+func (c *client) starred(ctx context.Context) ([]song, error) {
+	res, err := c.call(ctx, "getStarred2", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Response.Starred2.Song, nil
+}
+
+// playlists returns the playlists with their entries.
+func (c *client) playlists(ctx context.Context) ([]playlist, error) {
+	res, err := c.call(ctx, "getPlaylists", nil)
+	if err != nil {
+		return nil, err
+	}
+	var playlists []playlist
+	for _, p := range res.Response.Playlists.Playlist {
+		res, err := c.call(ctx, "getPlaylist", url.Values{"id": {p.ID}})
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, res.Response.Playlist)
+	}
+	return playlists, nil
+}
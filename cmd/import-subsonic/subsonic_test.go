@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		token := md5.Sum([]byte("secret" + q.Get("s")))
+		if q.Get("t") != hex.EncodeToString(token[:]) {
+			fmt.Fprint(w, `{"subsonic-response": {"status": "failed", "error": {"code": 40, "message": "Wrong password"}}}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/rest/getAlbumList2":
+			fmt.Fprint(w, `{"subsonic-response": {"status": "ok", "albumList2": {"album": [{"id": "al1"}]}}}`)
+		case "/rest/getAlbum":
+			fmt.Fprint(w, `{"subsonic-response": {"status": "ok", "album": {"song": [
+				{"id": "s1", "title": "Help!", "userRating": 4, "playCount": 9, "played": "2021-01-02T03:04:05.000Z", "path": "Beatles/Help.mp3"}]}}}`)
+		}
+	}))
+	defer server.Close()
+
+	c := client{URL: server.URL, User: "me", Password: "secret", HTTP: server.Client()}
+	songs, err := c.songs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 {
+		t.Fatalf("songs = %+v", songs)
+	}
+	if rating := songs[0].rating(); rating.Stars != 4 || rating.PlayCount != 9 || rating.LastPlayed.Year() != 2021 || rating.Starred {
+		t.Errorf("rating = %+v", rating)
+	}
+
+	c.Password = "wrong"
+	if _, err := c.songs(context.Background()); err == nil {
+		t.Errorf("a wrong password must fail")
+	}
+}
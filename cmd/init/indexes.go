@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	admin "google.golang.org/api/datastore/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// indexFile is index.yaml, which `gcloud datastore indexes create` takes too.
+type indexFile struct {
+	Indexes []struct {
+		Kind       string `yaml:"kind"`
+		Ancestor   bool   `yaml:"ancestor"`
+		Properties []struct {
+			Name      string `yaml:"name"`
+			Direction string `yaml:"direction"`
+		} `yaml:"properties"`
+	} `yaml:"indexes"`
+}
+
+// readIndexes reads the composite indexes in the index.yaml at `path`.
+func readIndexes(path string) ([]*admin.GoogleDatastoreAdminV1Index, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file indexFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	var indexes []*admin.GoogleDatastoreAdminV1Index
+	for _, definition := range file.Indexes {
+		index := &admin.GoogleDatastoreAdminV1Index{Kind: definition.Kind, Ancestor: "NONE"}
+		if definition.Ancestor {
+			index.Ancestor = "ALL_ANCESTORS"
+		}
+		for _, property := range definition.Properties {
+			direction := "ASCENDING"
+			if property.Direction == "desc" {
+				direction = "DESCENDING"
+			}
+			index.Properties = append(index.Properties, &admin.GoogleDatastoreAdminV1IndexedProperty{Name: property.Name, Direction: direction})
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// indexSignature identifies the definition of `index`.
+func indexSignature(index *admin.GoogleDatastoreAdminV1Index) string {
+	parts := []string{index.Kind, index.Ancestor}
+	for _, property := range index.Properties {
+		parts = append(parts, property.Name+" "+property.Direction)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// missingIndexes returns the indexes of `want` not in `existing`.
+func missingIndexes(want, existing []*admin.GoogleDatastoreAdminV1Index) []*admin.GoogleDatastoreAdminV1Index {
+	found := make(map[string]bool)
+	for _, index := range existing {
+		found[indexSignature(index)] = true
+	}
+	var missing []*admin.GoogleDatastoreAdminV1Index
+	for _, index := range want {
+		if !found[indexSignature(index)] {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// createIndexes starts creating the indexes of `want` missing in the project,
+// and returns them. They take minutes to be built, which is not waited for.
+func createIndexes(ctx context.Context, service *admin.Service, projectID string, want []*admin.GoogleDatastoreAdminV1Index) ([]*admin.GoogleDatastoreAdminV1Index, error) {
+	var existing []*admin.GoogleDatastoreAdminV1Index
+	err := service.Projects.Indexes.List(projectID).Pages(ctx, func(page *admin.GoogleDatastoreAdminV1ListIndexesResponse) error {
+		existing = append(existing, page.Indexes...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	missing := missingIndexes(want, existing)
+	for _, index := range missing {
+		if _, err := service.Projects.Indexes.Create(projectID, index).Context(ctx).Do(); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,261 @@
+// Command init sets up a GCP project for benten: it checks that the caller
+// has the IAM permissions needed, creates the buckets, the Pub/Sub topics and
+// the subscription of the syncer, and the composite indexes of the datastore,
+// and writes a starter config of the syncer. Existing resources are kept, so
+// it can be run again.
+//
+//	init [-project p] [-location US] [-indexes index.yaml] [-config config.json] [-yes]
+//
+// It asks for the names, offering defaults which -yes takes without asking.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	crm "google.golang.org/api/cloudresourcemanager/v1"
+	admin "google.golang.org/api/datastore/v1"
+)
+
+// requiredPermissions are what init needs in the project.
+var requiredPermissions = []string{
+	"storage.buckets.create",
+	"storage.buckets.get",
+	"pubsub.topics.create",
+	"pubsub.topics.get",
+	"pubsub.subscriptions.create",
+	"pubsub.subscriptions.get",
+	"datastore.indexes.create",
+	"datastore.indexes.list",
+}
+
+// starterConfig is the config of the syncer written by init; see cmd/syncer.
+type starterConfig struct {
+	ProjectID          string
+	PieceBucket        string
+	AlbumPictureBucket string
+	SubscriptionID     string
+	LogFileName        string
+	HashCache          string
+	Target             string
+}
+
+// prompter asks questions on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	// yes takes the defaults without asking.
+	yes bool
+}
+
+// ask returns the answer to `question`, or `def` if it is empty.
+func (p *prompter) ask(question, def string) string {
+	if p.yes {
+		return def
+	}
+	fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	answer, _ := p.in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm returns true if the answer to `question` is yes. It is no with -yes.
+func (p *prompter) confirm(question string) bool {
+	answer := strings.ToLower(p.ask(question+" (y/N)", "n"))
+	return answer == "y" || answer == "yes"
+}
+
+// missingPermissions returns the requiredPermissions the caller lacks in the
+// project.
+func missingPermissions(ctx context.Context, projectID string) ([]string, error) {
+	service, err := crm.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := service.Projects.TestIamPermissions(projectID, &crm.TestIamPermissionsRequest{Permissions: requiredPermissions}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	granted := make(map[string]bool)
+	for _, permission := range response.Permissions {
+		granted[permission] = true
+	}
+	var missing []string
+	for _, permission := range requiredPermissions {
+		if !granted[permission] {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// createBucket creates the bucket `name` unless it exists, and returns true if
+// it did.
+func createBucket(ctx context.Context, client *storage.Client, projectID, name, location string) (bool, error) {
+	bucket := client.Bucket(name)
+	_, err := bucket.Attrs(ctx)
+	if err == nil {
+		return false, nil
+	}
+	if err != storage.ErrBucketNotExist {
+		return false, err
+	}
+	return true, bucket.Create(ctx, projectID, &storage.BucketAttrs{Location: location})
+}
+
+// createTopic creates the topic `name` unless it exists, and returns true if
+// it did.
+func createTopic(ctx context.Context, client *pubsub.Client, name string) (bool, error) {
+	exists, err := client.Topic(name).Exists(ctx)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = client.CreateTopic(ctx, name)
+	return true, err
+}
+
+// createSubscription creates the pull subscription `name` of `topic` unless
+// it exists, and returns true if it did.
+func createSubscription(ctx context.Context, client *pubsub.Client, name, topic string) (bool, error) {
+	exists, err := client.Subscription(name).Exists(ctx)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = client.CreateSubscription(ctx, name, pubsub.SubscriptionConfig{
+		Topic:       client.Topic(topic),
+		AckDeadline: time.Minute,
+	})
+	return true, err
+}
+
+// writeConfig writes `config` to `path`, asking before replacing a file.
+func writeConfig(p *prompter, path string, config starterConfig) (bool, error) {
+	if _, err := os.Stat(path); err == nil && !p.confirm(fmt.Sprintf("%s exists. Replace it?", path)) {
+		return false, nil
+	}
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func main() {
+	var projectID, location, indexPath, configPath string
+	var yes bool
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.StringVar(&location, "location", "US", "the location of the buckets")
+	flag.StringVar(&indexPath, "indexes", "index.yaml", "the composite indexes to create")
+	flag.StringVar(&configPath, "config", "config.json", "the config of the syncer to write")
+	flag.BoolVar(&yes, "yes", false, "take the defaults without asking")
+	flag.Parse()
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout, yes: yes}
+	projectID = p.ask("GCP project ID", projectID)
+	if projectID == "" {
+		log.Fatalf("The project ID is required")
+	}
+	home, _ := os.UserHomeDir()
+	config := starterConfig{
+		ProjectID:          projectID,
+		PieceBucket:        p.ask("Bucket of pieces", projectID+"-"+benten.PieceBucket),
+		AlbumPictureBucket: p.ask("Bucket of album pictures", projectID+"-"+benten.AlbumPictureBucket),
+		SubscriptionID:     p.ask("Subscription of upload requests", "upload-requests-syncer"),
+		LogFileName:        "syncer.log",
+		HashCache:          "hashes.json",
+		Target:             p.ask("Local music library", filepath.Join(home, "Music")),
+	}
+	uploadTopic := p.ask("Topic of upload requests", "upload-requests")
+	location = p.ask("Location of the buckets", location)
+	indexes, err := readIndexes(indexPath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", indexPath, err)
+	}
+
+	ctx := context.Background()
+	missing, err := missingPermissions(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to check the IAM permissions: %v", err)
+	}
+	if len(missing) > 0 {
+		log.Fatalf("Missing permissions in %s: %s", projectID, strings.Join(missing, ", "))
+	}
+
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a storage client: %v", err)
+	}
+	defer storageClient.Close()
+	for _, name := range []string{config.PieceBucket, config.AlbumPictureBucket} {
+		created, err := createBucket(ctx, storageClient, projectID, name, location)
+		if err != nil {
+			log.Fatalf("Failed to create the bucket %s: %v", name, err)
+		}
+		if created {
+			log.Printf("Created the bucket %s.", name)
+		} else {
+			log.Printf("The bucket %s exists.", name)
+		}
+	}
+
+	pubsubClient, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a pubsub client: %v", err)
+	}
+	defer pubsubClient.Close()
+	for _, name := range []string{uploadTopic, benten.IndexRequestTopic} {
+		created, err := createTopic(ctx, pubsubClient, name)
+		if err != nil {
+			log.Fatalf("Failed to create the topic %s: %v", name, err)
+		}
+		if created {
+			log.Printf("Created the topic %s.", name)
+		} else {
+			log.Printf("The topic %s exists.", name)
+		}
+	}
+	created, err := createSubscription(ctx, pubsubClient, config.SubscriptionID, uploadTopic)
+	if err != nil {
+		log.Fatalf("Failed to create the subscription %s: %v", config.SubscriptionID, err)
+	}
+	if created {
+		log.Printf("Created the subscription %s.", config.SubscriptionID)
+	} else {
+		log.Printf("The subscription %s exists.", config.SubscriptionID)
+	}
+
+	service, err := admin.NewService(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore admin client: %v", err)
+	}
+	createdIndexes, err := createIndexes(ctx, service, projectID, indexes)
+	if err != nil {
+		log.Fatalf("Failed to create the indexes: %v", err)
+	}
+	log.Printf("Creating %d of the %d indexes, which takes a few minutes.", len(createdIndexes), len(indexes))
+
+	written, err := writeConfig(p, configPath, config)
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", configPath, err)
+	}
+	if written {
+		log.Printf("Wrote %s.", configPath)
+	}
+	log.Printf("Deploy the server with PIECE_BUCKET=%s and ALBUM_PICTURE_BUCKET=%s, push %s to its /api/admin/index/worker, and run the syncer with -config %s -full.",
+		config.PieceBucket, config.AlbumPictureBucket, benten.IndexRequestTopic, configPath)
+}
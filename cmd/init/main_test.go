@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadIndexes(t *testing.T) {
+	indexes, err := readIndexes("../../index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var play string
+	for _, index := range indexes {
+		if index.Kind == "play" {
+			play = indexSignature(index)
+		}
+	}
+	if want := "play, ALL_ANCESTORS, Time DESCENDING"; play != want {
+		t.Errorf("the index of play is %q, want %q", play, want)
+	}
+
+	missing := missingIndexes(indexes, indexes[1:])
+	if len(missing) != 1 || missing[0] != indexes[0] {
+		t.Errorf("missingIndexes() = %v", missing)
+	}
+}
+
+func TestPrompter(t *testing.T) {
+	var out bytes.Buffer
+	p := &prompter{in: bufio.NewReader(strings.NewReader("\nmine\ny\n")), out: &out}
+	if got := p.ask("Bucket", "default"); got != "default" {
+		t.Errorf("ask() = %q for an empty answer", got)
+	}
+	if got := p.ask("Bucket", "default"); got != "mine" {
+		t.Errorf("ask() = %q, want mine", got)
+	}
+	if !p.confirm("Replace?") {
+		t.Errorf("confirm() = false for y")
+	}
+	if p.confirm("Replace?") {
+		t.Errorf("confirm() = true at EOF")
+	}
+	p.yes = true
+	if got := p.ask("Bucket", "default"); got != "default" {
+		t.Errorf("ask() = %q with yes", got)
+	}
+}
@@ -0,0 +1,197 @@
+// Command loadtest sends a mix of searches, streams and album pictures to a
+// benten server, from concurrent workers for a while, and reports the
+// latencies by percentile, to tell whether a deployment can take more
+// clients.
+//
+//	loadtest -server https://benten.example.com -concurrency 16 -duration 5m -mix search=8,stream=2,picture=1
+//
+// The requests are made from a sample of the library: the searches are for
+// the titles, the artists and the albums of the sampled pieces, or the first
+// few characters of them as if being typed. The latency of a stream is until
+// its response starts, and -stream-bytes of it are read like a player
+// buffering. Requests are not retried, so that errors such as 429 show up.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+// sample returns up to `n` pieces, from the first pages of /api/all.
+func sample(ctx context.Context, c *client.Client, n int) ([]client.Piece, error) {
+	var pieces []client.Piece
+	cursor := ""
+	for len(pieces) < n {
+		page, next, err := c.All(ctx, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(pieces) > n {
+		pieces = pieces[:n]
+	}
+	return pieces, nil
+}
+
+// queryFor returns a search for `m`: its title, artist or album, or a prefix
+// of it. The name of the file is for the pieces without them.
+func queryFor(m *benten.Metadata, r *rand.Rand) string {
+	var candidates []string
+	for _, name := range []string{m.Title, m.Artist, m.Album} {
+		if name != "" {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		name := filepath.Base(m.Path)
+		candidates = append(candidates, strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	text := []rune(candidates[r.Intn(len(candidates))])
+	if len(text) > 4 && r.Intn(2) == 0 {
+		text = text[:4+r.Intn(len(text)-4)]
+	}
+	return string(text)
+}
+
+// load is the traffic the workers send.
+type load struct {
+	c           *client.Client
+	pieces      []client.Piece
+	pictures    []string
+	mix         []weighted
+	streamBytes int64
+	recorder    *recorder
+	// ticks paces the requests, or is nil not to.
+	ticks <-chan time.Time
+}
+
+// run sends requests picked from l.mix until `ctx` is done.
+func (l *load) run(ctx context.Context, r *rand.Rand) {
+	for {
+		if l.ticks != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.ticks:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		operation := pick(l.mix, r)
+		piece := &l.pieces[r.Intn(len(l.pieces))]
+		start := time.Now()
+		var latency time.Duration
+		var err error
+		switch operation {
+		case "search":
+			_, err = l.c.Search(ctx, queryFor(&piece.Metadata, r), nil)
+			latency = time.Since(start)
+		case "stream":
+			var body io.ReadCloser
+			body, _, err = l.c.Stream(ctx, piece.Key, nil)
+			latency = time.Since(start)
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, io.LimitReader(body, l.streamBytes))
+				body.Close()
+			}
+		case "picture":
+			var body io.ReadCloser
+			body, _, err = l.c.Picture(ctx, l.pictures[r.Intn(len(l.pictures))])
+			latency = time.Since(start)
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, body)
+				body.Close()
+			}
+		}
+		if ctx.Err() != nil {
+			// Cut short by the end of the run.
+			return
+		}
+		l.recorder.record(operation, latency, err)
+	}
+}
+
+func main() {
+	server := flag.String("server", "", "the URL of the benten server")
+	token := flag.String("token", os.Getenv("BENTEN_TOKEN"), "the admin token, for servers requiring it to stream")
+	concurrency := flag.Int("concurrency", 8, "the number of concurrent workers")
+	duration := flag.Duration("duration", time.Minute, "how long to send requests")
+	mixFlag := flag.String("mix", "search=8,stream=2,picture=1", "the weights of the operations")
+	rate := flag.Float64("rate", 0, "the maximum requests per second of all the workers, or 0 for as fast as possible")
+	sampleSize := flag.Int("sample", 1000, "the number of pieces the requests are made from")
+	streamBytes := flag.Int64("stream-bytes", 256<<10, "the bytes read of each stream")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "the seed of the random choices")
+	flag.Parse()
+	if *server == "" || *concurrency <= 0 || *duration <= 0 {
+		log.Fatalf("Usage: loadtest -server <url> [-concurrency n] [-duration d] [-mix search=8,stream=2,picture=1]")
+	}
+	mix, err := parseMix(*mixFlag)
+	if err != nil {
+		log.Fatalf("Invalid -mix: %v", err)
+	}
+
+	ctx := context.Background()
+	c := client.New(*server, *token)
+	c.MaxRetries = -1
+	c.HTTPClient = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}
+	pieces, err := sample(ctx, c, *sampleSize)
+	if err != nil {
+		log.Fatalf("Failed to sample the library: %v", err)
+	}
+	if len(pieces) == 0 {
+		log.Fatalf("The library is empty")
+	}
+	l := &load{c: c, pieces: pieces, mix: mix, streamBytes: *streamBytes, recorder: newRecorder()}
+	seen := make(map[string]bool)
+	for _, p := range pieces {
+		if p.Metadata.Picture != "" && !seen[p.Metadata.Picture] {
+			seen[p.Metadata.Picture] = true
+			l.pictures = append(l.pictures, p.Metadata.Picture)
+		}
+	}
+	if len(l.pictures) == 0 {
+		l.mix = without(l.mix, "picture")
+		if l.mix == nil {
+			log.Fatalf("The sampled pieces have no pictures to request")
+		}
+	}
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		l.ticks = ticker.C
+	}
+	log.Printf("Sending requests with %d workers for %s, from %d pieces.", *concurrency, *duration, len(pieces))
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(r *rand.Rand) {
+			defer wg.Done()
+			l.run(ctx, r)
+		}(rand.New(rand.NewSource(*seed + int64(i))))
+	}
+	wg.Wait()
+	l.recorder.report(os.Stdout, time.Since(start))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yutakahirano/benten/client"
+)
+
+// operations are the kinds of requests loadtest sends.
+var operations = []string{"search", "stream", "picture"}
+
+// weighted is an operation and its share of the requests.
+type weighted struct {
+	name   string
+	weight int
+}
+
+// parseMix parses a mix such as "search=8,stream=2".
+func parseMix(s string) ([]weighted, error) {
+	var mix []weighted
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q is not <operation>=<weight>", part)
+		}
+		known := false
+		for _, name := range operations {
+			known = known || name == fields[0]
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q; it is one of %s", fields[0], strings.Join(operations, ", "))
+		}
+		weight, err := strconv.Atoi(fields[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("the weight of %s (%q) is not a non-negative integer", fields[0], fields[1])
+		}
+		mix = append(mix, weighted{fields[0], weight})
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("the mix has no weight")
+	}
+	return mix, nil
+}
+
+// without returns `mix` without `operation`, or nil if nothing is left.
+func without(mix []weighted, operation string) []weighted {
+	var kept []weighted
+	total := 0
+	for _, w := range mix {
+		if w.name != operation {
+			kept = append(kept, w)
+			total += w.weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	return kept
+}
+
+// pick returns an operation of `mix` chosen by weight.
+func pick(mix []weighted, r *rand.Rand) string {
+	total := 0
+	for _, w := range mix {
+		total += w.weight
+	}
+	n := r.Intn(total)
+	for _, w := range mix {
+		if n < w.weight {
+			return w.name
+		}
+		n -= w.weight
+	}
+	return mix[len(mix)-1].name
+}
+
+// recorder collects the latencies and the errors of the requests by
+// operation.
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	// errors counts the errors by status, or "other" for the failures
+	// without a response.
+	errors map[string]map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: make(map[string][]time.Duration), errors: make(map[string]map[string]int)}
+}
+
+func (r *recorder) record(operation string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.latencies[operation] = append(r.latencies[operation], latency)
+		return
+	}
+	status := "other"
+	var e *client.Error
+	if errors.As(err, &e) {
+		status = strconv.Itoa(e.StatusCode)
+	}
+	if r.errors[operation] == nil {
+		r.errors[operation] = make(map[string]int)
+	}
+	r.errors[operation][status]++
+}
+
+// percentile returns the nearest-rank `p`th percentile of `sorted`.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// report writes the summary of the requests sent for `elapsed` to `w`.
+func (r *recorder) report(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(w, "%-8s %8s %8s %9s %9s %9s %9s  %s\n", "", "requests", "req/s", "p50", "p90", "p99", "max", "errors")
+	for _, operation := range operations {
+		latencies := r.latencies[operation]
+		errs := 0
+		var statuses []string
+		for status, n := range r.errors[operation] {
+			errs += n
+			statuses = append(statuses, fmt.Sprintf("%s: %d", status, n))
+		}
+		if len(latencies)+errs == 0 {
+			continue
+		}
+		sort.Strings(statuses)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		requests := len(latencies) + errs
+		summary := strconv.Itoa(errs)
+		if errs > 0 {
+			summary += " (" + strings.Join(statuses, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%-8s %8d %8.1f %9s %9s %9s %9s  %s\n", operation, requests, float64(requests)/elapsed.Seconds(),
+			round(percentile(latencies, 50)), round(percentile(latencies, 90)), round(percentile(latencies, 99)),
+			round(percentile(latencies, 100)), summary)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
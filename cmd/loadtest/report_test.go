@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten/client"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("search=8, stream=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mix) != 2 || mix[0] != (weighted{"search", 8}) || mix[1] != (weighted{"stream", 2}) {
+		t.Errorf("parseMix() = %v", mix)
+	}
+	for _, invalid := range []string{"", "search", "browse=1", "search=-1", "search=0"} {
+		if _, err := parseMix(invalid); err == nil {
+			t.Errorf("parseMix(%q) succeeded", invalid)
+		}
+	}
+	if got := without(mix, "stream"); len(got) != 1 || got[0].name != "search" {
+		t.Errorf("without() = %v", got)
+	}
+	if got := without(mix[:1], "search"); got != nil {
+		t.Errorf("without() = %v, want nil", got)
+	}
+}
+
+func TestPick(t *testing.T) {
+	mix := []weighted{{"search", 3}, {"stream", 0}, {"picture", 1}}
+	r := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[pick(mix, r)]++
+	}
+	if counts["stream"] != 0 || counts["search"] < 2800 || counts["search"] > 3200 {
+		t.Errorf("counts = %v", counts)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond, 0: time.Millisecond} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of none = %v", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := newRecorder()
+	r.record("search", 10*time.Millisecond, nil)
+	r.record("search", 0, &client.Error{StatusCode: 429})
+	r.record("search", 0, errors.New("connection reset"))
+	var out bytes.Buffer
+	r.report(&out, time.Second)
+	if !strings.Contains(out.String(), "2 (429: 1, other: 1)") || strings.Contains(out.String(), "stream") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,128 @@
+// Command migrate-index builds the per-piece index entities (benten.PieceIndexKind)
+// for all the pieces, and optionally deletes the legacy per-gram index
+// entities (benten.LegacyPieceIndexKind). It is safe to run repeatedly.
+//
+// It also stores the index config (benten.IndexConfig) when the gram sizes are
+// given by flags or when the stored one was written for another
+// benten.IndexVersion, and then rebuilds the index for it.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// The maximum number of entities a single datastore call can handle.
+const batchSize = 500
+
+func spanAll(ctx context.Context, client *datastore.Client) error {
+	aliases, err := benten.LoadAliases(ctx, client)
+	if err != nil {
+		return err
+	}
+	query := datastore.NewQuery(benten.PieceKind)
+	t := client.Run(ctx, query)
+	count := 0
+	for {
+		var piece benten.Metadata
+		key, err := t.Next(&piece)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if piece.IsTrashed() {
+			// Trashed pieces are not indexed.
+			continue
+		}
+		err = benten.RespanIndex(ctx, client, &piece, key, aliases)
+		if err != nil {
+			return err
+		}
+		count++
+		if count%1000 == 0 {
+			log.Printf("Indexed %d pieces...", count)
+		}
+	}
+	log.Printf("Indexed %d pieces.", count)
+	return nil
+}
+
+func deleteLegacy(ctx context.Context, client *datastore.Client) error {
+	query := datastore.NewQuery(benten.LegacyPieceIndexKind).KeysOnly()
+	t := client.Run(ctx, query)
+	count := 0
+	keys := make([]*datastore.Key, 0, batchSize)
+	for {
+		key, err := t.Next(nil)
+		if err != nil && err != iterator.Done {
+			return err
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+		if len(keys) == batchSize || (err == iterator.Done && len(keys) > 0) {
+			if err := client.DeleteMulti(ctx, keys); err != nil {
+				return err
+			}
+			count += len(keys)
+			keys = keys[:0]
+			log.Printf("Deleted %d legacy index entries...", count)
+		}
+		if err == iterator.Done {
+			return nil
+		}
+	}
+}
+
+func main() {
+	var projectID string
+	var deleteLegacyFlag bool
+	var gramSizeForAscii, gramSizeForNonAscii int
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.BoolVar(&deleteLegacyFlag, "delete-legacy", false, "delete the legacy index entities after indexing")
+	flag.IntVar(&gramSizeForAscii, "gram-size-ascii", 0, "store a new index config with this ASCII gram size")
+	flag.IntVar(&gramSizeForNonAscii, "gram-size-non-ascii", 0, "store a new index config with this non-ASCII gram size")
+	flag.Parse()
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+
+	config, err := benten.LoadIndexConfig(ctx, client)
+	if err != nil && err != benten.ErrIndexVersionMismatch {
+		log.Fatalf("Failed to load the index config: %v", err)
+	}
+	if err == benten.ErrIndexVersionMismatch || gramSizeForAscii > 0 || gramSizeForNonAscii > 0 {
+		if gramSizeForAscii > 0 {
+			config.GramSizeForAscii = gramSizeForAscii
+		}
+		if gramSizeForNonAscii > 0 {
+			config.GramSizeForNonAscii = gramSizeForNonAscii
+		}
+		config, err = benten.StoreIndexConfig(ctx, client, config)
+		if err != nil {
+			log.Fatalf("Failed to store the index config: %v", err)
+		}
+		log.Printf("Stored index config revision %d.", config.Revision)
+	}
+
+	if err := spanAll(ctx, client); err != nil {
+		log.Fatalf("Failed to index pieces: %v", err)
+	}
+	if deleteLegacyFlag {
+		if err := deleteLegacy(ctx, client); err != nil {
+			log.Fatalf("Failed to delete the legacy index: %v", err)
+		}
+	}
+}
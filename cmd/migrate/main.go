@@ -0,0 +1,197 @@
+// Command migrate copies a benten library from one GCP project to another: the
+// datastore entities (pieces, their index entities, playlists, ratings, plays,
+// artist aliases and the index config) and the objects in the piece and album
+// picture buckets.
+//
+// Progress is saved to the file given by -state after every batch, so an
+// interrupted migration continues where it stopped when run again. Copying is
+// idempotent, so a batch which was being copied is simply copied again.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// The maximum number of entities a single datastore call can handle.
+const batchSize = 500
+
+// state is the progress of a migration.
+type state struct {
+	// Cursors are the datastore cursors to continue from, by kind.
+	Cursors map[string]string
+	// LastObjects are the names of the last copied objects, by source bucket.
+	LastObjects map[string]string
+	// Done lists the finished kinds and buckets.
+	Done map[string]bool
+}
+
+func loadState(path string) (*state, error) {
+	s := &state{Cursors: make(map[string]string), LastObjects: make(map[string]string), Done: make(map[string]bool)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(data, s)
+}
+
+func (s *state) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write and rename so that a crash doesn't leave a truncated file.
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// copyKind copies all the entities of `kind` from `src` to `dst`, keeping their keys.
+func copyKind(ctx context.Context, src, dst *datastore.Client, kind string, s *state, statePath string) error {
+	if s.Done[kind] {
+		return nil
+	}
+	count := 0
+	for {
+		query := datastore.NewQuery(kind).Limit(batchSize)
+		if cursorString := s.Cursors[kind]; cursorString != "" {
+			cursor, err := datastore.DecodeCursor(cursorString)
+			if err != nil {
+				return err
+			}
+			query = query.Start(cursor)
+		}
+		t := src.Run(ctx, query)
+		var keys []*datastore.Key
+		var entities []datastore.PropertyList
+		for {
+			var entity datastore.PropertyList
+			key, err := t.Next(&entity)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			entities = append(entities, entity)
+		}
+		if len(keys) > 0 {
+			if _, err := dst.PutMulti(ctx, keys, entities); err != nil {
+				return err
+			}
+		}
+		count += len(keys)
+		if len(keys) < batchSize {
+			s.Done[kind] = true
+			log.Printf("Copied %d entities of %s.", count, kind)
+			return s.save(statePath)
+		}
+		cursor, err := t.Cursor()
+		if err != nil {
+			return err
+		}
+		s.Cursors[kind] = cursor.String()
+		if err := s.save(statePath); err != nil {
+			return err
+		}
+		log.Printf("Copied %d entities of %s...", count, kind)
+	}
+}
+
+// copyBucket copies all the objects in `src` to `dst`. Objects are listed in
+// lexicographical order, so the ones up to the last copied one are skipped.
+func copyBucket(ctx context.Context, src, dst *storage.BucketHandle, name string, s *state, statePath string) error {
+	if s.Done[name] {
+		return nil
+	}
+	last := s.LastObjects[name]
+	count := 0
+	it := src.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if attrs.Name <= last {
+			continue
+		}
+		if _, err := dst.Object(attrs.Name).CopierFrom(src.Object(attrs.Name)).Run(ctx); err != nil {
+			return err
+		}
+		count++
+		s.LastObjects[name] = attrs.Name
+		if count%100 == 0 {
+			if err := s.save(statePath); err != nil {
+				return err
+			}
+			log.Printf("Copied %d objects of %s...", count, name)
+		}
+	}
+	s.Done[name] = true
+	log.Printf("Copied %d objects of %s.", count, name)
+	return s.save(statePath)
+}
+
+func main() {
+	var fromProject, toProject, toPieceBucket, toPictureBucket, statePath string
+	flag.StringVar(&fromProject, "from-project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "the source GCP project ID")
+	flag.StringVar(&toProject, "to-project", "", "the destination GCP project ID")
+	flag.StringVar(&toPieceBucket, "to-piece-bucket", "", "the destination bucket of the pieces")
+	flag.StringVar(&toPictureBucket, "to-picture-bucket", "", "the destination bucket of the album pictures")
+	flag.StringVar(&statePath, "state", "migrate-state.json", "the file to save the progress to")
+	flag.Parse()
+	if toProject == "" || toPieceBucket == "" || toPictureBucket == "" {
+		log.Fatalf("-to-project, -to-piece-bucket and -to-picture-bucket are required")
+	}
+
+	ctx := context.Background()
+	s, err := loadState(statePath)
+	if err != nil {
+		log.Fatalf("Failed to load the state: %v", err)
+	}
+	src, err := datastore.NewClient(ctx, fromProject)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer src.Close()
+	dst, err := datastore.NewClient(ctx, toProject)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer dst.Close()
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a storage client: %v", err)
+	}
+	defer storageClient.Close()
+
+	for _, kind := range []string{benten.IndexConfigKind, benten.ArtistAliasKind, benten.PieceKind, benten.PieceIndexKind, benten.PlaylistKind, benten.RatingKind, benten.PlayKind, benten.ArtistArtKind, benten.LyricsKind, benten.PodcastKind, benten.ClientProfileKind, benten.TombstoneKind, benten.TagRulesKind, benten.FeatureFlagKind} {
+		if err := copyKind(ctx, src, dst, kind, s, statePath); err != nil {
+			log.Fatalf("Failed to copy %s: %v", kind, err)
+		}
+	}
+	buckets := map[string]string{benten.PieceBucket: toPieceBucket, benten.AlbumPictureBucket: toPictureBucket}
+	for from, to := range buckets {
+		if err := copyBucket(ctx, storageClient.Bucket(from), storageClient.Bucket(to), from, s, statePath); err != nil {
+			log.Fatalf("Failed to copy %s: %v", from, err)
+		}
+	}
+	log.Printf("Done. Remove %s before migrating again.", statePath)
+}
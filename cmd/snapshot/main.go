@@ -0,0 +1,249 @@
+// Command snapshot manages snapshots of the library metadata: the pieces,
+// their index entities, playlists, ratings, plays, artist aliases and the
+// index config. Snapshots are Datastore managed exports written under
+// gs://<bucket>/snapshots/, and the audio files are not included.
+//
+//	snapshot -bucket b export [-keep n]   export a snapshot, and delete all but the newest n
+//	snapshot -bucket b list               list the snapshots, oldest first
+//	snapshot -bucket b restore -yes name  roll the metadata back to the snapshot `name`
+//
+// Run `export` from cron or Cloud Scheduler to take snapshots regularly.
+// `restore` deletes all the entities of the snapshotted kinds before
+// importing the snapshot, so that entities created after it don't survive.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	admin "google.golang.org/api/datastore/v1"
+	"google.golang.org/api/iterator"
+)
+
+// The maximum number of entities a single datastore call can handle.
+const batchSize = 500
+
+// The prefix of snapshots in the bucket.
+const snapshotPrefix = "snapshots/"
+
+// kinds are the kinds included in snapshots.
+func kinds() []string {
+	return []string{
+		benten.PieceKind,
+		benten.PieceIndexKind,
+		benten.ArtistAliasKind,
+		benten.IndexConfigKind,
+		benten.PlaylistKind,
+		benten.RatingKind,
+		benten.PlayKind,
+		benten.TombstoneKind,
+		benten.TagRulesKind,
+		benten.ArtistArtKind,
+		benten.LyricsKind,
+		benten.PodcastKind,
+		benten.ClientProfileKind,
+		benten.FeatureFlagKind,
+	}
+}
+
+// snapshotName returns the name of a snapshot taken at `t`. Names sort by time.
+func snapshotName(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// metadataURL returns the URL of the metadata file of the snapshot `name`,
+// which the import takes.
+func metadataURL(bucket, name string) string {
+	return fmt.Sprintf("gs://%s/%s%s/%s.overall_export_metadata", bucket, snapshotPrefix, name, name)
+}
+
+// toPrune returns the snapshots to delete to keep the newest `keep` of `names`.
+func toPrune(names []string, keep int) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	if len(sorted) <= keep {
+		return nil
+	}
+	return sorted[:len(sorted)-keep]
+}
+
+// wait waits for the operation `op` to finish.
+func wait(ctx context.Context, service *admin.Service, op *admin.GoogleLongrunningOperation) error {
+	for !op.Done {
+		time.Sleep(10 * time.Second)
+		var err error
+		op, err = service.Projects.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("%s (code %d)", op.Error.Message, op.Error.Code)
+	}
+	return nil
+}
+
+func export(ctx context.Context, service *admin.Service, projectID, bucket string) (string, error) {
+	name := snapshotName(time.Now())
+	op, err := service.Projects.Export(projectID, &admin.GoogleDatastoreAdminV1ExportEntitiesRequest{
+		EntityFilter:    &admin.GoogleDatastoreAdminV1EntityFilter{Kinds: kinds()},
+		OutputUrlPrefix: fmt.Sprintf("gs://%s/%s%s", bucket, snapshotPrefix, name),
+		Labels:          map[string]string{"benten-snapshot": strings.ToLower(name)},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return name, wait(ctx, service, op)
+}
+
+func list(ctx context.Context, client *storage.Client, bucket string) ([]string, error) {
+	t := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: snapshotPrefix, Delimiter: "/"})
+	var names []string
+	for {
+		attrs, err := t.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			names = append(names, path.Base(attrs.Prefix))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func deleteSnapshot(ctx context.Context, client *storage.Client, bucket, name string) error {
+	t := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: snapshotPrefix + name + "/"})
+	for {
+		attrs, err := t.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := client.Bucket(bucket).Object(attrs.Name).Delete(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// clear deletes all the entities of `kind`.
+func clear(ctx context.Context, client *datastore.Client, kind string) error {
+	for {
+		keys, err := client.GetAll(ctx, datastore.NewQuery(kind).KeysOnly().Limit(batchSize), nil)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := client.DeleteMulti(ctx, keys); err != nil {
+			return err
+		}
+	}
+}
+
+func restore(ctx context.Context, service *admin.Service, client *datastore.Client, projectID, bucket, name string) error {
+	for _, kind := range kinds() {
+		log.Printf("Deleting %s entities...", kind)
+		if err := clear(ctx, client, kind); err != nil {
+			return err
+		}
+	}
+	log.Printf("Importing %s...", name)
+	op, err := service.Projects.Import(projectID, &admin.GoogleDatastoreAdminV1ImportEntitiesRequest{
+		EntityFilter: &admin.GoogleDatastoreAdminV1EntityFilter{Kinds: kinds()},
+		InputUrl:     metadataURL(bucket, name),
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return wait(ctx, service, op)
+}
+
+func main() {
+	var projectID, bucket string
+	var keep int
+	var yes bool
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.StringVar(&bucket, "bucket", "", "the bucket holding snapshots")
+	flag.IntVar(&keep, "keep", 0, "with export, the number of snapshots to keep (0 keeps all)")
+	flag.BoolVar(&yes, "yes", false, "with restore, really replace the current metadata")
+	flag.Parse()
+	if bucket == "" || flag.NArg() == 0 {
+		log.Fatalf("Usage: snapshot -bucket <bucket> export|list|restore <name>")
+	}
+
+	ctx := context.Background()
+	service, err := admin.NewService(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore admin client: %v", err)
+	}
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a storage client: %v", err)
+	}
+	defer storageClient.Close()
+
+	switch flag.Arg(0) {
+	case "export":
+		name, err := export(ctx, service, projectID, bucket)
+		if err != nil {
+			log.Fatalf("Failed to export a snapshot: %v", err)
+		}
+		log.Printf("Exported %s.", name)
+		if keep <= 0 {
+			return
+		}
+		names, err := list(ctx, storageClient, bucket)
+		if err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		for _, name := range toPrune(names, keep) {
+			if err := deleteSnapshot(ctx, storageClient, bucket, name); err != nil {
+				log.Fatalf("Failed to delete %s: %v", name, err)
+			}
+			log.Printf("Deleted %s.", name)
+		}
+	case "list":
+		names, err := list(ctx, storageClient, bucket)
+		if err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case "restore":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: snapshot -bucket <bucket> restore -yes <name>")
+		}
+		if !yes {
+			log.Fatalf("restore deletes the current metadata; pass -yes to proceed")
+		}
+		client, err := datastore.NewClient(ctx, projectID)
+		if err != nil {
+			log.Fatalf("Failed to create a datastore client: %v", err)
+		}
+		defer client.Close()
+		if err := restore(ctx, service, client, projectID, bucket, flag.Arg(1)); err != nil {
+			log.Fatalf("Failed to restore %s: %v", flag.Arg(1), err)
+		}
+		log.Printf("Restored %s. Restart the servers to reload the index config, and run a reindex job if an external search index is used.", flag.Arg(1))
+	default:
+		log.Fatalf("Unknown command: %s", flag.Arg(0))
+	}
+}
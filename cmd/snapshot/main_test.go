@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotName(t *testing.T) {
+	name := snapshotName(time.Date(2020, 6, 1, 12, 34, 56, 0, time.UTC))
+	if name != "20200601T123456Z" {
+		t.Errorf("name = %s", name)
+	}
+	if url := metadataURL("b", name); url != "gs://b/snapshots/20200601T123456Z/20200601T123456Z.overall_export_metadata" {
+		t.Errorf("url = %s", url)
+	}
+}
+
+func TestToPrune(t *testing.T) {
+	names := []string{"20200603T000000Z", "20200601T000000Z", "20200602T000000Z"}
+	if got := toPrune(names, 2); !reflect.DeepEqual(got, []string{"20200601T000000Z"}) {
+		t.Errorf("toPrune = %v", got)
+	}
+	if got := toPrune(names, 3); got != nil {
+		t.Errorf("toPrune = %v", got)
+	}
+}
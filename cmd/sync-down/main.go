@@ -0,0 +1,164 @@
+// Command sync-down copies the pieces of playlists and albums from a benten
+// server to a local folder, such as the music folder of a phone, and keeps it
+// updated: each run downloads the pieces changed since the last one, and
+// removes those no longer in the selection.
+//
+//	sync-down -server https://benten.example.com -playlist Favorites -dir /sdcard/Music
+//
+// -playlist (a name) and -album (a benten.Metadata.AlbumKey) may be repeated.
+// -quality low or high downloads the renditions of the pieces which have
+// them, as the server doesn't transcode. Renditions lack the tags and the
+// pictures of the originals, which are embedded with -ffmpeg. The pieces are
+// laid out by their paths in the library, and the state of the folder is kept
+// in .benten-sync.json in it.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+// names is a flag which may be repeated.
+type names []string
+
+func (n *names) String() string { return strings.Join(*n, ",") }
+
+func (n *names) Set(value string) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func main() {
+	var playlists, albums names
+	server := flag.String("server", "", "the URL of the benten server")
+	token := flag.String("token", os.Getenv("BENTEN_TOKEN"), "the admin token, for servers requiring it to stream")
+	dir := flag.String("dir", "", "the folder to copy the pieces to")
+	quality := flag.String("quality", benten.QualityOriginal, "the quality of the copies: low, high or original")
+	ffmpeg := flag.String("ffmpeg", "", "the path to ffmpeg, which embeds the tags and the pictures of renditions")
+	flag.Var(&playlists, "playlist", "the name of a playlist to copy; may be repeated")
+	flag.Var(&albums, "album", "the album key of an album to copy; may be repeated")
+	flag.Parse()
+	if *server == "" || *dir == "" || len(playlists)+len(albums) == 0 {
+		log.Fatalf("Usage: sync-down -server <url> -dir <dir> [-playlist <name>]... [-album <key>]...")
+	}
+	if _, _, err := (&benten.Metadata{}).SelectRendition(*quality); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx := context.Background()
+	c := client.New(*server, *token)
+	var selected []client.Piece
+	for _, name := range playlists {
+		pieces, err := c.Playlist(ctx, name)
+		if err != nil {
+			log.Fatalf("Failed to get the playlist %s: %v", name, err)
+		}
+		selected = append(selected, pieces...)
+	}
+	for _, key := range albums {
+		pieces, err := c.Album(ctx, key)
+		if err != nil {
+			log.Fatalf("Failed to get the album %s: %v", key, err)
+		}
+		selected = append(selected, pieces...)
+	}
+
+	st, err := loadState(*dir)
+	if err != nil {
+		log.Fatalf("Failed to read the state: %v", err)
+	}
+	downloads, removals := plan(st, selected, *quality)
+	for i, d := range downloads {
+		log.Printf("[%d/%d] %s", i+1, len(downloads), d.synced.File)
+		if err := download(ctx, c, *dir, d, *quality, *ffmpeg); err != nil {
+			log.Fatalf("Failed to download %s: %v", d.synced.File, err)
+		}
+		if old, ok := st.Pieces[d.synced.Key]; ok && old.File != d.synced.File {
+			removeFile(*dir, old.File)
+		}
+		st.Pieces[d.synced.Key] = d.synced
+		// Saved for each piece, so that an interrupted run resumes.
+		if err := st.save(*dir); err != nil {
+			log.Fatalf("Failed to write the state: %v", err)
+		}
+	}
+	for _, removed := range removals {
+		removeFile(*dir, removed.File)
+		delete(st.Pieces, removed.Key)
+	}
+	if err := st.save(*dir); err != nil {
+		log.Fatalf("Failed to write the state: %v", err)
+	}
+	log.Printf("Downloaded %d and removed %d pieces; %d are in %s.", len(downloads), len(removals), len(st.Pieces), *dir)
+}
+
+// download copies the piece of `d` to its file in `dir`, embedding the tags
+// and the picture with `ffmpeg` if it is a rendition.
+func download(ctx context.Context, c *client.Client, dir string, d pieceDownload, quality, ffmpeg string) error {
+	dest := filepath.Join(dir, filepath.FromSlash(d.synced.File))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	body, _, err := c.Stream(ctx, d.synced.Key, &client.StreamOptions{Quality: quality})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	tmp, err := copyToTemp(filepath.Dir(dest), filepath.Ext(dest), body)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if d.codec != "" && ffmpeg != "" {
+		tagged, err := embedTags(ctx, c, ffmpeg, tmp, &d.piece.Metadata, d.codec)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tagged)
+		tmp = tagged
+	}
+	return os.Rename(tmp, dest)
+}
+
+// copyToTemp copies `r` to a new temporary file in `dir` ending with `ext`,
+// and returns its path.
+func copyToTemp(dir, ext string, r io.Reader) (string, error) {
+	file, err := ioutil.TempFile(dir, ".sync-down-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// removeFile removes `file` in `dir`, and its parent folders left empty.
+func removeFile(dir, file string) {
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s: %v", path, err)
+		return
+	}
+	for parent := filepath.Dir(path); parent != filepath.Clean(dir); parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			// It is not empty.
+			return
+		}
+	}
+}
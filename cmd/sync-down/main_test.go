@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+func TestLocalFile(t *testing.T) {
+	cases := []struct {
+		piece benten.Metadata
+		codec string
+		want  string
+	}{
+		{benten.Metadata{Path: "Artist/Album/01.flac"}, "", "Artist/Album/01.flac"},
+		{benten.Metadata{Path: "Artist/Album/01.flac"}, "opus", "Artist/Album/01.opus"},
+		{benten.Metadata{Path: "../../etc/passwd"}, "", "etc/passwd"},
+		{benten.Metadata{Hash: "h0", FileType: "MP3"}, "", "h0.mp3"},
+	}
+	for _, c := range cases {
+		if got := localFile(&c.piece, c.codec); got != c.want {
+			t.Errorf("localFile(%q, %q) = %q, want %q", c.piece.Path, c.codec, got, c.want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	updated := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	piece := func(key, hash string, updated time.Time) client.Piece {
+		return client.Piece{Key: key, Metadata: benten.Metadata{Hash: hash, Path: key + ".mp3", Updated: updated}}
+	}
+	st := &state{Pieces: map[string]syncedPiece{
+		"same":    {Key: "same", Hash: "h0", Updated: updated, Object: "h0", File: "same.mp3"},
+		"edited":  {Key: "edited", Hash: "h1", Updated: updated, Object: "h1", File: "edited.mp3"},
+		"removed": {Key: "removed", Hash: "h2", Updated: updated, Object: "h2", File: "removed.mp3"},
+	}}
+	selected := []client.Piece{
+		piece("same", "h0", updated.In(time.FixedZone("JST", 9*60*60))),
+		piece("edited", "h1", updated.Add(time.Minute)),
+		piece("new", "h3", updated),
+		piece("new", "h3", updated),
+	}
+	downloads, removals := plan(st, selected, benten.QualityOriginal)
+	if len(downloads) != 2 || downloads[0].synced.Key != "edited" || downloads[1].synced.Key != "new" || downloads[1].synced.File != "new.mp3" {
+		t.Errorf("downloads = %v", downloads)
+	}
+	if len(removals) != 1 || removals[0].Key != "removed" {
+		t.Errorf("removals = %v", removals)
+	}
+
+	selected[0].Metadata.Renditions.Low = benten.Rendition{Object: "low/h0", Codec: "opus"}
+	downloads, _ = plan(st, selected[:1], benten.QualityLow)
+	if len(downloads) != 1 || downloads[0].synced.File != "same.opus" || downloads[0].codec != "opus" {
+		t.Errorf("downloads = %v", downloads)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+// stateFile is the name of the file keeping the state in the folder.
+const stateFile = ".benten-sync.json"
+
+// syncedPiece is a piece copied to the folder.
+type syncedPiece struct {
+	Key     string
+	Hash    string
+	Updated time.Time
+	// Object is the object copied, which differs by the quality.
+	Object string
+	// File is the slash-separated path of the copy in the folder.
+	File string
+}
+
+// state is what is in the folder, by the keys of the pieces.
+type state struct {
+	Pieces map[string]syncedPiece
+}
+
+// loadState reads the state of `dir`, which is empty on the first run.
+func loadState(dir string) (*state, error) {
+	st := &state{Pieces: make(map[string]syncedPiece)}
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	if st.Pieces == nil {
+		st.Pieces = make(map[string]syncedPiece)
+	}
+	return st, nil
+}
+
+// save writes `st` to `dir`, replacing the previous state at once.
+func (st *state) save(dir string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
+
+// pieceDownload is a piece to download.
+type pieceDownload struct {
+	piece  client.Piece
+	synced syncedPiece
+	// codec is the codec of the rendition, or empty for the original.
+	codec string
+}
+
+// plan returns the pieces of `selected` to download in `quality`, as they are
+// not in `st` or have changed since, and the pieces in `st` to remove, as
+// they are no longer selected.
+func plan(st *state, selected []client.Piece, quality string) ([]pieceDownload, []syncedPiece) {
+	var downloads []pieceDownload
+	wanted := make(map[string]bool)
+	for _, piece := range selected {
+		if wanted[piece.Key] {
+			continue
+		}
+		wanted[piece.Key] = true
+		object, codec, _ := piece.Metadata.SelectRendition(quality)
+		synced := syncedPiece{
+			Key:     piece.Key,
+			Hash:    piece.Metadata.Hash,
+			Updated: piece.Metadata.Updated,
+			Object:  object,
+			File:    localFile(&piece.Metadata, codec),
+		}
+		old, ok := st.Pieces[piece.Key]
+		if ok && old.Hash == synced.Hash && old.Updated.Equal(synced.Updated) && old.Object == synced.Object && old.File == synced.File {
+			continue
+		}
+		downloads = append(downloads, pieceDownload{piece, synced, codec})
+	}
+	var removals []syncedPiece
+	for key, synced := range st.Pieces {
+		if !wanted[key] {
+			removals = append(removals, synced)
+		}
+	}
+	return downloads, removals
+}
+
+// localFile returns the slash-separated path of the copy of `piece`, its
+// path in the library, with the extension of `codec` for renditions. It never
+// leaves the folder.
+func localFile(piece *benten.Metadata, codec string) string {
+	file := filepath.ToSlash(piece.Path)
+	if file == "" {
+		file = piece.Hash + "." + strings.ToLower(piece.FileType)
+	}
+	file = strings.TrimPrefix(path.Clean("/"+file), "/")
+	if codec != "" {
+		file = strings.TrimSuffix(file, path.Ext(file)) + "." + codec
+	}
+	return file
+}
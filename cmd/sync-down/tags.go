@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+// embedTimeout bounds running ffmpeg on a piece.
+const embedTimeout = time.Minute
+
+// pictureCodecs are the codecs whose files ffmpeg embeds pictures in.
+var pictureCodecs = map[string]bool{"mp3": true, "m4a": true, "flac": true}
+
+// embedTags copies the rendition at `path` to a new file with the tags of
+// `piece`, and its picture if the container of `codec` takes one, and
+// returns the path of the new file.
+func embedTags(ctx context.Context, c *client.Client, ffmpeg, path string, piece *benten.Metadata, codec string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+	dir := filepath.Dir(path)
+	args := []string{"-v", "error", "-y", "-i", path}
+	if piece.Picture != "" && pictureCodecs[codec] {
+		body, _, err := c.Picture(ctx, piece.Picture)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the picture: %v", err)
+		}
+		picture, err := copyToTemp(dir, ".img", body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(picture)
+		args = append(args, "-i", picture, "-map", "0:a", "-map", "1:v", "-disposition:v", "attached_pic")
+	} else {
+		args = append(args, "-map", "0:a")
+	}
+	args = append(args, "-c", "copy", "-map_metadata", "-1")
+	for _, tag := range ffmpegTags(piece) {
+		args = append(args, "-metadata", tag)
+	}
+	out := strings.TrimSuffix(path, filepath.Ext(path)) + ".tagged." + codec
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg, append(args, out)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(out)
+		return "", fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// ffmpegTags returns the tags of `piece` as the -metadata arguments of
+// ffmpeg, leaving out the unknown ones.
+func ffmpegTags(piece *benten.Metadata) []string {
+	var tags []string
+	add := func(name, value string) {
+		if value != "" {
+			tags = append(tags, name+"="+value)
+		}
+	}
+	number := func(n, total int) string {
+		switch {
+		case n == 0:
+			return ""
+		case total == 0:
+			return strconv.Itoa(n)
+		}
+		return fmt.Sprintf("%d/%d", n, total)
+	}
+	add("title", piece.Title)
+	add("artist", piece.Artist)
+	add("album", piece.Album)
+	add("album_artist", piece.AlbumArtist)
+	add("composer", piece.Composer)
+	add("genre", piece.Genre)
+	if piece.Year != 0 {
+		add("date", strconv.Itoa(piece.Year))
+	}
+	add("track", number(piece.Track, piece.TotalTracks))
+	add("disc", number(piece.Disc, piece.TotalDisks))
+	add("comment", piece.Comment)
+	return tags
+}
@@ -0,0 +1,169 @@
+// Command sync-stats syncs the ratings and the play counts of benten both
+// ways with those of a local player, such as foobar2000, MusicBee or Quod
+// Libet. The players keep them in databases of their own formats, so they are
+// exchanged as a CSV file, which the player exports and imports again after
+// this updates it:
+//
+//	path,hash,rating,play_count,last_played,loved
+//	D:\Music\Artist\Album\01.flac,,80,12,2020-05-01T10:00:00Z,false
+//
+// Rows are matched with pieces by hash (see benten.Metadata.Hash), or else by
+// path suffix. Both sides end up with the higher play count and the later
+// play time, and the rating of benten, or of the player if benten has none.
+// -scale is the rating of five stars in the file, such as 100 or 1.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+func main() {
+	var projectID string
+	var scale float64
+	var dryRun bool
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.Float64Var(&scale, "scale", 5, "the rating of five stars in the file")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what would be synced")
+	flag.Parse()
+	if flag.NArg() != 1 || scale <= 0 {
+		log.Fatalf("Usage: sync-stats [flags] <stats.csv>")
+	}
+	path := flag.Arg(0)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	stats, err := readStats(bytes.NewReader(b), scale)
+	if err != nil {
+		log.Fatalf("Failed to parse %s: %v", path, err)
+	}
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	matcher, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+	var hashes []string
+	for i := range stats.rows {
+		if hash := stats.cell(i, columnHash); hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	byHash, err := benten.FindByHashes(ctx, client, hashes)
+	if err != nil {
+		log.Fatalf("Failed to find pieces: %v", err)
+	}
+
+	// rows are the rows matched with the pieces at keys. The rows matching
+	// the same piece are merged into the first of them.
+	var rows [][]int
+	var keys []*datastore.Key
+	var local []benten.Rating
+	indices := make(map[string]int)
+	matched := 0
+	for i := range stats.rows {
+		rating, err := stats.rating(i)
+		if err != nil {
+			log.Fatalf("Failed to parse row %d: %v", i+2, err)
+		}
+		var key *datastore.Key
+		if found := byHash[stats.cell(i, columnHash)]; len(found) > 0 {
+			key = found[0]
+		} else if p := stats.cell(i, columnPath); p != "" {
+			key = matcher.ByPath(p)
+		}
+		if key == nil {
+			log.Printf("No piece for row %d: %s", i+2, stats.cell(i, columnPath))
+			continue
+		}
+		matched++
+		if j, ok := indices[key.Encode()]; ok {
+			rows[j] = append(rows[j], i)
+			local[j].Merge(rating)
+			continue
+		}
+		indices[key.Encode()] = len(keys)
+		rows = append(rows, []int{i})
+		keys = append(keys, key)
+		local = append(local, rating)
+	}
+	log.Printf("Matched %d of %d rows.", matched, len(stats.rows))
+
+	merged, err := mergedRatings(ctx, client, keys, local)
+	if err != nil {
+		log.Fatalf("Failed to get ratings: %v", err)
+	}
+	changed := 0
+	for j := range keys {
+		if merged[j] != local[j] {
+			changed++
+		}
+		for _, i := range rows[j] {
+			stats.setRating(i, merged[j])
+		}
+	}
+	log.Printf("%d pieces differ from benten.", changed)
+	if dryRun {
+		return
+	}
+
+	if err := benten.MergeRatings(ctx, client, keys, local); err != nil {
+		log.Fatalf("Failed to put ratings: %v", err)
+	}
+	var out bytes.Buffer
+	if err := stats.write(&out); err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, out.Bytes(), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Fatalf("Failed to replace %s: %v", path, err)
+	}
+	log.Printf("Synced %d pieces; import %s into the player.", len(keys), path)
+}
+
+// mergedRatings returns the stored ratings of the pieces at `keys` merged
+// with `local`, as benten.MergeRatings will store them.
+func mergedRatings(ctx context.Context, client *datastore.Client, keys []*datastore.Key, local []benten.Rating) ([]benten.Rating, error) {
+	merged := make([]benten.Rating, len(keys))
+	for i := 0; i < len(keys); i += 1000 {
+		end := i + 1000
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ratingKeys := make([]*datastore.Key, 0, end-i)
+		for _, key := range keys[i:end] {
+			ratingKeys = append(ratingKeys, benten.RatingKey(key))
+		}
+		err := client.GetMulti(ctx, ratingKeys, merged[i:end])
+		if multi, ok := err.(datastore.MultiError); ok {
+			for _, e := range multi {
+				if e != nil && e != datastore.ErrNoSuchEntity {
+					return nil, err
+				}
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for i := range merged {
+		merged[i].Merge(local[i])
+	}
+	return merged, nil
+}
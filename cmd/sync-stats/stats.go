@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+// The columns of a statistics file, compared case-insensitively. Path or Hash
+// is required to match the rows with pieces, and the other columns are all
+// optional. Unknown columns are kept as they are.
+const (
+	columnPath       = "path"
+	columnHash       = "hash"
+	columnRating     = "rating"
+	columnPlayCount  = "play_count"
+	columnLastPlayed = "last_played"
+	columnLoved      = "loved"
+)
+
+// statsFile is a CSV file of the statistics of a player, one track a row
+// after the header.
+type statsFile struct {
+	header []string
+	rows   [][]string
+	// columns are the indices of the known columns in header.
+	columns map[string]int
+	// scale is the rating of five stars in the file, such as 100 or 1.
+	scale float64
+}
+
+// readStats reads a statsFile from `r`, whose ratings are up to `scale`.
+func readStats(r io.Reader, scale float64) (*statsFile, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the header is missing")
+	}
+	f := &statsFile{header: records[0], rows: records[1:], columns: make(map[string]int), scale: scale}
+	for i, name := range f.header {
+		f.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasPath := f.columns[columnPath]
+	_, hasHash := f.columns[columnHash]
+	if !hasPath && !hasHash {
+		return nil, fmt.Errorf("neither %s nor %s is in the header", columnPath, columnHash)
+	}
+	return f, nil
+}
+
+// cell returns the value of `column` in the `i`th row, or empty.
+func (f *statsFile) cell(i int, column string) string {
+	j, ok := f.columns[column]
+	if !ok || j >= len(f.rows[i]) {
+		return ""
+	}
+	return strings.TrimSpace(f.rows[i][j])
+}
+
+// setCell sets the value of `column` in the `i`th row if the file has it.
+func (f *statsFile) setCell(i int, column, value string) {
+	j, ok := f.columns[column]
+	if !ok {
+		return
+	}
+	for len(f.rows[i]) <= j {
+		f.rows[i] = append(f.rows[i], "")
+	}
+	f.rows[i][j] = value
+}
+
+// rating returns the statistics in the `i`th row.
+func (f *statsFile) rating(i int) (benten.Rating, error) {
+	var r benten.Rating
+	if s := f.cell(i, columnRating); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 || v > f.scale {
+			return r, fmt.Errorf("%s (%v) is invalid", columnRating, s)
+		}
+		r.Stars = int(math.Round(v / f.scale * 5))
+	}
+	if s := f.cell(i, columnPlayCount); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("%s (%v) is invalid", columnPlayCount, s)
+		}
+		r.PlayCount = n
+	}
+	if s := f.cell(i, columnLastPlayed); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return r, fmt.Errorf("%s (%v) is invalid", columnLastPlayed, s)
+		}
+		r.LastPlayed = t
+	}
+	if s := f.cell(i, columnLoved); s != "" {
+		loved, err := strconv.ParseBool(s)
+		if err != nil {
+			return r, fmt.Errorf("%s (%v) is invalid", columnLoved, s)
+		}
+		r.Starred = loved
+	}
+	return r, nil
+}
+
+// setRating writes `r` to the `i`th row. Times are written in the format of
+// the previous value: Unix seconds or RFC 3339.
+func (f *statsFile) setRating(i int, r benten.Rating) {
+	rating := ""
+	if r.Stars != 0 {
+		rating = strconv.FormatFloat(float64(r.Stars)*f.scale/5, 'f', -1, 64)
+	}
+	f.setCell(i, columnRating, rating)
+	f.setCell(i, columnPlayCount, strconv.Itoa(r.PlayCount))
+	lastPlayed := ""
+	if !r.LastPlayed.IsZero() {
+		if _, err := strconv.ParseInt(f.cell(i, columnLastPlayed), 10, 64); err == nil {
+			lastPlayed = strconv.FormatInt(r.LastPlayed.Unix(), 10)
+		} else {
+			lastPlayed = r.LastPlayed.UTC().Format(time.RFC3339)
+		}
+	}
+	f.setCell(i, columnLastPlayed, lastPlayed)
+	f.setCell(i, columnLoved, strconv.FormatBool(r.Starred))
+}
+
+// parseTime parses Unix seconds, RFC 3339, or "2006-01-02 15:04:05" in the
+// local time zone.
+func parseTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
+
+// write writes `f` as CSV to `w`.
+func (f *statsFile) write(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(f.header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(f.rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+const statsCSV = `Path,Title,Rating,Play_Count,Last_Played,Loved
+D:\Music\Beatles\Help.mp3,Help!,80,7,2020-05-01T10:00:00Z,true
+D:\Music\Beatles\Yesterday.mp3,Yesterday,,0,1588327200,false
+`
+
+func TestStatsFile(t *testing.T) {
+	f, err := readStats(strings.NewReader(statsCSV), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	played := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	r, err := f.rating(0)
+	if err != nil || r.Stars != 4 || r.PlayCount != 7 || !r.LastPlayed.Equal(played) || !r.Starred {
+		t.Errorf("rating(0) = %v, %v", r, err)
+	}
+	r, err = f.rating(1)
+	if err != nil || r.Stars != 0 || r.PlayCount != 0 || !r.LastPlayed.Equal(played) {
+		t.Errorf("rating(1) = %v, %v", r, err)
+	}
+
+	f.setRating(1, benten.Rating{Stars: 3, PlayCount: 2, LastPlayed: played.Add(time.Hour)})
+	var b bytes.Buffer
+	if err := f.write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(statsCSV, ",,0,1588327200,false", ",60,2,1588330800,false", 1)
+	if b.String() != want {
+		t.Errorf("written = %q, want %q", b.String(), want)
+	}
+
+	if _, err := readStats(strings.NewReader("Title,Rating\n"), 5); err == nil {
+		t.Errorf("a file without paths nor hashes is read")
+	}
+	f, _ = readStats(strings.NewReader("hash,rating\nh0,7\n"), 5)
+	if _, err := f.rating(0); err == nil {
+		t.Errorf("a rating out of the scale is parsed")
+	}
+}
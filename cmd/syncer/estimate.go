@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/syncer"
+)
+
+// printEstimate prints `e` with the time its writes take within `budget`.
+func printEstimate(w io.Writer, e *syncer.Estimate, budget syncer.WriteBudget) {
+	fmt.Fprintf(w, "files:     %d (new %d, changed %d, moved %d, unverified %d, unchanged %d)\n",
+		e.Files, e.New, e.Changed, e.Moved, e.Unverified, e.Unchanged)
+	if e.Missing > 0 {
+		fmt.Fprintf(w, "missing:   %d pieces whose files are gone\n", e.Missing)
+	}
+	fmt.Fprintf(w, "storage:   %s\n", benten.FormatBytes(e.StorageBytes))
+	fmt.Fprintf(w, "upload:    %s to %s\n", benten.FormatBytes(e.UploadBytes), benten.FormatBytes(e.MaxUploadBytes))
+	fmt.Fprintf(w, "entities:  %d pieces, %d index entries\n", e.Pieces, e.Pieces)
+	fmt.Fprintf(w, "writes:    %d to %d\n", e.Writes, e.MaxWrites)
+	if budget.Rate > 0 {
+		d := time.Duration(float64(e.MaxWrites) / budget.Rate * float64(time.Second))
+		fmt.Fprintf(w, "           up to %s at %g writes/s\n", d.Round(time.Second), budget.Rate)
+	}
+	if budget.Daily > 0 {
+		fmt.Fprintf(w, "           up to %d days at %d writes/day\n", (e.MaxWrites+budget.Daily-1)/budget.Daily, budget.Daily)
+	}
+}
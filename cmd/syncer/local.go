@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/yutakahirano/benten"
+)
+
+// useLocal points the syncer at the emulators in `c.Emulators` and creates
+// the buckets, and the topic and the subscription of upload requests named
+// SubscriptionID, in them.
+func (c *config) useLocal(ctx context.Context) error {
+	benten.UseEmulators(c.Emulators)
+	if c.ProjectID == "" {
+		c.ProjectID = benten.LocalProjectID
+	}
+	var topics []string
+	if c.SubscriptionID != "" {
+		topics = append(topics, c.SubscriptionID)
+	}
+	if err := benten.CreateLocalResources(ctx, c.ProjectID, topics...); err != nil {
+		return err
+	}
+	if c.SubscriptionID == "" {
+		return nil
+	}
+	client, err := pubsub.NewClient(ctx, c.ProjectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	sub := client.Subscription(c.SubscriptionID)
+	exists, err := sub.Exists(ctx)
+	if err != nil || exists {
+		return err
+	}
+	_, err = client.CreateSubscription(ctx, c.SubscriptionID, pubsub.SubscriptionConfig{
+		Topic:       client.Topic(c.SubscriptionID),
+		AckDeadline: time.Minute,
+	})
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/yutakahirano/benten"
+)
+
+// profile overrides the settings of config for an environment, such as
+// "prod" or "test". Empty fields keep the values of config.
+type profile struct {
+	ProjectID          string
+	SubscriptionID     string
+	ServiceAccountKey  string
+	PieceBucket        string
+	AlbumPictureBucket string
+	SearchCache        string
+	Standalone         string
+}
+
+// applyProfile overrides `c` with the profile `name`, or does nothing if
+// `name` is empty.
+func (c *config) applyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+	override := func(dest *string, value string) {
+		if value != "" {
+			*dest = value
+		}
+	}
+	override(&c.ProjectID, p.ProjectID)
+	override(&c.SubscriptionID, p.SubscriptionID)
+	override(&c.ServiceAccountKey, p.ServiceAccountKey)
+	override(&c.PieceBucket, p.PieceBucket)
+	override(&c.AlbumPictureBucket, p.AlbumPictureBucket)
+	override(&c.SearchCache, p.SearchCache)
+	override(&c.Standalone, p.Standalone)
+	return nil
+}
+
+// useBuckets makes benten use the buckets of `c`, if given.
+func (c *config) useBuckets() {
+	if c.PieceBucket != "" {
+		benten.PieceBucket = c.PieceBucket
+	}
+	if c.AlbumPictureBucket != "" {
+		benten.AlbumPictureBucket = c.AlbumPictureBucket
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestApplyProfile(t *testing.T) {
+	c := config{
+		ProjectID:      "prod-project",
+		SubscriptionID: "uploads",
+		PieceBucket:    "pieces",
+		Profiles: map[string]profile{
+			"test": {ProjectID: "test-project", PieceBucket: "test-pieces"},
+		},
+	}
+	if err := c.applyProfile("test"); err != nil {
+		t.Fatal(err)
+	}
+	if c.ProjectID != "test-project" || c.PieceBucket != "test-pieces" || c.SubscriptionID != "uploads" {
+		t.Errorf("c = %+v", c)
+	}
+	if err := c.applyProfile("staging"); err == nil {
+		t.Errorf("an unknown profile is accepted")
+	}
+}
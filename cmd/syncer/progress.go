@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/syncer"
+)
+
+func formatETA(p syncer.Progress, now time.Time) string {
+	eta, ok := p.ETA(now)
+	if !ok {
+		return "unknown"
+	}
+	return eta.Round(time.Second).String()
+}
+
+func formatProgress(p syncer.Progress, now time.Time) string {
+	return fmt.Sprintf("discovered %d, processed %d, skipped %d, failed %d, uploaded %s, ETA %s",
+		p.Discovered, p.Processed, p.Skipped, p.Failed, benten.FormatBytes(p.UploadedBytes), formatETA(p, now))
+}
+
+// renderTUI redraws a status block in place using ANSI escape sequences.
+func renderTUI(w io.Writer, p syncer.Progress, now time.Time, first bool) {
+	if !first {
+		// Move the cursor up to the beginning of the block.
+		fmt.Fprintf(w, "\x1b[5A")
+	}
+	scan := "scanning"
+	if p.ScanDone {
+		scan = "scan done"
+	}
+	fmt.Fprintf(w, "\x1b[2Kbenten syncer: %s, elapsed %s\n", scan, now.Sub(p.Started).Round(time.Second))
+	fmt.Fprintf(w, "\x1b[2K  files:    %d discovered, %d remaining\n", p.Discovered, p.Remaining())
+	fmt.Fprintf(w, "\x1b[2K  results:  %d processed, %d skipped, %d failed\n", p.Processed, p.Skipped, p.Failed)
+	fmt.Fprintf(w, "\x1b[2K  uploaded: %s, ETA %s\n", benten.FormatBytes(p.UploadedBytes), formatETA(p, now))
+	fmt.Fprintf(w, "\x1b[2K  current:  %s\n", p.Current)
+}
+
+// reportProgress writes the progress of the full scan to `w` every `interval`
+// until the scan finishes and all the discovered files are done.
+func reportProgress(ctx context.Context, s *syncer.Syncer, w io.Writer, interval time.Duration, tui bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p := s.Progress()
+			if p.Started.IsZero() {
+				continue
+			}
+			if tui {
+				renderTUI(w, p, now, first)
+				first = false
+			} else {
+				fmt.Fprintf(w, "%s (current: %s)\n", formatProgress(p, now), p.Current)
+			}
+			if p.ScanDone && p.Remaining() == 0 {
+				fmt.Fprintf(w, "Full scan finished: %s\n", formatProgress(p, now))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/yutakahirano/benten"
+)
+
+// resolveSecrets replaces the secret references in `c` (see
+// benten.ResolveSecret) with the secrets.
+func (c *config) resolveSecrets(ctx context.Context) error {
+	n := &c.Notification
+	return benten.ResolveSecrets(ctx, c.ProjectID,
+		&n.WebhookURL, &n.PushoverToken, &n.PushoverUser, &n.SMTPPassword, &c.Search.APIKey)
+}
+
+// useServiceAccountKey makes the cloud clients use the service account key
+// `ref`, which is the path of the key file or a secret reference to the key.
+// A key in Secret Manager or the environment is only kept in memory and
+// handed to the clients through benten.ClientOptions.
+func useServiceAccountKey(ctx context.Context, projectID, ref string) error {
+	if !benten.IsSecretReference(ref) || strings.HasPrefix(ref, "file://") {
+		return os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", strings.TrimPrefix(ref, "file://"))
+	}
+	key, err := benten.ResolveSecret(ctx, projectID, ref)
+	if err != nil {
+		return err
+	}
+	benten.UseCredentialsJSON([]byte(key))
+	return nil
+}
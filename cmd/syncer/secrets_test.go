@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestUseServiceAccountKey(t *testing.T) {
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("BENTEN_TEST_KEY", `{"type": "service_account"}`)
+	defer os.Unsetenv("BENTEN_TEST_KEY")
+	ctx := context.Background()
+
+	if err := useServiceAccountKey(ctx, "project", "/path/to/key.json"); err != nil {
+		t.Fatal(err)
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "/path/to/key.json" {
+		t.Errorf("GOOGLE_APPLICATION_CREDENTIALS = %q", path)
+	}
+	defer benten.UseCredentialsJSON(nil)
+	if err := useServiceAccountKey(ctx, "project", "env://BENTEN_TEST_KEY"); err != nil {
+		t.Fatal(err)
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "/path/to/key.json" {
+		t.Errorf("GOOGLE_APPLICATION_CREDENTIALS = %q", path)
+	}
+	if opts := benten.ClientOptions(); len(opts) != 1 {
+		t.Errorf("ClientOptions() = %v", opts)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := config{ProjectID: "project"}
+	c.Notification.SMTPPassword = "password"
+	c.Notification.PushoverToken = "sm://pushover-token"
+	c.Search.APIKey = "key"
+	redacted := c.redacted()
+	if redacted.Notification.SMTPPassword != redactedValue || redacted.Search.APIKey != redactedValue {
+		t.Errorf("redacted = %+v", redacted)
+	}
+	if redacted.Notification.PushoverToken != "sm://pushover-token" || redacted.ProjectID != "project" {
+		t.Errorf("redacted = %+v", redacted)
+	}
+	if c.Notification.SMTPPassword != "password" {
+		t.Errorf("redacted modifies the config")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/yutakahirano/benten/syncer"
+)
+
+// uploadStatus is an upload in flight reported by the status endpoint.
+type uploadStatus struct {
+	syncer.UploadProgress
+	BytesPerSecond float64
+	Percent        float64
+}
+
+// status is the response of the status endpoint.
+type status struct {
+	Progress syncer.Progress
+	Uploads  []uploadStatus
+}
+
+func currentStatus(s *syncer.Syncer) status {
+	now := time.Now()
+	st := status{Progress: s.Progress(), Uploads: make([]uploadStatus, 0)}
+	for _, u := range s.Uploads() {
+		st.Uploads = append(st.Uploads, uploadStatus{u, u.BytesPerSecond(now), u.Percent()})
+	}
+	return st
+}
+
+// serveStatus serves the status of `s` as JSON at /status, and as the
+// "syncer" expvar at /debug/vars for metrics collectors, on `addr`.
+func serveStatus(addr string, s *syncer.Syncer) error {
+	expvar.Publish("syncer", expvar.Func(func() interface{} { return currentStatus(s) }))
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentStatus(s))
+	})
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"unicode/utf8"
+)
+
+// key is a key press: a printable rune, or one of the special keys below.
+type key rune
+
+// The special keys, which are below the printable runes.
+const (
+	keyNone key = -iota - 1
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyTab
+	keyBackspace
+	keyEscape
+	keyDelete
+)
+
+// ctrl returns the key of Ctrl and the letter `c`.
+func ctrl(c byte) key {
+	return key(c & 0x1f)
+}
+
+// escapeSequences are the sequences following ESC of the special keys, as
+// xterm and most terminals send them.
+var escapeSequences = map[string]key{
+	"[A":  keyUp,
+	"[B":  keyDown,
+	"[C":  keyRight,
+	"[D":  keyLeft,
+	"OA":  keyUp,
+	"OB":  keyDown,
+	"OC":  keyRight,
+	"OD":  keyLeft,
+	"[5~": keyPageUp,
+	"[6~": keyPageDown,
+	"[3~": keyDelete,
+}
+
+// readKey reads a key from `r`. A lone ESC is keyEscape only when nothing
+// follows it in the buffer, since terminals send escape sequences at once.
+func readKey(r *bufio.Reader) (key, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return keyNone, err
+	}
+	switch b {
+	case '\r', '\n':
+		return keyEnter, nil
+	case '\t':
+		return keyTab, nil
+	case 0x7f, 0x08:
+		return keyBackspace, nil
+	case 0x1b:
+		if r.Buffered() == 0 {
+			return keyEscape, nil
+		}
+		var seq []byte
+		for r.Buffered() > 0 && len(seq) < 4 {
+			c, _ := r.ReadByte()
+			seq = append(seq, c)
+			// Sequences end with a letter or '~'.
+			if len(seq) > 1 && (c == '~' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')) {
+				break
+			}
+		}
+		if k, ok := escapeSequences[string(seq)]; ok {
+			return k, nil
+		}
+		return keyNone, nil
+	}
+	if b < utf8.RuneSelf {
+		// Printable, or a control key such as ctrl('c').
+		return key(b), nil
+	}
+	// A multi-byte UTF-8 character, for searching in any language.
+	r.UnreadByte()
+	c, _, err := r.ReadRune()
+	return key(c), err
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("a\x1b[A\x1b[6~\r\x7f\x03弁"))
+	want := []key{'a', keyUp, keyPageDown, keyEnter, keyBackspace, ctrl('c'), '弁'}
+	for _, w := range want {
+		k, err := readKey(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != w {
+			t.Errorf("readKey() = %v, want %v", k, w)
+		}
+	}
+	if _, err := readKey(r); err == nil {
+		t.Errorf("readKey() at the end succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+// Command tui is a terminal player of a benten server. It searches as you
+// type, lists the albums of the results, queues tracks and albums, and plays
+// the queue with mpv, which streams the pieces from the server.
+//
+//	tui -server https://benten.example.com
+//
+// Type to search. Tab switches between the tracks, the albums and the queue,
+// Enter queues a track, opens an album or plays from the queue, and Esc goes
+// back or clears the search. The status line lists the other keys.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/client"
+)
+
+// How long typing must pause before searching.
+const searchDelay = 200 * time.Millisecond
+
+// The maximum number of search results.
+const searchLimit = 200
+
+// result is the result of a search or of opening an album.
+type result struct {
+	// query is the query searched for, or the key of the opened album.
+	query  string
+	album  bool
+	pieces []benten.Metadata
+	err    error
+}
+
+func main() {
+	server := flag.String("server", "", "the URL of the benten server")
+	token := flag.String("token", os.Getenv("BENTEN_TOKEN"), "the admin token, which searching doesn't need")
+	mpvPath := flag.String("mpv", "mpv", "the path to mpv")
+	flag.Parse()
+	if *server == "" {
+		log.Fatalf("-server is required")
+	}
+	c := client.New(*server, *token)
+
+	player, err := startMPV(*mpvPath)
+	if err != nil {
+		log.Fatalf("Failed to start mpv: %v", err)
+	}
+	defer player.close()
+	term, err := openTerminal()
+	if err != nil {
+		log.Fatalf("Failed to open the terminal: %v", err)
+	}
+	// The alternate screen, without the cursor.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.restore()
+	}()
+
+	keys := make(chan key)
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			k, err := readKey(r)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- k
+		}
+	}()
+
+	results := make(chan result)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	search := func(query string) {
+		pieces, err := c.Search(ctx, query, &client.SearchOptions{Limit: searchLimit})
+		results <- result{query: query, pieces: pieces, err: err}
+	}
+	openAlbum := func(albumKey string) {
+		found, err := c.Album(ctx, albumKey)
+		var pieces []benten.Metadata
+		for _, piece := range found {
+			pieces = append(pieces, piece.Metadata)
+		}
+		results <- result{query: albumKey, album: true, pieces: pieces, err: err}
+	}
+
+	u := newUI(player, c.StreamURL)
+	resized := term.resized()
+	var searchTimer <-chan time.Time
+	for {
+		columns, lines := term.size()
+		fmt.Print(draw(u, columns, lines))
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			switch u.handle(k) {
+			case effectQuit:
+				return
+			case effectSearch:
+				searchTimer = time.After(searchDelay)
+			case effectOpenAlbum:
+				go openAlbum(u.opened.key)
+			}
+		case <-searchTimer:
+			searchTimer = nil
+			if u.query == "" {
+				u.setResults(nil)
+			} else {
+				go search(u.query)
+			}
+		case r := <-results:
+			if r.err != nil {
+				u.message = fmt.Sprintf("Failed to search: %v", r.err)
+			} else if r.album && u.opened != nil && r.query == u.opened.key {
+				u.setAlbum(r.pieces)
+			} else if !r.album && r.query == u.query {
+				// Results of older queries are dropped.
+				u.setResults(r.pieces)
+			}
+		case event, ok := <-player.events:
+			if !ok {
+				u.message = "mpv exited"
+				player.events = nil
+				continue
+			}
+			switch event.Name {
+			case "playlist-pos":
+				// null, which leaves it -1, when nothing plays.
+				u.playing = -1
+				json.Unmarshal(event.Data, &u.playing)
+			case "pause":
+				json.Unmarshal(event.Data, &u.paused)
+			}
+		case <-resized:
+			// Clear what the old size left.
+			fmt.Print("\x1b[2J")
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long mpv may take to open its IPC socket.
+const mpvStartTimeout = 5 * time.Second
+
+// mpv plays the queue with an mpv process controlled over its JSON IPC. Its
+// playlist mirrors the queue of the UI.
+type mpv struct {
+	cmd    *exec.Cmd
+	dir    string
+	mu     sync.Mutex
+	conn   net.Conn
+	events chan mpvEvent
+}
+
+// mpvEvent is a change of an observed property.
+type mpvEvent struct {
+	Event string          `json:"event"`
+	Name  string          `json:"name"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// startMPV starts the mpv at `path` in the idle mode, and observes the
+// playlist position and the pause state.
+func startMPV(path string) (*mpv, error) {
+	dir, err := ioutil.TempDir("", "benten-tui")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, "mpv.sock")
+	cmd := exec.Command(path, "--idle=yes", "--no-video", "--no-terminal", "--input-ipc-server="+socket)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	p := &mpv{cmd: cmd, dir: dir, events: make(chan mpvEvent, 16)}
+	deadline := time.Now().Add(mpvStartTimeout)
+	for {
+		p.conn, err = net.Dial("unix", socket)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			p.close()
+			return nil, fmt.Errorf("mpv didn't open %s: %v", socket, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	go p.read()
+	p.command("observe_property", 1, "playlist-pos")
+	p.command("observe_property", 2, "pause")
+	return p, nil
+}
+
+// read sends the property changes to p.events until the connection closes.
+func (p *mpv) read() {
+	defer close(p.events)
+	scanner := bufio.NewScanner(p.conn)
+	for scanner.Scan() {
+		var event mpvEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Event != "property-change" {
+			// Replies to commands, and the other events.
+			continue
+		}
+		p.events <- event
+	}
+}
+
+// command sends a command without waiting for the reply.
+func (p *mpv) command(args ...interface{}) error {
+	data, err := json.Marshal(struct {
+		Command []interface{} `json:"command"`
+	}{args})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (p *mpv) load(url string) error     { return p.command("loadfile", url, "append-play") }
+func (p *mpv) remove(index int) error    { return p.command("playlist-remove", index) }
+func (p *mpv) playIndex(index int) error { return p.command("playlist-play-index", index) }
+func (p *mpv) next() error               { return p.command("playlist-next", "force") }
+func (p *mpv) previous() error           { return p.command("playlist-prev", "force") }
+func (p *mpv) togglePause() error        { return p.command("cycle", "pause") }
+
+// close quits mpv.
+func (p *mpv) close() {
+	if p.conn != nil {
+		p.command("quit")
+		p.conn.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+	}
+	os.RemoveAll(p.dir)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// fit truncates or pads `s` to `columns` display columns. East Asian wide
+// characters take two.
+func fit(s string, columns int) string {
+	var b strings.Builder
+	used := 0
+	for _, c := range s {
+		w := 1
+		switch width.LookupRune(c).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			w = 2
+		}
+		if c < ' ' {
+			// Control characters in tags would break the screen.
+			c = ' '
+		}
+		if used+w > columns {
+			break
+		}
+		b.WriteRune(c)
+		used += w
+	}
+	b.WriteString(strings.Repeat(" ", columns-used))
+	return b.String()
+}
+
+// draw returns the escape sequences drawing `u` on a terminal of `columns`
+// and `lines`: the query, the views, the list, the status and what is playing.
+func draw(u *ui, columns, lines int) string {
+	var b strings.Builder
+	// Home, without clearing, which would flicker.
+	b.WriteString("\x1b[H")
+	line := func(s string, reverse bool) {
+		if reverse {
+			b.WriteString("\x1b[7m")
+		}
+		b.WriteString(fit(s, columns))
+		if reverse {
+			b.WriteString("\x1b[0m")
+		}
+		b.WriteString("\r\n")
+	}
+	line("Search: "+u.query, false)
+	line(u.tabs(), true)
+	height := lines - 4
+	if height < 1 {
+		height = 1
+	}
+	// Scroll so that the cursor is on the screen.
+	offset := 0
+	if u.cursor >= height {
+		offset = u.cursor - height + 1
+	}
+	for i := offset; i < offset+height; i++ {
+		if i < u.rows() {
+			line(u.row(i), i == u.cursor)
+		} else {
+			line("", false)
+		}
+	}
+	status := u.message
+	if status == "" {
+		status = "Enter: play/open  ^A: queue album  ^P: pause  ^N/^B: next/prev  ^D: remove  Tab: view  ^Q: quit"
+	}
+	line(status, false)
+	b.WriteString(fit(u.nowPlaying(), columns))
+	return b.String()
+}
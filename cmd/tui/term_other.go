@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+type terminal struct{}
+
+func openTerminal() (*terminal, error) {
+	return nil, errors.New("the terminal of this OS is not supported")
+}
+
+func (t *terminal) size() (int, int) { return 80, 24 }
+func (t *terminal) restore()         {}
+
+// resized returns nil, which never receives.
+func (t *terminal) resized() <-chan os.Signal { return nil }
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yutakahirano/benten"
+)
+
+// player is what the UI controls; see mpv.
+type player interface {
+	load(url string) error
+	remove(index int) error
+	playIndex(index int) error
+	next() error
+	previous() error
+	togglePause() error
+}
+
+// view is what the list shows.
+type view int
+
+const (
+	viewTracks view = iota
+	viewAlbums
+	viewAlbum
+	viewQueue
+)
+
+// album is an album of the search results.
+type album struct {
+	key    string
+	title  string
+	artist string
+	year   int
+	// tracks are the matching tracks, or all of them once opened.
+	tracks []benten.Metadata
+}
+
+// groupAlbums groups `pieces` by AlbumKey, in the order of their first
+// tracks. Pieces of unknown albums are left out.
+func groupAlbums(pieces []benten.Metadata) []album {
+	var albums []album
+	index := make(map[string]int)
+	for _, piece := range pieces {
+		if piece.AlbumKey == "" {
+			continue
+		}
+		i, ok := index[piece.AlbumKey]
+		if !ok {
+			artist := piece.GroupAlbumArtist()
+			if artist == "" {
+				artist = piece.Artist
+			}
+			i = len(albums)
+			index[piece.AlbumKey] = i
+			albums = append(albums, album{key: piece.AlbumKey, title: piece.Album, artist: artist, year: piece.ReleaseYear()})
+		}
+		albums[i].tracks = append(albums[i].tracks, piece)
+	}
+	return albums
+}
+
+// sortTracks sorts the tracks of an album by disc and track.
+func sortTracks(tracks []benten.Metadata) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].Disc != tracks[j].Disc {
+			return tracks[i].Disc < tracks[j].Disc
+		}
+		return tracks[i].Track < tracks[j].Track
+	})
+}
+
+// effect is what handle asks the main loop to do.
+type effect int
+
+const (
+	effectNone effect = iota
+	// effectSearch searches for ui.query after a pause in typing.
+	effectSearch
+	// effectOpenAlbum loads all the tracks of ui.opened.
+	effectOpenAlbum
+	effectQuit
+)
+
+// ui is the state of the UI. The main loop feeds it keys, search results and
+// player events, and draws it after each.
+type ui struct {
+	player    player
+	streamURL func(piece *benten.Metadata) string
+
+	query   string
+	results []benten.Metadata
+	albums  []album
+	opened  *album
+	queue   []benten.Metadata
+	// playing is the index of the playing piece in queue, or -1.
+	playing int
+	paused  bool
+
+	view   view
+	cursor int
+	// message is shown in the status line until the next key.
+	message string
+}
+
+func newUI(p player, streamURL func(piece *benten.Metadata) string) *ui {
+	return &ui{player: p, streamURL: streamURL, playing: -1}
+}
+
+// rows returns the number of rows of the current list.
+func (u *ui) rows() int {
+	switch u.view {
+	case viewTracks:
+		return len(u.results)
+	case viewAlbums:
+		return len(u.albums)
+	case viewAlbum:
+		return len(u.opened.tracks)
+	default:
+		return len(u.queue)
+	}
+}
+
+// setResults replaces the search results.
+func (u *ui) setResults(pieces []benten.Metadata) {
+	u.results = pieces
+	u.albums = groupAlbums(pieces)
+	if u.view == viewTracks || u.view == viewAlbums {
+		u.cursor = 0
+	}
+}
+
+// setAlbum shows `tracks`, all the tracks of the opened album.
+func (u *ui) setAlbum(tracks []benten.Metadata) {
+	sortTracks(tracks)
+	u.opened.tracks = tracks
+}
+
+// enqueue appends `pieces` to the queue.
+func (u *ui) enqueue(pieces ...benten.Metadata) {
+	for i := range pieces {
+		if err := u.player.load(u.streamURL(&pieces[i])); err != nil {
+			u.message = fmt.Sprintf("Failed to play: %v", err)
+			return
+		}
+		u.queue = append(u.queue, pieces[i])
+	}
+	if len(pieces) == 1 {
+		u.message = fmt.Sprintf("Queued %s", pieces[0].Title)
+	} else {
+		u.message = fmt.Sprintf("Queued %d tracks", len(pieces))
+	}
+}
+
+// handle applies the key `k`.
+func (u *ui) handle(k key) effect {
+	u.message = ""
+	var err error
+	switch k {
+	case ctrl('c'), ctrl('q'):
+		return effectQuit
+	case keyUp:
+		u.cursor--
+	case keyDown:
+		u.cursor++
+	case keyPageUp:
+		u.cursor -= 10
+	case keyPageDown:
+		u.cursor += 10
+	case keyTab:
+		switch u.view {
+		case viewTracks:
+			u.view = viewAlbums
+		case viewAlbums, viewAlbum:
+			u.view = viewQueue
+		default:
+			u.view = viewTracks
+		}
+		u.cursor = 0
+	case keyEscape:
+		if u.view == viewAlbum {
+			u.view = viewAlbums
+			u.cursor = 0
+		} else if u.query != "" {
+			u.query = ""
+			return effectSearch
+		}
+	case keyBackspace:
+		if u.query != "" {
+			runes := []rune(u.query)
+			u.query = string(runes[:len(runes)-1])
+			return effectSearch
+		}
+	case keyEnter:
+		return u.enter()
+	case ctrl('a'):
+		// The whole album.
+		if u.view == viewAlbum {
+			u.enqueue(u.opened.tracks...)
+		}
+	case ctrl('d'), keyDelete:
+		if u.view == viewQueue && u.cursor < len(u.queue) {
+			err = u.player.remove(u.cursor)
+			if err == nil {
+				u.queue = append(u.queue[:u.cursor], u.queue[u.cursor+1:]...)
+				if u.cursor < u.playing {
+					u.playing--
+				}
+			}
+		}
+	case ctrl('p'):
+		err = u.player.togglePause()
+	case ctrl('n'):
+		err = u.player.next()
+	case ctrl('b'):
+		err = u.player.previous()
+	default:
+		if k >= ' ' {
+			u.query += string(rune(k))
+			return effectSearch
+		}
+	}
+	if err != nil {
+		u.message = fmt.Sprintf("Failed to control the player: %v", err)
+	}
+	u.clampCursor()
+	return effectNone
+}
+
+// enter opens the selected album, or queues or plays the selected track.
+func (u *ui) enter() effect {
+	if u.cursor >= u.rows() {
+		return effectNone
+	}
+	switch u.view {
+	case viewTracks:
+		u.enqueue(u.results[u.cursor])
+	case viewAlbums:
+		opened := u.albums[u.cursor]
+		u.opened = &opened
+		u.view = viewAlbum
+		u.cursor = 0
+		return effectOpenAlbum
+	case viewAlbum:
+		u.enqueue(u.opened.tracks[u.cursor])
+	case viewQueue:
+		if err := u.player.playIndex(u.cursor); err != nil {
+			u.message = fmt.Sprintf("Failed to control the player: %v", err)
+		}
+	}
+	return effectNone
+}
+
+func (u *ui) clampCursor() {
+	if u.cursor >= u.rows() {
+		u.cursor = u.rows() - 1
+	}
+	if u.cursor < 0 {
+		u.cursor = 0
+	}
+}
+
+// row returns the text of the `i`th row of the current list.
+func (u *ui) row(i int) string {
+	switch u.view {
+	case viewTracks:
+		p := &u.results[i]
+		return fmt.Sprintf("%s — %s · %s%s", p.Title, p.Artist, p.Album, yearSuffix(p.ReleaseYear()))
+	case viewAlbums:
+		a := &u.albums[i]
+		return fmt.Sprintf("%s — %s%s", a.title, a.artist, yearSuffix(a.year))
+	case viewAlbum:
+		p := &u.opened.tracks[i]
+		return fmt.Sprintf("%d-%02d %s — %s", p.Disc, p.Track, p.Title, p.Artist)
+	default:
+		p := &u.queue[i]
+		marker := "  "
+		if i == u.playing {
+			marker = "▶ "
+		}
+		return fmt.Sprintf("%s%s — %s", marker, p.Title, p.Artist)
+	}
+}
+
+func yearSuffix(year int) string {
+	if year == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d)", year)
+}
+
+// nowPlaying returns the text of the bottom line.
+func (u *ui) nowPlaying() string {
+	if u.playing < 0 || u.playing >= len(u.queue) {
+		return fmt.Sprintf("%d in the queue", len(u.queue))
+	}
+	state := "▶"
+	if u.paused {
+		state = "❚❚"
+	}
+	p := &u.queue[u.playing]
+	return fmt.Sprintf("%s %s — %s  [%d/%d]", state, p.Title, p.Artist, u.playing+1, len(u.queue))
+}
+
+// tabs returns the text of the line of the views.
+func (u *ui) tabs() string {
+	names := []string{"Tracks", "Albums", "Queue"}
+	current := map[view]int{viewTracks: 0, viewAlbums: 1, viewAlbum: 1, viewQueue: 2}[u.view]
+	var b strings.Builder
+	for i, name := range names {
+		if i == 2 {
+			name = fmt.Sprintf("Queue (%d)", len(u.queue))
+		}
+		if i == current {
+			fmt.Fprintf(&b, "[%s] ", name)
+		} else {
+			fmt.Fprintf(&b, " %s  ", name)
+		}
+	}
+	if u.view == viewAlbum {
+		fmt.Fprintf(&b, "› %s", u.opened.title)
+	}
+	return b.String()
+}
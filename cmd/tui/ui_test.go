@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+// fakePlayer records the playlist.
+type fakePlayer struct {
+	playlist []string
+}
+
+func (p *fakePlayer) load(url string) error {
+	p.playlist = append(p.playlist, url)
+	return nil
+}
+
+func (p *fakePlayer) remove(index int) error {
+	p.playlist = append(p.playlist[:index], p.playlist[index+1:]...)
+	return nil
+}
+
+func (p *fakePlayer) playIndex(index int) error { return nil }
+func (p *fakePlayer) next() error               { return nil }
+func (p *fakePlayer) previous() error           { return nil }
+func (p *fakePlayer) togglePause() error        { return nil }
+
+func TestGroupAlbums(t *testing.T) {
+	pieces := []benten.Metadata{
+		{Title: "b", Album: "X", AlbumKey: "x", Track: 2},
+		{Title: "c", Album: "Y", AlbumKey: "y"},
+		{Title: "a", Album: "X", AlbumKey: "x", Track: 1},
+		{Title: "d"},
+	}
+	albums := groupAlbums(pieces)
+	if len(albums) != 2 || albums[0].key != "x" || albums[1].key != "y" {
+		t.Fatalf("groupAlbums() = %v", albums)
+	}
+	if len(albums[0].tracks) != 2 {
+		t.Errorf("album x has %d tracks, want 2", len(albums[0].tracks))
+	}
+}
+
+func TestQueue(t *testing.T) {
+	p := &fakePlayer{}
+	u := newUI(p, func(piece *benten.Metadata) string { return piece.Title })
+	u.setResults([]benten.Metadata{
+		{Title: "a", Album: "X", AlbumKey: "x", Track: 1},
+		{Title: "b", Album: "X", AlbumKey: "x", Track: 2},
+	})
+	u.handle(keyDown)
+	u.handle(keyEnter)
+	u.handle(keyTab)
+	if e := u.handle(keyEnter); e != effectOpenAlbum {
+		t.Fatalf("Enter on an album = %v, want effectOpenAlbum", e)
+	}
+	u.setAlbum([]benten.Metadata{
+		{Title: "c", Track: 3},
+		{Title: "b", Track: 2},
+		{Title: "a", Track: 1},
+	})
+	u.handle(ctrl('a'))
+	if want := []string{"b", "a", "b", "c"}; !reflect.DeepEqual(p.playlist, want) {
+		t.Fatalf("playlist = %v, want %v", p.playlist, want)
+	}
+
+	u.handle(keyTab)
+	u.playing = 2
+	u.handle(keyDown)
+	u.handle(ctrl('d'))
+	if want := []string{"b", "b", "c"}; !reflect.DeepEqual(p.playlist, want) {
+		t.Errorf("playlist = %v, want %v", p.playlist, want)
+	}
+	if len(u.queue) != 3 || u.playing != 1 {
+		t.Errorf("queue has %d pieces playing %d, want 3 playing 1", len(u.queue), u.playing)
+	}
+}
+
+func TestFit(t *testing.T) {
+	for _, c := range []struct {
+		s       string
+		columns int
+		want    string
+	}{
+		{"abc", 5, "abc  "},
+		{"abcdef", 4, "abcd"},
+		{"弁財天", 5, "弁財 "},
+	} {
+		if got := fit(c.s, c.columns); got != c.want {
+			t.Errorf("fit(%q, %d) = %q, want %q", c.s, c.columns, got, c.want)
+		}
+	}
+}
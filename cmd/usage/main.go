@@ -0,0 +1,171 @@
+// Command usage reports the bytes stored in the piece and album picture
+// buckets, aggregated by album, artist, format and storage class.
+//
+// Objects in the piece bucket are matched with pieces by name, which is the
+// encoded datastore key, the hash or the path of the piece depending on the
+// uploader. Objects matching no piece are reported as "(unknown)".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+const unknown = "(unknown)"
+
+// usage is the number of bytes and objects by group.
+type usage struct {
+	bytes   map[string]int64
+	objects map[string]int
+}
+
+func newUsage() *usage {
+	return &usage{bytes: make(map[string]int64), objects: make(map[string]int)}
+}
+
+func (u *usage) add(group string, size int64) {
+	if group == "" {
+		group = unknown
+	}
+	u.bytes[group] += size
+	u.objects[group]++
+}
+
+// print prints the `top` largest groups, or all of them when `top` is zero.
+func (u *usage) print(w io.Writer, title string, top int) {
+	groups := make([]string, 0, len(u.bytes))
+	for group := range u.bytes {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if u.bytes[groups[i]] != u.bytes[groups[j]] {
+			return u.bytes[groups[i]] > u.bytes[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+	if top > 0 && len(groups) > top {
+		groups = groups[:top]
+	}
+	fmt.Fprintf(w, "%s\n", title)
+	for _, group := range groups {
+		fmt.Fprintf(w, "  %10s %6d  %s\n", benten.FormatBytes(u.bytes[group]), u.objects[group], group)
+	}
+	fmt.Fprintln(w)
+}
+
+// report aggregates the usage.
+type report struct {
+	album, artist, format, storageClass *usage
+}
+
+func newReport() *report {
+	return &report{album: newUsage(), artist: newUsage(), format: newUsage(), storageClass: newUsage()}
+}
+
+// addPiece adds an object in the piece bucket, which is `piece` or nil if unknown.
+func (r *report) addPiece(attrs *storage.ObjectAttrs, piece *benten.Metadata) {
+	r.storageClass.add(attrs.StorageClass, attrs.Size)
+	if piece == nil {
+		r.album.add(unknown, attrs.Size)
+		r.artist.add(unknown, attrs.Size)
+		r.format.add(attrs.ContentType, attrs.Size)
+		return
+	}
+	artist := piece.GroupAlbumArtist()
+	if artist == "" {
+		artist = piece.Artist
+	}
+	r.album.add(fmt.Sprintf("%s / %s", artist, piece.Album), attrs.Size)
+	r.artist.add(artist, attrs.Size)
+	r.format.add(piece.FileType, attrs.Size)
+}
+
+// loadPieces returns the pieces by their encoded keys, hashes, object names
+// (including those of their renditions) and paths.
+func loadPieces(ctx context.Context, client *datastore.Client) (map[string]*benten.Metadata, error) {
+	var pieces []benten.Metadata
+	keys, err := client.GetAll(ctx, datastore.NewQuery(benten.PieceKind), &pieces)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*benten.Metadata, 4*len(pieces))
+	for i := range pieces {
+		piece := &pieces[i]
+		byName[keys[i].Encode()] = piece
+		if piece.Hash != "" {
+			byName[piece.Hash] = piece
+		}
+		if piece.Object != "" {
+			byName[piece.Object] = piece
+		}
+		for _, rendition := range []benten.Rendition{piece.Renditions.Low, piece.Renditions.High} {
+			if rendition.Object != "" {
+				byName[rendition.Object] = piece
+			}
+		}
+		if piece.Path != "" {
+			byName[piece.Path] = piece
+		}
+	}
+	return byName, nil
+}
+
+func main() {
+	var projectID string
+	var top int
+	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project ID")
+	flag.IntVar(&top, "top", 20, "the number of the largest groups to print, or 0 for all")
+	flag.Parse()
+
+	ctx := context.Background()
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	defer client.Close()
+	storageClient, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create a storage client: %v", err)
+	}
+	defer storageClient.Close()
+
+	pieces, err := loadPieces(ctx, client)
+	if err != nil {
+		log.Fatalf("Failed to get pieces: %v", err)
+	}
+	r := newReport()
+	pictures := newUsage()
+	for _, bucket := range []string{benten.PieceBucket, benten.AlbumPictureBucket} {
+		it := storageClient.Bucket(bucket).Objects(ctx, nil)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Fatalf("Failed to list %s: %v", bucket, err)
+			}
+			if bucket == benten.PieceBucket {
+				r.addPiece(attrs, pieces[attrs.Name])
+			} else {
+				pictures.add(attrs.StorageClass, attrs.Size)
+			}
+		}
+	}
+
+	r.album.print(os.Stdout, "By album", top)
+	r.artist.print(os.Stdout, "By artist", top)
+	r.format.print(os.Stdout, "By format", 0)
+	r.storageClass.print(os.Stdout, "Pieces by storage class", 0)
+	pictures.print(os.Stdout, "Album pictures by storage class", 0)
+}
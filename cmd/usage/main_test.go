@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+)
+
+func TestReport(t *testing.T) {
+	r := newReport()
+	box := &benten.Metadata{Album: "Box", Artist: "A", FileType: "FLAC"}
+	r.addPiece(&storage.ObjectAttrs{Size: 3 << 20, StorageClass: "STANDARD"}, box)
+	r.addPiece(&storage.ObjectAttrs{Size: 1 << 20, StorageClass: "STANDARD"}, box)
+	r.addPiece(&storage.ObjectAttrs{Size: 1024, StorageClass: "NEARLINE", ContentType: "audio/mpeg"}, nil)
+
+	if r.album.bytes["A / Box"] != 4<<20 || r.album.objects["A / Box"] != 2 {
+		t.Errorf("album = %v", r.album.bytes)
+	}
+	if r.format.bytes["audio/mpeg"] != 1024 || r.artist.bytes[unknown] != 1024 {
+		t.Errorf("format = %v, artist = %v", r.format.bytes, r.artist.bytes)
+	}
+
+	var out bytes.Buffer
+	r.storageClass.print(&out, "By storage class", 1)
+	if got := out.String(); !strings.Contains(got, "4.0 MiB") || strings.Contains(got, "NEARLINE") {
+		t.Errorf("output = %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package benten
+
+import (
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// VariousArtists is the album artist of compilations tagged without one.
+const VariousArtists = "Various Artists"
+
+// variousArtistsNames are album artists meaning a compilation, after
+// Normalize.
+var variousArtistsNames = map[string]bool{
+	"various artists": true,
+	"various":         true,
+	"va":              true, // "V.A."
+	"v a":             true, // "V/A"
+}
+
+// IsCompilation returns true if `tags` mark the file as a part of a
+// compilation: the iTunes flag (TCMP in ID3, cpil in MP4), COMPILATION in
+// Vorbis comments, or an album artist such as "Various Artists".
+func IsCompilation(tags tag.Metadata) bool {
+	if variousArtistsNames[Normalize(tags.AlbumArtist())] {
+		return true
+	}
+	for _, name := range []string{"TCMP", "TCP", "cpil", "compilation"} {
+		switch v := tags.Raw()[name].(type) {
+		case string:
+			if n := strings.Trim(strings.TrimSpace(v), "\x00"); n != "" && n != "0" {
+				return true
+			}
+		case int:
+			if v != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GroupAlbumArtist returns the album artist the album of `m` is grouped by:
+// AlbumArtist, or VariousArtists for compilations without one, so that the
+// tracks of a compilation stay in one album rather than one per Artist.
+func (m *Metadata) GroupAlbumArtist() string {
+	if m.AlbumArtist == "" && m.Compilation {
+		return VariousArtists
+	}
+	return m.AlbumArtist
+}
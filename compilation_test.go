@@ -0,0 +1,49 @@
+package benten
+
+import (
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+// rawTags is a tag.Metadata with only an album artist and raw tags.
+type rawTags struct {
+	tag.Metadata
+	albumArtist string
+	raw         map[string]interface{}
+}
+
+func (t rawTags) AlbumArtist() string         { return t.albumArtist }
+func (t rawTags) Raw() map[string]interface{} { return t.raw }
+
+func TestIsCompilation(t *testing.T) {
+	for _, c := range []struct {
+		tags rawTags
+		want bool
+	}{
+		{rawTags{raw: map[string]interface{}{"TCMP": "1"}}, true},
+		{rawTags{raw: map[string]interface{}{"TCMP": "0"}}, false},
+		{rawTags{raw: map[string]interface{}{"cpil": 1}}, true},
+		{rawTags{raw: map[string]interface{}{"compilation": "1"}}, true},
+		{rawTags{albumArtist: "Various Artists"}, true},
+		{rawTags{albumArtist: "V.A."}, true},
+		{rawTags{albumArtist: "V/A"}, true},
+		{rawTags{albumArtist: "Vanessa"}, false},
+	} {
+		if got := IsCompilation(c.tags); got != c.want {
+			t.Errorf("IsCompilation(%q, %v) = %v", c.tags.albumArtist, c.tags.raw, got)
+		}
+	}
+}
+
+func TestGroupAlbumArtist(t *testing.T) {
+	if m := (Metadata{Artist: "A", Compilation: true}); m.GroupAlbumArtist() != VariousArtists {
+		t.Errorf("GroupAlbumArtist = %q for a compilation", m.GroupAlbumArtist())
+	}
+	if m := (Metadata{Artist: "A", AlbumArtist: "DJ", Compilation: true}); m.GroupAlbumArtist() != "DJ" {
+		t.Errorf("GroupAlbumArtist = %q for a mixed compilation", m.GroupAlbumArtist())
+	}
+	if m := (Metadata{Artist: "A"}); m.GroupAlbumArtist() != "" {
+		t.Errorf("GroupAlbumArtist = %q for an album", m.GroupAlbumArtist())
+	}
+}
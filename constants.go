@@ -1,9 +1,60 @@
 package benten
 
 var PieceKind string = "piece"
-var PieceIndexKind string = "piece-index"
+var PieceIndexKind string = "piece-grams"
+var LegacyPieceIndexKind string = "piece-index"
+var ArtistAliasKind string = "artist-alias"
+var IndexConfigKind string = "index-config"
+var PlaylistKind string = "playlist"
+var RatingKind string = "rating"
+var PlayKind string = "play"
+var JobKind string = "job"
+var AuditLogKind string = "audit-log"
+var ArtistArtKind string = "artist-art"
+var LyricsKind string = "lyrics"
+var PodcastKind string = "podcast"
+var ClientProfileKind string = "client-profile"
+var BandwidthKind string = "bandwidth"
+var RevokedSessionKind string = "revoked-session"
+var SearchLogKind string = "search-log"
+var TombstoneKind string = "tombstone"
+var TagRulesKind string = "tag-rules"
+var IdempotencyKind string = "idempotency-key"
+var MaintenanceKind string = "maintenance"
+var FeatureFlagKind string = "feature-flag"
 var AlbumPictureBucket string = "album-pictures"
 var PieceBucket string = "pieces"
 
+// IndexVersion is bumped whenever Normalize or the index layout changes, so
+// that RespanIndex rebuilds the entities built with an older version. Version
+// 4 added OriginalYear and made AlbumArtist Metadata.GroupAlbumArtist, and 5
+// made AlbumArtist its canonical name in the Aliases.
+var IndexVersion = 5
+
+// GramSizeForAscii and GramSizeForNonAscii are the defaults, which the stored
+// IndexConfig overrides; see LoadIndexConfig.
 var GramSizeForAscii = 4
 var GramSizeForNonAscii = 6
+
+// Stopwords are words too common to be worth indexing. See SetStopwords.
+var Stopwords = makeSet([]string{"a", "an", "and", "de", "der", "die", "for", "in", "la", "le", "of", "on", "the", "to", "with"})
+
+// SetStopwords replaces Stopwords. The syncer and the server must use the same
+// stopwords, and the index needs to be rebuilt after changing them.
+func SetStopwords(words []string) {
+	normalized := make([]string, 0, len(words))
+	for _, word := range words {
+		normalized = append(normalized, Normalize(word))
+	}
+	Stopwords = makeSet(normalized)
+}
+
+func makeSet(words []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		set[word] = struct{}{}
+	}
+	return set
+}
+
+var IndexRequestTopic string = "index-requests"
@@ -0,0 +1,100 @@
+package benten
+
+import (
+	"strconv"
+	"strings"
+)
+
+// bpmTags and keyTags are the raw tags holding the tempo and the musical key:
+// TBPM and TKEY in ID3v2.3 and 2.4, TBP and TKE in ID3v2.2, and BPM and
+// INITIALKEY in Vorbis comments and MP4 freeform atoms. The MP4 tmpo atom is
+// not read, as dhowden/tag keeps only its first byte.
+var (
+	bpmTags = []string{"TBPM", "TBP", "bpm", "BPM"}
+	keyTags = []string{"TKEY", "TKE", "initialkey", "INITIALKEY", "key"}
+)
+
+// readBPM returns the tempo in `raw`, the raw tags of a file, or zero if
+// unknown.
+func readBPM(raw map[string]interface{}) float64 {
+	for _, name := range bpmTags {
+		s, ok := raw[name].(string)
+		if !ok {
+			continue
+		}
+		bpm, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(s), "\x00"), 64)
+		if err == nil && bpm > 0 {
+			return bpm
+		}
+	}
+	return 0
+}
+
+// readKey returns the musical key in `raw` after NormalizeKey, or the empty
+// string if unknown.
+func readKey(raw map[string]interface{}) string {
+	for _, name := range keyTags {
+		s, ok := raw[name].(string)
+		if !ok {
+			continue
+		}
+		if key := NormalizeKey(strings.Trim(s, "\x00")); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+var (
+	// sharpNames are the names of pitch classes, from C.
+	sharpNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	// camelotMajor is the pitch class of the major key of each Camelot number
+	// (1B is B major). The relative minor (nA) is three semitones below.
+	camelotMajor = []int{11, 6, 1, 8, 3, 10, 5, 0, 7, 2, 9, 4}
+)
+
+// NormalizeKey converts a musical key written as "Am", "A minor", "a min",
+// "Bb", "A#m", "8A" (Camelot) or "o" (off key) into the form "Am", "C#" or
+// "o", with sharps rather than flats. It returns the empty string for what it
+// can't read.
+func NormalizeKey(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "o") {
+		return "o"
+	}
+	if n, err := strconv.Atoi(strings.TrimRight(s, "ABab")); err == nil && n >= 1 && n <= 12 && len(s) == len(strconv.Itoa(n))+1 {
+		pitch := camelotMajor[n-1]
+		if strings.EqualFold(s[len(s)-1:], "a") {
+			return sharpNames[(pitch+9)%12] + "m"
+		}
+		return sharpNames[pitch]
+	}
+	if s == "" {
+		return ""
+	}
+	pitch := strings.IndexByte("C D EF G A B", strings.ToUpper(s[:1])[0])
+	if pitch < 0 {
+		return ""
+	}
+	rest := s[1:]
+	switch {
+	case strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "♯"):
+		pitch++
+	case strings.HasPrefix(rest, "b") || strings.HasPrefix(rest, "♭"):
+		pitch--
+	}
+	rest = strings.TrimLeft(rest, "#b♯♭")
+	var minor bool
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", "maj", "major":
+	case "m", "min", "minor":
+		minor = true
+	default:
+		return ""
+	}
+	name := sharpNames[(pitch+12)%12]
+	if minor {
+		name += "m"
+	}
+	return name
+}
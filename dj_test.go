@@ -0,0 +1,40 @@
+package benten
+
+import "testing"
+
+func TestNormalizeKey(t *testing.T) {
+	for s, want := range map[string]string{
+		"Am":       "Am",
+		"A minor":  "Am",
+		"a min":    "Am",
+		"Bb":       "A#",
+		"Bbm":      "A#m",
+		"F# major": "F#",
+		"8A":       "Am",
+		"8B":       "C",
+		"1a":       "G#m",
+		"12B":      "E",
+		"o":        "o",
+		"Cb":       "B",
+		"H":        "",
+		"13A":      "",
+		"":         "",
+	} {
+		if got := NormalizeKey(s); got != want {
+			t.Errorf("NormalizeKey(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestReadBPMAndKey(t *testing.T) {
+	raw := map[string]interface{}{"TBPM": "127.5", "TKEY": "Ebm"}
+	if got := readBPM(raw); got != 127.5 {
+		t.Errorf("readBPM = %v", got)
+	}
+	if got := readKey(raw); got != "D#m" {
+		t.Errorf("readKey = %q", got)
+	}
+	if readBPM(nil) != 0 || readKey(map[string]interface{}{"initialkey": "?"}) != "" {
+		t.Errorf("unknown tempo or key read")
+	}
+}
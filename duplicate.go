@@ -0,0 +1,108 @@
+package benten
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// DuplicateGroup is a group of pieces which look like the same track.
+type DuplicateGroup struct {
+	// Reason is "hash" when the pieces have the same Hash, and "tags" when
+	// they have the same normalized Title and Artist, and durations within
+	// DuplicateDurationTolerance.
+	Reason string
+	// Keys are the encoded keys of Pieces.
+	Keys   []string
+	Pieces []Metadata
+}
+
+// DuplicateDurationTolerance is how much the durations of the pieces in a tags
+// group may differ, so that live and studio takes are told apart.
+const DuplicateDurationTolerance = 2 * time.Second
+
+// FindDuplicates groups `pieces` stored at `keys` by Hash and by normalized
+// Title, Artist and duration. A tags group whose pieces all share a Hash is
+// left out, as the hash group already reports it. Pieces of unknown duration
+// are grouped only with each other.
+func FindDuplicates(keys []*datastore.Key, pieces []Metadata) []DuplicateGroup {
+	byHash := make(map[string][]int)
+	byTags := make(map[string][]int)
+	for i, piece := range pieces {
+		if piece.Hash != "" {
+			byHash[piece.Hash] = append(byHash[piece.Hash], i)
+		}
+		if title := Normalize(piece.Title); title != "" {
+			tags := title + "\x00" + Normalize(piece.Artist)
+			byTags[tags] = append(byTags[tags], i)
+		}
+	}
+
+	var groups []DuplicateGroup
+	add := func(reason string, indices []int) {
+		group := DuplicateGroup{Reason: reason}
+		for _, i := range indices {
+			group.Keys = append(group.Keys, keys[i].Encode())
+			group.Pieces = append(group.Pieces, pieces[i])
+		}
+		groups = append(groups, group)
+	}
+	for _, indices := range byHash {
+		if len(indices) > 1 {
+			add("hash", indices)
+		}
+	}
+	for _, tagged := range byTags {
+		for _, indices := range byDuration(pieces, tagged) {
+			if len(indices) < 2 {
+				continue
+			}
+			sameHash := pieces[indices[0]].Hash != ""
+			for _, i := range indices {
+				sameHash = sameHash && pieces[i].Hash == pieces[indices[0]].Hash
+			}
+			if !sameHash {
+				add("tags", indices)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reason != groups[j].Reason {
+			return groups[i].Reason < groups[j].Reason
+		}
+		return groups[i].Pieces[0].Path < groups[j].Pieces[0].Path
+	})
+	return groups
+}
+
+// byDuration splits the `indices` of `pieces` into runs whose neighbouring
+// durations differ by at most DuplicateDurationTolerance. The pieces of
+// unknown duration make a run of their own.
+func byDuration(pieces []Metadata, indices []int) [][]int {
+	sorted := append([]int(nil), indices...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return pieces[sorted[i]].Duration < pieces[sorted[j]].Duration
+	})
+	var runs [][]int
+	for n, i := range sorted {
+		if n == 0 {
+			runs = append(runs, nil)
+		} else if previous, d := pieces[sorted[n-1]].Duration, pieces[i].Duration; (previous == 0) != (d == 0) || d-previous > DuplicateDurationTolerance {
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], i)
+	}
+	return runs
+}
+
+// LoadDuplicates finds the duplicates among all the pieces not in the trash;
+// see FindDuplicates.
+func LoadDuplicates(ctx context.Context, client *datastore.Client) ([]DuplicateGroup, error) {
+	keys, pieces, err := loadUntrashed(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return FindDuplicates(keys, pieces), nil
+}
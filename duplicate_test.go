@@ -0,0 +1,42 @@
+package benten
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	pieces := []Metadata{
+		{Title: "Yesterday", Artist: "The Beatles", Hash: "a", Path: "1.mp3"},
+		{Title: "Yesterday", Artist: "The Beatles", Hash: "a", Path: "2.mp3"},
+		{Title: "yesterday ", Artist: "the beatles", Hash: "b", Path: "3.flac"},
+		{Title: "Yesterday", Artist: "Ray Charles", Hash: "c", Path: "4.mp3"},
+		{Title: "Let It Be", Artist: "The Beatles", Hash: "d", Path: "5.mp3", Duration: 243 * time.Second},
+		{Title: "Let It Be", Artist: "The Beatles", Hash: "e", Path: "6.flac", Duration: 244500 * time.Millisecond},
+		{Title: "Let It Be (Live)", Artist: "The Beatles", Hash: "f", Path: "7.mp3", Duration: 243 * time.Second},
+		{Title: "Let It Be", Artist: "The Beatles", Hash: "g", Path: "8.mp3", Duration: 301 * time.Second},
+		{Title: "Let It Be", Artist: "The Beatles", Hash: "h", Path: "9.mp3"},
+	}
+	var keys []*datastore.Key
+	for i := range pieces {
+		keys = append(keys, datastore.IDKey(PieceKind, int64(i+1), nil))
+	}
+
+	groups := FindDuplicates(keys, pieces)
+	if len(groups) != 3 {
+		t.Fatalf("groups = %v", groups)
+	}
+	if groups[0].Reason != "hash" || len(groups[0].Pieces) != 2 {
+		t.Errorf("groups[0] = %v", groups[0])
+	}
+	if groups[1].Reason != "tags" || len(groups[1].Pieces) != 3 {
+		t.Errorf("groups[1] = %v", groups[1])
+	}
+	// The take of 301 seconds and the one of unknown duration are not
+	// duplicates of those around 243 seconds.
+	if groups[2].Reason != "tags" || len(groups[2].Pieces) != 2 || groups[2].Pieces[0].Path != "5.mp3" || groups[2].Pieces[1].Path != "6.flac" {
+		t.Errorf("groups[2] = %v", groups[2])
+	}
+}
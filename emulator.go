@@ -0,0 +1,116 @@
+package benten
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// LocalProjectID is the project used in local mode unless another is given.
+const LocalProjectID = "benten-local"
+
+// Emulators are the endpoints of the emulators replacing the cloud services in
+// local mode. Empty fields take the values of the environment variables the
+// client libraries read, or the default ports of the emulators.
+type Emulators struct {
+	// Datastore is the host of the Datastore emulator (DATASTORE_EMULATOR_HOST,
+	// "localhost:8081" by default).
+	Datastore string
+	// PubSub is the host of the Pub/Sub emulator (PUBSUB_EMULATOR_HOST,
+	// "localhost:8085" by default).
+	PubSub string
+	// Storage is the host of a fake GCS server speaking plain HTTP, such as
+	// `fake-gcs-server -scheme http` (STORAGE_EMULATOR_HOST, "localhost:4443"
+	// by default).
+	Storage string
+}
+
+// UseEmulators points the cloud clients created afterwards at `e`.
+func UseEmulators(e Emulators) {
+	for _, v := range []struct{ name, value, defaultValue string }{
+		{"DATASTORE_EMULATOR_HOST", e.Datastore, "localhost:8081"},
+		{"PUBSUB_EMULATOR_HOST", e.PubSub, "localhost:8085"},
+		{"STORAGE_EMULATOR_HOST", e.Storage, "localhost:4443"},
+	} {
+		if v.value != "" {
+			os.Setenv(v.name, v.value)
+		} else if os.Getenv(v.name) == "" {
+			os.Setenv(v.name, v.defaultValue)
+		}
+	}
+}
+
+// credentialsJSON is the service account key set by UseCredentialsJSON.
+var credentialsJSON []byte
+
+// UseCredentialsJSON makes the cloud clients created afterwards with
+// ClientOptions or StorageOptions authenticate with the service account key
+// `key`. Unlike GOOGLE_APPLICATION_CREDENTIALS, the key is only kept in memory.
+func UseCredentialsJSON(key []byte) {
+	credentialsJSON = key
+}
+
+// ClientOptions returns the options for the clients of the cloud services,
+// which carry the key given to UseCredentialsJSON if any.
+func ClientOptions() []option.ClientOption {
+	if credentialsJSON == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithCredentialsJSON(credentialsJSON)}
+}
+
+// StorageOptions returns the options for storage.NewClient. The client only
+// reads objects from STORAGE_EMULATOR_HOST by itself, so this points the rest
+// of the API at it too.
+func StorageOptions() []option.ClientOption {
+	host := os.Getenv("STORAGE_EMULATOR_HOST")
+	if host == "" {
+		return ClientOptions()
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return []option.ClientOption{option.WithEndpoint(host + "/storage/v1/"), option.WithoutAuthentication()}
+}
+
+// CreateLocalResources creates the buckets and the topics in the emulators
+// unless they exist, so that a fresh set of emulators can be used right away.
+func CreateLocalResources(ctx context.Context, projectID string, topics ...string) error {
+	storageClient, err := storage.NewClient(ctx, StorageOptions()...)
+	if err != nil {
+		return err
+	}
+	defer storageClient.Close()
+	for _, name := range []string{PieceBucket, AlbumPictureBucket} {
+		bucket := storageClient.Bucket(name)
+		if _, err := bucket.Attrs(ctx); err == storage.ErrBucketNotExist {
+			if err := bucket.Create(ctx, projectID, nil); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	pubsubClient, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer pubsubClient.Close()
+	for _, name := range topics {
+		exists, err := pubsubClient.Topic(name).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := pubsubClient.CreateTopic(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package benten
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+func TestCreateLocalResources(t *testing.T) {
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{Scheme: "http", Host: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	for _, name := range []string{"STORAGE_EMULATOR_HOST", "PUBSUB_EMULATOR_HOST"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("STORAGE_EMULATOR_HOST", server.URL())
+	os.Setenv("PUBSUB_EMULATOR_HOST", "localhost:8085")
+	ctx := context.Background()
+
+	if err := CreateLocalResources(ctx, LocalProjectID); err != nil {
+		t.Fatal(err)
+	}
+	// The buckets exist, so this is a no-op.
+	if err := CreateLocalResources(ctx, LocalProjectID); err != nil {
+		t.Fatal(err)
+	}
+	client, err := storage.NewClient(ctx, StorageOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Bucket(PieceBucket).Attrs(ctx); err != nil {
+		t.Errorf("Attrs = %v", err)
+	}
+}
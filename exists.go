@@ -0,0 +1,51 @@
+package benten
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+)
+
+// existsConcurrency is the number of queries FindByHashes runs at once.
+const existsConcurrency = 16
+
+// FindByHashes returns the keys of the pieces in the library, excluding
+// trashed ones, by each of `hashes` (see Metadata.Hash). Hashes no piece has
+// are absent from the result.
+func FindByHashes(ctx context.Context, client *datastore.Client, hashes []string) (map[string][]*datastore.Key, error) {
+	result := make(map[string][]*datastore.Key)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, existsConcurrency)
+	for _, hash := range hashes {
+		hash := hash
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var pieces []Metadata
+			keys, err := client.GetAll(ctx, datastore.NewQuery(PieceKind).Filter("Hash =", hash), &pieces)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for i, piece := range pieces {
+				if !piece.IsTrashed() {
+					result[hash] = append(result[hash], keys[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
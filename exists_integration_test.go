@@ -0,0 +1,27 @@
+package benten_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestFindByHashes(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	wall := testutil.PutPiece(t, client, benten.Metadata{Title: "The Wall", Hash: "h1"})
+	trashed := testutil.PutPiece(t, client, benten.Metadata{Title: "Money", Hash: "h2"})
+	if err := benten.TrashPiece(ctx, client, trashed); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := benten.FindByHashes(ctx, client, []string{"h1", "h2", "h3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || len(found["h1"]) != 1 || !found["h1"][0].Equal(wall) {
+		t.Errorf("found = %v", found)
+	}
+}
@@ -0,0 +1,70 @@
+package benten
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// FeatureFlag enables a feature for everyone or for some users, so that a
+// new subsystem can be tried by one user before the others. It is keyed by
+// FeatureFlagKey(Name). Users are identified like the bandwidth quota, such
+// as "admin" or "session:<id>".
+type FeatureFlag struct {
+	Name string
+	// Enabled is whether the feature is enabled for the users in neither
+	// Users nor Excluded.
+	Enabled bool
+	// Users are the users the feature is enabled for, and Excluded those it
+	// is disabled for. Excluded wins over Users.
+	Users    []string
+	Excluded []string
+	Updated  time.Time
+}
+
+// FeatureFlagKey returns the key of the FeatureFlag named `name`.
+func FeatureFlagKey(name string) *datastore.Key {
+	return datastore.NameKey(FeatureFlagKind, name, nil)
+}
+
+// LoadFeatureFlags loads all the FeatureFlags.
+func LoadFeatureFlags(ctx context.Context, client *datastore.Client) ([]FeatureFlag, error) {
+	var flags []FeatureFlag
+	if _, err := client.GetAll(ctx, datastore.NewQuery(FeatureFlagKind), &flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// EnabledFor returns true if the feature is enabled for `user`.
+func (f *FeatureFlag) EnabledFor(user string) bool {
+	for _, u := range f.Excluded {
+		if u == user {
+			return false
+		}
+	}
+	for _, u := range f.Users {
+		if u == user {
+			return true
+		}
+	}
+	return f.Enabled
+}
+
+// Summary describes the flag for AuditLog.
+func (f FeatureFlag) Summary() string {
+	summary := "disabled"
+	if f.Enabled {
+		summary = "enabled"
+	}
+	if len(f.Users) > 0 {
+		summary += fmt.Sprintf("; enabled for %s", strings.Join(f.Users, ", "))
+	}
+	if len(f.Excluded) > 0 {
+		summary += fmt.Sprintf("; disabled for %s", strings.Join(f.Excluded, ", "))
+	}
+	return summary
+}
@@ -0,0 +1,31 @@
+package benten
+
+import "testing"
+
+func TestFeatureFlagEnabledFor(t *testing.T) {
+	flag := FeatureFlag{Name: "subsonic", Users: []string{"admin", "session:s1"}, Excluded: []string{"session:s1"}}
+	cases := []struct {
+		enabled bool
+		user    string
+		want    bool
+	}{
+		{false, "admin", true},
+		{false, "session:s1", false},
+		{false, "session:s2", false},
+		{true, "session:s1", false},
+		{true, "session:s2", true},
+	}
+	for _, c := range cases {
+		flag.Enabled = c.enabled
+		if got := flag.EnabledFor(c.user); got != c.want {
+			t.Errorf("EnabledFor(%q) with Enabled = %v: %v, want %v", c.user, c.enabled, got, c.want)
+		}
+	}
+}
+
+func TestFeatureFlagSummary(t *testing.T) {
+	flag := FeatureFlag{Name: "subsonic", Users: []string{"admin"}}
+	if got, want := flag.Summary(), "disabled; enabled for admin"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package benten
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Filter restricts searches and browsing to pieces having the given
+// properties. Zero fields don't restrict anything. Genre and AlbumArtist are
+// compared after Normalize, and AlbumArtist by the canonical names of it and
+// Metadata.GroupAlbumArtist in the Aliases, so that an alias finds the albums
+// of its artist and VariousArtists finds compilations.
+type Filter struct {
+	Genre       string
+	AlbumArtist string
+	Year        int
+	// OriginalYear matches Metadata.ReleaseYear.
+	OriginalYear int
+	// MinBPM and MaxBPM bound BPM, inclusive. Pieces of unknown tempo don't
+	// pass either.
+	MinBPM, MaxBPM float64
+	// Key matches Metadata.Key after NormalizeKey.
+	Key string
+	// FileType matches Metadata.FileType, such as "FLAC", ignoring case.
+	FileType string
+	// Lossless passes only the pieces of lossless file types; see
+	// IsLossless.
+	Lossless bool
+	// MinBitrate is the minimum Metadata.Bitrate in kbps. Pieces of unknown
+	// bitrate don't pass.
+	MinBitrate int
+}
+
+// IsEmpty returns true if `f` doesn't restrict anything.
+func (f Filter) IsEmpty() bool {
+	return f == Filter{}
+}
+
+// Matches returns true if `piece` passes `f` with `aliases`. It is for the
+// search backends which can't filter by themselves.
+func (f Filter) Matches(piece *Metadata, aliases Aliases) bool {
+	return (f.Genre == "" || Normalize(f.Genre) == Normalize(piece.Genre)) &&
+		(f.AlbumArtist == "" || Normalize(aliases.Canonical(f.AlbumArtist)) == Normalize(aliases.AlbumArtist(piece))) &&
+		(f.Year == 0 || f.Year == piece.Year) &&
+		(f.OriginalYear == 0 || f.OriginalYear == piece.ReleaseYear()) &&
+		(f.MinBPM == 0 || (piece.BPM != 0 && f.MinBPM <= piece.BPM)) &&
+		(f.MaxBPM == 0 || (piece.BPM != 0 && piece.BPM <= f.MaxBPM)) &&
+		(f.Key == "" || NormalizeKey(f.Key) == piece.Key) &&
+		(f.FileType == "" || strings.EqualFold(f.FileType, piece.FileType)) &&
+		(!f.Lossless || IsLossless(piece.FileType)) &&
+		(f.MinBitrate == 0 || f.MinBitrate <= piece.Bitrate)
+}
+
+// pushDown adds the most selective filter of `f` to a query of
+// PieceIndexKind. The composite indexes in index.yaml cover each of Genre,
+// AlbumArtist, Year and OriginalYear, alone or with a Grams or Tokens lookup,
+// ordered by Value, so that no combination needs an index of its own. The
+// other filters, and BPM, Key and the file quality, which are not indexed,
+// are left to Matches.
+func (f Filter) pushDown(q *datastore.Query, aliases Aliases) *datastore.Query {
+	switch {
+	case f.AlbumArtist != "":
+		return q.Filter("AlbumArtist =", Normalize(aliases.Canonical(f.AlbumArtist)))
+	case f.Year != 0:
+		return q.Filter("Year =", f.Year)
+	case f.OriginalYear != 0:
+		return q.Filter("OriginalYear =", f.OriginalYear)
+	case f.Genre != "":
+		return q.Filter("Genre =", Normalize(f.Genre))
+	}
+	return q
+}
+
+// FilteredSearcher is implemented by SearchIndexes which can filter in the
+// backend.
+type FilteredSearcher interface {
+	// SearchFiltered is Search restricted by `filter`. An empty query
+	// returns the pieces passing `filter`. Backends may give up early on
+	// filters few pieces pass and return fewer than `limit` pieces.
+	SearchFiltered(ctx context.Context, query string, filter Filter, limit int) ([]SearchResult, error)
+}
+
+// filteredPageSize is the number of index entries SearchFiltered and
+// SearchPhonetic read at a time.
+const filteredPageSize = 100
+
+// maxFilteredPages is the number of pages SearchFiltered and SearchPhonetic
+// read at most. A filter few pieces pass, such as an unindexed one without a
+// query, returns the pieces found so far instead of reading the whole index.
+const maxFilteredPages = 20
+
+// SearchFiltered implements FilteredSearcher. One of the filters is executed
+// by the datastore and the others by Matches; see pushDown. The index is read
+// page by page until `limit` pieces pass them, so that filters the datastore
+// can't execute, such as BPM, don't starve the results, or until
+// maxFilteredPages pages are read.
+func (index DatastoreIndex) SearchFiltered(ctx context.Context, query string, filter Filter, limit int) ([]SearchResult, error) {
+	search := Normalize(query)
+	q := datastore.NewQuery(PieceIndexKind)
+	if search != "" {
+		property, term, err := lookupTerm(search)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Filter(property+" =", term)
+	} else if filter.IsEmpty() {
+		return nil, ErrQueryTooShort
+	}
+	q = filter.pushDown(q, index.Aliases).Order("Value").KeysOnly().Limit(filteredPageSize)
+
+	results := make([]SearchResult, 0)
+	for page := 1; ; page++ {
+		t := index.Client.Run(ctx, q)
+		// The index entities are children of their pieces.
+		var keys []*datastore.Key
+		for {
+			key, err := t.Next(nil)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key.Parent)
+		}
+		candidates, err := index.getResults(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			piece := candidate.Metadata
+			if filter.Matches(piece, index.Aliases) && (search == "" || index.matches(piece, search)) {
+				results = append(results, candidate)
+				if len(results) == limit {
+					return results, nil
+				}
+			}
+		}
+		if len(keys) < filteredPageSize || page == maxFilteredPages {
+			return results, nil
+		}
+		cursor, err := t.Cursor()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Start(cursor)
+	}
+}
@@ -0,0 +1,37 @@
+package benten
+
+import (
+	"path"
+	"strings"
+)
+
+// FolderOf returns the PathDir of the file at the slash-separated
+// `relativePath` from the root of the library: its directory, or empty for
+// the files at the root.
+func FolderOf(relativePath string) string {
+	dir := path.Dir(path.Clean("/" + relativePath))
+	return strings.TrimPrefix(dir, "/")
+}
+
+// ChildFolder returns the child of the folder `parent` which is `dir` or one
+// of its ancestors, or false if `dir` is not under `parent`. The root is the
+// empty folder.
+func ChildFolder(parent, dir string) (string, bool) {
+	rest := dir
+	if parent != "" {
+		if !strings.HasPrefix(dir, parent+"/") {
+			return "", false
+		}
+		rest = dir[len(parent)+1:]
+	}
+	if rest == "" {
+		return "", false
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest = rest[:i]
+	}
+	if parent == "" {
+		return rest, true
+	}
+	return parent + "/" + rest, true
+}
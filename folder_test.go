@@ -0,0 +1,33 @@
+package benten
+
+import "testing"
+
+func TestFolderOf(t *testing.T) {
+	for relativePath, want := range map[string]string{
+		"a.mp3":                "",
+		"Artist/Album/01.mp3":  "Artist/Album",
+		"./Artist/../Other/02": "Other",
+	} {
+		if got := FolderOf(relativePath); got != want {
+			t.Errorf("FolderOf(%q) = %q, want %q", relativePath, got, want)
+		}
+	}
+}
+
+func TestChildFolder(t *testing.T) {
+	for _, c := range []struct {
+		parent, dir, want string
+		ok                bool
+	}{
+		{"", "Artist", "Artist", true},
+		{"", "Artist/Album", "Artist", true},
+		{"", "", "", false},
+		{"Artist", "Artist/Album/CD1", "Artist/Album", true},
+		{"Artist", "Artist", "", false},
+		{"Artist", "Artists/Album", "", false},
+	} {
+		if got, ok := ChildFolder(c.parent, c.dir); got != c.want || ok != c.ok {
+			t.Errorf("ChildFolder(%q, %q) = %q, %v", c.parent, c.dir, got, ok)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package benten
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 << 30:         "5.0 GiB",
+		3<<40 + 512<<30: "3.5 TiB",
+	}
+	for n, want := range cases {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
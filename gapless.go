@@ -0,0 +1,203 @@
+package benten
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// Gapless is what players need to cut the silence encoders add around the
+// audio, so that the tracks of live and classical albums play without gaps.
+// All the counts are in samples (per channel), and zero if unknown.
+type Gapless struct {
+	// EncoderDelay is the number of samples to skip at the start. For MP3
+	// it excludes the decoder delay (529 samples for LAME-compatible
+	// decoders).
+	EncoderDelay int
+	// EncoderPadding is the number of samples to drop at the end.
+	EncoderPadding int
+	// TotalSamples is the number of samples of the audio without the delay
+	// and the padding.
+	TotalSamples int64
+}
+
+// ReadGapless reads the encoder delay and padding of the file `r` whose
+// tags are `tags`, from the iTunSMPB tag written by iTunes or, for MP3, the
+// LAME header. It returns the zero value if neither is found. The position
+// of `r` is kept, since tag.Sum reads from it.
+func ReadGapless(r io.ReadSeeker, tags tag.Metadata) (Gapless, error) {
+	for name, value := range tags.Raw() {
+		var text string
+		switch v := value.(type) {
+		case *tag.Comm:
+			// ID3 keeps it in a COMM or TXXX frame.
+			if v.Description == "iTunSMPB" {
+				text = v.Text
+			}
+		case string:
+			if name == "iTunSMPB" {
+				text = v
+			}
+		}
+		if g, ok := parseITunSMPB(text); ok {
+			return g, nil
+		}
+	}
+	if tags.FileType() != tag.MP3 {
+		return Gapless{}, nil
+	}
+	position, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Gapless{}, err
+	}
+	g, err := readLAMEHeader(r)
+	if _, seekErr := r.Seek(position, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	return g, err
+}
+
+// parseITunSMPB parses an iTunSMPB value such as
+// " 00000000 00000840 000001CA 00000000001CDF76 00000000 ...", whose second to
+// fourth fields are the delay, the padding and the total samples in hex.
+func parseITunSMPB(s string) (Gapless, bool) {
+	fields := strings.Fields(strings.Trim(s, "\x00"))
+	if len(fields) < 4 {
+		return Gapless{}, false
+	}
+	delay, err1 := strconv.ParseInt(fields[1], 16, 32)
+	padding, err2 := strconv.ParseInt(fields[2], 16, 32)
+	total, err3 := strconv.ParseInt(fields[3], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return Gapless{}, false
+	}
+	return Gapless{EncoderDelay: int(delay), EncoderPadding: int(padding), TotalSamples: total}, true
+}
+
+// The number of bytes searched for the first MPEG frame after the ID3 tag.
+const mpegFrameSearchSize = 8192
+
+// firstMPEGFrame returns the bytes of the MP3 `r` from its first frame after
+// the ID3 tag, up to mpegFrameSearchSize, or nil if no frame is found. It also
+// returns the offset of the frame.
+func firstMPEGFrame(r io.ReadSeeker) ([]byte, int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, nil
+	}
+	start := int64(0)
+	if string(header[:3]) == "ID3" {
+		// The size is syncsafe: 7 bits per byte.
+		size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		start = 10 + size
+		if header[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	b := make([]byte, mpegFrameSearchSize)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, 0, nil
+	}
+	b = b[:n]
+	for i := 0; i+4 <= len(b); i++ {
+		if b[i] == 0xff && b[i+1]&0xe0 == 0xe0 {
+			return b[i:], start + int64(i), nil
+		}
+	}
+	return nil, 0, nil
+}
+
+// HasXingHeader returns true if the MPEG frame `frame` carries a Xing/Info
+// header instead of audio.
+func HasXingHeader(frame []byte) bool {
+	xing, _ := xingHeader(frame)
+	return xing != nil
+}
+
+// xingHeader returns the Xing/Info header in the MPEG frame `b`, or nil. It
+// also returns the number of samples per frame.
+func xingHeader(b []byte) ([]byte, int64) {
+	mpeg1 := (b[1]>>3)&3 == 3
+	mono := b[3]>>6 == 3
+	// The Xing header follows the side information, whose size depends on
+	// the version and the channels.
+	sideInfo := 17
+	samplesPerFrame := int64(576)
+	if mpeg1 {
+		samplesPerFrame = 1152
+		if !mono {
+			sideInfo = 32
+		}
+	} else if mono {
+		sideInfo = 9
+	}
+	if 4+sideInfo > len(b) {
+		return nil, samplesPerFrame
+	}
+	xing := b[4+sideInfo:]
+	if len(xing) < 8 || (!bytes.HasPrefix(xing, []byte("Xing")) && !bytes.HasPrefix(xing, []byte("Info"))) {
+		return nil, samplesPerFrame
+	}
+	return xing, samplesPerFrame
+}
+
+// readLAMEHeader reads the Xing/Info header in the first frame of an MP3
+// and the LAME extension following it.
+func readLAMEHeader(r io.ReadSeeker) (Gapless, error) {
+	b, _, err := firstMPEGFrame(r)
+	if b == nil {
+		return Gapless{}, err
+	}
+	xing, samplesPerFrame := xingHeader(b)
+	if xing == nil {
+		return Gapless{}, nil
+	}
+	flags := binary.BigEndian.Uint32(xing[4:8])
+	offset := 8
+	frames := int64(0)
+	if flags&1 != 0 {
+		if len(xing) < offset+4 {
+			return Gapless{}, nil
+		}
+		frames = int64(binary.BigEndian.Uint32(xing[offset:]))
+		offset += 4
+	}
+	if flags&2 != 0 {
+		offset += 4 // bytes
+	}
+	if flags&4 != 0 {
+		offset += 100 // TOC
+	}
+	if flags&8 != 0 {
+		offset += 4 // quality
+	}
+	// The LAME extension: the encoder (9 bytes), the revision, the lowpass,
+	// the replay gain (8 bytes), the flags and the bitrate precede the delay
+	// and the padding, 12 bits each.
+	if len(xing) < offset+24 {
+		return Gapless{}, nil
+	}
+	lame := xing[offset:]
+	if !(bytes.HasPrefix(lame, []byte("LAME")) || bytes.HasPrefix(lame, []byte("Lavc")) || bytes.HasPrefix(lame, []byte("Lavf"))) {
+		return Gapless{}, nil
+	}
+	g := Gapless{
+		EncoderDelay:   int(lame[21])<<4 | int(lame[22])>>4,
+		EncoderPadding: int(lame[22]&0x0f)<<8 | int(lame[23]),
+	}
+	if frames > 0 {
+		g.TotalSamples = frames*samplesPerFrame - int64(g.EncoderDelay) - int64(g.EncoderPadding)
+	}
+	return g, nil
+}
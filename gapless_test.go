@@ -0,0 +1,42 @@
+package benten
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseITunSMPB(t *testing.T) {
+	g, ok := parseITunSMPB("\x00\x00\x00\x00 00000000 00000840 000001CA 00000000001CDF76 00000000 00000000")
+	if want := (Gapless{EncoderDelay: 0x840, EncoderPadding: 0x1ca, TotalSamples: 0x1cdf76}); !ok || g != want {
+		t.Errorf("parseITunSMPB = %+v, %v, want %+v", g, ok, want)
+	}
+	if _, ok := parseITunSMPB("garbage"); ok {
+		t.Errorf("parseITunSMPB(garbage) succeeded")
+	}
+}
+
+func TestReadLAMEHeader(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("ID3\x03\x00\x00\x00\x00\x00\x00")
+	// An MPEG-1 Layer III joint stereo frame, whose side information is 32 bytes.
+	b.Write([]byte{0xff, 0xfb, 0x90, 0x64})
+	b.Write(make([]byte, 32))
+	b.WriteString("Info\x00\x00\x00\x01")
+	b.Write([]byte{0, 0, 0, 100})
+	b.WriteString("LAME3.100")
+	b.Write(make([]byte, 12))
+	// A delay of 576 and a padding of 1000.
+	b.Write([]byte{0x24, 0x03, 0xe8})
+	b.Write(make([]byte, 100))
+
+	g, err := readLAMEHeader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Gapless{EncoderDelay: 576, EncoderPadding: 1000, TotalSamples: 100*1152 - 1576}); g != want {
+		t.Errorf("readLAMEHeader = %+v, want %+v", g, want)
+	}
+	if g, err := readLAMEHeader(bytes.NewReader([]byte("not an mp3"))); g != (Gapless{}) || err != nil {
+		t.Errorf("readLAMEHeader = %+v, %v for a non-MP3", g, err)
+	}
+}
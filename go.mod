@@ -3,12 +3,20 @@ module github.com/yutakahirano/benten
 go 1.14
 
 require (
+	cloud.google.com/go v0.57.0
 	cloud.google.com/go/datastore v1.1.0
 	cloud.google.com/go/pubsub v1.3.1
 	cloud.google.com/go/storage v1.9.0
+	github.com/blevesearch/bleve v1.0.14
 	github.com/dhowden/tag v0.0.0-20200412032933-5d76b8eaae27
 	github.com/fsnotify/fsnotify v1.4.9
+	github.com/fsouza/fake-gcs-server v1.19.4
+	github.com/mattn/go-sqlite3 v1.14.6
+	golang.org/x/net v0.0.0-20200520182314-0ba52f642ac2
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	golang.org/x/sys v0.0.0-20200523222454-059865788121
 	golang.org/x/text v0.3.4
 	google.golang.org/api v0.26.0
-
+	google.golang.org/grpc v1.29.1
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,60 @@
+package benten
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Play is a play of a piece, keyed by PlayKey. The plays of a piece are its
+// descendants, so they can be counted with an ancestor query.
+type Play struct {
+	// Time is when the piece was played.
+	Time time.Time
+	// Source is where the play was recorded, such as "listenbrainz".
+	Source string
+}
+
+// PlayKey returns the key of the play of `piece` at `t`. Plays of the same
+// piece at the same second share a key, which makes importing idempotent.
+func PlayKey(piece *datastore.Key, t time.Time) *datastore.Key {
+	return datastore.NameKey(PlayKind, strconv.FormatInt(t.Unix(), 10), piece)
+}
+
+// RecordPlays puts the plays of the pieces at `pieces`, and updates the
+// PlayCount and LastPlayed of their ratings.
+func RecordPlays(ctx context.Context, client *datastore.Client, pieces []*datastore.Key, plays []Play) error {
+	touched := make(map[string]*datastore.Key)
+	for i := 0; i < len(plays); i += 500 {
+		end := i + 500
+		if end > len(plays) {
+			end = len(plays)
+		}
+		keys := make([]*datastore.Key, 0, end-i)
+		for j := i; j < end; j++ {
+			keys = append(keys, PlayKey(pieces[j], plays[j].Time))
+			touched[pieces[j].Encode()] = pieces[j]
+		}
+		if _, err := client.PutMulti(ctx, keys, plays[i:end]); err != nil {
+			return err
+		}
+	}
+
+	var keys []*datastore.Key
+	var ratings []Rating
+	for _, piece := range touched {
+		query := datastore.NewQuery(PlayKind).Ancestor(piece).Order("-Time")
+		var history []Play
+		if _, err := client.GetAll(ctx, query, &history); err != nil {
+			return err
+		}
+		if len(history) == 0 {
+			continue
+		}
+		keys = append(keys, piece)
+		ratings = append(ratings, Rating{PlayCount: len(history), LastPlayed: history[0].Time})
+	}
+	return MergeRatings(ctx, client, keys, ratings)
+}
@@ -0,0 +1,106 @@
+package benten
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// IdempotencyRetention is how long the responses of requests with an
+// Idempotency-Key are kept for their retries; see PurgeIdempotentRequests.
+var IdempotencyRetention = 24 * time.Hour
+
+// idempotencyPendingTimeout is how long a request may be handled before its
+// retries take it over, as the instance handling it may be gone.
+const idempotencyPendingTimeout = 5 * time.Minute
+
+// ErrIdempotencyKeyReused is returned by BeginIdempotentRequest when the key
+// was used for another request.
+var ErrIdempotencyKeyReused = errors.New("the idempotency key was used for another request")
+
+// ErrIdempotentRequestInProgress is returned by BeginIdempotentRequest while
+// the first request with the key is being handled.
+var ErrIdempotentRequestInProgress = errors.New("a request with the idempotency key is in progress")
+
+// IdempotentRequest is a request with an Idempotency-Key and its response,
+// keyed by IdempotencyKey.
+type IdempotentRequest struct {
+	// Fingerprint is a hash of the request, which its retries must match.
+	Fingerprint string `datastore:",noindex"`
+	// Status is the status of the response, or zero while the request is
+	// being handled.
+	Status      int    `datastore:",noindex"`
+	ContentType string `datastore:",noindex"`
+	Body        []byte `datastore:",noindex"`
+	Created     time.Time
+}
+
+// IdempotencyKey returns the key of the IdempotentRequest of `key` sent by
+// `identity`, so that clients don't share keys.
+func IdempotencyKey(identity, key string) *datastore.Key {
+	sum := sha256.Sum256([]byte(identity + "\x00" + key))
+	return datastore.NameKey(IdempotencyKind, hex.EncodeToString(sum[:]), nil)
+}
+
+// BeginIdempotentRequest returns the finished request at `key` to replay, or
+// stores a pending one with `fingerprint` and returns nil if there is none
+// within IdempotencyRetention. The caller then handles the request and calls
+// FinishIdempotentRequest or CancelIdempotentRequest.
+func BeginIdempotentRequest(ctx context.Context, client *datastore.Client, key *datastore.Key, fingerprint string) (*IdempotentRequest, error) {
+	var request IdempotentRequest
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		err := tx.Get(key, &request)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		now := time.Now()
+		if err == nil && now.Sub(request.Created) < IdempotencyRetention {
+			if request.Fingerprint != fingerprint {
+				return ErrIdempotencyKeyReused
+			}
+			if request.Status != 0 {
+				return nil
+			}
+			if now.Sub(request.Created) < idempotencyPendingTimeout {
+				return ErrIdempotentRequestInProgress
+			}
+		}
+		request = IdempotentRequest{Fingerprint: fingerprint, Created: now}
+		_, err = tx.Put(key, &request)
+		return err
+	})
+	if err != nil || request.Status == 0 {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// FinishIdempotentRequest stores the response of the request at `key`.
+func FinishIdempotentRequest(ctx context.Context, client *datastore.Client, key *datastore.Key, fingerprint string, status int, contentType string, body []byte) error {
+	request := IdempotentRequest{Fingerprint: fingerprint, Status: status, ContentType: contentType, Body: body, Created: time.Now()}
+	_, err := client.Put(ctx, key, &request)
+	return err
+}
+
+// CancelIdempotentRequest deletes the request at `key`, so that its retries
+// are handled again.
+func CancelIdempotentRequest(ctx context.Context, client *datastore.Client, key *datastore.Key) error {
+	return client.Delete(ctx, key)
+}
+
+// PurgeIdempotentRequests deletes up to `limit` IdempotentRequests created
+// before `before`, and returns how many it deleted.
+func PurgeIdempotentRequests(ctx context.Context, client *datastore.Client, before time.Time, limit int) (int, error) {
+	keys, err := client.GetAll(ctx, datastore.NewQuery(IdempotencyKind).Filter("Created <", before).KeysOnly().Limit(limit), nil)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
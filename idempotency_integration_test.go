@@ -0,0 +1,42 @@
+package benten_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestIdempotentRequest(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	key := benten.IdempotencyKey("session:s0", "k0")
+
+	if previous, err := benten.BeginIdempotentRequest(ctx, client, key, "post-a"); err != nil || previous != nil {
+		t.Fatalf("first request: %v, %v", previous, err)
+	}
+	if _, err := benten.BeginIdempotentRequest(ctx, client, key, "post-a"); err != benten.ErrIdempotentRequestInProgress {
+		t.Errorf("retry in progress: %v", err)
+	}
+	if err := benten.FinishIdempotentRequest(ctx, client, key, "post-a", 201, "application/json", []byte(`{"ID":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := benten.BeginIdempotentRequest(ctx, client, key, "post-a")
+	if err != nil || previous == nil || previous.Status != 201 || string(previous.Body) != `{"ID":1}` {
+		t.Errorf("retry: %v, %v", previous, err)
+	}
+	if _, err := benten.BeginIdempotentRequest(ctx, client, key, "post-b"); err != benten.ErrIdempotencyKeyReused {
+		t.Errorf("another request: %v", err)
+	}
+	if other := benten.IdempotencyKey("session:s1", "k0"); other.Equal(key) {
+		t.Errorf("the key is shared by clients")
+	}
+
+	if err := benten.CancelIdempotentRequest(ctx, client, key); err != nil {
+		t.Fatal(err)
+	}
+	if previous, err := benten.BeginIdempotentRequest(ctx, client, key, "post-b"); err != nil || previous != nil {
+		t.Errorf("after cancel: %v, %v", previous, err)
+	}
+}
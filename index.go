@@ -1,36 +1,326 @@
 package benten
 
 import (
+	"bytes"
+	"context"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"cloud.google.com/go/datastore"
 	"golang.org/x/text/unicode/norm"
 )
 
-// Normalize normalizes given the string and returns it.
+// normalizeReplacer strips the combining marks left by NFKD and spells out
+// the ligatures. Building it is costly, so it is shared; Replacers are safe
+// for concurrent use.
+var normalizeReplacer = strings.NewReplacer(
+	"\u0301", "", // Combining Acute Accent
+	"\u0307", "", // Combining Dot Above
+	"\u0309", "", // Combining Hook Above
+	"\u0300", "", // Combining Grave Accent
+	"\u0302", "", // Combining Circumflex Accent
+	"\u0303", "", // Combining Tilde
+	"\u0304", "", // Combining Macron
+	"\u0306", "", // Combining Breve
+	"\u0308", "", // Combining Diaeresis
+	"\u030a", "", // Combining Ring Above
+	"\u030b", "", // Combining Double Acute Accent
+	"\u030c", "", // Combining Caron
+	"\u031b", "", // Combining Horn
+	"\u0323", "", // Combining Dot Below
+	"\u0326", "", // Combining Comma Below
+	"\u0327", "", // Combining Cedilla
+	"\u0328", "", // Combining Ogonek
+
+	"\u00e6", "ae",
+	"\u0133", "ij",
+	"\u0153", "oe",
+	"\u00df", "ss",
+)
+
+// Normalize normalizes given the string and returns it. Diacritics are
+// stripped, apostrophes and periods are removed so that "R.E.M." matches
+// "REM", other punctuation becomes a space, and runs of spaces collapse into
+// one. Changing it requires bumping IndexVersion.
 func Normalize(s string) string {
-	r := strings.NewReplacer(
-		"\u0301", "", // Combining Acute Accent
-		"\u0307", "", // Combining Dot Above
-		"\u0309", "", // Combining Hook Above
-		"\u0300", "", // Combining Grave Accent
-		"\u0302", "", // Combining Circumflex Accent
-		"\u0303", "", // Combining Tilde
-		"\u0304", "", // Combining Macron
-		"\u0306", "", // Combining Breve
-		"\u0308", "", // Combining Diaeresis
-		"\u030a", "", // Combining Ring Above
-		"\u030b", "", // Combining Double Acute Accent
-		"\u030c", "", // Combining Caron
-		"\u031b", "", // Combining Horn
-		"\u0323", "", // Combining Dot Below
-		"\u0326", "", // Combining Comma Below
-		"\u0327", "", // Combining Cedilla
-		"\u0328", "", // Combining Ogonek
-
-		"\u00e6", "ae",
-		"\u0133", "ij",
-		"\u0153", "oe",
-		"\u00df", "ss",
-	)
-	return r.Replace(strings.ToLower(norm.NFKD.String(s)))
+	return collapseSpaces(strings.Map(replacePunctuation, normalizeReplacer.Replace(strings.ToLower(norm.NFKD.String(s)))))
+}
+
+func replacePunctuation(r rune) rune {
+	switch {
+	case r == '\'' || r == '\u2019' || r == '.':
+		return -1
+	case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+		return ' '
+	}
+	return r
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func generateWordsForIndexInternal(text string, words *map[string]struct{}) {
+	if len(text) < GramSizeForAscii {
+		return
+	}
+	for i := 0; i <= len(text)-GramSizeForAscii; i++ {
+		isASCII := true
+		for j := 0; j <= GramSizeForNonAscii; j++ {
+			if (j == GramSizeForAscii && isASCII) ||
+				j == GramSizeForNonAscii {
+				(*words)[text[i:i+j]] = struct{}{}
+				break
+			}
+			if i+j == len(text) {
+				break
+			}
+			isASCII = isASCII && text[i+j] <= unicode.MaxASCII
+		}
+	}
+}
+
+func generateWordsForIndex(text string, words *map[string]struct{}) {
+	generateWordsForIndexInternal(truncateIndexed(Normalize(text)), words)
+}
+
+// maxIndexedBytes caps the bytes of each normalized text which are indexed,
+// and so the grams and tokens it yields, so that a long text such as lyrics
+// pasted into a title doesn't take up maxIndexedTerms by itself.
+const maxIndexedBytes = 1000
+
+// maxIndexEntries is the number of index entries the datastore allows per
+// entity. A PieceIndex exceeding it can't be stored at all.
+const maxIndexEntries = 20000
+
+// Each gram and token of a PieceIndex costs entriesPerTerm index entries: the
+// built-in ascending and descending ones, and one in each composite index of
+// index.yaml with Grams or Tokens, which are with Value and with each of
+// Genre, AlbumArtist, Year and OriginalYear. Each phonetic key costs the
+// built-in ones and the one with Value.
+const (
+	entriesPerTerm         = 7
+	entriesPerPhoneticCode = 3
+)
+
+// maxPhoneticCodes and maxIndexedTerms cap the phonetic keys and the grams
+// and tokens together of a PieceIndex within maxIndexEntries. The
+// reservedIndexEntries are for the single-valued properties.
+const (
+	reservedIndexEntries = 100
+	maxPhoneticCodes     = 300
+	maxIndexedTerms      = (maxIndexEntries - reservedIndexEntries - maxPhoneticCodes*entriesPerPhoneticCode) / entriesPerTerm
+)
+
+// truncateIndexed returns the first maxIndexedBytes of `text`, cut at a rune
+// boundary.
+func truncateIndexed(text string) string {
+	if len(text) <= maxIndexedBytes {
+		return text
+	}
+	n := maxIndexedBytes
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+// isStopGram returns true if `gram` is a stopword with spaces around it, such
+// as "the " or " of ". Such grams appear in too many pieces to be useful.
+func isStopGram(gram string) bool {
+	trimmed := strings.Trim(gram, " ")
+	if len(trimmed) == len(gram) {
+		return false
+	}
+	_, ok := Stopwords[trimmed]
+	return ok
+}
+
+// tokenize splits normalized `text` into words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexedTexts returns the texts in `metadata` to index.
+func indexedTexts(metadata *Metadata) []string {
+	return []string{metadata.Title, metadata.Album, metadata.Artist, metadata.AlbumArtist, metadata.Composer}
+}
+
+// Grams returns the grams to index for `metadata`.
+func Grams(metadata *Metadata) map[string]struct{} {
+	return gramsOf(indexedTexts(metadata)...)
+}
+
+func gramsOf(texts ...string) map[string]struct{} {
+	words := make(map[string]struct{})
+	for _, text := range texts {
+		generateWordsForIndex(strings.ToLower(text), &words)
+	}
+	for word := range words {
+		if isStopGram(word) {
+			delete(words, word)
+		}
+	}
+	return words
+}
+
+// Tokens returns the words to index for `metadata`, excluding stopwords.
+// They let queries shorter than a gram, such as "u2", find whole words.
+func Tokens(metadata *Metadata) map[string]struct{} {
+	return tokensOf(indexedTexts(metadata)...)
+}
+
+func tokensOf(texts ...string) map[string]struct{} {
+	tokens := make(map[string]struct{})
+	for _, text := range texts {
+		for _, token := range tokenize(truncateIndexed(Normalize(text))) {
+			if _, ok := Stopwords[token]; !ok {
+				tokens[token] = struct{}{}
+			}
+		}
+	}
+	return tokens
+}
+
+// indexedTerms returns the grams and the tokens of `texts`, up to
+// maxIndexedTerms of them together. The texts are taken in order, the tokens
+// of each before its grams, so that the first texts stay searchable however
+// long the others are. The terms of each text are taken in sorted order, so
+// that the same texts always give the same terms.
+func indexedTerms(texts []string) (map[string]struct{}, map[string]struct{}) {
+	grams := make(map[string]struct{})
+	tokens := make(map[string]struct{})
+	for _, text := range texts {
+		for _, terms := range []struct{ found, indexed map[string]struct{} }{
+			{tokensOf(text), tokens},
+			{gramsOf(text), grams},
+		} {
+			for _, term := range sortedStrings(terms.found) {
+				if _, ok := terms.indexed[term]; ok {
+					continue
+				}
+				if len(grams)+len(tokens) == maxIndexedTerms {
+					return grams, tokens
+				}
+				terms.indexed[term] = struct{}{}
+			}
+		}
+	}
+	return grams, tokens
+}
+
+func sortedStrings(set map[string]struct{}) []string {
+	sorted := make([]string, 0, len(set))
+	for s := range set {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func sortedBytes(set map[string]struct{}) [][]byte {
+	sorted := sortedStrings(set)
+	result := make([][]byte, 0, len(sorted))
+	for _, s := range sorted {
+		result = append(result, []byte(s))
+	}
+	return result
+}
+
+// IndexKey returns the key of the index entity for the piece stored at `piece`.
+// Each piece has exactly one index entity, a child of the piece entity.
+func IndexKey(piece *datastore.Key) *datastore.Key {
+	return datastore.IDKey(PieceIndexKind, 1, piece)
+}
+
+// NewPieceIndex creates the index entity for `metadata` stored at `key`.
+// The names equivalent to Artist and AlbumArtist in `aliases` are indexed as
+// well. The grams and tokens are sorted so that identical indexes compare equal,
+// and capped so that the entity stays within maxIndexEntries; see
+// indexedTerms.
+func NewPieceIndex(metadata *Metadata, key *datastore.Key, aliases Aliases) *PieceIndex {
+	artists := append([]string{metadata.Artist, metadata.AlbumArtist},
+		aliases.Equivalents(metadata.Artist, metadata.AlbumArtist)...)
+	grams, tokens := indexedTerms(append(indexedTexts(metadata), artists...))
+	phonetic := sortedBytes(PhoneticCodes(artists...))
+	if len(phonetic) > maxPhoneticCodes {
+		phonetic = phonetic[:maxPhoneticCodes]
+	}
+	return &PieceIndex{
+		Grams:    sortedBytes(grams),
+		Tokens:   sortedBytes(tokens),
+		Phonetic: phonetic,
+		Version:  IndexVersion,
+		Value:    key,
+
+		ConfigRevision: indexConfigRevision,
+		Genre:          Normalize(metadata.Genre),
+		AlbumArtist:    Normalize(aliases.AlbumArtist(metadata)),
+		Year:           metadata.Year,
+		OriginalYear:   metadata.ReleaseYear(),
+	}
+}
+
+func sameGrams(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanIndex puts the index entity for the piece stored at `key`.
+func SpanIndex(ctx context.Context, client *datastore.Client, metadata *Metadata, key *datastore.Key, aliases Aliases) error {
+	_, err := client.Put(ctx, IndexKey(key), NewPieceIndex(metadata, key, aliases))
+	return err
+}
+
+// RespanIndex updates the index entity of the piece stored at `key` so that it
+// matches `metadata`. Nothing is written when the index hasn't changed.
+func RespanIndex(ctx context.Context, client *datastore.Client, metadata *Metadata, key *datastore.Key, aliases Aliases) error {
+	index := NewPieceIndex(metadata, key, aliases)
+	var existing PieceIndex
+	err := client.Get(ctx, IndexKey(key), &existing)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	if err == nil && sameIndex(&existing, index) {
+		return nil
+	}
+	_, err = client.Put(ctx, IndexKey(key), index)
+	return err
+}
+
+// RespanIndexInTransaction is RespanIndex in `tx`, so that the index is
+// written atomically with the piece, whose key must be complete.
+func RespanIndexInTransaction(tx *datastore.Transaction, metadata *Metadata, key *datastore.Key, aliases Aliases) error {
+	index := NewPieceIndex(metadata, key, aliases)
+	var existing PieceIndex
+	err := tx.Get(IndexKey(key), &existing)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	if err == nil && sameIndex(&existing, index) {
+		return nil
+	}
+	_, err = tx.Put(IndexKey(key), index)
+	return err
+}
+
+// sameIndex returns true if `existing` is identical to `index` built now.
+func sameIndex(existing, index *PieceIndex) bool {
+	return existing.Version == IndexVersion && existing.ConfigRevision == indexConfigRevision &&
+		existing.Value.Equal(index.Value) && existing.Genre == index.Genre &&
+		existing.AlbumArtist == index.AlbumArtist && existing.Year == index.Year &&
+		existing.OriginalYear == index.OriginalYear &&
+		sameGrams(existing.Grams, index.Grams) && sameGrams(existing.Tokens, index.Tokens) &&
+		sameGrams(existing.Phonetic, index.Phonetic)
 }
@@ -0,0 +1,73 @@
+//go:build go1.18
+// +build go1.18
+
+package benten
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// fuzzSeeds are tag values seen in the wild, and the pathological ones.
+var fuzzSeeds = []string{
+	"Don’t Stop",
+	"R.E.M.",
+	"Sigur Rós",
+	"ヨルシカ",
+	"Mötley Crüe",
+	"\xff\xfe\x00abc",
+	"a" + strings.Repeat("́", 100),
+	strings.Repeat("̣̈", 50),
+	"ﷺ",
+	"İstanbul",
+	strings.Repeat("Never Gonna Give You Up ", 100),
+	strings.Repeat("夜に駆ける", 300),
+}
+
+func FuzzNormalize(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		normalized := Normalize(s)
+		if !utf8.ValidString(normalized) {
+			t.Fatalf("Normalize(%q) = %q is invalid UTF-8", s, normalized)
+		}
+		if normalized != collapseSpaces(normalized) {
+			t.Fatalf("Normalize(%q) = %q has extra spaces", s, normalized)
+		}
+		if again := Normalize(normalized); again != normalized {
+			t.Fatalf("Normalize(%q) = %q, but Normalize(%q) = %q", s, normalized, normalized, again)
+		}
+	})
+}
+
+func FuzzGrams(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		normalized := truncateIndexed(Normalize(strings.ToLower(s)))
+		grams := gramsOf(s)
+		if len(grams) > len(normalized) || len(grams) > maxIndexedBytes {
+			t.Fatalf("%d grams of %d bytes", len(grams), len(normalized))
+		}
+		if tokens := tokensOf(s); len(tokens) > maxIndexedBytes {
+			t.Fatalf("%d tokens", len(tokens))
+		}
+		for gram := range grams {
+			if len(gram) != GramSizeForAscii && len(gram) != GramSizeForNonAscii {
+				t.Fatalf("gram %q of %q has %d bytes", gram, s, len(gram))
+			}
+			if !strings.Contains(normalized, gram) {
+				t.Fatalf("gram %q is not in %q", gram, normalized)
+			}
+		}
+		for i := 0; ; i++ {
+			if _, ok := gramAt(normalized, i); !ok {
+				break
+			}
+		}
+	})
+}
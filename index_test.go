@@ -0,0 +1,239 @@
+package benten
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGenerateWordsForIndexASCII(t *testing.T) {
+	words := make(map[string]struct{})
+	text := "abcdehdiaba"
+	generateWordsForIndex(text, &words)
+    patterns := [...]string {
+		"abcd",
+		"bcde",
+		"cdeh",
+		"dehd",
+		"ehdi",
+		"hdia",
+		"diab",
+		"iaba",
+	}
+
+	for _, s := range patterns {
+		if _, ok := words[s]; !ok {
+			fmt.Println(s)
+			t.Fail()
+		}
+	}
+	if _, ok := words["abc"]; ok {
+		t.Fail()
+	}
+	if _, ok := words["abcde"]; ok {
+		t.Fail()
+	}
+	if _, ok := words["aba"]; ok {
+		t.Fail()
+	}
+	if _, ok := words["ba"]; ok {
+		t.Fail()
+	}
+	if _, ok := words["a"]; ok {
+		t.Fail()
+	}
+}
+
+func TestGenerateWordsForIndexNonASCII(t *testing.T) {
+	words := make(map[string]struct{})
+	text := "日本語"
+	generateWordsForIndex(text, &words)
+	for i := 0; i <= 3; i++ {
+		if _, ok := words[text[i:i+6]]; !ok {
+			t.Fail()
+		}
+	}
+}
+
+func TestGenerateWordsForIndexWithNormalization(t *testing.T) {
+	words := make(map[string]struct{})
+	text := norm.NFC.String("ÁÅÇÈñöûÆĲŒß")
+	patterns := [...]string {
+		"aace",
+		"acen",
+		"ceno",
+		"enou",
+		"noua",
+		"ouae",
+		"uaei",
+		"aeij",
+		"eijo",
+		"ijoe",
+		"joes",
+		"oess",
+	}
+	generateWordsForIndex(text, &words)
+
+	for _, s := range patterns {
+		if _, ok := words[s]; !ok {
+			fmt.Println(s)
+			t.Fail()
+		}
+	}
+}
+
+
+func TestNewPieceIndex(t *testing.T) {
+	metadata := Metadata{Title: "abcde", Artist: "abcd"}
+	index := NewPieceIndex(&metadata, nil, nil)
+	if len(index.Grams) != 2 || string(index.Grams[0]) != "abcd" || string(index.Grams[1]) != "bcde" {
+		t.Errorf("grams = %q", index.Grams)
+	}
+
+	same := Metadata{Title: "bcde", Album: "abcd"}
+	if !sameGrams(index.Grams, NewPieceIndex(&same, nil, nil).Grams) {
+		t.Errorf("grams must be the same")
+	}
+	different := Metadata{Title: "abcdef"}
+	if sameGrams(index.Grams, NewPieceIndex(&different, nil, nil).Grams) {
+		t.Errorf("grams must be different")
+	}
+}
+
+func TestTruncateIndexed(t *testing.T) {
+	long := strings.Repeat("夜", maxIndexedBytes)
+	truncated := truncateIndexed(long)
+	if len(truncated) > maxIndexedBytes || !utf8.ValidString(truncated) || !strings.HasPrefix(long, truncated) {
+		t.Errorf("truncateIndexed() = %d bytes, valid = %v", len(truncated), utf8.ValidString(truncated))
+	}
+	if truncateIndexed("abc") != "abc" {
+		t.Errorf("short texts must be kept")
+	}
+	var numbers strings.Builder
+	for i := 0; numbers.Len() < 10*maxIndexedBytes; i++ {
+		fmt.Fprintf(&numbers, "%d ", i)
+	}
+	index := NewPieceIndex(&Metadata{Title: numbers.String()}, nil, nil)
+	if len(index.Grams) == 0 || len(index.Grams) > maxIndexedBytes {
+		t.Errorf("%d grams", len(index.Grams))
+	}
+}
+
+// randomWords returns words of random letters, `n` bytes in total.
+func randomWords(r *rand.Rand, n int) string {
+	var b strings.Builder
+	for b.Len() < n {
+		for i := 3 + r.Intn(6); i > 0; i-- {
+			b.WriteByte(byte('a' + r.Intn(26)))
+		}
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func TestPieceIndexEntries(t *testing.T) {
+	data, err := ioutil.ReadFile("index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Indexes []struct {
+			Kind       string
+			Properties []struct{ Name string }
+		}
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every text is long and made of distinct words, and the artist has many
+	// long aliases.
+	r := rand.New(rand.NewSource(1))
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, randomWords(r, 2*maxIndexedBytes))
+	}
+	metadata := &Metadata{
+		Title:       randomWords(r, 2*maxIndexedBytes),
+		Album:       randomWords(r, 2*maxIndexedBytes),
+		Artist:      randomWords(r, 2*maxIndexedBytes),
+		AlbumArtist: randomWords(r, 2*maxIndexedBytes),
+		Composer:    randomWords(r, 2*maxIndexedBytes),
+		Genre:       "Rock",
+		Year:        2000,
+	}
+	aliases := NewAliases([]*ArtistAlias{NewArtistAlias(metadata.Artist, names)})
+	index := NewPieceIndex(metadata, nil, aliases)
+	if len(index.Grams)+len(index.Tokens) != maxIndexedTerms {
+		t.Errorf("%d grams and %d tokens, want the cap reached", len(index.Grams), len(index.Tokens))
+	}
+
+	values := map[string]int{
+		"Grams":    len(index.Grams),
+		"Tokens":   len(index.Tokens),
+		"Phonetic": len(index.Phonetic),
+	}
+	for _, name := range []string{"Version", "ConfigRevision", "Genre", "AlbumArtist", "Year", "OriginalYear", "Value"} {
+		values[name] = 1
+	}
+	// The built-in indexes are ascending and descending.
+	entries := 0
+	for _, n := range values {
+		entries += 2 * n
+	}
+	for _, definition := range file.Indexes {
+		if definition.Kind != PieceIndexKind {
+			continue
+		}
+		n := 1
+		for _, property := range definition.Properties {
+			n *= values[property.Name]
+		}
+		entries += n
+	}
+	if entries > maxIndexEntries {
+		t.Errorf("%d index entries, want at most %d", entries, maxIndexEntries)
+	}
+}
+
+func TestStopwords(t *testing.T) {
+	metadata := Metadata{Title: "The Wall", Artist: "U2"}
+	grams := Grams(&metadata)
+	if _, ok := grams["the "]; ok {
+		t.Errorf("\"the \" must not be indexed")
+	}
+	if _, ok := grams["he w"]; !ok {
+		t.Errorf("\"he w\" must be indexed")
+	}
+	tokens := Tokens(&metadata)
+	if len(tokens) != 2 {
+		t.Errorf("tokens = %v", tokens)
+	}
+	for _, token := range []string{"wall", "u2"} {
+		if _, ok := tokens[token]; !ok {
+			t.Errorf("%q must be a token", token)
+		}
+	}
+}
+
+func TestNormalizePunctuation(t *testing.T) {
+	cases := map[string]string{
+		"R.E.M.":          "rem",
+		"Don't Stop":      "dont stop",
+		"Don’t  Stop":     "dont stop",
+		" Guns N' Roses ": "guns n roses",
+		"AC/DC":           "ac dc",
+		"Hello,\tWorld!":  "hello world",
+	}
+	for text, want := range cases {
+		if got := Normalize(text); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package benten
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ErrIndexVersionMismatch is returned by LoadIndexConfig when the stored
+// configuration was written for a different IndexVersion.
+var ErrIndexVersionMismatch = errors.New("the index was built with a different index version")
+
+// IndexConfig is the configuration of the n-gram index shared by the syncer
+// and the server. It is stored at IndexConfigKey.
+type IndexConfig struct {
+	// Revision is incremented whenever the configuration changes. Index
+	// entities built with another revision are rebuilt by RespanIndex.
+	Revision int
+	// IndexVersion is the IndexVersion of the code which wrote the configuration.
+	IndexVersion int
+	// GramSizeForAscii is the size of grams consisting of ASCII characters.
+	GramSizeForAscii int
+	// GramSizeForNonAscii is the size in bytes of grams with non-ASCII characters.
+	GramSizeForNonAscii int
+}
+
+// indexConfigRevision is the Revision of the configuration in use.
+var indexConfigRevision = 0
+
+// IndexConfigKey returns the key of the IndexConfig.
+func IndexConfigKey() *datastore.Key {
+	return datastore.NameKey(IndexConfigKind, "current", nil)
+}
+
+// CurrentIndexConfig returns the configuration in use.
+func CurrentIndexConfig() IndexConfig {
+	return IndexConfig{
+		Revision:            indexConfigRevision,
+		IndexVersion:        IndexVersion,
+		GramSizeForAscii:    GramSizeForAscii,
+		GramSizeForNonAscii: GramSizeForNonAscii,
+	}
+}
+
+// Validate returns an error if the gram sizes are unusable.
+func (c IndexConfig) Validate() error {
+	if c.GramSizeForAscii <= 0 || c.GramSizeForNonAscii < c.GramSizeForAscii {
+		return fmt.Errorf("invalid gram sizes: %d, %d", c.GramSizeForAscii, c.GramSizeForNonAscii)
+	}
+	return nil
+}
+
+// UseIndexConfig makes the index and query code use `c`.
+func UseIndexConfig(c IndexConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	indexConfigRevision = c.Revision
+	GramSizeForAscii = c.GramSizeForAscii
+	GramSizeForNonAscii = c.GramSizeForNonAscii
+	return nil
+}
+
+// LoadIndexConfig loads the stored IndexConfig and uses it. The built-in
+// configuration is used when none is stored. On ErrIndexVersionMismatch the
+// stored configuration is returned but not used.
+func LoadIndexConfig(ctx context.Context, client *datastore.Client) (IndexConfig, error) {
+	var c IndexConfig
+	err := client.Get(ctx, IndexConfigKey(), &c)
+	if err == datastore.ErrNoSuchEntity {
+		return CurrentIndexConfig(), nil
+	}
+	if err != nil {
+		return IndexConfig{}, err
+	}
+	if c.IndexVersion != IndexVersion {
+		return c, ErrIndexVersionMismatch
+	}
+	return c, UseIndexConfig(c)
+}
+
+// StoreIndexConfig stores `c` as a new revision and uses it. The index needs
+// to be rebuilt afterwards.
+func StoreIndexConfig(ctx context.Context, client *datastore.Client, c IndexConfig) (IndexConfig, error) {
+	if err := c.Validate(); err != nil {
+		return IndexConfig{}, err
+	}
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var stored IndexConfig
+		err := tx.Get(IndexConfigKey(), &stored)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		c.Revision = stored.Revision + 1
+		c.IndexVersion = IndexVersion
+		_, err = tx.Put(IndexConfigKey(), &c)
+		return err
+	})
+	if err != nil {
+		return IndexConfig{}, err
+	}
+	return c, UseIndexConfig(c)
+}
@@ -0,0 +1,21 @@
+package benten
+
+import "testing"
+
+func TestUseIndexConfig(t *testing.T) {
+	defer UseIndexConfig(CurrentIndexConfig())
+
+	if err := UseIndexConfig(IndexConfig{GramSizeForAscii: 4, GramSizeForNonAscii: 3}); err == nil {
+		t.Errorf("non-ASCII grams shorter than ASCII grams must be rejected")
+	}
+	if err := UseIndexConfig(IndexConfig{Revision: 3, GramSizeForAscii: 3, GramSizeForNonAscii: 6}); err != nil {
+		t.Fatal(err)
+	}
+	grams := Grams(&Metadata{Title: "abcd"})
+	if _, ok := grams["abc"]; !ok || len(grams) != 2 {
+		t.Errorf("grams = %v", grams)
+	}
+	if index := NewPieceIndex(&Metadata{}, nil, nil); index.ConfigRevision != 3 {
+		t.Errorf("ConfigRevision = %d", index.ConfigRevision)
+	}
+}
@@ -0,0 +1,183 @@
+package benten
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Utterance is a request spoken to a voice assistant, such as "play the
+// second Brandenburg concerto", parsed by ParseUtterance.
+type Utterance struct {
+	// Kind is "album" or "playlist" if the utterance says so, or empty.
+	Kind string
+	// Query is what to play, normalized, such as "brandenburg concerto".
+	Query string
+	// By is the artist or the composer, normalized, or empty.
+	By string
+	// Number is the number of the work, such as 2 for "the second" or "no.
+	// 2", or zero.
+	Number  int
+	Shuffle bool
+}
+
+var ordinalWords = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5, "sixth": 6,
+	"seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10, "eleventh": 11, "twelfth": 12,
+}
+
+var numberWords = map[string]int{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+var ordinalPattern = regexp.MustCompile(`^(\d+)(?:st|nd|rd|th)$`)
+
+// parseNumber parses "2", "two" and "2nd" or "second" if `ordinal`.
+func parseNumber(word string, ordinal bool) int {
+	if ordinal {
+		if n, ok := ordinalWords[word]; ok {
+			return n
+		}
+		if m := ordinalPattern.FindStringSubmatch(word); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+		return 0
+	}
+	if n, ok := numberWords[word]; ok {
+		return n
+	}
+	n, _ := strconv.Atoi(word)
+	return n
+}
+
+// ParseUtterance parses `s`, such as "play the album Kind of Blue by Miles
+// Davis" or "shuffle my road trip playlist".
+func ParseUtterance(s string) Utterance {
+	var u Utterance
+	words := strings.Fields(Normalize(s))
+	trimPrefix := func(prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			p := strings.Fields(prefix)
+			if len(words) >= len(p) && strings.Join(words[:len(p)], " ") == prefix {
+				words = words[len(p):]
+				return true
+			}
+		}
+		return false
+	}
+	if trimPrefix("shuffle") {
+		u.Shuffle = true
+	} else {
+		trimPrefix("play", "put on", "listen to", "i want to hear", "start")
+	}
+	trimPrefix("me")
+	trimPrefix("some", "the", "my")
+	for _, kind := range []string{"album", "playlist"} {
+		if trimPrefix(kind) {
+			u.Kind = kind
+		} else if len(words) > 1 && words[len(words)-1] == kind {
+			u.Kind = kind
+			words = words[:len(words)-1]
+		}
+	}
+	trimPrefix("the", "my")
+
+	var rest []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if n := parseNumber(word, true); n > 0 && u.Number == 0 {
+			u.Number = n
+			continue
+		}
+		if (word == "no" || word == "number" || word == "nr") && i+1 < len(words) {
+			if n := parseNumber(words[i+1], false); n > 0 && u.Number == 0 {
+				u.Number = n
+				i++
+				continue
+			}
+		}
+		if word == "by" && i > 0 && i+1 < len(words) {
+			u.By = strings.Join(words[i+1:], " ")
+			break
+		}
+		rest = append(rest, word)
+	}
+	u.Query = strings.Join(rest, " ")
+	return u
+}
+
+var (
+	movementPattern   = regexp.MustCompile(`^(.+?)\s*:\s*([IVXL]+|\d+)\.\s*\S`)
+	workNumberPattern = regexp.MustCompile(`(?i)\b(?:no|nr|number|n°)\.?\s*(\d+)`)
+	workTitlePattern  = regexp.MustCompile(`(?i)\b(?:concerto|symphony|sonata|suite|partita|quartet|prelude|etude|nocturne|ballade)\s+(\d+)\b`)
+)
+
+// ParseWork splits the title of a movement, in the common form "Brandenburg
+// Concerto No. 2 in F Major, BWV 1047: I. Allegro", into the work and the
+// number of the movement. It returns an empty work for other titles.
+func ParseWork(title string) (string, int) {
+	m := movementPattern.FindStringSubmatch(title)
+	if m == nil {
+		return "", 0
+	}
+	movement, err := strconv.Atoi(m[2])
+	if err != nil {
+		movement = parseRoman(m[2])
+	}
+	return m[1], movement
+}
+
+func parseRoman(s string) int {
+	values := map[byte]int{'I': 1, 'V': 5, 'X': 10, 'L': 50}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		v := values[s[i]]
+		if i+1 < len(s) && values[s[i+1]] > v {
+			n -= v
+		} else {
+			n += v
+		}
+	}
+	return n
+}
+
+// WorkNumber returns the number of the work `name`, such as 2 for
+// "Brandenburg Concerto No. 2" or "Symphony 2", or zero.
+func WorkNumber(name string) int {
+	m := workNumberPattern.FindStringSubmatch(name)
+	if m == nil {
+		m = workTitlePattern.FindStringSubmatch(name)
+	}
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// MatchScore returns how well `name` matches the normalized `query`, from 0
+// (no common words) to 1.5 (the same words): the fraction of the words of the
+// query in the name, plus half the fraction of the words of the name in the
+// query, so that shorter names matching all the words win.
+func MatchScore(query, name string) float64 {
+	queryWords := make(map[string]struct{})
+	for _, word := range tokenize(query) {
+		queryWords[word] = struct{}{}
+	}
+	nameWords := make(map[string]struct{})
+	for _, word := range tokenize(Normalize(name)) {
+		nameWords[word] = struct{}{}
+	}
+	if len(queryWords) == 0 || len(nameWords) == 0 {
+		return 0
+	}
+	matched := 0
+	for word := range queryWords {
+		if _, ok := nameWords[word]; ok {
+			matched++
+		}
+	}
+	return float64(matched)/float64(len(queryWords)) + 0.5*float64(matched)/float64(len(nameWords))
+}
@@ -0,0 +1,49 @@
+package benten
+
+import "testing"
+
+func TestParseUtterance(t *testing.T) {
+	for s, want := range map[string]Utterance{
+		"Play the second Brandenburg concerto":       {Query: "brandenburg concerto", Number: 2},
+		"play Brandenburg Concerto No. 5 by Bach":    {Query: "brandenburg concerto", By: "bach", Number: 5},
+		"play the album Kind of Blue by Miles Davis": {Kind: "album", Query: "kind of blue", By: "miles davis"},
+		"shuffle my road trip playlist":              {Kind: "playlist", Query: "road trip", Shuffle: true},
+		"put on Stand By Me":                         {Query: "stand", By: "me"},
+	} {
+		if got := ParseUtterance(s); got != want {
+			t.Errorf("ParseUtterance(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+}
+
+func TestParseWork(t *testing.T) {
+	for title, want := range map[string]struct {
+		work     string
+		movement int
+	}{
+		"Brandenburg Concerto No. 2 in F Major, BWV 1047: III. Allegro assai": {"Brandenburg Concerto No. 2 in F Major, BWV 1047", 3},
+		"Symphony No. 9: 4. Presto":  {"Symphony No. 9", 4},
+		"Time: The Conclusion":       {"", 0},
+		"Shine On You Crazy Diamond": {"", 0},
+	} {
+		if work, movement := ParseWork(title); work != want.work || movement != want.movement {
+			t.Errorf("ParseWork(%q) = %q, %d", title, work, movement)
+		}
+	}
+	if n := WorkNumber("Brandenburg Concerto No. 2 in F Major, BWV 1047"); n != 2 {
+		t.Errorf("WorkNumber = %d", n)
+	}
+	if n := WorkNumber("Piano Concerto 21"); n != 21 {
+		t.Errorf("WorkNumber = %d", n)
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	query := "brandenburg concerto"
+	if work, movement := MatchScore(query, "Brandenburg Concerto No. 2"), MatchScore(query, "Brandenburg Concerto No. 2: I. Allegro"); work <= movement {
+		t.Errorf("the work (%v) doesn't beat the movement (%v)", work, movement)
+	}
+	if s := MatchScore(query, "Kind of Blue"); s != 0 {
+		t.Errorf("MatchScore = %v", s)
+	}
+}
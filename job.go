@@ -0,0 +1,148 @@
+package benten
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Statuses of a Job.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// ErrJobNotRunnable is returned when a job is finished, canceled, or leased by
+// another worker.
+var ErrJobNotRunnable = errors.New("the job is not runnable")
+
+// Job is a long-running operation started from the admin API, such as
+// rebuilding the index. Workers run it step by step, saving the progress
+// after each step, so that another worker can resume it when one stops.
+type Job struct {
+	// Type is what the job does, such as "reindex".
+	Type   string
+	Status string
+	// Processed is the number of items processed so far.
+	Processed int
+	// Repaired is the number of the items which a repairing job, such as
+	// "repair-index", fixed.
+	Repaired int
+	// Cursor is where the job continues from.
+	Cursor string `datastore:",noindex"`
+	Error  string `datastore:",noindex"`
+	// Lease is when the worker running the job is considered gone.
+	Lease time.Time
+	// LeaseToken identifies the worker holding the lease, so that a worker
+	// which stalled past its lease can't overwrite the progress of the one
+	// which took the job over.
+	LeaseToken string `datastore:",noindex"`
+	Started    time.Time
+	Updated    time.Time
+}
+
+// CreateJob stores a new running job of `jobType`.
+func CreateJob(ctx context.Context, client *datastore.Client, jobType string) (*datastore.Key, *Job, error) {
+	now := time.Now()
+	job := &Job{Type: jobType, Status: JobRunning, Started: now, Updated: now}
+	key, err := client.Put(ctx, datastore.IncompleteKey(JobKind, nil), job)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, job, nil
+}
+
+// RunnableJobs returns the keys of the running jobs whose leases have expired.
+func RunnableJobs(ctx context.Context, client *datastore.Client) ([]*datastore.Key, error) {
+	var jobs []Job
+	keys, err := client.GetAll(ctx, datastore.NewQuery(JobKind).Filter("Status =", JobRunning), &jobs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var runnable []*datastore.Key
+	for i, job := range jobs {
+		if job.Lease.Before(now) {
+			runnable = append(runnable, keys[i])
+		}
+	}
+	return runnable, nil
+}
+
+// ClaimJob leases the job at `key` for `lease` if it is runnable, and returns
+// it. It returns ErrJobNotRunnable otherwise.
+func ClaimJob(ctx context.Context, client *datastore.Client, key *datastore.Key, lease time.Duration) (*Job, error) {
+	var job Job
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, &job); err != nil {
+			return err
+		}
+		now := time.Now()
+		if job.Status != JobRunning || job.Lease.After(now) {
+			return ErrJobNotRunnable
+		}
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return err
+		}
+		job.Lease = now.Add(lease)
+		job.LeaseToken = hex.EncodeToString(token)
+		job.Updated = now
+		_, err := tx.Put(key, &job)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SaveJob stores the progress of `job`, claimed with ClaimJob, and extends its
+// lease by `lease`. It returns ErrJobNotRunnable without saving if the job has
+// been canceled, or claimed by another worker since.
+func SaveJob(ctx context.Context, client *datastore.Client, key *datastore.Key, job *Job, lease time.Duration) error {
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var current Job
+		if err := tx.Get(key, &current); err != nil {
+			return err
+		}
+		if current.Status != JobRunning || current.LeaseToken != job.LeaseToken {
+			return ErrJobNotRunnable
+		}
+		now := time.Now()
+		job.Updated = now
+		job.Lease = now.Add(lease)
+		if job.Status != JobRunning {
+			job.Lease = time.Time{}
+		}
+		_, err := tx.Put(key, job)
+		return err
+	})
+	return err
+}
+
+// CancelJob marks the job at `key` canceled unless it has finished. The
+// worker running it stops when it saves its progress next time.
+func CancelJob(ctx context.Context, client *datastore.Client, key *datastore.Key) error {
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var job Job
+		if err := tx.Get(key, &job); err != nil {
+			return err
+		}
+		if job.Status != JobRunning {
+			return ErrJobNotRunnable
+		}
+		job.Status = JobCanceled
+		job.Lease = time.Time{}
+		job.Updated = time.Now()
+		_, err := tx.Put(key, &job)
+		return err
+	})
+	return err
+}
@@ -0,0 +1,101 @@
+package benten
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// LyricsRetry is how long a piece without lyrics is left until the providers
+// are asked again.
+var LyricsRetry = 30 * 24 * time.Hour
+
+// Lyrics are the lyrics of a piece fetched from an external provider.
+type Lyrics struct {
+	// Synced is the lyrics in the LRC format, or the empty string if they are
+	// not synced; see ParseLRC.
+	Synced string `datastore:",noindex"`
+	// Plain is the lyrics without timestamps, or the empty string if no
+	// provider had the lyrics.
+	Plain string `datastore:",noindex"`
+	// Instrumental is true if the piece is known to have no lyrics.
+	Instrumental bool
+	// Source is the provider the lyrics came from, such as "lrclib".
+	Source string
+	// Fetched is when the providers were asked.
+	Fetched time.Time
+}
+
+// LyricsKey returns the key of the Lyrics of the piece stored at `piece`.
+func LyricsKey(piece *datastore.Key) *datastore.Key {
+	return datastore.IDKey(LyricsKind, 1, piece)
+}
+
+// IsStale returns true if the providers should be asked again.
+func (l *Lyrics) IsStale(now time.Time) bool {
+	return l.Plain == "" && l.Synced == "" && !l.Instrumental && now.Sub(l.Fetched) > LyricsRetry
+}
+
+// LyricLine is a line of synced lyrics.
+type LyricLine struct {
+	// Time is when the line starts, from the start of the piece.
+	Time time.Duration
+	Text string
+}
+
+var (
+	lrcTagPattern  = regexp.MustCompile(`^\[([^\]]*)\]`)
+	lrcTimePattern = regexp.MustCompile(`^(\d+):(\d{1,2})(?:[.:](\d{1,3}))?$`)
+)
+
+// ParseLRC parses lyrics in the LRC format, such as
+//
+//	[ti:Yesterday]
+//	[00:01.20]Yesterday
+//	[00:05.80][00:40.10]All my troubles seemed so far away
+//
+// and returns the lines in the order of their times. A line may have several
+// times, and the "offset" tag (in milliseconds) shifts all of them. Lines
+// without times are skipped.
+func ParseLRC(lrc string) []LyricLine {
+	var lines []LyricLine
+	var offset time.Duration
+	for _, line := range strings.Split(lrc, "\n") {
+		line = strings.TrimSpace(line)
+		var times []time.Duration
+		for {
+			match := lrcTagPattern.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			line = line[len(match[0]):]
+			if t := lrcTimePattern.FindStringSubmatch(match[1]); t != nil {
+				minutes, _ := strconv.Atoi(t[1])
+				seconds, _ := strconv.Atoi(t[2])
+				// "05.8" is 800 ms, and "05.80" too.
+				fraction, _ := strconv.Atoi((t[3] + "000")[:3])
+				times = append(times, time.Duration(minutes)*time.Minute+time.Duration(seconds)*time.Second+time.Duration(fraction)*time.Millisecond)
+			} else if strings.HasPrefix(match[1], "offset:") {
+				if ms, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(match[1], "offset:"))); err == nil {
+					offset = time.Duration(ms) * time.Millisecond
+				}
+			}
+		}
+		for _, t := range times {
+			lines = append(lines, LyricLine{Time: t, Text: strings.TrimSpace(line)})
+		}
+	}
+	// A positive offset makes the lines appear earlier.
+	for i := range lines {
+		lines[i].Time -= offset
+		if lines[i].Time < 0 {
+			lines[i].Time = 0
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time < lines[j].Time })
+	return lines
+}
@@ -0,0 +1,112 @@
+package lyrics
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/yutakahirano/benten"
+	"golang.org/x/net/html"
+)
+
+// Genius finds plain lyrics in genius.com. The API only finds the songs, so
+// the lyrics are taken from their pages.
+type Genius struct {
+	// AccessToken is the client access token of the API.
+	AccessToken string
+	// BaseURL defaults to "https://api.genius.com/".
+	BaseURL string
+	Client  *http.Client
+}
+
+// Name implements Provider.
+func (p Genius) Name() string { return "genius" }
+
+// Fetch implements Provider.
+func (p Genius) Fetch(ctx context.Context, q Query) (*Result, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://api.genius.com/"
+	}
+	if q.Title == "" {
+		return nil, nil
+	}
+	header := http.Header{"Authorization": {"Bearer " + p.AccessToken}}
+	var response struct {
+		Response struct {
+			Hits []struct {
+				Result struct {
+					Title         string `json:"title"`
+					URL           string `json:"url"`
+					PrimaryArtist struct {
+						Name string `json:"name"`
+					} `json:"primary_artist"`
+				} `json:"result"`
+			} `json:"hits"`
+		} `json:"response"`
+	}
+	params := url.Values{"q": {q.Title + " " + q.Artist}}
+	if ok, err := getJSON(ctx, p.Client, base+"search?"+params.Encode(), header, &response); !ok || err != nil {
+		return nil, err
+	}
+	// The search is fuzzy, so songs by other artists are common.
+	for _, hit := range response.Response.Hits {
+		song := hit.Result
+		if benten.Normalize(song.Title) != benten.Normalize(q.Title) || benten.Normalize(song.PrimaryArtist.Name) != benten.Normalize(q.Artist) {
+			continue
+		}
+		res, err := get(ctx, p.Client, song.URL, nil)
+		if res == nil || err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		doc, err := html.Parse(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		plain := strings.TrimSpace(geniusLyrics(doc))
+		if plain == "" {
+			return nil, nil
+		}
+		return &Result{Plain: plain}, nil
+	}
+	return nil, nil
+}
+
+// geniusLyrics returns the text in the lyrics containers of a Genius page,
+// with <br> as newlines.
+func geniusLyrics(doc *html.Node) string {
+	var b strings.Builder
+	var text func(n *html.Node)
+	text = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			text(c)
+		}
+	}
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				if attr.Key == "data-lyrics-container" && attr.Val == "true" {
+					if b.Len() > 0 {
+						b.WriteString("\n")
+					}
+					text(n)
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	return b.String()
+}
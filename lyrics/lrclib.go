@@ -0,0 +1,89 @@
+package lyrics
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LRCLIB finds lyrics, mostly synced, in lrclib.net.
+type LRCLIB struct {
+	// BaseURL defaults to "https://lrclib.net/api/".
+	BaseURL string
+	Client  *http.Client
+}
+
+// The maximum difference of durations to take a record in LRCLIB as the
+// piece, which LRCLIB also uses.
+const lrclibDurationTolerance = 2 * time.Second
+
+type lrclibRecord struct {
+	Duration     float64 `json:"duration"`
+	Instrumental bool    `json:"instrumental"`
+	PlainLyrics  string  `json:"plainLyrics"`
+	SyncedLyrics string  `json:"syncedLyrics"`
+}
+
+func (r *lrclibRecord) result() *Result {
+	return &Result{Synced: r.SyncedLyrics, Plain: r.PlainLyrics, Instrumental: r.Instrumental}
+}
+
+// Name implements Provider.
+func (p LRCLIB) Name() string { return "lrclib" }
+
+// Fetch implements Provider. The exact match is looked up when the duration
+// is known, and the search results are taken otherwise.
+func (p LRCLIB) Fetch(ctx context.Context, q Query) (*Result, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://lrclib.net/api/"
+	}
+	if q.Title == "" {
+		return nil, nil
+	}
+	if q.Duration > 0 && q.Album != "" {
+		params := url.Values{
+			"track_name":  {q.Title},
+			"artist_name": {q.Artist},
+			"album_name":  {q.Album},
+			"duration":    {strconv.Itoa(int(math.Round(q.Duration.Seconds())))},
+		}
+		var record lrclibRecord
+		ok, err := getJSON(ctx, p.Client, base+"get?"+params.Encode(), nil, &record)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return record.result(), nil
+		}
+	}
+
+	params := url.Values{"track_name": {q.Title}}
+	if q.Artist != "" {
+		params.Set("artist_name", q.Artist)
+	}
+	var records []lrclibRecord
+	if ok, err := getJSON(ctx, p.Client, base+"search?"+params.Encode(), nil, &records); !ok || err != nil {
+		return nil, err
+	}
+	var found *lrclibRecord
+	for i := range records {
+		record := &records[i]
+		if q.Duration > 0 && math.Abs(record.Duration-q.Duration.Seconds()) > lrclibDurationTolerance.Seconds() {
+			continue
+		}
+		if record.SyncedLyrics != "" {
+			return record.result(), nil
+		}
+		if found == nil && (record.PlainLyrics != "" || record.Instrumental) {
+			found = record
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return found.result(), nil
+}
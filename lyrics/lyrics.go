@@ -0,0 +1,119 @@
+// Package lyrics fetches the lyrics of pieces from online providers.
+package lyrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// userAgent identifies benten to the providers, as LRCLIB asks.
+const userAgent = "benten/1.0 (https://github.com/yutakahirano/benten)"
+
+// Query identifies the piece whose lyrics are asked.
+type Query struct {
+	Title  string
+	Artist string
+	Album  string
+	// Duration is the length of the piece, or zero if unknown. Providers use
+	// it to tell versions apart.
+	Duration time.Duration
+}
+
+// Result is the lyrics found by a provider.
+type Result struct {
+	// Synced is the lyrics in the LRC format, or the empty string.
+	Synced string
+	// Plain is the lyrics without timestamps, or the empty string.
+	Plain string
+	// Instrumental is true if the provider knows the piece has no lyrics.
+	Instrumental bool
+	// Source is the name of the provider.
+	Source string
+}
+
+// Provider finds lyrics.
+type Provider interface {
+	// Name returns the name recorded as the source of the lyrics.
+	Name() string
+	// Fetch returns the lyrics of `q`, or nil if the provider has none.
+	Fetch(ctx context.Context, q Query) (*Result, error)
+}
+
+// Fetch asks `providers` in order for the lyrics of `q`. Synced lyrics are
+// preferred, so plain ones are returned only if no provider has synced ones.
+// A provider failing doesn't stop the others, but the error is returned if no
+// lyrics are found, so that the caller can retry.
+func Fetch(ctx context.Context, providers []Provider, q Query) (*Result, error) {
+	var plain *Result
+	var lastErr error
+	for _, provider := range providers {
+		result, err := provider.Fetch(ctx, q)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", provider.Name(), err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+		result.Source = provider.Name()
+		if result.Synced != "" || result.Instrumental {
+			return result, nil
+		}
+		if plain == nil && result.Plain != "" {
+			plain = result
+		}
+	}
+	if plain != nil {
+		return plain, nil
+	}
+	return nil, lastErr
+}
+
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+// get gets `url` with `header`, and returns the response unless it is 404.
+func get(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := httpClient(client).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 404 {
+		res.Body.Close()
+		return nil, nil
+	}
+	if res.StatusCode/100 != 2 {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return res, nil
+}
+
+// getJSON gets `url` and decodes the JSON response into `v`. It returns false
+// for 404.
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, v interface{}) (bool, error) {
+	res, err := get(ctx, client, url, header)
+	if res == nil || err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,109 @@
+package lyrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLRCLIB(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path == "/get" && q.Get("duration") == "125":
+			fmt.Fprint(w, `{"duration":125,"plainLyrics":"Yesterday","syncedLyrics":"[00:01.00]Yesterday"}`)
+		case r.URL.Path == "/search" && q.Get("track_name") == "Yesterday":
+			fmt.Fprint(w, `[{"duration":200,"plainLyrics":"Live"},{"duration":125,"plainLyrics":"Yesterday"},{"duration":126,"plainLyrics":"Yesterday","syncedLyrics":"[00:01.00]Yesterday"}]`)
+		case r.URL.Path == "/search":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := LRCLIB{BaseURL: server.URL + "/"}
+	for _, c := range []struct {
+		q      Query
+		synced bool
+		plain  string
+	}{
+		{Query{Title: "Yesterday", Artist: "The Beatles", Album: "Help!", Duration: 125 * time.Second}, true, "Yesterday"},
+		{Query{Title: "Yesterday", Artist: "The Beatles", Album: "Live", Duration: 200 * time.Second}, false, "Live"},
+		{Query{Title: "Yesterday", Artist: "The Beatles"}, true, "Yesterday"},
+	} {
+		result, err := provider.Fetch(ctx, c.q)
+		if err != nil {
+			t.Errorf("Fetch(%+v): %v", c.q, err)
+		} else if result == nil || (result.Synced != "") != c.synced || result.Plain != c.plain {
+			t.Errorf("Fetch(%+v) = %+v", c.q, result)
+		}
+	}
+	if result, err := provider.Fetch(ctx, Query{Title: "Unknown"}); result != nil || err != nil {
+		t.Errorf("Fetch(Unknown) = %+v, %v", result, err)
+	}
+}
+
+func TestGenius(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", 401)
+				return
+			}
+			fmt.Fprintf(w, `{"response":{"hits":[{"result":{"title":"Yesterday","url":"%s/cover","primary_artist":{"name":"Someone"}}},{"result":{"title":"Yesterday","url":"%s/yesterday","primary_artist":{"name":"The Beatles"}}}]}}`, server.URL, server.URL)
+		case "/yesterday":
+			fmt.Fprint(w, `<html><body><div data-lyrics-container="true">Yesterday<br/><i>all my troubles</i></div><div>ad</div><div data-lyrics-container="true">seemed so far away</div></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := Genius{AccessToken: "token", BaseURL: server.URL + "/"}
+	result, err := provider.Fetch(ctx, Query{Title: "Yesterday", Artist: "the beatles"})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if want := "Yesterday\nall my troubles\nseemed so far away"; result == nil || result.Plain != want {
+		t.Errorf("Fetch = %+v, want %q", result, want)
+	}
+}
+
+type fakeProvider struct {
+	name   string
+	result *Result
+}
+
+func (p fakeProvider) Name() string { return p.name }
+
+func (p fakeProvider) Fetch(ctx context.Context, q Query) (*Result, error) {
+	if p.result == nil {
+		return nil, fmt.Errorf("unavailable")
+	}
+	result := *p.result
+	return &result, nil
+}
+
+func TestFetchPrefersSynced(t *testing.T) {
+	providers := []Provider{
+		fakeProvider{"down", nil},
+		fakeProvider{"plain", &Result{Plain: "a"}},
+		fakeProvider{"synced", &Result{Synced: "[00:01.00]a"}},
+	}
+	result, err := Fetch(context.Background(), providers, Query{Title: "a"})
+	if err != nil || result == nil || result.Source != "synced" {
+		t.Errorf("Fetch = %+v, %v", result, err)
+	}
+	result, err = Fetch(context.Background(), providers[:2], Query{Title: "a"})
+	if err != nil || result == nil || result.Source != "plain" {
+		t.Errorf("Fetch = %+v, %v", result, err)
+	}
+}
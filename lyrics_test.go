@@ -0,0 +1,23 @@
+package benten
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLRC(t *testing.T) {
+	lrc := "[ti:Yesterday]\n[offset:+200]\n[00:05.80][00:40.10]All my troubles\r\n[00:01.2]Yesterday\nno time\n[01:02]"
+	want := []LyricLine{
+		{time.Second, "Yesterday"},
+		{5600 * time.Millisecond, "All my troubles"},
+		{39900 * time.Millisecond, "All my troubles"},
+		{61800 * time.Millisecond, ""},
+	}
+	if lines := ParseLRC(lrc); !reflect.DeepEqual(lines, want) {
+		t.Errorf("ParseLRC = %v, want %v", lines, want)
+	}
+	if lines := ParseLRC(""); len(lines) != 0 {
+		t.Errorf("ParseLRC(\"\") = %v", lines)
+	}
+}
@@ -0,0 +1,53 @@
+package benten
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Maintenance is the maintenance mode of the server, stored at
+// MaintenanceKey. While ReadOnly, the server rejects mutating requests, such
+// as during migrations, restores and index rebuilds.
+type Maintenance struct {
+	ReadOnly bool
+	// Reason is told to the clients.
+	Reason string
+	// Until is when the maintenance is expected to end, or the zero value if
+	// unknown. It is only a hint for the clients to retry.
+	Until   time.Time
+	Updated time.Time
+}
+
+// MaintenanceKey returns the key of the Maintenance.
+func MaintenanceKey() *datastore.Key {
+	return datastore.NameKey(MaintenanceKind, "current", nil)
+}
+
+// LoadMaintenance loads the stored Maintenance, which is the zero value if
+// none is stored.
+func LoadMaintenance(ctx context.Context, client *datastore.Client) (Maintenance, error) {
+	var m Maintenance
+	err := client.Get(ctx, MaintenanceKey(), &m)
+	if err == datastore.ErrNoSuchEntity {
+		return Maintenance{}, nil
+	}
+	return m, err
+}
+
+// Summary describes the maintenance mode for AuditLog.
+func (m Maintenance) Summary() string {
+	if !m.ReadOnly {
+		return "read-write"
+	}
+	summary := "read-only"
+	if m.Reason != "" {
+		summary += fmt.Sprintf(" (%s)", m.Reason)
+	}
+	if !m.Until.IsZero() {
+		summary += " until " + m.Until.UTC().Format(time.RFC3339)
+	}
+	return summary
+}
@@ -0,0 +1,87 @@
+package benten
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// PieceMatcher finds the pieces referred to by other music libraries, by path
+// or by tags.
+type PieceMatcher struct {
+	byPath map[string]*datastore.Key
+	byTags map[string]*datastore.Key
+	// byTitleArtist holds the first piece for each title and artist, and
+	// byTitle the first piece for each title.
+	byTitleArtist map[string]*datastore.Key
+	byTitle       map[string]*datastore.Key
+}
+
+func tagTriple(title, artist, album string) string {
+	return Normalize(title) + "\x00" + Normalize(artist) + "\x00" + Normalize(album)
+}
+
+// NewPieceMatcher creates a PieceMatcher for `pieces` stored at `keys`.
+func NewPieceMatcher(keys []*datastore.Key, pieces []Metadata) *PieceMatcher {
+	m := &PieceMatcher{
+		byPath:        make(map[string]*datastore.Key),
+		byTags:        make(map[string]*datastore.Key),
+		byTitleArtist: make(map[string]*datastore.Key),
+		byTitle:       make(map[string]*datastore.Key),
+	}
+	for i, piece := range pieces {
+		if piece.Path != "" {
+			m.byPath[strings.ToLower(strings.Trim(piece.Path, "/"))] = keys[i]
+		}
+		m.byTags[tagTriple(piece.Title, piece.Artist, piece.Album)] = keys[i]
+		if _, ok := m.byTitleArtist[tagTriple(piece.Title, piece.Artist, "")]; !ok {
+			m.byTitleArtist[tagTriple(piece.Title, piece.Artist, "")] = keys[i]
+		}
+		if _, ok := m.byTitle[Normalize(piece.Title)]; !ok {
+			m.byTitle[Normalize(piece.Title)] = keys[i]
+		}
+	}
+	return m
+}
+
+// LoadPieceMatcher creates a PieceMatcher for all the pieces not in the trash.
+func LoadPieceMatcher(ctx context.Context, client *datastore.Client) (*PieceMatcher, error) {
+	keys, pieces, err := loadUntrashed(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return NewPieceMatcher(keys, pieces), nil
+}
+
+// ByPath returns the piece whose Path is a suffix of `path`, comparing whole
+// path components case-insensitively, or nil. Backslashes are treated as
+// separators. The longest matching suffix wins.
+func (m *PieceMatcher) ByPath(path string) *datastore.Key {
+	path = strings.ToLower(strings.Trim(strings.Replace(path, "\\", "/", -1), "/"))
+	for {
+		if key, ok := m.byPath[path]; ok {
+			return key
+		}
+		i := strings.Index(path, "/")
+		if i < 0 {
+			return nil
+		}
+		path = path[i+1:]
+	}
+}
+
+// ByTags returns the piece having the given normalized title, artist and
+// album, or nil.
+func (m *PieceMatcher) ByTags(title, artist, album string) *datastore.Key {
+	return m.byTags[tagTriple(title, artist, album)]
+}
+
+// ByTitleArtist returns the first piece having the given normalized title
+// and artist, or nil. Without an artist, only the title is compared.
+func (m *PieceMatcher) ByTitleArtist(title, artist string) *datastore.Key {
+	if artist == "" {
+		return m.byTitle[Normalize(title)]
+	}
+	return m.byTitleArtist[tagTriple(title, artist, "")]
+}
@@ -0,0 +1,48 @@
+package benten
+
+import (
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestPieceMatcher(t *testing.T) {
+	a := datastore.IDKey(PieceKind, 1, nil)
+	b := datastore.IDKey(PieceKind, 2, nil)
+	m := NewPieceMatcher([]*datastore.Key{a, b}, []Metadata{
+		{Title: "Help!", Artist: "The Beatles", Album: "Help!", Path: "Beatles/Help/01 Help.mp3"},
+		{Title: "Yesterday", Artist: "The Beatles", Album: "Help!", Path: "Beatles/Help/13 Yesterday.mp3"},
+	})
+
+	if key := m.ByPath("/Users/me/Music/beatles/help/01 Help.mp3"); !key.Equal(a) {
+		t.Errorf("ByPath = %v", key)
+	}
+	if key := m.ByPath(`C:\Music\Beatles\Help\13 Yesterday.mp3`); !key.Equal(b) {
+		t.Errorf("ByPath = %v", key)
+	}
+	if key := m.ByPath("/Music/Other/01 Help.mp3"); key != nil {
+		t.Errorf("ByPath = %v", key)
+	}
+	if key := m.ByTags("yesterday", "the beatles", "HELP"); !key.Equal(b) {
+		t.Errorf("ByTags = %v", key)
+	}
+}
+
+func TestPieceMatcherByTitle(t *testing.T) {
+	var keys []*datastore.Key
+	var pieces []Metadata
+	for i, artist := range []string{"The Beatles", "Ray Charles", "Frank Sinatra", "Elvis Presley", "Marianne Faithfull"} {
+		keys = append(keys, datastore.IDKey(PieceKind, int64(i+1), nil))
+		pieces = append(pieces, Metadata{Title: "Yesterday", Artist: artist})
+	}
+	m := NewPieceMatcher(keys, pieces)
+	// The first piece wins, whatever the order of the maps.
+	for i := 0; i < 10; i++ {
+		if key := m.ByTitleArtist("yesterday", ""); !key.Equal(keys[0]) {
+			t.Fatalf("ByTitleArtist = %v, want %v", key, keys[0])
+		}
+	}
+	if key := m.ByTitleArtist("Yesterday", "ray charles"); !key.Equal(keys[1]) {
+		t.Errorf("ByTitleArtist = %v, want %v", key, keys[1])
+	}
+}
@@ -1,10 +1,18 @@
 package benten
 
 import (
+	"time"
+
 	"cloud.google.com/go/datastore"
 	"github.com/dhowden/tag"
 )
 
+// Media types of pieces.
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+)
+
 // Metadata epresents a metadata of an audio file. This is equivalent to tag.Metadata except for the following members:
 //  - Picture
 //  - Hash
@@ -14,6 +22,9 @@ type Metadata struct {
 	Format string
 	// FileType is the file type of the audio file.
 	FileType string
+	// MediaType is MediaAudio or MediaVideo, or empty for the audio files
+	// synced before it was recorded.
+	MediaType string
 	// Title is the title of the track.
 	Title string
 	// Album is the album name of the track.
@@ -22,12 +33,24 @@ type Metadata struct {
 	Artist string
 	// AlbumArtist is the album artist name of the track.
 	AlbumArtist string
+	// Compilation is true if the album is a compilation of various artists;
+	// see IsCompilation.
+	Compilation bool
+	// AlbumKey groups the pieces of an album: the album without disc
+	// numbers, GroupAlbumArtist (or Artist) by its canonical name in the
+	// Aliases, and Year, normalized. The discs
+	// of a set share it, while re-releases of other years don't. It is empty
+	// if the album is unknown; see SetAlbumKey.
+	AlbumKey string
 	// Composer is the name of the composer of the track.
 	Composer string
 	// Genre is the genre of the track.
 	Genre string
 	// Year is the year of the track.
 	Year int
+	// OriginalYear is the year of the original release of a remaster or a
+	// reissue, or zero if unknown; see ReleaseYear.
+	OriginalYear int
 
 	// Track is the track number of this piece in the album, or zero values if unavailable.
 	Track int
@@ -41,13 +64,77 @@ type Metadata struct {
 	// Comment is the comment, or an empty string if unavailable.
 	Comment string
 
+	// BPM is the tempo in beats per minute, or zero if unknown.
+	BPM float64
+	// Key is the musical key after NormalizeKey, such as "Am", or an empty
+	// string if unknown.
+	Key string
+
+	// Gapless is the encoder delay and padding, for gapless playback.
+	Gapless Gapless
+	// Bitrate is the average bitrate in kbps, or zero if unknown; see
+	// ReadBitrate.
+	Bitrate int
+	// Duration is the length of the piece, or zero if unknown; see
+	// ReadDuration.
+	Duration time.Duration
+
+	// Podcast is the ID of the Podcast the piece is an episode of, or zero
+	// for music.
+	Podcast int64
+	// EpisodeGUID identifies the episode in the feed of Podcast.
+	EpisodeGUID string
+	// Published is when the episode was published.
+	Published time.Time
+
 	// The key of the item stored in the datastore which represents the picture of the file, or the empty string if unavailable.
 	Picture string
+	// PictureSource is the online provider Picture was fetched from, such as
+	// "coverartarchive", or the empty string if it came with the file.
+	PictureSource string
 	// The metadata-invariant checksum: see
 	// https://github.com/dhowden/tag#audio-data-checksum-sha1.
 	Hash string
 	// The relative Path of the file stored in the client storage.
 	Path string
+	// PathDir is the slash-separated directory of the file relative to the
+	// root of the library, such as "Artist/Album", or empty at the root; see
+	// FolderOf. The pieces synced before it was recorded get it by the next
+	// full sync.
+	PathDir string
+	// Naming is the scheme Object was named with, or empty for pieces synced
+	// before it was recorded.
+	Naming NamingScheme
+	// Object is the name of the object in PieceBucket; see ObjectName.
+	Object string
+	// Renditions are the transcodes of the file; see SelectRendition.
+	Renditions Renditions
+	// Replicated is true once the file has been copied to the replica store.
+	Replicated bool
+	// Created is when the piece was added to the library, or the zero value
+	// for the pieces added before it was recorded.
+	Created time.Time
+	// Updated is when the syncer or the edit API last wrote the entity.
+	Updated time.Time
+	// Revision is incremented whenever the syncer or the edit API writes the
+	// metadata. It is the ETag of the edit API.
+	Revision int
+	// Inferred are the fields inferred from Path rather than read from the
+	// tags, comma-separated, such as "Artist,Title", until they are edited;
+	// see PathTemplate.
+	Inferred string
+	// Reviewed is when the piece was accepted in the review queue, after
+	// which missing fields no longer flag it.
+	Reviewed time.Time
+	// NeedsReview is true if the piece is in the review queue; see
+	// SetNeedsReview. The pieces synced before it was recorded are flagged
+	// by the next full sync.
+	NeedsReview bool
+	// Edited is when the entity was last edited via the API, or the zero value.
+	Edited time.Time
+	// Deleted is when the piece was moved to the trash, or the zero value; see
+	// TrashPiece.
+	Deleted time.Time
 }
 
 // NewMetadata creates a Metadata from a tag.Metadata and
@@ -60,14 +147,19 @@ func NewMetadata(src tag.Metadata, picture string, hash string, path string) Met
 	dest.Album = src.Album()
 	dest.Artist = src.Artist()
 	dest.AlbumArtist = src.AlbumArtist()
+	dest.Compilation = IsCompilation(src)
 	dest.Composer = src.Composer()
 	dest.Genre = src.Genre()
 	dest.Year = src.Year()
+	dest.OriginalYear = readOriginalYear(src.Raw())
 
 	dest.Track, dest.TotalTracks = src.Track()
 	dest.Disc, dest.TotalDisks = src.Disc()
 
 	dest.Comment = src.Comment()
+	dest.BPM = readBPM(src.Raw())
+	dest.Key = readKey(src.Raw())
+	dest.SetAlbumKey(nil)
 
 	dest.Picture = picture
 	dest.Hash = hash
@@ -76,8 +168,33 @@ func NewMetadata(src tag.Metadata, picture string, hash string, path string) Met
 	return dest
 }
 
-// PieceIndex is an entry of index from text in a Metadata to the key of the Metadata.
+// PieceIndex is the index from text in a Metadata to the key of the Metadata.
+// There is one PieceIndex per Metadata, keyed by IndexKey.
 type PieceIndex struct {
+	// Grams are the grams generated from the Metadata; see Grams.
+	Grams [][]byte
+	// Tokens are the words in the Metadata; see Tokens.
+	Tokens [][]byte
+	// Phonetic are the phonetic keys of Artist and AlbumArtist; see PhoneticCodes.
+	Phonetic [][]byte
+	// Version is the IndexVersion the entity was built with.
+	Version int
+	// ConfigRevision is the IndexConfig.Revision the entity was built with.
+	ConfigRevision int
+	// Genre and AlbumArtist are normalized, and copied with Year from the
+	// Metadata so that searches can be filtered by them; see Filter.
+	// OriginalYear is the ReleaseYear of the Metadata.
+	Genre        string
+	AlbumArtist  string
+	Year         int
+	OriginalYear int
+
+	Value *datastore.Key
+}
+
+// LegacyPieceIndex is an entry of the legacy index, which had an entity per
+// gram. It is kept only for migrating to PieceIndex.
+type LegacyPieceIndex struct {
 	Key []byte
 
 	Value *datastore.Key
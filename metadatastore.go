@@ -0,0 +1,30 @@
+package benten
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by MetadataStore.Get for unknown pieces.
+var ErrNotFound = errors.New("not found")
+
+// MetadataStore stores the metadata of pieces by their paths. It replaces the
+// datastore in the standalone mode, where the pieces and the album pictures
+// are kept in a BlobStore as "<bucket>/<name>", in the same layout as a
+// replica. See the sqlite package for the implementation.
+type MetadataStore interface {
+	// Put creates or replaces the metadata of the piece at `m.Path`.
+	Put(ctx context.Context, m *Metadata) error
+	// Get returns the metadata of the piece at `path`.
+	Get(ctx context.Context, path string) (*Metadata, error)
+	// Delete deletes the metadata of the piece at `path`.
+	Delete(ctx context.Context, path string) error
+	// Search returns up to `limit` pieces whose title, album, artist or album
+	// artist contains `text`, ignoring case.
+	Search(ctx context.Context, text string, limit int) ([]Metadata, error)
+	// All returns up to `limit` pieces whose paths come after `after`, in
+	// the order of their paths.
+	All(ctx context.Context, after string, limit int) ([]Metadata, error)
+	// Close closes the store.
+	Close() error
+}
@@ -0,0 +1,54 @@
+package benten
+
+import (
+	"fmt"
+	"path"
+)
+
+// NamingScheme decides the names of the objects of pieces in PieceBucket.
+type NamingScheme string
+
+const (
+	// NamingHash names an object by Metadata.Hash, so that copies of a file
+	// share one object.
+	NamingHash NamingScheme = "hash"
+	// NamingPath names an object by the path of the file relative to the
+	// root of the library, such as "Artist/Album/01.mp3".
+	NamingPath NamingScheme = "path"
+	// NamingHybrid names an object "<hash>/<base name>", which is unique per
+	// contents and keeps the file name for downloads.
+	NamingHybrid NamingScheme = "hybrid"
+)
+
+// ParseNamingScheme parses `s`, which defaults to NamingHash when empty.
+func ParseNamingScheme(s string) (NamingScheme, error) {
+	switch scheme := NamingScheme(s); scheme {
+	case "":
+		return NamingHash, nil
+	case NamingHash, NamingPath, NamingHybrid:
+		return scheme, nil
+	}
+	return "", fmt.Errorf("unknown naming scheme: %s", s)
+}
+
+// ObjectName returns the name of the object of the file whose hash is `hash`
+// and whose slash-separated path relative to the root of the library is
+// `relativePath`.
+func (n NamingScheme) ObjectName(hash, relativePath string) string {
+	switch n {
+	case NamingPath:
+		return relativePath
+	case NamingHybrid:
+		return hash + "/" + path.Base(relativePath)
+	}
+	return hash
+}
+
+// ObjectName returns the name of the object of the piece in PieceBucket.
+// Pieces synced before naming schemes were recorded are named by their hashes.
+func (m *Metadata) ObjectName() string {
+	if m.Object == "" {
+		return m.Hash
+	}
+	return m.Object
+}
@@ -0,0 +1,31 @@
+package benten
+
+import "testing"
+
+func TestObjectName(t *testing.T) {
+	for _, c := range []struct {
+		scheme string
+		want   string
+	}{
+		{"", "abc"},
+		{"hash", "abc"},
+		{"path", "Artist/Album/01.mp3"},
+		{"hybrid", "abc/01.mp3"},
+	} {
+		scheme, err := ParseNamingScheme(c.scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scheme.ObjectName("abc", "Artist/Album/01.mp3"); got != c.want {
+			t.Errorf("ObjectName = %q for %q, want %q", got, c.scheme, c.want)
+		}
+	}
+	if _, err := ParseNamingScheme("random"); err == nil {
+		t.Errorf("an unknown scheme is accepted")
+	}
+
+	legacy := Metadata{Hash: "abc"}
+	if legacy.ObjectName() != "abc" {
+		t.Errorf("ObjectName = %q for a legacy piece", legacy.ObjectName())
+	}
+}
@@ -0,0 +1,41 @@
+package benten
+
+import (
+	"strconv"
+	"strings"
+)
+
+// originalDateTags are the raw tags holding the original release date: TDOR
+// (ID3v2.4), TORY and TOR (ID3v2.3 and 2.2), ORIGINALDATE and ORIGINALYEAR
+// (Vorbis comments and MP4 freeform atoms, as written by MusicBrainz Picard).
+var originalDateTags = []string{"TDOR", "TORY", "TOR", "originaldate", "ORIGINALDATE", "originalyear", "ORIGINALYEAR"}
+
+// readOriginalYear returns the year of the original release in `raw`, the
+// raw tags of a file, or zero if unknown.
+func readOriginalYear(raw map[string]interface{}) int {
+	for _, name := range originalDateTags {
+		s, ok := raw[name].(string)
+		if !ok {
+			continue
+		}
+		// "1979", "1979-11-30" or "1979-11".
+		s = strings.Trim(strings.TrimSpace(s), "\x00")
+		if len(s) > 4 {
+			s = s[:4]
+		}
+		if year, err := strconv.Atoi(s); err == nil && year > 0 {
+			return year
+		}
+	}
+	return 0
+}
+
+// ReleaseYear returns the year the recording of `m` was first released:
+// OriginalYear, or Year if that is unknown. Remasters and reissues are sorted
+// and filtered by it.
+func (m *Metadata) ReleaseYear() int {
+	if m.OriginalYear != 0 {
+		return m.OriginalYear
+	}
+	return m.Year
+}
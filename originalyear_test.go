@@ -0,0 +1,21 @@
+package benten
+
+import "testing"
+
+func TestReadOriginalYear(t *testing.T) {
+	for _, c := range []struct {
+		raw  map[string]interface{}
+		want int
+	}{
+		{map[string]interface{}{"TDOR": "1979-11-30"}, 1979},
+		{map[string]interface{}{"TORY": "1979"}, 1979},
+		{map[string]interface{}{"originaldate": "1979-11"}, 1979},
+		{map[string]interface{}{"TDOR": "", "originalyear": "1979"}, 1979},
+		{map[string]interface{}{"TDOR": "unknown"}, 0},
+		{nil, 0},
+	} {
+		if got := readOriginalYear(c.raw); got != c.want {
+			t.Errorf("readOriginalYear(%v) = %d, want %d", c.raw, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package benten
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PathTemplate infers tags from the paths of the files without them, such as
+// "{artist}/{album}/{track} - {title}". It matches the end of the path, the
+// extension aside unless it has one. The placeholders are {title}, {album},
+// {artist}, {albumartist}, {composer}, {genre}, and the numbers {track},
+// {disc} and {year}.
+type PathTemplate struct {
+	source  string
+	pattern *regexp.Regexp
+	// fields are the placeholders in order.
+	fields []string
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// pathTemplateFields are the placeholders and the fields they fill.
+var pathTemplateFields = map[string]func(m *Metadata, value string) (string, bool){
+	"title":       inferString("Title", func(m *Metadata) *string { return &m.Title }),
+	"album":       inferString("Album", func(m *Metadata) *string { return &m.Album }),
+	"artist":      inferString("Artist", func(m *Metadata) *string { return &m.Artist }),
+	"albumartist": inferString("AlbumArtist", func(m *Metadata) *string { return &m.AlbumArtist }),
+	"composer":    inferString("Composer", func(m *Metadata) *string { return &m.Composer }),
+	"genre":       inferString("Genre", func(m *Metadata) *string { return &m.Genre }),
+	"track":       inferInt("Track", func(m *Metadata) *int { return &m.Track }),
+	"disc":        inferInt("Disc", func(m *Metadata) *int { return &m.Disc }),
+	"year":        inferInt("Year", func(m *Metadata) *int { return &m.Year }),
+}
+
+// inferString sets the field to the value if it is empty, and returns its
+// name and true then.
+func inferString(name string, field func(m *Metadata) *string) func(m *Metadata, value string) (string, bool) {
+	return func(m *Metadata, value string) (string, bool) {
+		if *field(m) != "" || value == "" {
+			return "", false
+		}
+		*field(m) = value
+		return name, true
+	}
+}
+
+func inferInt(name string, field func(m *Metadata) *int) func(m *Metadata, value string) (string, bool) {
+	return func(m *Metadata, value string) (string, bool) {
+		n, err := strconv.Atoi(value)
+		if *field(m) != 0 || err != nil || n == 0 {
+			return "", false
+		}
+		*field(m) = n
+		return name, true
+	}
+}
+
+// ParsePathTemplate parses `s`.
+func ParsePathTemplate(s string) (*PathTemplate, error) {
+	t := &PathTemplate{source: s}
+	var b strings.Builder
+	b.WriteString(`(?:^|/)`)
+	last := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatchIndex(s, -1) {
+		name := s[m[2]:m[3]]
+		if _, ok := pathTemplateFields[name]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in %q", name, s)
+		}
+		b.WriteString(regexp.QuoteMeta(s[last:m[0]]))
+		switch name {
+		case "track", "disc", "year":
+			b.WriteString(`(\d+)`)
+		default:
+			b.WriteString(`([^/]+?)`)
+		}
+		t.fields = append(t.fields, name)
+		last = m[1]
+	}
+	if len(t.fields) == 0 {
+		return nil, fmt.Errorf("no placeholders in %q", s)
+	}
+	b.WriteString(regexp.QuoteMeta(s[last:]))
+	if path.Ext(s[last:]) == "" {
+		b.WriteString(`(?:\.[^./]*)?`)
+	}
+	b.WriteString(`$`)
+	pattern, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	t.pattern = pattern
+	return t, nil
+}
+
+func (t *PathTemplate) String() string {
+	return t.source
+}
+
+// Infer fills the empty fields of `m` from its Path, and returns false if the
+// path doesn't match. The filled fields are added to m.Inferred.
+func (t *PathTemplate) Infer(m *Metadata) bool {
+	match := t.pattern.FindStringSubmatch(strings.ReplaceAll(m.Path, "\\", "/"))
+	if match == nil {
+		return false
+	}
+	inferred := m.InferredFields()
+	for i, name := range t.fields {
+		if field, ok := pathTemplateFields[name](m, strings.TrimSpace(match[i+1])); ok {
+			inferred = append(inferred, field)
+		}
+	}
+	m.Inferred = strings.Join(inferred, ",")
+	m.SetAlbumKey(nil)
+	return true
+}
+
+// InferredFields returns the fields in m.Inferred.
+func (m *Metadata) InferredFields() []string {
+	if m.Inferred == "" {
+		return nil
+	}
+	return strings.Split(m.Inferred, ",")
+}
+
+// ClearInferred removes `field` from m.Inferred, such as when it has been
+// reviewed.
+func (m *Metadata) ClearInferred(field string) {
+	var kept []string
+	for _, f := range m.InferredFields() {
+		if f != field {
+			kept = append(kept, f)
+		}
+	}
+	m.Inferred = strings.Join(kept, ",")
+}
@@ -0,0 +1,39 @@
+package benten
+
+import "testing"
+
+func TestPathTemplate(t *testing.T) {
+	template, err := ParsePathTemplate("{artist}/{album}/{track} - {title}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Metadata{Path: `Music/Pink Floyd/Animals/02 - Dogs.mp3`, Album: "Animals (Remastered)"}
+	if !template.Infer(&m) {
+		t.Fatalf("%s doesn't match", m.Path)
+	}
+	if m.Artist != "Pink Floyd" || m.Album != "Animals (Remastered)" || m.Track != 2 || m.Title != "Dogs" {
+		t.Errorf("m = %+v", m)
+	}
+	if m.Inferred != "Artist,Track,Title" {
+		t.Errorf("Inferred = %q", m.Inferred)
+	}
+	m.ClearInferred("Track")
+	if m.Inferred != "Artist,Title" {
+		t.Errorf("Inferred = %q after clearing Track", m.Inferred)
+	}
+
+	m = Metadata{Path: `Artist/Album/Mr. Brown.mp3`}
+	if template.Infer(&m) {
+		t.Errorf("%s matches: %+v", m.Path, m)
+	}
+	m = Metadata{Path: `Prince/1999/01 - 1999.flac`}
+	if !template.Infer(&m) || m.Album != "1999" || m.Title != "1999" {
+		t.Errorf("m = %+v", m)
+	}
+
+	for _, s := range []string{"{artist}/{name}", "artist - title"} {
+		if _, err := ParsePathTemplate(s); err == nil {
+			t.Errorf("%q is parsed", s)
+		}
+	}
+}
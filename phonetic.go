@@ -0,0 +1,107 @@
+package benten
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Transliterations applied before Normalize strips diacritics, so that e.g.
+// "Č" and "ch" end up the same.
+var phoneticTransliterations = strings.NewReplacer(
+	"č", "ch",
+	"ć", "ch",
+	"š", "sh",
+	"ś", "sh",
+	"ž", "zh",
+	"ź", "zh",
+	"ł", "l",
+)
+
+// Multi-letter spellings of the same sound, longest first.
+var phoneticSpellings = strings.NewReplacer(
+	"tsch", "x",
+	"tch", "x",
+	"sch", "x",
+	"ch", "x",
+	"sh", "x",
+	"cz", "x",
+	"zh", "x",
+	"ph", "f",
+	"th", "t",
+	"ck", "k",
+	"kh", "x",
+	"gh", "g",
+)
+
+// phoneticNormalize transliterates and normalizes `text`.
+func phoneticNormalize(text string) string {
+	return Normalize(phoneticTransliterations.Replace(norm.NFC.String(strings.ToLower(text))))
+}
+
+func isVowel(c byte) bool {
+	return strings.IndexByte("aeiouy", c) >= 0
+}
+
+// phoneticCode returns the phonetic key of a word. It is a simplified
+// Metaphone tuned for transliterated names: vowels other than the first
+// letter are dropped, consonants which sound alike share a code, and repeated
+// codes collapse, so "Tchaikovsky", "Chaikovsky" and "Čajkovskij" share a key.
+func phoneticCode(word string) string {
+	word = phoneticSpellings.Replace(phoneticNormalize(word))
+
+	var code strings.Builder
+	last := byte(0)
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		var out byte
+		switch {
+		case isVowel(c) || c == 'j':
+			if i == 0 {
+				out = 'a'
+			}
+		case c == 'h':
+			// Silent in most transliterations.
+		case c == 'c':
+			if i+1 < len(word) && strings.IndexByte("eiy", word[i+1]) >= 0 {
+				out = 's'
+			} else {
+				out = 'k'
+			}
+		case c == 'q':
+			out = 'k'
+		case c == 'v' || c == 'w':
+			out = 'f'
+		case c == 'z':
+			out = 's'
+		case c == 'x':
+			out = 'x'
+		case c >= 'a' && c <= 'z':
+			out = c
+		case c >= '0' && c <= '9':
+			out = c
+		}
+		if out == 0 {
+			// A vowel separates repeated consonants only in the spelling.
+			continue
+		}
+		if out != last {
+			code.WriteByte(out)
+		}
+		last = out
+	}
+	return strings.ToUpper(code.String())
+}
+
+// PhoneticCodes returns the phonetic keys of the words in `texts`.
+func PhoneticCodes(texts ...string) map[string]struct{} {
+	codes := make(map[string]struct{})
+	for _, text := range texts {
+		for _, word := range tokenize(phoneticNormalize(text)) {
+			if code := phoneticCode(word); code != "" {
+				codes[code] = struct{}{}
+			}
+		}
+	}
+	return codes
+}
@@ -0,0 +1,24 @@
+package benten
+
+import (
+	"testing"
+)
+
+func TestPhoneticCode(t *testing.T) {
+	groups := [][]string{
+		{"Tchaikovsky", "Chaikovsky", "Čajkovskij", "Tschaikowsky"},
+		{"Dvořák", "Dvorak"},
+		{"Rachmaninoff", "Rakhmaninov"},
+	}
+	for _, group := range groups {
+		expected := phoneticCode(group[0])
+		for _, word := range group[1:] {
+			if code := phoneticCode(word); code != expected {
+				t.Errorf("phoneticCode(%q) = %q, want %q", word, code, expected)
+			}
+		}
+	}
+	if phoneticCode("Bach") == phoneticCode("Beethoven") {
+		t.Errorf("Bach and Beethoven must differ")
+	}
+}
@@ -0,0 +1,94 @@
+package benten
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Playlist is an ordered list of pieces, keyed by PlaylistKey(Name).
+type Playlist struct {
+	Name string
+	// Pieces are the keys of the pieces in order.
+	Pieces []*datastore.Key
+	// Source is where the playlist was imported from, such as "itunes", or
+	// the empty string if it was created in benten.
+	Source string
+	// Updated is when the playlist was last modified.
+	Updated time.Time
+}
+
+// PlaylistKey returns the key of the playlist named `name`.
+func PlaylistKey(name string) *datastore.Key {
+	return datastore.NameKey(PlaylistKind, name, nil)
+}
+
+// Rating is the rating and the play statistics of a piece, keyed by RatingKey.
+type Rating struct {
+	// Stars is the rating from 1 to 5, or zero when the piece is unrated.
+	Stars int
+	// Starred is true when the piece is a favorite.
+	Starred bool
+	// PlayCount is the number of times the piece was played.
+	PlayCount int
+	// LastPlayed is when the piece was last played, or the zero time.
+	LastPlayed time.Time
+}
+
+// RatingKey returns the key of the Rating of the piece stored at `piece`.
+func RatingKey(piece *datastore.Key) *datastore.Key {
+	return datastore.IDKey(RatingKind, 1, piece)
+}
+
+// Merge merges `other`, imported from another library, into `r`. The higher
+// play count and the later play time win, and `other` fills in a missing
+// rating.
+func (r *Rating) Merge(other Rating) {
+	if r.Stars == 0 {
+		r.Stars = other.Stars
+	}
+	r.Starred = r.Starred || other.Starred
+	if other.PlayCount > r.PlayCount {
+		r.PlayCount = other.PlayCount
+	}
+	if other.LastPlayed.After(r.LastPlayed) {
+		r.LastPlayed = other.LastPlayed
+	}
+}
+
+// MergeRatings merges `ratings` into the stored ratings of the pieces at `keys`.
+func MergeRatings(ctx context.Context, client *datastore.Client, keys []*datastore.Key, ratings []Rating) error {
+	for i := 0; i < len(keys); i += 500 {
+		end := i + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ratingKeys := make([]*datastore.Key, 0, end-i)
+		for _, key := range keys[i:end] {
+			ratingKeys = append(ratingKeys, RatingKey(key))
+		}
+		_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			stored := make([]Rating, len(ratingKeys))
+			err := tx.GetMulti(ratingKeys, stored)
+			if multi, ok := err.(datastore.MultiError); ok {
+				for _, e := range multi {
+					if e != nil && e != datastore.ErrNoSuchEntity {
+						return err
+					}
+				}
+			} else if err != nil {
+				return err
+			}
+			for j := range stored {
+				stored[j].Merge(ratings[i+j])
+			}
+			_, err = tx.PutMulti(ratingKeys, stored)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
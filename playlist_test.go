@@ -0,0 +1,15 @@
+package benten
+
+import "testing"
+
+func TestRatingMerge(t *testing.T) {
+	r := Rating{PlayCount: 3}
+	r.Merge(Rating{Stars: 4, Starred: true, PlayCount: 2})
+	if r != (Rating{Stars: 4, Starred: true, PlayCount: 3}) {
+		t.Errorf("r = %+v", r)
+	}
+	r.Merge(Rating{Stars: 1, PlayCount: 5})
+	if r.Stars != 4 || r.PlayCount != 5 || !r.Starred {
+		t.Errorf("r = %+v", r)
+	}
+}
@@ -0,0 +1,158 @@
+package benten
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// PlaylistEntry is an entry of a playlist file.
+type PlaylistEntry struct {
+	// Path is the path or the URL of the file.
+	Path string
+	// Title and Artist are given by the playlist file, or guessed from the file
+	// name in the "Artist - Title" form.
+	Title  string
+	Artist string
+}
+
+// ParsePlaylistFile parses an M3U, M3U8 or PLS playlist. `name` is the file
+// name, whose extension chooses the format; PLS is also detected by its
+// "[playlist]" header.
+func ParsePlaylistFile(name string, r io.Reader) ([]PlaylistEntry, error) {
+	reader := bufio.NewReader(r)
+	head, _ := reader.Peek(64)
+	pls := strings.EqualFold(path.Ext(name), ".pls") ||
+		strings.HasPrefix(strings.ToLower(strings.TrimSpace(strings.TrimPrefix(string(head), "\ufeff"))), "[playlist]")
+	var entries []PlaylistEntry
+	var err error
+	if pls {
+		entries, err = parsePLS(reader)
+	} else {
+		entries, err = parseM3U(reader)
+	}
+	for i := range entries {
+		if entries[i].Title == "" {
+			entries[i].Artist, entries[i].Title = guessTags(entries[i].Path)
+		}
+	}
+	return entries, err
+}
+
+// splitArtistTitle splits "Artist - Title".
+func splitArtistTitle(s string) (string, string) {
+	if i := strings.Index(s, " - "); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+3:])
+	}
+	return "", strings.TrimSpace(s)
+}
+
+// guessTags guesses the artist and the title from the file name, ignoring a
+// leading track number.
+func guessTags(p string) (string, string) {
+	base := path.Base(strings.Replace(p, "\\", "/", -1))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	base = strings.TrimLeft(base, "0123456789. ")
+	return splitArtistTitle(base)
+}
+
+func parseM3U(r io.Reader) ([]PlaylistEntry, error) {
+	var entries []PlaylistEntry
+	var pending PlaylistEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<artist> - <title>
+			if i := strings.Index(line, ","); i >= 0 {
+				pending.Artist, pending.Title = splitArtistTitle(line[i+1:])
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Path = line
+			entries = append(entries, pending)
+			pending = PlaylistEntry{}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func parsePLS(r io.Reader) ([]PlaylistEntry, error) {
+	byNumber := make(map[int]*PlaylistEntry)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key, value := strings.ToLower(line[:i]), strings.TrimSpace(line[i+1:])
+		var field string
+		for _, f := range []string{"file", "title"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+		entry, ok := byNumber[n]
+		if !ok {
+			entry = &PlaylistEntry{}
+			byNumber[n] = entry
+		}
+		if field == "file" {
+			entry.Path = value
+		} else {
+			entry.Artist, entry.Title = splitArtistTitle(value)
+		}
+	}
+	numbers := make([]int, 0, len(byNumber))
+	for n, entry := range byNumber {
+		if entry.Path != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	entries := make([]PlaylistEntry, 0, len(numbers))
+	for _, n := range numbers {
+		entries = append(entries, *byNumber[n])
+	}
+	return entries, scanner.Err()
+}
+
+// ImportPlaylist resolves `entries` with `matcher` and puts them as the
+// playlist `name`, replacing an existing one. It returns the playlist and
+// the entries which matched no piece.
+func ImportPlaylist(ctx context.Context, client *datastore.Client, matcher *PieceMatcher, name string, entries []PlaylistEntry) (*Playlist, []PlaylistEntry, error) {
+	playlist := &Playlist{Name: name, Source: "file", Updated: time.Now()}
+	unresolved := make([]PlaylistEntry, 0)
+	for _, entry := range entries {
+		key := matcher.ByPath(entry.Path)
+		if key == nil && entry.Title != "" {
+			key = matcher.ByTitleArtist(entry.Title, entry.Artist)
+		}
+		if key == nil {
+			unresolved = append(unresolved, entry)
+			continue
+		}
+		playlist.Pieces = append(playlist.Pieces, key)
+	}
+	if _, err := client.Put(ctx, PlaylistKey(name), playlist); err != nil {
+		return nil, nil, err
+	}
+	return playlist, unresolved, nil
+}
@@ -0,0 +1,32 @@
+package benten
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePlaylistFile(t *testing.T) {
+	m3u := "#EXTM3U\n#EXTINF:123,The Beatles - Help!\nBeatles/Help.mp3\n\n# comment\nC:\\Music\\03 Queen - Bohemian Rhapsody.flac\n"
+	entries, err := ParsePlaylistFile("a.m3u8", strings.NewReader(m3u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PlaylistEntry{
+		{Path: "Beatles/Help.mp3", Title: "Help!", Artist: "The Beatles"},
+		{Path: `C:\Music\03 Queen - Bohemian Rhapsody.flac`, Title: "Bohemian Rhapsody", Artist: "Queen"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	pls := "[playlist]\nFile2=b.mp3\nTitle2=B - Two\nFile1=a.mp3\nNumberOfEntries=2\nVersion=2\n"
+	entries, err = ParsePlaylistFile("list", strings.NewReader(pls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []PlaylistEntry{{Path: "a.mp3", Title: "a"}, {Path: "b.mp3", Title: "Two", Artist: "B"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v", entries)
+	}
+}
@@ -0,0 +1,26 @@
+package benten
+
+import (
+	"time"
+)
+
+// PodcastEpisodesOnSubscribe is the number of the latest episodes downloaded
+// when a podcast is subscribed to, unless specified.
+var PodcastEpisodesOnSubscribe = 3
+
+// Podcast is a subscribed feed, whose new episodes are downloaded into
+// PieceBucket as pieces with Metadata.Podcast set to the ID of the Podcast.
+type Podcast struct {
+	// FeedURL is the URL of the RSS feed.
+	FeedURL string
+	Title   string
+	// Subscribed is when the podcast was subscribed to.
+	Subscribed time.Time
+	// Latest is when the newest episode downloaded was published. Episodes
+	// published after it are new.
+	Latest time.Time
+	// Checked is when the feed was last read.
+	Checked time.Time
+	// Error is the error of the last check, or the empty string.
+	Error string `datastore:",noindex"`
+}
@@ -0,0 +1,180 @@
+// Package podcast reads podcast RSS feeds.
+package podcast
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userAgent identifies benten to the feeds.
+const userAgent = "benten/1.0 (https://github.com/yutakahirano/benten)"
+
+// The maximum size of a feed, which may list thousands of episodes.
+const maxFeedSize = 32 << 20
+
+// Feed is a podcast.
+type Feed struct {
+	Title  string
+	Author string
+	// Episodes are in the order of the feed, which is usually the newest
+	// first.
+	Episodes []Episode
+}
+
+// Episode is an episode with an audio enclosure.
+type Episode struct {
+	// GUID identifies the episode in the feed. It is the enclosure URL for
+	// feeds without guids.
+	GUID        string
+	Title       string
+	Description string
+	Published   time.Time
+	// URL is the URL of the audio file.
+	URL string
+	// MIMEType is the type of the audio file, such as "audio/mpeg".
+	MIMEType string
+	// Length is the size of the audio file in bytes, or zero if unknown.
+	Length int64
+	// Duration is the length of the episode, or zero if unknown.
+	Duration time.Duration
+}
+
+type rss struct {
+	Channel struct {
+		Title  string `xml:"title"`
+		Author string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		Items  []struct {
+			GUID        string `xml:"guid"`
+			Title       string `xml:"title"`
+			Description string `xml:"description"`
+			Summary     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+			PubDate     string `xml:"pubDate"`
+			Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure   struct {
+				URL    string `xml:"url,attr"`
+				Type   string `xml:"type,attr"`
+				Length string `xml:"length,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// Parse reads an RSS feed. Items without audio enclosures, such as
+// announcements and videos, are skipped.
+func Parse(r io.Reader) (*Feed, error) {
+	var doc rss
+	decoder := xml.NewDecoder(r)
+	// Feeds declare all sorts of encodings, but are UTF-8 in practice.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	feed := &Feed{Title: strings.TrimSpace(doc.Channel.Title), Author: strings.TrimSpace(doc.Channel.Author)}
+	if feed.Title == "" {
+		return nil, fmt.Errorf("the feed has no title")
+	}
+	for _, item := range doc.Channel.Items {
+		enclosure := item.Enclosure
+		if enclosure.URL == "" || (enclosure.Type != "" && !strings.HasPrefix(enclosure.Type, "audio/")) {
+			continue
+		}
+		episode := Episode{
+			GUID:        strings.TrimSpace(item.GUID),
+			Title:       strings.TrimSpace(item.Title),
+			Description: strings.TrimSpace(item.Description),
+			Published:   parseDate(item.PubDate),
+			URL:         enclosure.URL,
+			MIMEType:    enclosure.Type,
+			Duration:    parseDuration(item.Duration),
+		}
+		if episode.GUID == "" {
+			episode.GUID = enclosure.URL
+		}
+		if episode.Description == "" {
+			episode.Description = strings.TrimSpace(item.Summary)
+		}
+		episode.Length, _ = strconv.ParseInt(enclosure.Length, 10, 64)
+		feed.Episodes = append(feed.Episodes, episode)
+	}
+	return feed, nil
+}
+
+// Fetch gets and parses the feed at `url`.
+func Fetch(ctx context.Context, client *http.Client, url string) (*Feed, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return Parse(io.LimitReader(res.Body, maxFeedSize))
+}
+
+// NewEpisodes returns the episodes published after `after`, the oldest first.
+// Episodes without dates are never new.
+func (f *Feed) NewEpisodes(after time.Time) []Episode {
+	var episodes []Episode
+	for _, episode := range f.Episodes {
+		if episode.Published.After(after) {
+			episodes = append(episodes, episode)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool { return episodes[i].Published.Before(episodes[j].Published) })
+	return episodes
+}
+
+// Latest returns the publication time of the `n`th newest episode, or the zero
+// time if there are not as many, so that NewEpisodes(f.Latest(n + 1)) are the
+// newest n episodes.
+func (f *Feed) Latest(n int) time.Time {
+	episodes := f.NewEpisodes(time.Time{})
+	if n <= 0 || n > len(episodes) {
+		return time.Time{}
+	}
+	return episodes[len(episodes)-n].Published
+}
+
+// dateLayouts are the formats of pubDate found in the wild, RFC 822 and its
+// variants.
+var dateLayouts = []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700", time.RFC3339}
+
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseDuration parses an itunes:duration, which is "H:MM:SS", "MM:SS" or
+// seconds.
+func parseDuration(s string) time.Duration {
+	var seconds int
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,61 @@
+package podcast
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+  <title>Radiolab</title>
+  <itunes:author>WNYC Studios</itunes:author>
+  <item>
+    <title>Episode 3</title>
+    <guid>ep3</guid>
+    <pubDate>Wed, 03 Jun 2020 12:00:00 +0000</pubDate>
+    <itunes:duration>1:02:03</itunes:duration>
+    <enclosure url="https://example.com/3.mp3" type="audio/mpeg" length="1234"/>
+  </item>
+  <item>
+    <title>Video</title>
+    <pubDate>Tue, 02 Jun 2020 12:00:00 +0000</pubDate>
+    <enclosure url="https://example.com/2.mp4" type="video/mp4"/>
+  </item>
+  <item>
+    <title>Episode 1</title>
+    <itunes:summary>The first one</itunes:summary>
+    <pubDate>Mon, 1 Jun 2020 12:00:00 GMT</pubDate>
+    <itunes:duration>95</itunes:duration>
+    <enclosure url="https://example.com/1.mp3" type="audio/mpeg"/>
+  </item>
+</channel>
+</rss>`
+
+func TestParse(t *testing.T) {
+	feed, err := Parse(strings.NewReader(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Radiolab" || feed.Author != "WNYC Studios" || len(feed.Episodes) != 2 {
+		t.Fatalf("feed = %+v", feed)
+	}
+	first := feed.Episodes[1]
+	if first.GUID != "https://example.com/1.mp3" || first.Description != "The first one" || first.Duration != 95*time.Second || first.Published.Day() != 1 {
+		t.Errorf("first = %+v", first)
+	}
+	if third := feed.Episodes[0]; third.Duration != time.Hour+2*time.Minute+3*time.Second || third.Length != 1234 {
+		t.Errorf("third = %+v", third)
+	}
+
+	if episodes := feed.NewEpisodes(feed.Latest(2)); len(episodes) != 1 || episodes[0].GUID != "ep3" {
+		t.Errorf("NewEpisodes(Latest(2)) = %+v", episodes)
+	}
+	if episodes := feed.NewEpisodes(feed.Latest(3)); len(episodes) != 2 || episodes[0].Title != "Episode 1" {
+		t.Errorf("NewEpisodes(Latest(3)) = %+v", episodes)
+	}
+	if episodes := feed.NewEpisodes(feed.Episodes[0].Published); len(episodes) != 0 {
+		t.Errorf("NewEpisodes(the latest) = %+v", episodes)
+	}
+}
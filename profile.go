@@ -0,0 +1,93 @@
+package benten
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Networks a client streams over.
+const (
+	NetworkWiFi     = "wifi"
+	NetworkCellular = "cellular"
+)
+
+// StreamPreference is how a client wants pieces streamed over a network.
+type StreamPreference struct {
+	// MaxBitrate is the maximum bitrate in kbps, or zero for no limit.
+	MaxBitrate int
+	// Codec is the preferred codec, such as "opus", or empty for any.
+	Codec string
+}
+
+// ClientProfile is the streaming preferences of a device, keyed by
+// ClientProfileKey(Device), so that the device doesn't need to ask for a
+// quality on every request.
+type ClientProfile struct {
+	// Device is the ID the device sends, such as "pixel".
+	Device   string
+	WiFi     StreamPreference
+	Cellular StreamPreference
+	Updated  time.Time
+}
+
+// ClientProfileKey returns the key of the ClientProfile of `device`.
+func ClientProfileKey(device string) *datastore.Key {
+	return datastore.NameKey(ClientProfileKind, device, nil)
+}
+
+// Quality returns the best quality of `piece` (see SelectRendition) within
+// the preference of `network`, which is NetworkWiFi unless NetworkCellular.
+// The preferred codec is chosen if it fits the bitrate. When nothing fits,
+// the smallest rendition is chosen. The original fits a limit only if its
+// bitrate is known.
+func (p *ClientProfile) Quality(piece *Metadata, network string) string {
+	pref := p.WiFi
+	if network == NetworkCellular {
+		pref = p.Cellular
+	}
+	type option struct {
+		quality string
+		codec   string
+		bitrate int
+	}
+	// From the best.
+	options := []option{{QualityOriginal, piece.FileType, piece.Bitrate}}
+	if r := piece.Renditions.High; r.Object != "" {
+		options = append(options, option{QualityHigh, r.Codec, r.Bitrate})
+	}
+	if r := piece.Renditions.Low; r.Object != "" {
+		options = append(options, option{QualityLow, r.Codec, r.Bitrate})
+	}
+	fits := func(o option) bool {
+		return pref.MaxBitrate == 0 || (o.bitrate != 0 && o.bitrate <= pref.MaxBitrate)
+	}
+	for _, o := range options {
+		if fits(o) && (pref.Codec == "" || strings.EqualFold(o.codec, pref.Codec)) {
+			return o.quality
+		}
+	}
+	for _, o := range options {
+		if fits(o) {
+			return o.quality
+		}
+	}
+	return options[len(options)-1].quality
+}
+
+// Summary returns a short description of `p` for audit logs.
+func (p *ClientProfile) Summary() string {
+	pref := func(s StreamPreference) string {
+		limit := "any"
+		if s.MaxBitrate != 0 {
+			limit = fmt.Sprintf("%d kbps", s.MaxBitrate)
+		}
+		if s.Codec != "" {
+			limit += " " + s.Codec
+		}
+		return limit
+	}
+	return fmt.Sprintf("wifi: %s, cellular: %s", pref(p.WiFi), pref(p.Cellular))
+}
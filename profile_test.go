@@ -0,0 +1,35 @@
+package benten
+
+import "testing"
+
+func TestClientProfileQuality(t *testing.T) {
+	piece := &Metadata{FileType: "FLAC", Bitrate: 900, Renditions: Renditions{
+		Low:  Rendition{Object: "low", Codec: "opus", Bitrate: 96},
+		High: Rendition{Object: "high", Codec: "aac", Bitrate: 256},
+	}}
+	profile := &ClientProfile{
+		WiFi:     StreamPreference{},
+		Cellular: StreamPreference{MaxBitrate: 320, Codec: "opus"},
+	}
+	for _, c := range []struct {
+		piece   *Metadata
+		network string
+		want    string
+	}{
+		{piece, NetworkWiFi, QualityOriginal},
+		{piece, "", QualityOriginal},
+		// Opus is preferred over the better AAC.
+		{piece, NetworkCellular, QualityLow},
+		// Nothing fits but the original.
+		{&Metadata{FileType: "MP3"}, NetworkCellular, QualityOriginal},
+	} {
+		if got := profile.Quality(c.piece, c.network); got != c.want {
+			t.Errorf("Quality(%+v, %q) = %q, want %q", c.piece, c.network, got, c.want)
+		}
+	}
+	profile.Cellular.Codec = "flac"
+	// The preferred codec doesn't fit.
+	if got := profile.Quality(piece, NetworkCellular); got != QualityHigh {
+		t.Errorf("Quality = %q, want %q", got, QualityHigh)
+	}
+}
@@ -0,0 +1,172 @@
+package benten
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// IsLossless returns true if pieces of `fileType` (Metadata.FileType) are
+// losslessly encoded.
+func IsLossless(fileType string) bool {
+	switch tag.FileType(strings.ToUpper(fileType)) {
+	case tag.FLAC, tag.ALAC, tag.DSF:
+		return true
+	}
+	return false
+}
+
+// Layer III bitrates in kbps by the bitrate index, for MPEG-1 and for MPEG-2
+// and 2.5, and sample rates by the version and the sample rate index.
+var (
+	mpeg1Bitrates   = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates   = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mpegSampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+)
+
+// MPEGFrame is what the header of an MPEG Layer III frame tells.
+type MPEGFrame struct {
+	// Bitrate is in kbps.
+	Bitrate    int
+	SampleRate int
+	// Size is the number of bytes of the frame, including the header.
+	Size int
+	// Samples is the number of samples per channel in the frame.
+	Samples int
+}
+
+// ParseMPEGFrame parses the first four bytes of `h` as the header of an MPEG
+// Layer III frame. It returns false if they are not one, or one of a free
+// format stream, whose frame size is unknown.
+func ParseMPEGFrame(h []byte) (MPEGFrame, bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return MPEGFrame{}, false
+	}
+	version := (h[1] >> 3) & 3
+	layer := (h[1] >> 1) & 3
+	bitrateIndex := h[2] >> 4
+	rateIndex := (h[2] >> 2) & 3
+	if version == 1 || layer != 1 || rateIndex == 3 {
+		return MPEGFrame{}, false
+	}
+	f := MPEGFrame{SampleRate: mpegSampleRates[version][rateIndex]}
+	padding := int(h[2]>>1) & 1
+	if version == 3 {
+		f.Bitrate = mpeg1Bitrates[bitrateIndex]
+		f.Size = 144*f.Bitrate*1000/f.SampleRate + padding
+		f.Samples = 1152
+	} else {
+		f.Bitrate = mpeg2Bitrates[bitrateIndex]
+		f.Size = 72*f.Bitrate*1000/f.SampleRate + padding
+		f.Samples = 576
+	}
+	if f.Bitrate == 0 {
+		return MPEGFrame{}, false
+	}
+	return f, true
+}
+
+// Duration returns the playing time of the frame.
+func (f MPEGFrame) Duration() time.Duration {
+	return time.Duration(f.Samples) * time.Second / time.Duration(f.SampleRate)
+}
+
+// stream is what ReadBitrate and ReadDuration read from the audio stream.
+type stream struct {
+	// bitrate is the average bitrate in kbps, or zero if unknown.
+	bitrate int
+	// seconds is the duration, or zero if unknown.
+	seconds float64
+}
+
+// ReadBitrate returns the average bitrate in kbps of the file `r` of `size`
+// bytes whose tags are `tags`. It knows MP3, from the Xing header of VBR
+// files or the first frame of CBR ones, and FLAC, from the number of
+// samples; it returns zero for the other formats. The position of `r` is
+// kept, like ReadGapless.
+func ReadBitrate(r io.ReadSeeker, tags tag.Metadata, size int64) (int, error) {
+	s, err := readStream(r, tags, size)
+	return s.bitrate, err
+}
+
+// ReadDuration returns the duration of the file `r` like ReadBitrate. It is
+// exact for FLAC and VBR MP3 files, and estimated from the bitrate for CBR
+// ones.
+func ReadDuration(r io.ReadSeeker, tags tag.Metadata, size int64) (time.Duration, error) {
+	s, err := readStream(r, tags, size)
+	return time.Duration(s.seconds * float64(time.Second)), err
+}
+
+func readStream(r io.ReadSeeker, tags tag.Metadata, size int64) (stream, error) {
+	if tags.FileType() != tag.MP3 && tags.FileType() != tag.FLAC {
+		return stream{}, nil
+	}
+	position, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return stream{}, err
+	}
+	var s stream
+	if tags.FileType() == tag.MP3 {
+		s, err = readMP3Stream(r, size)
+	} else {
+		s, err = readFLACStream(r, size)
+	}
+	if _, seekErr := r.Seek(position, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	return s, err
+}
+
+func readMP3Stream(r io.ReadSeeker, size int64) (stream, error) {
+	b, offset, err := firstMPEGFrame(r)
+	if b == nil {
+		return stream{}, err
+	}
+	frame, ok := ParseMPEGFrame(b)
+	if !ok {
+		return stream{}, nil
+	}
+	cbr := stream{bitrate: frame.Bitrate, seconds: float64(size-offset) * 8 / float64(frame.Bitrate*1000)}
+	xing, samplesPerFrame := xingHeader(b)
+	if xing == nil || binary.BigEndian.Uint32(xing[4:8])&1 == 0 || len(xing) < 12 {
+		// CBR, or a VBR file without the number of frames.
+		return cbr, nil
+	}
+	frames := int64(binary.BigEndian.Uint32(xing[8:12]))
+	if frames == 0 {
+		return cbr, nil
+	}
+	seconds := float64(frames*samplesPerFrame) / float64(frame.SampleRate)
+	return stream{bitrate: int(float64(size) * 8 / seconds / 1000), seconds: seconds}, nil
+}
+
+// readFLACStream reads the sample rate and the number of samples from
+// STREAMINFO, the first metadata block.
+func readFLACStream(r io.ReadSeeker, size int64) (stream, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return stream{}, err
+	}
+	// "fLaC", the block header and STREAMINFO, whose sample rate (20 bits),
+	// channels (3), bits per sample (5) and number of samples (36) start at
+	// the 10th byte.
+	var b [4 + 4 + 18]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil || string(b[:4]) != "fLaC" {
+		return stream{}, nil
+	}
+	info := b[8:]
+	sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+	samples := int64(info[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || samples == 0 {
+		return stream{}, nil
+	}
+	seconds := float64(samples) / float64(sampleRate)
+	return stream{bitrate: int(float64(size) * 8 / seconds / 1000), seconds: seconds}, nil
+}
@@ -0,0 +1,70 @@
+package benten
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIsLossless(t *testing.T) {
+	if !IsLossless("FLAC") || !IsLossless("alac") || IsLossless("MP3") || IsLossless("") {
+		t.Errorf("IsLossless is wrong")
+	}
+}
+
+func TestParseMPEGFrame(t *testing.T) {
+	for _, c := range []struct {
+		header []byte
+		want   MPEGFrame
+		ok     bool
+	}{
+		{[]byte{0xff, 0xfb, 0x90, 0x64}, MPEGFrame{Bitrate: 128, SampleRate: 44100, Size: 417, Samples: 1152}, true},
+		{[]byte{0xff, 0xf3, 0x90, 0x64}, MPEGFrame{Bitrate: 80, SampleRate: 22050, Size: 261, Samples: 576}, true},
+		// Layer II, and the free format.
+		{[]byte{0xff, 0xfd, 0x90, 0x64}, MPEGFrame{}, false},
+		{[]byte{0xff, 0xfb, 0x00, 0x64}, MPEGFrame{}, false},
+		{[]byte("fLaC"), MPEGFrame{}, false},
+	} {
+		if got, ok := ParseMPEGFrame(c.header); got != c.want || ok != c.ok {
+			t.Errorf("ParseMPEGFrame(% x) = %+v, %v", c.header, got, ok)
+		}
+	}
+	if d := (MPEGFrame{SampleRate: 44100, Samples: 1152}).Duration(); d != 26122448*time.Nanosecond {
+		t.Errorf("Duration = %v", d)
+	}
+}
+
+func TestReadMP3Stream(t *testing.T) {
+	// A 128 kbps 44.1 kHz MPEG-1 Layer III frame.
+	frame := []byte{0xff, 0xfb, 0x90, 0x64}
+	cbr := append(append([]byte{}, frame...), make([]byte, 400)...)
+	if got, err := readMP3Stream(bytes.NewReader(cbr), 1000); got != (stream{128, 0.0625}) || err != nil {
+		t.Errorf("readMP3Stream = %v, %v for CBR, want 128 kbps for 0.0625s", got, err)
+	}
+
+	var vbr bytes.Buffer
+	vbr.Write(frame)
+	vbr.Write(make([]byte, 32))
+	// 100 frames of 1152 samples.
+	vbr.WriteString("Xing\x00\x00\x00\x01")
+	vbr.Write([]byte{0, 0, 0, 100})
+	vbr.Write(make([]byte, 100))
+	// 192 kbps for the 2.6 seconds.
+	if got, err := readMP3Stream(bytes.NewReader(vbr.Bytes()), 62694); got.bitrate != 192 || math.Abs(got.seconds-2.612) > 0.001 || err != nil {
+		t.Errorf("readMP3Stream = %v, %v for VBR, want 192 kbps for 2.6s", got, err)
+	}
+}
+
+func TestReadFLACStream(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+	b.Write([]byte{0x80, 0, 0, 34})
+	b.Write(make([]byte, 10))
+	// 44100 Hz, stereo, 16 bits and 441000 samples.
+	b.Write([]byte{0x0a, 0xc4, 0x42, 0xf0, 0x00, 0x06, 0xba, 0xa8})
+	b.Write(make([]byte, 16))
+	if got, err := readFLACStream(bytes.NewReader(b.Bytes()), 1250000); got != (stream{1000, 10}) || err != nil {
+		t.Errorf("readFLACStream = %v, %v, want 1000 kbps for 10s", got, err)
+	}
+}
@@ -0,0 +1,65 @@
+package benten
+
+import "fmt"
+
+// Qualities of the renditions of a piece.
+const (
+	QualityLow      = "low"
+	QualityHigh     = "high"
+	QualityOriginal = "original"
+)
+
+// Rendition is a transcode of a piece, such as an Opus file for slow
+// connections. It is linked from the Metadata of the original instead of
+// being a piece, so that the library lists the original only.
+type Rendition struct {
+	// Object is the name of the object in PieceBucket, or empty if there is
+	// no rendition.
+	Object string
+	// Codec is the codec of the rendition, such as "opus", which is also the
+	// extension of its file.
+	Codec string
+	// Bitrate is in kbps.
+	Bitrate int
+}
+
+// Renditions are the renditions of a piece by quality.
+type Renditions struct {
+	Low  Rendition
+	High Rendition
+}
+
+// SelectRendition returns the object in PieceBucket of `quality` and its
+// codec, which is empty for the original. A missing rendition falls back to
+// the next better one; the empty quality is QualityOriginal.
+func (m *Metadata) SelectRendition(quality string) (string, string, error) {
+	switch quality {
+	case QualityLow:
+		if r := m.Renditions.Low; r.Object != "" {
+			return r.Object, r.Codec, nil
+		}
+		fallthrough
+	case QualityHigh:
+		if r := m.Renditions.High; r.Object != "" {
+			return r.Object, r.Codec, nil
+		}
+		fallthrough
+	case "", QualityOriginal:
+		return m.ObjectName(), "", nil
+	}
+	return "", "", fmt.Errorf("quality (%v) is invalid", quality)
+}
+
+// SetRendition links `r` as the rendition of `quality`, which is QualityLow
+// or QualityHigh. The transcoder calls this after uploading it.
+func (m *Metadata) SetRendition(quality string, r Rendition) error {
+	switch quality {
+	case QualityLow:
+		m.Renditions.Low = r
+	case QualityHigh:
+		m.Renditions.High = r
+	default:
+		return fmt.Errorf("quality (%v) is invalid", quality)
+	}
+	return nil
+}
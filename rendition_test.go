@@ -0,0 +1,29 @@
+package benten
+
+import "testing"
+
+func TestSelectRendition(t *testing.T) {
+	piece := &Metadata{Object: "original.flac"}
+	if err := piece.SetRendition(QualityHigh, Rendition{Object: "high.opus", Codec: "opus", Bitrate: 160}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		quality, object, codec string
+	}{
+		{QualityLow, "high.opus", "opus"},
+		{QualityHigh, "high.opus", "opus"},
+		{QualityOriginal, "original.flac", ""},
+		{"", "original.flac", ""},
+	} {
+		object, codec, err := piece.SelectRendition(c.quality)
+		if err != nil || object != c.object || codec != c.codec {
+			t.Errorf("SelectRendition(%q) = %q, %q, %v, want %q, %q", c.quality, object, codec, err, c.object, c.codec)
+		}
+	}
+	if _, _, err := piece.SelectRendition("best"); err == nil {
+		t.Errorf("unknown qualities must be rejected")
+	}
+	if err := piece.SetRendition(QualityOriginal, Rendition{}); err == nil {
+		t.Errorf("the original must not be replaced")
+	}
+}
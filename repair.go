@@ -0,0 +1,90 @@
+package benten
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+)
+
+// RepairIndex makes the index agree with the pieces `pieces` stored at
+// `keys`: it spans the missing index entities of the pieces and deletes those
+// of the trashed ones. It returns the number of pieces repaired. The pieces
+// are put and indexed in one transaction now, but the older ones may lack
+// their index.
+func RepairIndex(ctx context.Context, client *datastore.Client, keys []*datastore.Key, pieces []Metadata, aliases Aliases) (int, error) {
+	indexKeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		indexKeys[i] = IndexKey(key)
+	}
+	exists, err := existing(ctx, client, indexKeys)
+	if err != nil {
+		return 0, err
+	}
+	var spanKeys, deleteKeys []*datastore.Key
+	var spans []*PieceIndex
+	for i := range keys {
+		switch trashed := pieces[i].IsTrashed(); {
+		case !trashed && !exists[i]:
+			spanKeys = append(spanKeys, indexKeys[i])
+			spans = append(spans, NewPieceIndex(&pieces[i], keys[i], aliases))
+		case trashed && exists[i]:
+			deleteKeys = append(deleteKeys, indexKeys[i])
+		}
+	}
+	if len(spanKeys) > 0 {
+		if _, err := client.PutMulti(ctx, spanKeys, spans); err != nil {
+			return 0, err
+		}
+	}
+	if len(deleteKeys) > 0 {
+		if err := client.DeleteMulti(ctx, deleteKeys); err != nil {
+			return 0, err
+		}
+	}
+	return len(spanKeys) + len(deleteKeys), nil
+}
+
+// DeleteOrphanIndexes deletes the index entities at `indexKeys` whose pieces
+// are gone, and returns how many it deleted.
+func DeleteOrphanIndexes(ctx context.Context, client *datastore.Client, indexKeys []*datastore.Key) (int, error) {
+	keys := make([]*datastore.Key, len(indexKeys))
+	for i, key := range indexKeys {
+		keys[i] = key.Parent
+	}
+	exists, err := existing(ctx, client, keys)
+	if err != nil {
+		return 0, err
+	}
+	var orphans []*datastore.Key
+	for i := range indexKeys {
+		if !exists[i] {
+			orphans = append(orphans, indexKeys[i])
+		}
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+	return len(orphans), client.DeleteMulti(ctx, orphans)
+}
+
+// existing tells whether each of the entities at `keys` exists.
+func existing(ctx context.Context, client *datastore.Client, keys []*datastore.Key) ([]bool, error) {
+	exists := make([]bool, len(keys))
+	err := client.GetMulti(ctx, keys, make([]datastore.PropertyList, len(keys)))
+	if multi, ok := err.(datastore.MultiError); ok {
+		for i, e := range multi {
+			if e != nil && e != datastore.ErrNoSuchEntity {
+				return nil, e
+			}
+			exists[i] = e == nil
+		}
+		return exists, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range exists {
+		exists[i] = true
+	}
+	return exists, nil
+}
@@ -0,0 +1,53 @@
+package benten_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestRepairIndex(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	indexed := testutil.PutPiece(t, client, benten.Metadata{Title: "Help!", Path: "Help.mp3"})
+	unindexed, err := client.Put(ctx, datastore.IncompleteKey(benten.PieceKind, nil), &benten.Metadata{Title: "Yesterday", Path: "Yesterday.mp3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed := testutil.PutPiece(t, client, benten.Metadata{Title: "Michelle", Path: "Michelle.mp3", Deleted: time.Now()})
+	orphan := testutil.PutPiece(t, client, benten.Metadata{Title: "Girl", Path: "Girl.mp3"})
+	if err := client.Delete(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []*datastore.Key{indexed, unindexed, trashed}
+	pieces := make([]benten.Metadata, len(keys))
+	if err := client.GetMulti(ctx, keys, pieces); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := benten.RepairIndex(ctx, client, keys, pieces, nil); err != nil || n != 2 {
+		t.Errorf("repaired %d pieces, %v", n, err)
+	}
+	var index benten.PieceIndex
+	if err := client.Get(ctx, benten.IndexKey(unindexed), &index); err != nil || !index.Value.Equal(unindexed) {
+		t.Errorf("the index of the unindexed piece = %v, %v", index.Value, err)
+	}
+	if err := client.Get(ctx, benten.IndexKey(trashed), &index); err != datastore.ErrNoSuchEntity {
+		t.Errorf("the index of the trashed piece is left: %v", err)
+	}
+
+	indexKeys := []*datastore.Key{benten.IndexKey(indexed), benten.IndexKey(orphan)}
+	if n, err := benten.DeleteOrphanIndexes(ctx, client, indexKeys); err != nil || n != 1 {
+		t.Errorf("deleted %d orphans, %v", n, err)
+	}
+	if err := client.Get(ctx, benten.IndexKey(orphan), &index); err != datastore.ErrNoSuchEntity {
+		t.Errorf("the orphan index is left: %v", err)
+	}
+	if n, err := benten.RepairIndex(ctx, client, keys, pieces, nil); err != nil || n != 0 {
+		t.Errorf("repaired %d pieces again, %v", n, err)
+	}
+}
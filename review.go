@@ -0,0 +1,28 @@
+package benten
+
+// ReviewReasons returns why the piece needs review, such as "missing:Title"
+// and "inferred:Artist". Missing fields don't count once the piece has been
+// Reviewed.
+func (m *Metadata) ReviewReasons() []string {
+	var reasons []string
+	if m.Reviewed.IsZero() {
+		for _, f := range []struct {
+			name  string
+			value string
+		}{{"Title", m.Title}, {"Artist", m.Artist}, {"Album", m.Album}} {
+			if f.value == "" {
+				reasons = append(reasons, "missing:"+f.name)
+			}
+		}
+	}
+	for _, field := range m.InferredFields() {
+		reasons = append(reasons, "inferred:"+field)
+	}
+	return reasons
+}
+
+// SetNeedsReview sets `m.NeedsReview`. Writers of Metadata call it after
+// changing the tags, Inferred or Reviewed, like SetAlbumKey.
+func (m *Metadata) SetNeedsReview() {
+	m.NeedsReview = len(m.ReviewReasons()) > 0
+}
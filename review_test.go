@@ -0,0 +1,21 @@
+package benten
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReviewReasons(t *testing.T) {
+	m := Metadata{Title: "Dogs", Artist: "Pink Floyd", Inferred: "Artist"}
+	m.SetNeedsReview()
+	if want := []string{"missing:Album", "inferred:Artist"}; !reflect.DeepEqual(m.ReviewReasons(), want) || !m.NeedsReview {
+		t.Errorf("reasons = %v, NeedsReview = %v", m.ReviewReasons(), m.NeedsReview)
+	}
+	m.Inferred = ""
+	m.Reviewed = time.Now()
+	m.SetNeedsReview()
+	if m.ReviewReasons() != nil || m.NeedsReview {
+		t.Errorf("reasons = %v, NeedsReview = %v after the review", m.ReviewReasons(), m.NeedsReview)
+	}
+}
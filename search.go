@@ -0,0 +1,222 @@
+package benten
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// ErrQueryTooShort is returned by SearchIndex.Search when the query is too
+// short for the index to handle.
+var ErrQueryTooShort = errors.New("the query is too short")
+
+// SearchResult is a piece matching a search query.
+type SearchResult struct {
+	// Key is the key of the Metadata in the datastore.
+	Key *datastore.Key
+	// Metadata is the metadata of the piece, or nil when the index doesn't
+	// have it: the caller needs to get it from the datastore.
+	Metadata *Metadata
+}
+
+// SearchIndex is a full-text index of pieces.
+type SearchIndex interface {
+	// Index adds or updates the piece stored at `key`, with the names
+	// equivalent to its artists in `aliases`.
+	Index(ctx context.Context, key *datastore.Key, metadata *Metadata, aliases Aliases) error
+	// Delete removes the piece stored at `key`.
+	Delete(ctx context.Context, key *datastore.Key) error
+	// Search returns at most `limit` pieces matching `query`.
+	Search(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// PhoneticSearcher is implemented by SearchIndexes which can search artists by
+// how their names sound.
+type PhoneticSearcher interface {
+	// SearchPhonetic returns at most `limit` pieces whose Artist or
+	// AlbumArtist sounds like all the words in `query`.
+	SearchPhonetic(ctx context.Context, query string, limit int) ([]SearchResult, error)
+}
+
+// DatastoreIndex is a SearchIndex backed by the n-gram index entities in the datastore.
+type DatastoreIndex struct {
+	Client *datastore.Client
+	// Aliases are applied when searching.
+	Aliases Aliases
+}
+
+// Index implements SearchIndex.
+func (index DatastoreIndex) Index(ctx context.Context, key *datastore.Key, metadata *Metadata, aliases Aliases) error {
+	return RespanIndex(ctx, index.Client, metadata, key, aliases)
+}
+
+// Delete implements SearchIndex.
+func (index DatastoreIndex) Delete(ctx context.Context, key *datastore.Key) error {
+	return index.Client.Delete(ctx, IndexKey(key))
+}
+
+// gramAt returns the gram starting at `i` in `search`, or false if `search`
+// is too short.
+func gramAt(search string, i int) (string, bool) {
+	if len(search)-i < GramSizeForAscii {
+		return "", false
+	}
+	isASCII := true
+	for j := 0; j < GramSizeForAscii; j++ {
+		isASCII = isASCII && search[i+j] <= unicode.MaxASCII
+	}
+	if isASCII {
+		return search[i : i+GramSizeForAscii], true
+	}
+	if len(search)-i < GramSizeForNonAscii {
+		return "", false
+	}
+	return search[i : i+GramSizeForNonAscii], true
+}
+
+// lookupTerm returns the property of PieceIndex and the value used to look up
+// the index for `search`, which must be normalized. That is the first gram
+// which is not a stopgram, or the first token when there is no such gram.
+func lookupTerm(search string) (string, []byte, error) {
+	for i := 0; ; i++ {
+		gram, ok := gramAt(search, i)
+		if !ok {
+			break
+		}
+		if !isStopGram(gram) {
+			return "Grams", []byte(gram), nil
+		}
+	}
+	for _, token := range tokenize(search) {
+		if _, ok := Stopwords[token]; !ok {
+			return "Tokens", []byte(token), nil
+		}
+	}
+	return "", nil, ErrQueryTooShort
+}
+
+// matches returns true if `piece` contains `search`, which must be normalized.
+// The aliases of the artists are taken into account.
+func (index DatastoreIndex) matches(piece *Metadata, search string) bool {
+	if strings.Contains(Normalize(piece.Title), search) ||
+		strings.Contains(Normalize(piece.Album), search) ||
+		strings.Contains(Normalize(piece.Artist), search) ||
+		strings.Contains(Normalize(piece.AlbumArtist), search) {
+		return true
+	}
+	for _, name := range index.Aliases.Equivalents(piece.Artist, piece.AlbumArtist) {
+		if strings.Contains(Normalize(name), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search implements SearchIndex. The index is looked up with a gram or a
+// token of the query (see lookupTerm), and then the candidates are filtered
+// by the whole query. Up to `limit` pieces matching it are returned; see
+// SearchFiltered.
+func (index DatastoreIndex) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	if Normalize(query) == "" {
+		return nil, ErrQueryTooShort
+	}
+	return index.SearchFiltered(ctx, query, Filter{}, limit)
+}
+
+// getResults gets the pieces stored at `keys`. Those which no longer exist
+// are skipped, as the index may be stale.
+func (index DatastoreIndex) getResults(ctx context.Context, keys []*datastore.Key) ([]SearchResult, error) {
+	pieces := make([]Metadata, len(keys))
+	err := index.Client.GetMulti(ctx, keys, pieces)
+	multi, _ := err.(datastore.MultiError)
+	if err != nil && multi == nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(keys))
+	for i := range keys {
+		if multi != nil && multi[i] != nil {
+			if multi[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return nil, multi[i]
+		}
+		results = append(results, SearchResult{Key: keys[i], Metadata: &pieces[i]})
+	}
+	return results, nil
+}
+
+// hasCodes returns true if `phonetic`, the Phonetic of a PieceIndex, has all
+// the `codes`.
+func hasCodes(phonetic [][]byte, codes map[string]struct{}) bool {
+	found := make(map[string]struct{}, len(phonetic))
+	for _, code := range phonetic {
+		found[string(code)] = struct{}{}
+	}
+	for code := range codes {
+		if _, ok := found[code]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchPhonetic implements PhoneticSearcher. The index is looked up with the
+// first phonetic key of the query, and then the candidates are filtered by
+// the other keys. The index is read page by page until `limit` pieces have
+// all the keys, or until maxFilteredPages pages are read.
+func (index DatastoreIndex) SearchPhonetic(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	codes := PhoneticCodes(query)
+	if len(codes) == 0 {
+		return nil, ErrQueryTooShort
+	}
+	var first string
+	for code := range codes {
+		if first == "" || code < first {
+			first = code
+		}
+	}
+
+	q := datastore.NewQuery(PieceIndexKind).Filter("Phonetic =", []byte(first)).Order("Value").Limit(filteredPageSize)
+	results := make([]SearchResult, 0)
+	for page := 1; ; page++ {
+		t := index.Client.Run(ctx, q)
+		read := 0
+		var keys []*datastore.Key
+		for {
+			var entry PieceIndex
+			_, err := t.Next(&entry)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			read++
+			if hasCodes(entry.Phonetic, codes) {
+				keys = append(keys, entry.Value)
+			}
+		}
+		matched, err := index.getResults(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range matched {
+			results = append(results, result)
+			if len(results) == limit {
+				return results, nil
+			}
+		}
+		if read < filteredPageSize || page == maxFilteredPages {
+			return results, nil
+		}
+		cursor, err := t.Cursor()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Start(cursor)
+	}
+}
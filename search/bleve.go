@@ -0,0 +1,59 @@
+package search
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"github.com/blevesearch/bleve"
+	"github.com/yutakahirano/benten"
+)
+
+// BleveIndex is a SearchIndex embedded in the process. The index directory can
+// be opened by only one process at a time, so this suits deployments where the
+// syncer runs in the same process as the server.
+type BleveIndex struct {
+	index bleve.Index
+}
+
+// OpenBleveIndex opens the index at `path`, creating it if it doesn't exist.
+func OpenBleveIndex(path string) (*BleveIndex, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(path, bleve.NewIndexMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BleveIndex{index: index}, nil
+}
+
+// Close closes the index.
+func (b *BleveIndex) Close() error {
+	return b.index.Close()
+}
+
+// Index implements benten.SearchIndex.
+func (b *BleveIndex) Index(ctx context.Context, key *datastore.Key, metadata *benten.Metadata, aliases benten.Aliases) error {
+	return b.index.Index(key.Encode(), newDocument(metadata, aliases))
+}
+
+// Delete implements benten.SearchIndex.
+func (b *BleveIndex) Delete(ctx context.Context, key *datastore.Key) error {
+	return b.index.Delete(key.Encode())
+}
+
+// Search implements benten.SearchIndex. Terms match with an edit distance of one.
+func (b *BleveIndex) Search(ctx context.Context, query string, limit int) ([]benten.SearchResult, error) {
+	q := bleve.NewMatchQuery(benten.Normalize(query))
+	q.SetFuzziness(1)
+	request := bleve.NewSearchRequestOptions(q, limit, 0, false)
+	response, err := b.index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(response.Hits))
+	for _, hit := range response.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return results(ids)
+}
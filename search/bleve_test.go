@@ -0,0 +1,65 @@
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+func TestBleveIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benten-bleve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, err := OpenBleveIndex(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatalf("OpenBleveIndex: %v", err)
+	}
+	defer index.Close()
+
+	ctx := context.Background()
+	tchaikovsky := datastore.IDKey(benten.PieceKind, 1, nil)
+	bach := datastore.IDKey(benten.PieceKind, 2, nil)
+	aliases := benten.NewAliases([]*benten.ArtistAlias{benten.NewArtistAlias("Tchaikovsky", []string{"Pyotr Ilyich"})})
+	if err := index.Index(ctx, tchaikovsky, &benten.Metadata{Title: "Swan Lake", Artist: "Tchaikovsky"}, aliases); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := index.Index(ctx, bach, &benten.Metadata{Title: "Goldberg Variations", Artist: "Bach"}, aliases); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	results, err := index.Search(ctx, "Goldberg Variatons", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 || !results[0].Key.Equal(bach) {
+		t.Errorf("results = %v", results)
+	}
+
+	// The aliases are indexed too.
+	results, err = index.Search(ctx, "ilyich", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 1 || !results[0].Key.Equal(tchaikovsky) {
+		t.Errorf("results = %v", results)
+	}
+
+	if err := index.Delete(ctx, bach); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	results, err = index.Search(ctx, "goldberg", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("results = %v", results)
+	}
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// ElasticsearchIndex is a SearchIndex backed by an Elasticsearch server.
+type ElasticsearchIndex struct {
+	// URL is the base URL of the server, e.g. "http://localhost:9200".
+	URL string
+	// IndexName is the name of the Elasticsearch index.
+	IndexName string
+	// APIKey is the API key sent to the server, if any.
+	APIKey string
+}
+
+func (e *ElasticsearchIndex) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, e.URL+"/"+url.PathEscape(e.IndexName)+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("authorization", "ApiKey "+e.APIKey)
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 && method == "DELETE" {
+		return nil
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("elasticsearch: %s %s: %s", method, path, res.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Index implements benten.SearchIndex.
+func (e *ElasticsearchIndex) Index(ctx context.Context, key *datastore.Key, metadata *benten.Metadata, aliases benten.Aliases) error {
+	return e.do(ctx, "PUT", "/_doc/"+url.PathEscape(key.Encode()), newDocument(metadata, aliases), nil)
+}
+
+// Delete implements benten.SearchIndex.
+func (e *ElasticsearchIndex) Delete(ctx context.Context, key *datastore.Key) error {
+	return e.do(ctx, "DELETE", "/_doc/"+url.PathEscape(key.Encode()), nil, nil)
+}
+
+// Search implements benten.SearchIndex. Titles weigh more than the other
+// fields, and terms match fuzzily.
+func (e *ElasticsearchIndex) Search(ctx context.Context, query string, limit int) ([]benten.SearchResult, error) {
+	body := map[string]interface{}{
+		"size":    limit,
+		"_source": false,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     benten.Normalize(query),
+				"fields":    []string{"Title^3", "Album^2", "Artist^2", "AlbumArtist", "Composer", "Aliases^2"},
+				"fuzziness": "AUTO",
+			},
+		},
+	}
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			}
+		}
+	}
+	if err := e.do(ctx, "POST", "/_search", body, &response); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return results(ids)
+}
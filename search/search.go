@@ -0,0 +1,89 @@
+// Package search provides the SearchIndex implementations other than the
+// datastore n-gram index, and selects one from a configuration.
+package search
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// Config selects and configures a SearchIndex.
+type Config struct {
+	// Backend is one of "datastore" (the default), "bleve" and "elasticsearch".
+	Backend string
+	// Path is the directory of the Bleve index.
+	Path string
+	// URL is the base URL of the Elasticsearch server.
+	URL string
+	// Index is the name of the Elasticsearch index. Defaults to "benten".
+	Index string
+	// APIKey is the Elasticsearch API key, if any.
+	APIKey string
+}
+
+// New creates the SearchIndex selected by `config`. `client` is used by the
+// datastore backend.
+func New(config Config, client *datastore.Client) (benten.SearchIndex, error) {
+	switch config.Backend {
+	case "", "datastore":
+		return benten.DatastoreIndex{Client: client}, nil
+	case "bleve":
+		if config.Path == "" {
+			return nil, fmt.Errorf("the bleve backend needs a path")
+		}
+		return OpenBleveIndex(config.Path)
+	case "elasticsearch":
+		if config.URL == "" {
+			return nil, fmt.Errorf("the elasticsearch backend needs a URL")
+		}
+		index := config.Index
+		if index == "" {
+			index = "benten"
+		}
+		return &ElasticsearchIndex{URL: config.URL, IndexName: index, APIKey: config.APIKey}, nil
+	}
+	return nil, fmt.Errorf("unknown search backend: %q", config.Backend)
+}
+
+// document is what the external engines index for a piece.
+type document struct {
+	Title  string
+	Album  string
+	Artist string
+	// AlbumArtist is the canonical name in the aliases.
+	AlbumArtist string
+	Composer    string
+	// Aliases are the names equivalent to Artist and AlbumArtist, so that
+	// searching any of them finds the piece.
+	Aliases []string
+}
+
+func newDocument(metadata *benten.Metadata, aliases benten.Aliases) document {
+	equivalents := make([]string, 0)
+	for _, name := range aliases.Equivalents(metadata.Artist, metadata.AlbumArtist) {
+		equivalents = append(equivalents, benten.Normalize(name))
+	}
+	return document{
+		Title:       benten.Normalize(metadata.Title),
+		Album:       benten.Normalize(metadata.Album),
+		Artist:      benten.Normalize(metadata.Artist),
+		AlbumArtist: benten.Normalize(aliases.AlbumArtist(metadata)),
+		Composer:    benten.Normalize(metadata.Composer),
+		Aliases:     equivalents,
+	}
+}
+
+// results converts document IDs, which are encoded datastore keys, to search results.
+func results(ids []string) ([]benten.SearchResult, error) {
+	results := make([]benten.SearchResult, 0, len(ids))
+	for _, id := range ids {
+		key, err := datastore.DecodeKey(id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, benten.SearchResult{Key: key})
+	}
+	return results, nil
+}
@@ -0,0 +1,130 @@
+package benten_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestDatastoreIndexSearch(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	wall := testutil.PutPiece(t, client, benten.Metadata{Title: "The Wall", Artist: "Pink Floyd"})
+	testutil.PutPiece(t, client, benten.Metadata{Title: "Wish You Were Here", Artist: "Pink Floyd"})
+
+	index := benten.DatastoreIndex{Client: client}
+	results, err := index.Search(ctx, "wall", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Key.Equal(wall) {
+		t.Errorf("results = %v", results)
+	}
+	results, err = index.Search(ctx, "pink floyd", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("results = %v", results)
+	}
+
+	if err := index.Delete(ctx, wall); err != nil {
+		t.Fatal(err)
+	}
+	results, err = index.Search(ctx, "wall", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("results = %v", results)
+	}
+}
+
+func TestDatastoreIndexSearchFiltered(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	bohemian := testutil.PutPiece(t, client, benten.Metadata{Title: "Bohemian Rhapsody", Artist: "Queen", AlbumArtist: "Queen", Genre: "Rock", Year: 1975})
+	testutil.PutPiece(t, client, benten.Metadata{Title: "Radio Ga Ga", Artist: "Queen", AlbumArtist: "Queen", Genre: "Pop", Year: 1984})
+	testutil.PutPiece(t, client, benten.Metadata{Title: "Black Dog", Artist: "Led Zeppelin", AlbumArtist: "Led Zeppelin", Genre: "Rock", Year: 1971})
+
+	index := benten.DatastoreIndex{Client: client}
+	// Only one of the filters is executed by the datastore.
+	for _, filter := range []benten.Filter{
+		{Genre: "rock", AlbumArtist: "queen"},
+		{Genre: "Rock", OriginalYear: 1975},
+		{AlbumArtist: "Queen", Year: 1975, Genre: "rock"},
+	} {
+		results, err := index.SearchFiltered(ctx, "", filter, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !results[0].Key.Equal(bohemian) {
+			t.Errorf("results = %v for %+v", results, filter)
+		}
+	}
+}
+
+func TestDatastoreIndexSearchUnindexedFilter(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	for i := 0; i < 150; i++ {
+		testutil.PutPiece(t, client, benten.Metadata{Title: fmt.Sprintf("Ballad %d", i), Artist: "Slow", BPM: 70})
+	}
+	for i := 0; i < 3; i++ {
+		testutil.PutPiece(t, client, benten.Metadata{Title: fmt.Sprintf("Anthem %d", i), Artist: "Fast", BPM: 128})
+	}
+
+	index := benten.DatastoreIndex{Client: client}
+	// BPM is not indexed, so the pieces are read until enough of them pass.
+	results, err := index.SearchFiltered(ctx, "", benten.Filter{MinBPM: 120}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Metadata.BPM != 128 || results[1].Metadata.BPM != 128 {
+		t.Errorf("results = %v", results)
+	}
+	results, err = index.SearchFiltered(ctx, "", benten.Filter{MinBPM: 120}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("results = %v", results)
+	}
+}
+
+func TestDatastoreIndexSearchPhonetic(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	for i := 0; i < 150; i++ {
+		testutil.PutPiece(t, client, benten.Metadata{Title: fmt.Sprintf("Song %d", i), Artist: "Jones"})
+	}
+	for i := 0; i < 3; i++ {
+		testutil.PutPiece(t, client, benten.Metadata{Title: fmt.Sprintf("Duet %d", i), Artist: "Smith Jones"})
+	}
+	// The index of a purged piece is left behind.
+	purged := testutil.PutPiece(t, client, benten.Metadata{Title: "Purged", Artist: "Smith Jones"})
+	if err := client.Delete(ctx, purged); err != nil {
+		t.Fatal(err)
+	}
+
+	index := benten.DatastoreIndex{Client: client}
+	// The pieces of "Jones" share the first phonetic key, so the index is
+	// read until enough of them have the other.
+	results, err := index.SearchPhonetic(ctx, "Smyth Jones", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Metadata.Artist != "Smith Jones" || results[1].Metadata.Artist != "Smith Jones" {
+		t.Errorf("results = %v", results)
+	}
+	results, err = index.SearchPhonetic(ctx, "Smyth Jones", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("results = %v", results)
+	}
+}
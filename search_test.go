@@ -0,0 +1,55 @@
+package benten
+
+import (
+	"testing"
+)
+
+func TestLookupTerm(t *testing.T) {
+	patterns := []struct {
+		search   string
+		property string
+		term     string
+	}{
+		{"abcdef", "Grams", "abcd"},
+		{"the wall", "Grams", "he w"},
+		{"u2", "Tokens", "u2"},
+		{"日本語", "Grams", "日本"},
+	}
+	for _, p := range patterns {
+		property, term, err := lookupTerm(p.search)
+		if err != nil || property != p.property || string(term) != p.term {
+			t.Errorf("lookupTerm(%q) = %q, %q, %v", p.search, property, term, err)
+		}
+	}
+	if _, _, err := lookupTerm("the"); err != ErrQueryTooShort {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	piece := &Metadata{Genre: "Rock", AlbumArtist: "Queen", Year: 1975}
+	if !(Filter{}).Matches(piece, nil) || !(Filter{Genre: "rock", Year: 1975}).Matches(piece, nil) {
+		t.Errorf("the filters must match")
+	}
+	if (Filter{AlbumArtist: "Queen", Year: 1976}).Matches(piece, nil) {
+		t.Errorf("the filter must not match")
+	}
+	remaster := &Metadata{Year: 2011, OriginalYear: 1975}
+	if !(Filter{OriginalYear: 1975}).Matches(remaster, nil) || !(Filter{OriginalYear: 1975}).Matches(piece, nil) {
+		t.Errorf("the OriginalYear filter must match")
+	}
+	track := &Metadata{BPM: 128, Key: "Am"}
+	if !(Filter{MinBPM: 120, MaxBPM: 130, Key: "8A"}).Matches(track, nil) {
+		t.Errorf("the BPM and Key filter must match")
+	}
+	if (Filter{MinBPM: 130}).Matches(track, nil) || (Filter{MaxBPM: 130}).Matches(piece, nil) {
+		t.Errorf("the BPM filter must not match")
+	}
+	flac := &Metadata{FileType: "FLAC", Bitrate: 900}
+	if !(Filter{FileType: "flac", Lossless: true, MinBitrate: 256}).Matches(flac, nil) {
+		t.Errorf("the quality filter must match")
+	}
+	if (Filter{Lossless: true}).Matches(piece, nil) || (Filter{MinBitrate: 256}).Matches(piece, nil) {
+		t.Errorf("the quality filter must not match")
+	}
+}
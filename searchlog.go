@@ -0,0 +1,106 @@
+package benten
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// SearchLogRetention is how long search logs are kept; see PurgeSearchLogs.
+var SearchLogRetention = 30 * 24 * time.Hour
+
+// SearchLog records a search, to find what users fail to find.
+type SearchLog struct {
+	Time time.Time
+	// User is a hash of who searched, which tells repeated searches by one
+	// user from searches by many without recording who.
+	User string
+	// Query is the query after Normalize.
+	Query string
+	// Results is the number of pieces returned.
+	Results int
+	Latency time.Duration `datastore:",noindex"`
+}
+
+// RecordSearch stores `entry`, with its Time set to now if unset.
+func RecordSearch(ctx context.Context, client *datastore.Client, entry SearchLog) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	_, err := client.Put(ctx, datastore.IncompleteKey(SearchLogKind, nil), &entry)
+	return err
+}
+
+// ZeroResultQuery is a query which found nothing.
+type ZeroResultQuery struct {
+	Query string
+	// Count is the number of the searches, and Users the number of the
+	// users who searched.
+	Count int
+	Users int
+	Last  time.Time
+}
+
+// ZeroResultQueries returns up to `limit` of the queries which found nothing
+// since `since`, the most searched by the most users first.
+func ZeroResultQueries(ctx context.Context, client *datastore.Client, since time.Time, limit int) ([]ZeroResultQuery, error) {
+	query := datastore.NewQuery(SearchLogKind).Filter("Results =", 0).Filter("Time >=", since)
+	byQuery := make(map[string]*ZeroResultQuery)
+	users := make(map[string]map[string]struct{})
+	t := client.Run(ctx, query)
+	for {
+		var entry SearchLog
+		_, err := t.Next(&entry)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		q, ok := byQuery[entry.Query]
+		if !ok {
+			q = &ZeroResultQuery{Query: entry.Query}
+			byQuery[entry.Query] = q
+			users[entry.Query] = make(map[string]struct{})
+		}
+		q.Count++
+		users[entry.Query][entry.User] = struct{}{}
+		if entry.Time.After(q.Last) {
+			q.Last = entry.Time
+		}
+	}
+	queries := make([]ZeroResultQuery, 0, len(byQuery))
+	for _, q := range byQuery {
+		q.Users = len(users[q.Query])
+		queries = append(queries, *q)
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].Count != queries[j].Count {
+			return queries[i].Count > queries[j].Count
+		}
+		if queries[i].Users != queries[j].Users {
+			return queries[i].Users > queries[j].Users
+		}
+		return queries[i].Query < queries[j].Query
+	})
+	if len(queries) > limit {
+		queries = queries[:limit]
+	}
+	return queries, nil
+}
+
+// PurgeSearchLogs deletes up to `limit` search logs older than `before`, and
+// returns how many it deleted.
+func PurgeSearchLogs(ctx context.Context, client *datastore.Client, before time.Time, limit int) (int, error) {
+	keys, err := client.GetAll(ctx, datastore.NewQuery(SearchLogKind).Filter("Time <", before).KeysOnly().Limit(limit), nil)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,38 @@
+package benten_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestZeroResultQueries(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	now := time.Now()
+	for _, entry := range []benten.SearchLog{
+		{User: "a", Query: "bjork", Results: 0},
+		{User: "b", Query: "bjork", Results: 0},
+		{User: "a", Query: "sigur ros", Results: 0},
+		{User: "a", Query: "queen", Results: 3},
+		{User: "a", Query: "old", Results: 0, Time: now.Add(-48 * time.Hour)},
+	} {
+		if err := benten.RecordSearch(ctx, client, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries, err := benten.ZeroResultQueries(ctx, client, now.Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0].Query != "bjork" || queries[0].Count != 2 || queries[0].Users != 2 || queries[1].Query != "sigur ros" {
+		t.Errorf("ZeroResultQueries = %+v", queries)
+	}
+
+	if n, err := benten.PurgeSearchLogs(ctx, client, now.Add(-time.Hour), 10); n != 1 || err != nil {
+		t.Errorf("PurgeSearchLogs = %d, %v, want 1", n, err)
+	}
+}
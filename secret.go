@@ -0,0 +1,90 @@
+package benten
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// Prefixes of secret references; see ResolveSecret.
+const (
+	secretManagerPrefix = "sm://"
+	secretFilePrefix    = "file://"
+	secretEnvPrefix     = "env://"
+)
+
+// IsSecretReference returns true if `value` refers to a secret rather than
+// being one; see ResolveSecret.
+func IsSecretReference(value string) bool {
+	for _, prefix := range []string{secretManagerPrefix, secretFilePrefix, secretEnvPrefix} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSecret returns the secret `ref` refers to, which is one of
+//
+//	sm://<name>                                  the latest version of the secret in projectID
+//	sm://projects/<project>/secrets/<name>/versions/<version>
+//	file://<path>                                the contents of the file
+//	env://<name>                                 the environment variable
+//
+// in Secret Manager, a file (such as a secret mounted by Cloud Run) or the
+// environment, so that configs hold references instead of the secrets. Other
+// values are secrets themselves and returned as is. Trailing newlines of
+// files and Secret Manager payloads are removed.
+func ResolveSecret(ctx context.Context, projectID, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, secretManagerPrefix):
+		name := strings.TrimPrefix(ref, secretManagerPrefix)
+		if !strings.HasPrefix(name, "projects/") {
+			name = fmt.Sprintf("projects/%s/secrets/%s/versions/latest", projectID, name)
+		}
+		service, err := secretmanager.NewService(ctx, ClientOptions()...)
+		if err != nil {
+			return "", err
+		}
+		response, err := service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to access %s: %v", name, err)
+		}
+		data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, secretFilePrefix):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(ref, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, secretEnvPrefix):
+		name := strings.TrimPrefix(ref, secretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s is not set", name)
+		}
+		return value, nil
+	}
+	return ref, nil
+}
+
+// ResolveSecrets replaces each of `refs` with the secret it refers to.
+func ResolveSecrets(ctx context.Context, projectID string, refs ...*string) error {
+	for _, ref := range refs {
+		secret, err := ResolveSecret(ctx, projectID, *ref)
+		if err != nil {
+			return err
+		}
+		*ref = secret
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package benten
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	file, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+	os.Setenv("BENTEN_TEST_SECRET", "from-env")
+	defer os.Unsetenv("BENTEN_TEST_SECRET")
+	ctx := context.Background()
+
+	for ref, want := range map[string]string{
+		"plain":                    "plain",
+		"file://" + file.Name():    "from-file",
+		"env://BENTEN_TEST_SECRET": "from-env",
+	} {
+		if got, err := ResolveSecret(ctx, "project", ref); err != nil || got != want {
+			t.Errorf("ResolveSecret(%q) = %q, %v", ref, got, err)
+		}
+	}
+	if _, err := ResolveSecret(ctx, "project", "env://BENTEN_UNSET_SECRET"); err == nil {
+		t.Errorf("an unset variable is resolved")
+	}
+}
@@ -0,0 +1,123 @@
+// Package sqlite implements benten.MetadataStore on SQLite, for the
+// standalone mode.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	// Registers the "sqlite3" driver.
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yutakahirano/benten"
+)
+
+// DatabaseName is the name of the database in the standalone directory.
+const DatabaseName = "benten.db"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS pieces (
+	path TEXT PRIMARY KEY,
+	search TEXT NOT NULL,
+	metadata TEXT NOT NULL
+)`
+
+// Store is a MetadataStore keeping each piece as a row holding the JSON of
+// its metadata, with the lowercased searchable fields in a separate column.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at `path`, creating it if needed.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// searchText returns the value of the search column of `m`.
+func searchText(m *benten.Metadata) string {
+	return strings.ToLower(strings.Join([]string{m.Title, m.Album, m.Artist, m.AlbumArtist}, "\n"))
+}
+
+// Put implements benten.MetadataStore.
+func (s *Store) Put(ctx context.Context, m *benten.Metadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "INSERT OR REPLACE INTO pieces (path, search, metadata) VALUES (?, ?, ?)", m.Path, searchText(m), string(data))
+	return err
+}
+
+// Get implements benten.MetadataStore.
+func (s *Store) Get(ctx context.Context, path string) (*benten.Metadata, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT metadata FROM pieces WHERE path = ?", path).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, benten.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m benten.Metadata
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Delete implements benten.MetadataStore.
+func (s *Store) Delete(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM pieces WHERE path = ?", path)
+	return err
+}
+
+// escapeLike escapes the wildcards of LIKE in `s`, with '\' as the escape.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Search implements benten.MetadataStore.
+func (s *Store) Search(ctx context.Context, text string, limit int) ([]benten.Metadata, error) {
+	pattern := "%" + escapeLike(strings.ToLower(text)) + "%"
+	return s.query(ctx, `SELECT metadata FROM pieces WHERE search LIKE ? ESCAPE '\' ORDER BY path LIMIT ?`, pattern, limit)
+}
+
+// All implements benten.MetadataStore.
+func (s *Store) All(ctx context.Context, after string, limit int) ([]benten.Metadata, error) {
+	return s.query(ctx, "SELECT metadata FROM pieces WHERE path > ? ORDER BY path LIMIT ?", after, limit)
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) ([]benten.Metadata, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pieces []benten.Metadata
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var m benten.Metadata
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, m)
+	}
+	return pieces, rows.Err()
+}
+
+// Close implements benten.MetadataStore.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
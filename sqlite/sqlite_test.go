@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := Open(filepath.Join(dir, DatabaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	pieces := []benten.Metadata{
+		{Path: "/a.mp3", Title: "Lemon", Artist: "Kenshi Yonezu"},
+		{Path: "/b.mp3", Title: "100%", Artist: "Someone"},
+	}
+	for i := range pieces {
+		if err := store.Put(ctx, &pieces[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m, err := store.Get(ctx, "/a.mp3"); err != nil || *m != pieces[0] {
+		t.Errorf("Get = %+v, %v", m, err)
+	}
+	if found, err := store.Search(ctx, "yonezu", 10); err != nil || len(found) != 1 || found[0].Path != "/a.mp3" {
+		t.Errorf("Search = %+v, %v", found, err)
+	}
+	// % is not a wildcard.
+	if found, err := store.Search(ctx, "%", 10); err != nil || len(found) != 1 || found[0].Path != "/b.mp3" {
+		t.Errorf("Search = %+v, %v for %%", found, err)
+	}
+	if all, err := store.All(ctx, "/a.mp3", 10); err != nil || len(all) != 1 || all[0].Path != "/b.mp3" {
+		t.Errorf("All = %+v, %v", all, err)
+	}
+	if err := store.Delete(ctx, "/a.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "/a.mp3"); err != benten.ErrNotFound {
+		t.Errorf("Get = %v after Delete", err)
+	}
+}
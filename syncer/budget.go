@@ -0,0 +1,139 @@
+package syncer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WriteBudget limits the entity writes and deletes of a Syncer in the
+// datastore, so that a full sync of a large library stays within the quota.
+// The zero value is unlimited.
+type WriteBudget struct {
+	// Rate is the number of writes per second, which bursts of up to Burst
+	// writes may exceed for a while. Zero Rate is unlimited, and zero Burst
+	// is Rate.
+	Rate  float64
+	Burst int
+	// Daily is the number of writes per day in UTC, after which writes wait
+	// for the next day. The quota of the datastore resets at midnight Pacific
+	// time, so it needs a margin. Zero is unlimited.
+	Daily int
+}
+
+// The bounds of the slowdown of writeThrottle after RESOURCE_EXHAUSTED.
+const (
+	maxSlowdown = 64
+	// exhaustedBackoff is how long writes pause after RESOURCE_EXHAUSTED.
+	exhaustedBackoff = 5 * time.Second
+)
+
+// writeThrottle spends a WriteBudget. Its rate is halved whenever the
+// datastore responds with RESOURCE_EXHAUSTED, and recovers with successes.
+type writeThrottle struct {
+	budget WriteBudget
+	logger *log.Logger
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// slowdown divides budget.Rate; 1 is none.
+	slowdown float64
+	// paused is when writes may resume after RESOURCE_EXHAUSTED.
+	paused time.Time
+	// day is the UTC day `written` writes were made in.
+	day     string
+	written int
+}
+
+func newWriteThrottle(budget WriteBudget, logger *log.Logger) *writeThrottle {
+	if budget.Burst <= 0 {
+		budget.Burst = int(budget.Rate)
+	}
+	if budget.Burst < 1 {
+		budget.Burst = 1
+	}
+	return &writeThrottle{budget: budget, logger: logger, tokens: float64(budget.Burst), last: time.Now(), slowdown: 1}
+}
+
+// wait blocks until `n` writes are within the budget, and spends them. It
+// fails only when `ctx` is done.
+func (t *writeThrottle) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	for {
+		delay := t.reserve(n, time.Now())
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve spends `n` writes at `now` and returns zero, or returns how long to
+// wait before trying again.
+func (t *writeThrottle) reserve(n int, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Before(t.paused) {
+		return t.paused.Sub(now)
+	}
+	if t.budget.Daily > 0 {
+		day := now.UTC().Format("2006-01-02")
+		if day != t.day {
+			t.day, t.written = day, 0
+		}
+		if t.written > 0 && t.written+n > t.budget.Daily {
+			y, m, d := now.UTC().Date()
+			tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+			t.logger.Printf("The daily budget of %d writes is used up; waiting until %v\n", t.budget.Daily, tomorrow.Local())
+			return tomorrow.Sub(now)
+		}
+	}
+	if rate := t.budget.Rate / t.slowdown; rate > 0 {
+		t.tokens += now.Sub(t.last).Seconds() * rate
+		if t.tokens > float64(t.budget.Burst) {
+			t.tokens = float64(t.budget.Burst)
+		}
+		t.last = now
+		if t.tokens < 1 {
+			return time.Duration((1 - t.tokens) / rate * float64(time.Second))
+		}
+		// A write larger than the burst goes into debt, which the
+		// following writes wait for.
+		t.tokens -= float64(n)
+	}
+	t.written += n
+	return 0
+}
+
+// observe adapts the rate to `err`, the result of the writes.
+func (t *writeThrottle) observe(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status.Code(err) == codes.ResourceExhausted {
+		if t.slowdown < maxSlowdown {
+			t.slowdown *= 2
+		}
+		t.paused = time.Now().Add(exhaustedBackoff)
+		t.logger.Printf("The datastore is out of quota; slowing down writes by %v times\n", t.slowdown)
+		return
+	}
+	if err == nil && t.slowdown > 1 {
+		t.slowdown *= 0.95
+		if t.slowdown < 1 {
+			t.slowdown = 1
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteThrottle(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	now := time.Date(2020, 4, 1, 23, 59, 0, 0, time.UTC)
+	throttle := newWriteThrottle(WriteBudget{Rate: 10, Burst: 20, Daily: 30}, logger)
+	throttle.last = now
+	if d := throttle.reserve(20, now); d != 0 {
+		t.Errorf("the burst waits %v", d)
+	}
+	if d := throttle.reserve(1, now); d != 100*time.Millisecond {
+		t.Errorf("a write after the burst waits %v", d)
+	}
+	now = now.Add(time.Second)
+	if d := throttle.reserve(5, now); d != 0 {
+		t.Errorf("a write within the rate waits %v", d)
+	}
+	now = now.Add(time.Second)
+	if d := throttle.reserve(10, now); d != 58*time.Second {
+		t.Errorf("a write beyond the daily budget waits %v", d)
+	}
+	now = now.Add(time.Minute)
+	if d := throttle.reserve(10, now); d != 0 {
+		t.Errorf("a write on the next day waits %v", d)
+	}
+
+	throttle = newWriteThrottle(WriteBudget{Rate: 10}, logger)
+	throttle.observe(status.Error(codes.ResourceExhausted, "quota exceeded"))
+	if throttle.slowdown != 2 || throttle.reserve(1, time.Now()) == 0 {
+		t.Errorf("slowdown = %v without a pause", throttle.slowdown)
+	}
+	throttle.observe(nil)
+	if throttle.slowdown >= 2 || throttle.slowdown < 1 {
+		t.Errorf("slowdown = %v after a success", throttle.slowdown)
+	}
+
+	unlimited := newWriteThrottle(WriteBudget{}, logger)
+	if d := unlimited.reserve(1000, time.Now()); d != 0 {
+		t.Errorf("an unlimited write waits %v", d)
+	}
+}
@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// writesPerPiece is the number of datastore writes of syncing a new or
+// changed piece: the piece, its index and the audit entry.
+const writesPerPiece = 3
+
+// estimatedExtensions are the extensions of the audio files counted by
+// Estimate. Other files are skipped by the syncer unless they have tags, which
+// Estimate doesn't read.
+var estimatedExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".m4b":  true,
+	".mp4":  true,
+	".ogg":  true,
+	".dsf":  true,
+}
+
+// Estimate is the projected cost of a full sync.
+type Estimate struct {
+	// Files is the number of the audio and video files under the target.
+	Files int
+	// New is the number of the files not in the catalog.
+	New int
+	// Changed is the number of the files whose contents changed.
+	Changed int
+	// Moved is the number of the files in the catalog at another path.
+	Moved int
+	// Unverified is the number of the files in the catalog modified since
+	// they were hashed; they are changed or only retagged.
+	Unverified int
+	// Unchanged is the number of the files needing no writes.
+	Unchanged int
+	// Missing is the number of the pieces in the catalog under the target
+	// whose files are gone. The syncer leaves them alone.
+	Missing int
+	// StorageBytes is the size of all the files, which is what they take in
+	// GCS once synced.
+	StorageBytes int64
+	// UploadBytes is the size of the files to upload, and MaxUploadBytes
+	// includes the Unverified files too.
+	UploadBytes, MaxUploadBytes int64
+	// Pieces is the number of the pieces after the sync, each with an index
+	// entity.
+	Pieces int
+	// Writes is the number of the datastore writes, and MaxWrites includes
+	// the Unverified files too.
+	Writes, MaxWrites int
+}
+
+// localFile is a file found by Estimate. `hash` is empty unless the hash
+// cache knows the file unmodified.
+type localFile struct {
+	path string
+	size int64
+	hash string
+}
+
+// Estimate walks the target and compares it with the hash cache and the
+// catalog in the datastore, without writing anything.
+func (s *Syncer) Estimate(ctx context.Context) (*Estimate, error) {
+	client, err := datastore.NewClient(ctx, s.opts.ProjectID, benten.ClientOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var catalog []benten.Metadata
+	if _, err := client.GetAll(ctx, datastore.NewQuery(benten.PieceKind), &catalog); err != nil {
+		return nil, err
+	}
+	hashes, err := loadHashCache(s.opts.HashCachePath)
+	if err != nil {
+		return nil, err
+	}
+	var files []localFile
+	err = filepath.Walk(s.opts.Target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !s.estimated(path) {
+			return nil
+		}
+		f := localFile{path: path, size: info.Size()}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		entry := hashEntry{Size: info.Size(), ModTime: info.ModTime()}
+		if entry.Fingerprint, err = fingerprint(file, info.Size()); err != nil {
+			return err
+		}
+		f.hash, _ = hashes.lookup(path, entry)
+		files = append(files, f)
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return estimate(files, catalog, s.opts.Target), nil
+}
+
+// estimated returns true if the file at `path` is counted by Estimate.
+func (s *Syncer) estimated(path string) bool {
+	if s.isVideo(path) {
+		return true
+	}
+	return estimatedExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// estimate compares `files` under `target` with `catalog`.
+func estimate(files []localFile, catalog []benten.Metadata, target string) *Estimate {
+	byPath := make(map[string]string)
+	byHash := make(map[string]bool)
+	for _, m := range catalog {
+		if m.IsTrashed() {
+			continue
+		}
+		byPath[m.Path] = m.Hash
+		byHash[m.Hash] = true
+	}
+	e := &Estimate{Files: len(files), Pieces: len(byPath)}
+	found := make(map[string]bool)
+	moved := make(map[string]bool)
+	for _, f := range files {
+		found[f.path] = true
+		e.StorageBytes += f.size
+		hash, cataloged := byPath[f.path]
+		switch {
+		case cataloged && f.hash == "":
+			e.Unverified++
+			e.MaxUploadBytes += f.size
+			e.MaxWrites += writesPerPiece
+			continue
+		case cataloged && f.hash == hash:
+			e.Unchanged++
+			continue
+		case f.hash != "" && byHash[f.hash]:
+			// The piece is updated in place, and its object is reused.
+			e.Moved++
+			moved[f.hash] = true
+			e.Writes += writesPerPiece
+			continue
+		case cataloged:
+			e.Changed++
+		default:
+			e.New++
+			e.Pieces++
+		}
+		e.UploadBytes += f.size
+		e.Writes += writesPerPiece
+	}
+	e.MaxUploadBytes += e.UploadBytes
+	e.MaxWrites += e.Writes
+	prefix := target + string(filepath.Separator)
+	for path, hash := range byPath {
+		if strings.HasPrefix(path, prefix) && !found[path] && !moved[hash] {
+			e.Missing++
+		}
+	}
+	return e
+}
@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yutakahirano/benten"
+)
+
+func TestEstimate(t *testing.T) {
+	catalog := []benten.Metadata{
+		{Path: "/music/same.mp3", Hash: "same"},
+		{Path: "/music/changed.mp3", Hash: "old"},
+		{Path: "/music/retagged.mp3", Hash: "retagged"},
+		{Path: "/music/old/moved.mp3", Hash: "moved"},
+		{Path: "/music/gone.mp3", Hash: "gone"},
+		{Path: "/music/trashed.mp3", Hash: "trashed", Deleted: time.Now()},
+		{Path: "/elsewhere/a.mp3", Hash: "elsewhere"},
+	}
+	files := []localFile{
+		{path: "/music/same.mp3", size: 1, hash: "same"},
+		{path: "/music/changed.mp3", size: 10, hash: "new"},
+		{path: "/music/retagged.mp3", size: 100},
+		{path: "/music/new/moved.mp3", size: 1000, hash: "moved"},
+		{path: "/music/new.mp3", size: 10000},
+	}
+	got := *estimate(files, catalog, "/music")
+	want := Estimate{
+		Files:          5,
+		New:            1,
+		Changed:        1,
+		Moved:          1,
+		Unverified:     1,
+		Unchanged:      1,
+		Missing:        1,
+		StorageBytes:   11111,
+		UploadBytes:    10010,
+		MaxUploadBytes: 10110,
+		Pieces:         7,
+		Writes:         9,
+		MaxWrites:      12,
+	}
+	if got != want {
+		t.Errorf("estimate() = %+v, want %+v", got, want)
+	}
+}
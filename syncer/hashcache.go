@@ -0,0 +1,137 @@
+package syncer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// fingerprintSampleSize is the number of bytes sampled from each end of a
+// file for its fingerprint.
+const fingerprintSampleSize = 64 << 10
+
+// hashCacheSaveInterval is how often the hash cache is written to the disk.
+const hashCacheSaveInterval = time.Minute
+
+// hashEntry is what the hash cache knows about a file.
+type hashEntry struct {
+	Size    int64
+	ModTime time.Time
+	// Fingerprint is the hash of the size and the head and the tail of the
+	// file; see fingerprint.
+	Fingerprint string
+	// Hash is the full metadata-invariant checksum of the file.
+	Hash string
+}
+
+// hashCache remembers the hashes of files by path, so that only the files
+// whose cheap fingerprints changed are read entirely. It is kept in the JSON
+// file at `path`, or only in memory if `path` is empty.
+type hashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]hashEntry
+	dirty   bool
+}
+
+func loadHashCache(path string) (*hashCache, error) {
+	c := &hashCache{path: path, entries: make(map[string]hashEntry)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, json.Unmarshal(data, &c.entries)
+}
+
+// lookup returns the hash of the file at `path` if the cached entry has the
+// same size, modification time and fingerprint as `e`.
+func (c *hashCache) lookup(path string, e hashEntry) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[path]
+	if !ok || cached.Size != e.Size || !cached.ModTime.Equal(e.ModTime) || cached.Fingerprint != e.Fingerprint {
+		return "", false
+	}
+	return cached.Hash, true
+}
+
+func (c *hashCache) store(path string, e hashEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = e
+	c.dirty = true
+}
+
+// save writes the cache if it has changed.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	if c.path == "" || !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Write and rename so that a crash doesn't leave a truncated file.
+	if err := ioutil.WriteFile(c.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+// fingerprint returns a hash of the size and up to fingerprintSampleSize bytes
+// from the head and from the tail of `file`. Tags are at the ends of audio
+// files, so retagging changes it.
+func fingerprint(file io.ReaderAt, size int64) (string, error) {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(size)
+	head := int64(fingerprintSampleSize)
+	if head > size {
+		head = size
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, head)); err != nil {
+		return "", err
+	}
+	tail := size - fingerprintSampleSize
+	if tail < head {
+		tail = head
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(file, tail, size-tail)); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// saveHashes saves the hash cache periodically and when `ctx` is done.
+func (s *Syncer) saveHashes(ctx context.Context) {
+	ticker := time.NewTicker(hashCacheSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.hashes.save(); err != nil {
+				s.logger.Printf("Failed to save the hash cache: %v\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.hashes.save(); err != nil {
+				s.logger.Printf("Failed to save the hash cache: %v\n", err)
+			}
+		}
+	}
+}
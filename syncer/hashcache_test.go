@@ -0,0 +1,59 @@
+package syncer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 3*fingerprintSampleSize)
+	base, err := fingerprint(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The middle of the file is not sampled.
+	data[fingerprintSampleSize+1] = 'b'
+	if f, _ := fingerprint(bytes.NewReader(data), int64(len(data))); f != base {
+		t.Errorf("the fingerprint depends on the middle")
+	}
+	data[len(data)-1] = 'b'
+	if f, _ := fingerprint(bytes.NewReader(data), int64(len(data))); f == base {
+		t.Errorf("the fingerprint doesn't depend on the tail")
+	}
+	if _, err := fingerprint(bytes.NewReader(data[:10]), 10); err != nil {
+		t.Errorf("err = %v for a small file", err)
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hashes.json")
+	c, err := loadHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := hashEntry{Size: 3, ModTime: time.Unix(100, 0), Fingerprint: "f", Hash: "h"}
+	c.store("a.mp3", entry)
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = loadHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, ok := c.lookup("a.mp3", hashEntry{Size: 3, ModTime: time.Unix(100, 0), Fingerprint: "f"}); !ok || hash != "h" {
+		t.Errorf("lookup = %q, %v", hash, ok)
+	}
+	if _, ok := c.lookup("a.mp3", hashEntry{Size: 3, ModTime: time.Unix(101, 0), Fingerprint: "f"}); ok {
+		t.Errorf("found an entry with another modification time")
+	}
+}
@@ -0,0 +1,253 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"google.golang.org/api/iterator"
+)
+
+// ClearIndex deletes all the index entries.
+func (s *Syncer) ClearIndex(ctx context.Context) error {
+	client, err := datastore.NewClient(ctx, s.opts.ProjectID, benten.ClientOptions()...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	query := datastore.NewQuery(benten.PieceIndexKind)
+	iter := client.Run(ctx, query)
+	for {
+		key, err := iter.Next(nil)
+		if err != nil {
+			if err == iterator.Done {
+				return nil
+			}
+			return err
+		}
+		if err := s.writes.wait(ctx, 1); err != nil {
+			return err
+		}
+		err = client.Delete(ctx, key)
+		s.writes.observe(err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// audit records `action` done by the syncer. Failures are only logged.
+func (s *Syncer) audit(ctx context.Context, action, target, before, after string) {
+	entry := benten.AuditLog{Actor: "syncer", Action: action, Target: target, Before: before, After: after}
+	err := s.writes.wait(ctx, 1)
+	if err == nil {
+		err = benten.RecordAudit(ctx, s.datastoreClient, entry)
+		s.writes.observe(err)
+	}
+	if err != nil {
+		s.logger.Printf("Failed to record %s on %s: %v\n", action, target, err)
+	}
+}
+
+// updateResult describes what updateMetadata did.
+type updateResult int
+
+const (
+	// added means the metadata was new to the library.
+	added updateResult = iota
+	// updated means the metadata replaced existing entries.
+	updated
+	// unchanged means an identical entry already existed, so nothing was written.
+	unchanged
+	// conflicted means the entry was edited via the API after the file was
+	// modified, so the edits were kept and nothing was written.
+	conflicted
+)
+
+// isUnchanged returns true if the only entry having the same hash and path as
+// `metadata` is identical to it. The file is the same, so Replicated,
+// Renditions, Created, Updated, Revision, Edited and Reviewed are taken over
+// from the entry.
+func (s *Syncer) isUnchanged(ctx context.Context, metadata *benten.Metadata) (bool, error) {
+	query := datastore.NewQuery(benten.PieceKind).Filter("Hash =", metadata.Hash).Filter("Path =", metadata.Path).Limit(2)
+	var existing []benten.Metadata
+	_, err := s.datastoreClient.GetAll(ctx, query, &existing)
+	if err != nil {
+		return false, err
+	}
+	if len(existing) != 1 {
+		return false, nil
+	}
+	metadata.Replicated = existing[0].Replicated
+	metadata.Renditions = existing[0].Renditions
+	metadata.Created = existing[0].Created
+	metadata.Updated = existing[0].Updated
+	metadata.Revision = existing[0].Revision
+	metadata.Edited = existing[0].Edited
+	metadata.Reviewed = existing[0].Reviewed
+	metadata.SetNeedsReview()
+	return existing[0] == *metadata, nil
+}
+
+// updateMetadata stores `metadata` read from a file modified at `modTime`,
+// replacing existing entries having the same hash or path. Nothing is written
+// when an identical entry already exists, or when the entry was edited via the
+// API after `modTime`. The entry is read in the transaction, so an edit racing
+// with this makes the commit fail. The index is written in the same
+// transaction, so that no piece is left unsearchable.
+func (s *Syncer) updateMetadata(ctx context.Context, metadata *benten.Metadata, modTime time.Time) (updateResult, error) {
+	client := s.datastoreClient
+	same, err := s.isUnchanged(ctx, metadata)
+	if err != nil {
+		s.logger.Printf("Failed to get existing metadata: %v\n", err)
+		return added, err
+	}
+	if same {
+		return unchanged, nil
+	}
+
+	// The piece and its index; the trashed pieces are counted after.
+	if err := s.writes.wait(ctx, 2); err != nil {
+		return added, err
+	}
+	tr, err := client.NewTransaction(ctx)
+	if err != nil {
+		s.logger.Printf("Failed to create a transaction: %v\n", err)
+		return added, err
+	}
+	defer tr.Rollback()
+
+	// Find existing entries having the same path or the same content hash.
+	// The first of them is updated in place so that its index can be diffed,
+	// and the others are moved to the trash: in this transaction, but for
+	// those beyond benten.MaxTrashInTransaction, which are trashed after it.
+	query := datastore.NewQuery(benten.PieceKind).Transaction(tr).Filter("Path =", metadata.Path).KeysOnly()
+	existingPieces, err := client.GetAll(ctx, query, nil)
+	if err != nil {
+		s.logger.Printf("Failed to get existing metadata: %v\n", err)
+		return added, err
+	}
+	query = datastore.NewQuery(benten.PieceKind).Transaction(tr).Filter("Hash =", metadata.Hash).KeysOnly()
+	existingPieces2, err := client.GetAll(ctx, query, nil)
+	if err != nil {
+		s.logger.Printf("Failed to get existing metadata: %v\n", err)
+		return added, err
+	}
+	var reusedKey *datastore.Key
+	deletedPieces := make([]*datastore.Key, 0)
+	seen := make(map[string]bool)
+	for _, key := range append(existingPieces, existingPieces2...) {
+		// A piece may have both the path and the hash.
+		if seen[key.Encode()] {
+			continue
+		}
+		seen[key.Encode()] = true
+		if reusedKey == nil {
+			reusedKey = key
+			continue
+		}
+		deletedPieces = append(deletedPieces, key)
+	}
+	trashedNow, trashedLater := deletedPieces, []*datastore.Key(nil)
+	if len(deletedPieces) > benten.MaxTrashInTransaction {
+		trashedNow, trashedLater = deletedPieces[:benten.MaxTrashInTransaction], deletedPieces[benten.MaxTrashInTransaction:]
+	}
+	if len(trashedNow) > 0 {
+		if err := benten.TrashPiecesInTransaction(tr, trashedNow); err != nil {
+			s.logger.Printf("Failed to trash existing metadata: %v\n", err)
+			return added, err
+		}
+	}
+
+	metadata.Updated = time.Now()
+	metadata.Created = metadata.Updated
+	metadata.Revision = 1
+	key := datastore.IncompleteKey(benten.PieceKind, nil)
+	result := added
+	var existing benten.Metadata
+	if reusedKey != nil {
+		if err := tr.Get(reusedKey, &existing); err != nil {
+			s.logger.Printf("Failed to get existing metadata: %v\n", err)
+			return added, err
+		}
+		if existing.Edited.After(modTime) {
+			return conflicted, nil
+		}
+		metadata.Revision = existing.Revision + 1
+		metadata.Created = existing.Created
+		metadata.Edited = existing.Edited
+		metadata.Reviewed = existing.Reviewed
+		metadata.SetNeedsReview()
+		if existing.Hash == metadata.Hash {
+			// The renditions are of the same audio.
+			metadata.Renditions = existing.Renditions
+		}
+		key = reusedKey
+		result = updated
+	} else {
+		// The key is reserved to put the index in this transaction, so that
+		// no piece is committed without it.
+		keys, err := client.AllocateIDs(ctx, []*datastore.Key{key})
+		if err != nil {
+			s.logger.Printf("Failed to allocate a key: %v\n", err)
+			return result, err
+		}
+		key = keys[0]
+	}
+	if _, err := tr.Put(key, metadata); err != nil {
+		s.logger.Printf("Failed to put %v: %v\n", *key, err)
+		return result, err
+	}
+	if err := benten.RespanIndexInTransaction(tr, metadata, key, s.aliases()); err != nil {
+		s.logger.Printf("Failed to update title index: %v", err)
+		return result, err
+	}
+	_, err = tr.Commit()
+	s.writes.observe(err)
+	if err != nil {
+		s.logger.Printf("Failed to commit the transaction: %v\n", err)
+		return result, err
+	}
+	if err := s.writes.wait(ctx, 2*len(deletedPieces)); err != nil {
+		return result, err
+	}
+	if len(trashedLater) > 0 {
+		err := benten.TrashPieces(ctx, client, trashedLater)
+		s.writes.observe(err)
+		if err != nil {
+			s.logger.Printf("Failed to trash existing metadata: %v\n", err)
+			return result, err
+		}
+	}
+
+	if index := s.opts.SearchIndex; index != nil {
+		for _, deleted := range deletedPieces {
+			if err := index.Delete(ctx, deleted); err != nil {
+				s.logger.Printf("Failed to delete %v from the search index: %v", deleted, err)
+				return result, err
+			}
+		}
+		if err := index.Index(ctx, key, metadata, s.aliases()); err != nil {
+			s.logger.Printf("Failed to update the search index: %v", err)
+			return result, err
+		}
+	}
+	for _, deleted := range deletedPieces {
+		s.audit(ctx, benten.AuditPieceTrashed, deleted.Encode(), "", "replaced by "+metadata.Path)
+	}
+	if result == added {
+		s.audit(ctx, benten.AuditPieceAdded, key.Encode(), "", metadata.Summary())
+	} else {
+		before, after := benten.DiffMetadata(&existing, metadata)
+		s.audit(ctx, benten.AuditPieceUpdated, key.Encode(), before, after)
+	}
+	if cache := s.opts.SearchCache; cache != nil {
+		if err := cache.Invalidate(ctx); err != nil {
+			// Cached results expire anyway.
+			s.logger.Printf("Failed to invalidate the search cache: %v", err)
+		}
+	}
+	return result, nil
+}
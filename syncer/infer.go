@@ -0,0 +1,49 @@
+package syncer
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// untaggedExtensions are the extensions of the audio files synced without
+// tags when Options.PathTemplates are given, and their file types.
+var untaggedExtensions = map[string]tag.FileType{
+	".mp3":  tag.MP3,
+	".flac": tag.FLAC,
+	".m4a":  tag.M4A,
+	".ogg":  tag.OGG,
+}
+
+// untaggedFile is the tag.Metadata of an audio file without tags, whose
+// metadata is inferred from its path.
+type untaggedFile struct {
+	fileType tag.FileType
+}
+
+func (t untaggedFile) Format() tag.Format          { return tag.UnknownFormat }
+func (t untaggedFile) FileType() tag.FileType      { return t.fileType }
+func (t untaggedFile) Title() string               { return "" }
+func (t untaggedFile) Album() string               { return "" }
+func (t untaggedFile) Artist() string              { return "" }
+func (t untaggedFile) AlbumArtist() string         { return "" }
+func (t untaggedFile) Composer() string            { return "" }
+func (t untaggedFile) Genre() string               { return "" }
+func (t untaggedFile) Year() int                   { return 0 }
+func (t untaggedFile) Track() (int, int)           { return 0, 0 }
+func (t untaggedFile) Disc() (int, int)            { return 0, 0 }
+func (t untaggedFile) Picture() *tag.Picture       { return nil }
+func (t untaggedFile) Lyrics() string              { return "" }
+func (t untaggedFile) Comment() string             { return "" }
+func (t untaggedFile) Raw() map[string]interface{} { return nil }
+
+// untagged returns the tags of the untagged audio file at `path`, or false if
+// it is not synced.
+func (s *Syncer) untagged(path string) (tag.Metadata, bool) {
+	fileType, ok := untaggedExtensions[strings.ToLower(filepath.Ext(path))]
+	if !ok || len(s.opts.PathTemplates) == 0 {
+		return nil, false
+	}
+	return untaggedFile{fileType}, true
+}
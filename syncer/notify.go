@@ -0,0 +1,154 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Summary is the content of a notification.
+type Summary struct {
+	// Subject is a one-line description of why the notification is sent.
+	Subject string
+	// Progress is the progress of the Syncer when the notification is sent.
+	Progress Progress
+}
+
+// Text returns a human readable description of the summary.
+func (s Summary) Text() string {
+	p := s.Progress
+	return fmt.Sprintf("%s\n\nadded: %d\nupdated: %d\nunchanged: %d\nconflicted: %d\nfailed: %d\nskipped: %d\nuploaded bytes: %d\n",
+		s.Subject, p.Added, p.Updated, p.Unchanged, p.Conflicted, p.Failed, p.Skipped, p.UploadedBytes)
+}
+
+// Notifier delivers summaries to the user.
+type Notifier interface {
+	Notify(ctx context.Context, summary Summary) error
+}
+
+func checkResponse(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// WebhookNotifier posts summaries as JSON to a URL.
+type WebhookNotifier struct {
+	URL string
+}
+
+// Notify implements Notifier.
+func (n WebhookNotifier) Notify(ctx context.Context, summary Summary) error {
+	p := summary.Progress
+	body, err := json.Marshal(map[string]interface{}{
+		"subject":       summary.Subject,
+		"text":          summary.Text(),
+		"added":         p.Added,
+		"updated":       p.Updated,
+		"unchanged":     p.Unchanged,
+		"conflicted":    p.Conflicted,
+		"failed":        p.Failed,
+		"skipped":       p.Skipped,
+		"uploadedBytes": p.UploadedBytes,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	return checkResponse(http.DefaultClient.Do(req.WithContext(ctx)))
+}
+
+// PushoverNotifier sends summaries via https://pushover.net.
+type PushoverNotifier struct {
+	Token string
+	User  string
+}
+
+// Notify implements Notifier.
+func (n PushoverNotifier) Notify(ctx context.Context, summary Summary) error {
+	form := url.Values{
+		"token":   {n.Token},
+		"user":    {n.User},
+		"title":   {summary.Subject},
+		"message": {summary.Text()},
+	}
+	req, err := http.NewRequest("POST", "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	return checkResponse(http.DefaultClient.Do(req.WithContext(ctx)))
+}
+
+// EmailNotifier sends summaries via SMTP.
+type EmailNotifier struct {
+	// Server is the address of the SMTP server, e.g. "smtp.example.com:587".
+	Server   string
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+// Notify implements Notifier.
+func (n EmailNotifier) Notify(ctx context.Context, summary Summary) error {
+	var auth smtp.Auth
+	if n.User != "" {
+		auth = smtp.PlainAuth("", n.User, n.Password, strings.Split(n.Server, ":")[0])
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		n.From, strings.Join(n.To, ", "), summary.Subject, strings.ReplaceAll(summary.Text(), "\n", "\r\n"))
+	return smtp.SendMail(n.Server, auth, n.From, n.To, []byte(message))
+}
+
+func (s *Syncer) notify(ctx context.Context, subject string, p Progress) {
+	summary := Summary{Subject: subject, Progress: p}
+	for _, notifier := range s.opts.Notifiers {
+		if err := notifier.Notify(ctx, summary); err != nil {
+			s.logger.Printf("Failed to send a notification: %v\n", err)
+		}
+	}
+}
+
+// watchProgress sends notifications when the full scan finishes and every
+// time the number of failures grows by ErrorThreshold.
+func (s *Syncer) watchProgress(ctx context.Context) {
+	if len(s.opts.Notifiers) == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	scanReported := false
+	failuresReported := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p := s.Progress()
+		if !scanReported && p.ScanDone && p.Remaining() == 0 {
+			scanReported = true
+			s.notify(ctx, fmt.Sprintf("benten: full scan finished (%d files)", p.Discovered), p)
+		}
+		if s.opts.ErrorThreshold > 0 && p.Failed-failuresReported >= s.opts.ErrorThreshold {
+			failuresReported = p.Failed
+			s.notify(ctx, fmt.Sprintf("benten: %d files failed to sync", p.Failed), p)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	n := WebhookNotifier{URL: server.URL}
+	summary := Summary{Subject: "done", Progress: Progress{Added: 3, Updated: 1, Failed: 2}}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got["subject"] != "done" || got["added"] != 3.0 || got["updated"] != 1.0 || got["failed"] != 2.0 {
+		t.Errorf("got = %v", got)
+	}
+}
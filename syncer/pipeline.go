@@ -0,0 +1,173 @@
+package syncer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/dhowden/tag"
+	"github.com/yutakahirano/benten"
+)
+
+// piece is the unit of work flowing through the pipeline. Each stage fills in
+// some of the fields and passes it to the next stage.
+type piece struct {
+	// path is the path of the audio file.
+	path string
+	// tags is the tag read from the file.
+	tags tag.Metadata
+	// hash is the metadata-invariant checksum of the file.
+	hash string
+	// pictureHash is the key of the album picture, or the empty string if unavailable.
+	pictureHash string
+	// picture is the album picture to upload, or nil if there is none or it
+	// has already been uploaded.
+	picture *tag.Picture
+	// pictureDir is the directory `picture` was found in, or the empty string
+	// if it is embedded in the file.
+	pictureDir string
+	// pictureSource is the provider the picture was fetched from, or the
+	// empty string if it came with the file.
+	pictureSource string
+	// mediaType is benten.MediaAudio or benten.MediaVideo.
+	mediaType string
+	// gapless is the encoder delay and padding read from the file.
+	gapless benten.Gapless
+	// bitrate is the average bitrate in kbps, or zero if unknown.
+	bitrate int
+	// duration is the length of the piece, or zero if unknown.
+	duration time.Duration
+	// modTime is the modification time of the file.
+	modTime time.Time
+	// object is the name of the object of the piece, set by the read stage.
+	object string
+	// modified is set by the read stage when the file is new or modified
+	// since its hash was cached, so that its object may be missing or stale.
+	modified bool
+}
+
+func (p *piece) metadata() benten.Metadata {
+	metadata := benten.NewMetadata(p.tags, p.pictureHash, p.hash, p.path)
+	metadata.PictureSource = p.pictureSource
+	metadata.MediaType = p.mediaType
+	metadata.Gapless = p.gapless
+	metadata.Bitrate = p.bitrate
+	metadata.Duration = p.duration
+	return metadata
+}
+
+// Concurrency configures the number of workers of each pipeline stage.
+// Zero values are replaced with defaults.
+type Concurrency struct {
+	// ReadTags is the number of workers reading tags, computing hashes and
+	// finding album pictures. Defaults to the number of CPUs.
+	ReadTags int
+	// UploadArt is the number of workers uploading album pictures.
+	UploadArt int
+	// Index is the number of workers updating metadata and the index.
+	Index int
+	// UploadPiece is the number of goroutines handling upload requests, and
+	// of workers uploading discovered pieces with Options.UploadPieces.
+	UploadPiece int
+}
+
+func (c Concurrency) withDefaults() Concurrency {
+	if c.ReadTags <= 0 {
+		c.ReadTags = runtime.NumCPU()
+	}
+	if c.UploadArt <= 0 {
+		c.UploadArt = 2
+	}
+	if c.Index <= 0 {
+		c.Index = 2
+	}
+	if c.UploadPiece <= 0 {
+		c.UploadPiece = 1
+	}
+	return c
+}
+
+// runStage starts `n` workers, each of which calls `f` for every value
+// received from `in` and sends the result to `out` unless it is nil. Sending
+// blocks while the next stage is busy, which propagates backpressure to the
+// previous stages. `out` (if non-nil) is closed once all the workers finish.
+func runStage(ctx context.Context, n int, in <-chan *piece, out chan<- *piece, f func(ctx context.Context, p *piece) *piece) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var p *piece
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case p, ok = <-in:
+					if !ok {
+						return
+					}
+				}
+				p = f(ctx, p)
+				if p == nil || out == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- p:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		if out != nil {
+			close(out)
+		}
+	}()
+}
+
+// startPipeline starts the stages following discovery and debouncing:
+// read tags → upload art → (upload piece, with Options.UploadPieces →)
+// index. Pieces sent to the returned channel go through the stages in order,
+// and each stage blocks when the queue to the next one is full. The order is
+// deliberate: a piece is indexed only once its contents are uploaded, so
+// that a failed upload never leaves a searchable piece which can't be
+// streamed.
+func (s *Syncer) startPipeline(ctx context.Context) chan<- *piece {
+	queueSize := s.opts.QueueSize
+	concurrency := s.opts.Concurrency
+	discovered := make(chan *piece, queueSize)
+	read := make(chan *piece, queueSize)
+	withArt := make(chan *piece, queueSize)
+
+	var bucket *storage.BucketHandle
+	if s.storageClient != nil {
+		bucket = s.storageClient.Bucket(benten.AlbumPictureBucket)
+	}
+	arts := newAlbumArts(bucket)
+	// Reading is bound by the CPU and the disk, and uploading by the network,
+	// so they are separate stages which don't wait for each other as long as
+	// the queue between them has room.
+	runStage(ctx, concurrency.ReadTags, discovered, read, func(ctx context.Context, p *piece) *piece {
+		if p = s.readTags(ctx, p); p != nil {
+			p.object = s.objectName(p)
+			s.locateAlbumArt(arts, p)
+		}
+		return p
+	})
+	runStage(ctx, concurrency.UploadArt, read, withArt, func(ctx context.Context, p *piece) *piece {
+		return s.uploadAlbumArt(ctx, arts, p)
+	})
+	if !s.opts.UploadPieces {
+		runStage(ctx, concurrency.Index, withArt, nil, s.index)
+		return discovered
+	}
+	uploaded := make(chan *piece, queueSize)
+	runStage(ctx, concurrency.UploadPiece, withArt, uploaded, s.uploadDiscovered)
+	runStage(ctx, concurrency.Index, uploaded, nil, s.index)
+	return discovered
+}
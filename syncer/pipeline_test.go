@@ -0,0 +1,36 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunStage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan *piece)
+	out := make(chan *piece)
+	runStage(ctx, 3, in, out, func(ctx context.Context, p *piece) *piece {
+		if p.path == "drop" {
+			return nil
+		}
+		p.hash = "hash-" + p.path
+		return p
+	})
+
+	go func() {
+		for _, path := range []string{"a", "drop", "b", "c"} {
+			in <- &piece{path: path}
+		}
+		close(in)
+	}()
+
+	got := make(map[string]string)
+	for p := range out {
+		got[p.path] = p.hash
+	}
+	if len(got) != 3 || got["a"] != "hash-a" || got["b"] != "hash-b" || got["c"] != "hash-c" {
+		t.Errorf("got = %v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package syncer
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of the work done by a Syncer.
+type Progress struct {
+	// Started is when the full scan started, or the zero value if it has not.
+	Started time.Time
+	// Discovered is the number of files found by the full scan.
+	Discovered int
+	// ScanDone is true once the full scan has found all the files.
+	ScanDone bool
+	// Processed is the number of files synced successfully.
+	Processed int
+	// Added is the number of processed files which were new to the library.
+	Added int
+	// Updated is the number of processed files which replaced existing entries.
+	Updated int
+	// Unchanged is the number of processed files whose metadata was already stored.
+	Unchanged int
+	// Conflicted is the number of processed files whose entries had been
+	// edited via the API after the files were modified, so were kept.
+	Conflicted int
+	// Skipped is the number of files which are not audio files.
+	Skipped int
+	// Failed is the number of files which failed to sync.
+	Failed int
+	// UploadedBytes is the number of bytes uploaded to the storage.
+	UploadedBytes int64
+	// Current is the file being processed most recently.
+	Current string
+}
+
+// Done returns the number of files which went through the pipeline. Each of
+// them is counted once, in Processed, Skipped or Failed: a file failing to
+// upload is not indexed, so it is not Processed.
+func (p Progress) Done() int {
+	return p.Processed + p.Skipped + p.Failed
+}
+
+// Remaining returns the number of discovered files not done yet.
+func (p Progress) Remaining() int {
+	if p.Done() > p.Discovered {
+		return 0
+	}
+	return p.Discovered - p.Done()
+}
+
+// ETA estimates the remaining time of the full scan from the throughput so
+// far. It returns false when there is not enough information.
+func (p Progress) ETA(now time.Time) (time.Duration, bool) {
+	if p.Started.IsZero() || !p.ScanDone || p.Done() == 0 {
+		return 0, false
+	}
+	elapsed := now.Sub(p.Started)
+	perFile := elapsed / time.Duration(p.Done())
+	return perFile * time.Duration(p.Remaining()), true
+}
+
+// progressCounter accumulates Progress from the pipeline stages.
+type progressCounter struct {
+	mu sync.Mutex
+	p  Progress
+}
+
+func (c *progressCounter) update(f func(p *Progress)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.p)
+}
+
+func (c *progressCounter) snapshot() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.p
+}
+
+// Progress returns the current progress of the Syncer.
+func (s *Syncer) Progress() Progress {
+	return s.progress.snapshot()
+}
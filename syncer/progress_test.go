@@ -0,0 +1,24 @@
+package syncer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressETA(t *testing.T) {
+	started := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	p := Progress{Started: started, Discovered: 10, Processed: 3, Skipped: 1, Failed: 1}
+
+	if _, ok := p.ETA(started.Add(time.Minute)); ok {
+		t.Errorf("ETA must be unknown while scanning")
+	}
+
+	p.ScanDone = true
+	eta, ok := p.ETA(started.Add(50 * time.Second))
+	if !ok || eta != 50*time.Second {
+		t.Errorf("eta = %v, ok = %v", eta, ok)
+	}
+	if p.Remaining() != 5 {
+		t.Errorf("remaining = %d", p.Remaining())
+	}
+}
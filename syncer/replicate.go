@@ -0,0 +1,88 @@
+package syncer
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+)
+
+// replication is an object to mirror to Options.Replica.
+type replication struct {
+	// bucket and name identify the uploaded object.
+	bucket string
+	name   string
+	// path is the path of the piece whose Metadata records the status, or
+	// the empty string for album pictures.
+	path string
+}
+
+// enqueueReplication queues the object for replication if a replica is configured.
+func (s *Syncer) enqueueReplication(ctx context.Context, r replication) {
+	if s.replications == nil {
+		return
+	}
+	select {
+	case s.replications <- r:
+	case <-ctx.Done():
+	}
+}
+
+// replicate copies objects queued by enqueueReplication to the replica until
+// `ctx` is done. An object is stored in the replica as "<bucket>/<name>".
+func (s *Syncer) replicate(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-s.replications:
+			if err := s.replicateObject(ctx, r); err != nil {
+				s.logger.Printf("Failed to replicate %s/%s: %v\n", r.bucket, r.name, err)
+				continue
+			}
+			if r.path == "" {
+				continue
+			}
+			if err := s.markReplicated(ctx, r.path); err != nil {
+				s.logger.Printf("Failed to record the replication of %s: %v\n", r.path, err)
+			}
+		}
+	}
+}
+
+func (s *Syncer) replicateObject(ctx context.Context, r replication) error {
+	reader, err := s.storageClient.Bucket(r.bucket).Object(r.name).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer := s.opts.Replica.NewWriter(ctx, r.bucket+"/"+r.name, reader.Attrs.ContentType)
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// markReplicated sets Replicated of the pieces at `path`.
+func (s *Syncer) markReplicated(ctx context.Context, path string) error {
+	if err := s.writes.wait(ctx, 1); err != nil {
+		return err
+	}
+	_, err := s.datastoreClient.RunInTransaction(ctx, func(tr *datastore.Transaction) error {
+		var pieces []benten.Metadata
+		query := datastore.NewQuery(benten.PieceKind).Transaction(tr).Filter("Path =", path)
+		keys, err := s.datastoreClient.GetAll(ctx, query, &pieces)
+		if err != nil {
+			return err
+		}
+		for i := range pieces {
+			pieces[i].Replicated = true
+		}
+		_, err = tr.PutMulti(keys, pieces)
+		return err
+	})
+	s.writes.observe(err)
+	return err
+}
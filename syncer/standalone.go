@@ -0,0 +1,110 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/dhowden/tag"
+	"github.com/yutakahirano/benten"
+)
+
+// storeMetadata writes `metadata` to Options.Store, taking over the fields
+// kept by updateMetadata from the existing entry at the same path.
+func (s *Syncer) storeMetadata(ctx context.Context, metadata *benten.Metadata) (updateResult, error) {
+	existing, err := s.opts.Store.Get(ctx, metadata.Path)
+	if err != nil && err != benten.ErrNotFound {
+		s.logger.Printf("Failed to get existing metadata: %v\n", err)
+		return added, err
+	}
+	result := added
+	metadata.Revision = 1
+	metadata.SetNeedsReview()
+	if existing != nil {
+		metadata.Replicated = existing.Replicated
+		metadata.Updated = existing.Updated
+		metadata.Revision = existing.Revision
+		metadata.Edited = existing.Edited
+		metadata.Reviewed = existing.Reviewed
+		metadata.SetNeedsReview()
+		if *existing == *metadata {
+			return unchanged, nil
+		}
+		result = updated
+		metadata.Revision = existing.Revision + 1
+	}
+	metadata.Updated = time.Now()
+	if err := s.opts.Store.Put(ctx, metadata); err != nil {
+		s.logger.Printf("Failed to put metadata: %v\n", err)
+		return added, err
+	}
+	return result, nil
+}
+
+// storePicture uploads `picture` as `key` into `bucket`, or into
+// Options.Blobs in the standalone mode.
+func (s *Syncer) storePicture(ctx context.Context, bucket *storage.BucketHandle, key string, picture *tag.Picture) error {
+	if s.opts.Store == nil {
+		return s.uploadPicture(ctx, bucket, key, picture)
+	}
+	writer := s.opts.Blobs.NewWriter(ctx, benten.AlbumPictureBucket+"/"+key, picture.MIMEType)
+	n, err := io.Copy(writer, bytes.NewReader(picture.Data))
+	s.progress.update(func(p *Progress) { p.UploadedBytes += n })
+	if err != nil {
+		writer.Close()
+		s.logger.Printf("Failed to copy bytes: %v\n", err)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		s.logger.Printf("Failed to close the writer: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// storePiece copies the file of `p` to Options.Blobs as
+// "<PieceBucket>/<p.object>" when it is modified, or missing. It returns
+// false if that fails.
+func (s *Syncer) storePiece(ctx context.Context, p *piece) bool {
+	name := benten.PieceBucket + "/" + p.object
+	if !p.modified {
+		if reader, err := s.opts.Blobs.NewReader(ctx, name); err == nil {
+			reader.Close()
+			return true
+		}
+	}
+	if err := s.copyPiece(ctx, name, p.path); err != nil {
+		s.logger.Printf("Failed to store %s: %v\n", p.path, err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return false
+	}
+	s.logger.Printf("Stored %s from %s\n", name, p.path)
+	return true
+}
+
+func (s *Syncer) copyPiece(ctx context.Context, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	total := int64(-1)
+	if fi, err := file.Stat(); err == nil {
+		total = fi.Size()
+	}
+	reader, done := s.uploads.start(name, path, total, file)
+	defer done()
+	writer := s.opts.Blobs.NewWriter(ctx, name, mime.TypeByExtension(filepath.Ext(path)))
+	n, err := io.Copy(writer, reader)
+	s.progress.update(func(p *Progress) { p.UploadedBytes += n })
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
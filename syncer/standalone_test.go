@@ -0,0 +1,104 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/blob"
+	"github.com/yutakahirano/benten/sqlite"
+)
+
+func TestStandalone(t *testing.T) {
+	root, err := ioutil.TempDir("", "standalone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store, err := sqlite.Open(filepath.Join(root, sqlite.DatabaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := New(Options{Store: store, Blobs: blob.Dir{Root: root}})
+	ctx := context.Background()
+	path := filepath.Join(root, "a.mp3")
+	if err := ioutil.WriteFile(path, []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := benten.Metadata{Title: "a", Path: path, Hash: "hash", Object: "hash"}
+	for _, want := range []updateResult{added, unchanged} {
+		m := metadata
+		if result, err := s.storeMetadata(ctx, &m); err != nil || result != want {
+			t.Errorf("storeMetadata = %v, %v, want %v", result, err, want)
+		}
+	}
+	metadata.Title = "b"
+	if result, err := s.storeMetadata(ctx, &metadata); err != nil || result != updated || metadata.Revision != 2 {
+		t.Errorf("storeMetadata = %v, %v with Revision %d", result, err, metadata.Revision)
+	}
+
+	if !s.storePiece(ctx, &piece{path: path, object: "hash", modified: true}) {
+		t.Errorf("storePiece failed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, benten.PieceBucket, "hash"))
+	if err != nil || string(data) != "mp3" {
+		t.Errorf("data = %q, %v", data, err)
+	}
+	missing := &piece{path: filepath.Join(root, "missing.mp3"), object: "missing", modified: true}
+	if s.uploadDiscovered(ctx, missing) != nil {
+		t.Errorf("a piece failing to be stored is passed on to be indexed")
+	}
+}
+
+// failingBlobs fails to write any object.
+type failingBlobs struct {
+	blob.Dir
+}
+
+func (failingBlobs) NewWriter(ctx context.Context, name string, contentType string) io.WriteCloser {
+	return failingWriter{}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("no space left") }
+func (failingWriter) Close() error                { return errors.New("no space left") }
+
+func TestFailedUploadIsCountedOnce(t *testing.T) {
+	root, err := ioutil.TempDir("", "standalone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store, err := sqlite.Open(filepath.Join(root, sqlite.DatabaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := New(Options{Store: store, Blobs: failingBlobs{blob.Dir{Root: root}}, Target: root})
+	ctx := context.Background()
+	path := filepath.Join(root, "a.mp4")
+	if err := ioutil.WriteFile(path, []byte("mp4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// As the stages do: the piece is indexed only once it is uploaded.
+	p := &piece{path: path, tags: &videoTags{tags: map[string]string{"title": "a"}}, hash: "hash", object: "hash", modified: true}
+	if p := s.uploadDiscovered(ctx, p); p != nil {
+		s.index(ctx, p)
+	}
+	progress := s.Progress()
+	if progress.Failed != 1 || progress.Processed != 0 || progress.Done() != 1 {
+		t.Errorf("progress = %+v", progress)
+	}
+	if _, err := store.Get(ctx, path); err != benten.ErrNotFound {
+		t.Errorf("Get = %v for a piece failing to upload", err)
+	}
+}
@@ -0,0 +1,382 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/dhowden/tag"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/artwork"
+)
+
+// Uploads `picture` into `bucket`, with `key`.
+func (s *Syncer) uploadPicture(ctx context.Context, bucket *storage.BucketHandle, key string, picture *tag.Picture) error {
+	object := bucket.Object(key)
+	writer := object.NewWriter(ctx)
+	n, err := io.Copy(writer, bytes.NewBuffer(picture.Data))
+	s.progress.update(func(p *Progress) { p.UploadedBytes += n })
+	if err != nil {
+		s.logger.Printf("Failed to copy bytes: %v\n", err)
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		s.logger.Printf("Failed to close the writer: %v\n", err)
+		return err
+	}
+	_, err = object.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: picture.MIMEType})
+	if err != nil {
+		s.logger.Printf("Failed to update object's attributes: %v\n", err)
+		return err
+	}
+	s.enqueueReplication(ctx, replication{bucket: object.BucketName(), name: key})
+	return err
+}
+
+func getAlbumArtFromDir(dir string) (*tag.Picture, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var largestArt os.FileInfo = nil
+	largestArtType := ""
+	albumArtPattern := regexp.MustCompile("(?i)^AlbumArt.*\\.(jpg|png)$")
+	for _, fileInfo := range fileInfos {
+		if match := albumArtPattern.FindStringSubmatch(fileInfo.Name()); match != nil {
+			if largestArt == nil || largestArt.Size() < fileInfo.Size() {
+				largestArt = fileInfo
+				if strings.ToLower(match[1]) == "jpg" {
+					largestArtType = "image/jpeg"
+				} else if strings.ToLower(match[1]) == "png" {
+					largestArtType = "image/png"
+				} else {
+					panic("notreached")
+				}
+			}
+		}
+	}
+	if largestArt == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, largestArt.Name()))
+	if err != nil {
+		return nil, err
+	}
+	return &tag.Picture{
+		MIMEType: largestArtType,
+		Data:     data,
+	}, nil
+}
+
+// albumArts is a collection of album pictures shared by the workers of the
+// upload art stage.
+type albumArts struct {
+	bucket *storage.BucketHandle
+
+	mu sync.Mutex
+	// Each of key is either
+	//  - the path of the dictionary that the album is contined,
+	//  - the base64 encoded hash value of the bytes representing the album picture, or
+	//  - the album asked to Options.AlbumArtProvider; see albumKey.
+	// Either way, the value is the base64 encoded hash value of the bytes representing the album picture,
+	// or the empty string for an album the provider had no picture of.
+	pictures map[string]string
+}
+
+func newAlbumArts(bucket *storage.BucketHandle) *albumArts {
+	return &albumArts{bucket: bucket, pictures: make(map[string]string)}
+}
+
+func (a *albumArts) lookup(key string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hash, ok := a.pictures[key]
+	return hash, ok
+}
+
+func (a *albumArts) add(hash string, keys ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, key := range keys {
+		a.pictures[key] = hash
+	}
+}
+
+// readTags reads the tag and the hash of the file at `p.path`.
+func (s *Syncer) readTags(ctx context.Context, p *piece) *piece {
+	s.progress.update(func(progress *Progress) { progress.Current = p.path })
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		s.logger.Printf("Failed to get stat for %s: %v\n", p.path, err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	if fi.IsDir() {
+		return nil
+	}
+	p.modTime = fi.ModTime()
+
+	file, err := os.Open(p.path)
+	if err != nil {
+		s.logger.Printf("Failed to open %s: %v\n", p.path, err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	defer file.Close()
+
+	s.logger.Printf("Processing %s...\n", file.Name())
+	p.mediaType = benten.MediaAudio
+	if s.isVideo(p.path) {
+		p.mediaType = benten.MediaVideo
+		p.tags, err = s.probeVideo(ctx, p.path)
+	} else {
+		p.tags, err = tag.ReadFrom(file)
+	}
+	if err == tag.ErrNoTagsFound {
+		if tags, ok := s.untagged(p.path); ok {
+			s.logger.Printf("No tags found in %s; inferring them from the path\n", file.Name())
+			p.tags, err = tags, nil
+		} else {
+			s.logger.Printf("No tags found in %s\n", file.Name())
+			s.progress.update(func(p *Progress) { p.Skipped++ })
+			return nil
+		}
+	}
+	if err != nil {
+		s.logger.Printf("Failed read tag from %s: %v\n", file.Name(), err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	p.gapless, err = benten.ReadGapless(file, p.tags)
+	if err != nil {
+		// Pieces play without it, just with gaps.
+		s.logger.Printf("Failed to read the gapless info of %s: %v\n", file.Name(), err)
+	}
+	p.bitrate, err = benten.ReadBitrate(file, p.tags, fi.Size())
+	if err != nil {
+		s.logger.Printf("Failed to read the bitrate of %s: %v\n", file.Name(), err)
+	}
+	if video, ok := p.tags.(*videoTags); ok {
+		p.duration = video.duration
+	} else if p.duration, err = benten.ReadDuration(file, p.tags, fi.Size()); err != nil {
+		s.logger.Printf("Failed to read the duration of %s: %v\n", file.Name(), err)
+	}
+	entry := hashEntry{Size: fi.Size(), ModTime: fi.ModTime()}
+	entry.Fingerprint, err = fingerprint(file, fi.Size())
+	if err != nil {
+		s.logger.Printf("Failed to fingerprint %s: %v\n", file.Name(), err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	if hash, ok := s.hashes.lookup(p.path, entry); ok {
+		p.hash = hash
+		return p
+	}
+	if p.mediaType == benten.MediaVideo {
+		// tag.Sum knows only audio files, and videos have their tags
+		// rewritten rarely.
+		p.hash, err = tag.SumAll(file)
+	} else {
+		p.hash, err = tag.Sum(file)
+	}
+	if err != nil {
+		s.logger.Printf("Failed calculate the sum from %s: %v\n", file.Name(), err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	entry.Hash = p.hash
+	s.hashes.store(p.path, entry)
+	p.modified = true
+	return p
+}
+
+// locateAlbumArt finds the album picture of `p` and sets `p.pictureHash`,
+// and `p.picture` unless the picture is known to be uploaded. The picture is
+// the one embedded in the file if any, or the largest AlbumArt file in the
+// same directory. It only reads local files, so runs in the read stage.
+func (s *Syncer) locateAlbumArt(arts *albumArts, p *piece) {
+	if p.tags.Picture() == nil {
+		var ok bool
+		dirname := filepath.Dir(p.path)
+		p.pictureHash, ok = arts.lookup(dirname)
+		if !ok {
+			picture, err := getAlbumArtFromDir(dirname)
+			if err != nil {
+				s.logger.Printf("Failed to get an album art in %v: %v", dirname, err)
+			}
+			if picture != nil {
+				sum := sha256.Sum256(picture.Data)
+				p.pictureHash = base64.StdEncoding.EncodeToString(sum[:])
+				p.picture = picture
+				p.pictureDir = dirname
+			}
+		}
+	}
+	if p.pictureHash == "" && p.tags.Picture() != nil {
+		sum := sha256.Sum256(p.tags.Picture().Data)
+		p.pictureHash = base64.StdEncoding.EncodeToString(sum[:])
+		if _, ok := arts.lookup(p.pictureHash); !ok {
+			p.picture = p.tags.Picture()
+		}
+	}
+}
+
+// uploadAlbumArt uploads `p.picture` unless it has already been uploaded.
+// Pieces without a picture get one from Options.AlbumArtProvider if any.
+func (s *Syncer) uploadAlbumArt(ctx context.Context, arts *albumArts, p *piece) *piece {
+	if p.pictureHash == "" && s.opts.AlbumArtProvider != nil {
+		s.fetchAlbumArt(ctx, arts, p)
+		return p
+	}
+	if p.picture == nil {
+		return p
+	}
+	if _, ok := arts.lookup(p.pictureHash); !ok {
+		if err := s.storePicture(ctx, arts.bucket, p.pictureHash, p.picture); err != nil {
+			p.picture = nil
+			return p
+		}
+	}
+	if p.pictureDir != "" {
+		arts.add(p.pictureHash, p.pictureDir, p.pictureHash)
+	} else {
+		arts.add(p.pictureHash, p.pictureHash)
+	}
+	// The picture is no longer needed, and may be large.
+	p.picture = nil
+	return p
+}
+
+// albumKey returns the key of the album of `p` in albumArts, and the
+// arguments of AlbumProvider.FetchAlbum. The key is empty if the album is
+// unknown.
+func albumKey(p *piece) (key, releaseID, album, artist string) {
+	album = p.tags.Album()
+	artist = p.tags.AlbumArtist()
+	if artist == "" && benten.IsCompilation(p.tags) {
+		// The tracks of a compilation share its picture. MusicBrainz
+		// credits compilations to "Various Artists" too.
+		artist = benten.VariousArtists
+	}
+	if artist == "" {
+		artist = p.tags.Artist()
+	}
+	releaseID = artwork.MusicBrainzReleaseID(p.tags)
+	if releaseID != "" {
+		return "release:" + releaseID, releaseID, album, artist
+	}
+	if benten.Normalize(album) == "" {
+		return "", "", album, artist
+	}
+	return "album:" + benten.Normalize(album) + "\n" + benten.Normalize(artist), "", album, artist
+}
+
+// fetchAlbumArt asks Options.AlbumArtProvider for the picture of the album of
+// `p`, uploads it and sets `p.pictureHash` and `p.pictureSource`. Each album
+// is asked about once per run, even if the provider fails.
+func (s *Syncer) fetchAlbumArt(ctx context.Context, arts *albumArts, p *piece) {
+	provider := s.opts.AlbumArtProvider
+	key, releaseID, album, artist := albumKey(p)
+	if key == "" {
+		return
+	}
+	if hash, ok := arts.lookup(key); ok {
+		if hash != "" {
+			p.pictureHash = hash
+			p.pictureSource = provider.Name()
+		}
+		return
+	}
+	image, err := provider.FetchAlbum(ctx, releaseID, album, artist)
+	if err != nil {
+		s.logger.Printf("Failed to fetch the album art of %s: %v\n", p.path, err)
+	}
+	if image == nil {
+		arts.add("", key)
+		return
+	}
+	sum := sha256.Sum256(image.Data)
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+	if _, ok := arts.lookup(hash); !ok {
+		if err := s.storePicture(ctx, arts.bucket, hash, &tag.Picture{MIMEType: image.MIMEType, Data: image.Data}); err != nil {
+			arts.add("", key)
+			return
+		}
+	}
+	arts.add(hash, key, hash)
+	p.pictureHash = hash
+	p.pictureSource = provider.Name()
+}
+
+// relativePath returns the slash-separated path of `p` relative to
+// Options.Target.
+func (s *Syncer) relativePath(p *piece) string {
+	relativePath, err := filepath.Rel(s.opts.Target, p.path)
+	if err != nil {
+		relativePath = filepath.Base(p.path)
+	}
+	return filepath.ToSlash(relativePath)
+}
+
+// objectName returns the name of the object of `p` under Options.Naming.
+func (s *Syncer) objectName(p *piece) string {
+	return s.opts.Naming.ObjectName(p.hash, s.relativePath(p))
+}
+
+// index stores the metadata of `p` and updates the index.
+func (s *Syncer) index(ctx context.Context, p *piece) *piece {
+	metadata := p.metadata()
+	metadata.PathDir = benten.FolderOf(s.relativePath(p))
+	for _, template := range s.opts.PathTemplates {
+		if template.Infer(&metadata) {
+			break
+		}
+	}
+	s.tagRules().Apply(&metadata)
+	metadata.SetAlbumKey(s.aliases())
+	metadata.SetNeedsReview()
+	metadata.Naming = s.opts.Naming
+	metadata.Object = p.object
+	var result updateResult
+	var err error
+	if s.opts.Store != nil {
+		result, err = s.storeMetadata(ctx, &metadata)
+	} else {
+		result, err = s.updateMetadata(ctx, &metadata, p.modTime)
+	}
+	if err != nil {
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	if result == unchanged {
+		s.logger.Printf("%s is unchanged\n", p.path)
+	} else if result == conflicted {
+		s.logger.Printf("Kept the edits made via the API after %s was modified\n", p.path)
+	} else {
+		s.logger.Printf("Successfully updated data for %s\n", p.path)
+	}
+	s.progress.update(func(p *Progress) {
+		p.Processed++
+		switch result {
+		case added:
+			p.Added++
+		case updated:
+			p.Updated++
+		case unchanged:
+			p.Unchanged++
+		case conflicted:
+			p.Conflicted++
+		}
+	})
+	return p
+}
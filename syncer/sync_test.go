@@ -0,0 +1,196 @@
+package syncer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhowden/tag"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/artwork"
+	"github.com/yutakahirano/benten/blob"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestUploadPicture(t *testing.T) {
+	client := testutil.Storage(t)
+	s := New(Options{})
+	bucket := client.Bucket(benten.AlbumPictureBucket)
+	ctx := context.Background()
+	picture := &tag.Picture{MIMEType: "image/png", Data: []byte("png")}
+
+	if err := s.uploadPicture(ctx, bucket, "key", picture); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := bucket.Object("key").NewReader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "png" {
+		t.Errorf("data = %q", data)
+	}
+	if s.Progress().UploadedBytes != 3 {
+		t.Errorf("UploadedBytes = %d", s.Progress().UploadedBytes)
+	}
+}
+
+func TestReplicateObject(t *testing.T) {
+	client := testutil.Storage(t)
+	root, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := New(Options{Replica: blob.Dir{Root: root}})
+	s.storageClient = client
+	ctx := context.Background()
+	picture := &tag.Picture{MIMEType: "image/png", Data: []byte("png")}
+	if err := s.uploadPicture(ctx, client.Bucket(benten.AlbumPictureBucket), "key", picture); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-s.replications
+	if err := s.replicateObject(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, benten.AlbumPictureBucket, "key"))
+	if err != nil || string(data) != "png" {
+		t.Errorf("data = %q, %v", data, err)
+	}
+}
+
+func TestUploadAlbumArt(t *testing.T) {
+	client := testutil.Storage(t)
+	s := New(Options{})
+	arts := newAlbumArts(client.Bucket(benten.AlbumPictureBucket))
+	ctx := context.Background()
+	picture := &tag.Picture{MIMEType: "image/png", Data: []byte("png")}
+
+	for i := 0; i < 2; i++ {
+		p := s.uploadAlbumArt(ctx, arts, &piece{path: "/a/b.mp3", picture: picture, pictureHash: "hash", pictureDir: "/a"})
+		if p.picture != nil {
+			t.Errorf("the picture is kept after uploading")
+		}
+	}
+	if s.Progress().UploadedBytes != 3 {
+		t.Errorf("UploadedBytes = %d", s.Progress().UploadedBytes)
+	}
+	if hash, ok := arts.lookup("/a"); !ok || hash != "hash" {
+		t.Errorf("lookup = %q, %v", hash, ok)
+	}
+}
+
+func TestUploadEntrySkipsUploaded(t *testing.T) {
+	client := testutil.Storage(t)
+	s := New(Options{})
+	bucket := client.Bucket(benten.PieceBucket)
+	ctx := context.Background()
+	file, err := ioutil.TempFile("", "piece")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("mp3")
+	file.Close()
+
+	if err := s.uploadEntry(ctx, bucket, "key", file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.uploadEntry(ctx, bucket, "key", file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if s.Progress().UploadedBytes != 3 {
+		t.Errorf("UploadedBytes = %d", s.Progress().UploadedBytes)
+	}
+	ioutil.WriteFile(file.Name(), []byte("mp4"), 0644)
+	if uploaded, err := s.isUploaded(ctx, bucket, "key", file.Name()); uploaded || err != nil {
+		t.Errorf("isUploaded = %v, %v for a changed file", uploaded, err)
+	}
+}
+
+func TestUploadDiscovered(t *testing.T) {
+	client := testutil.Storage(t)
+	s := New(Options{UploadPieces: true})
+	s.storageClient = client
+	ctx := context.Background()
+	file, err := ioutil.TempFile("", "piece")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("mp3")
+	file.Close()
+
+	// An unmodified piece is uploaded only when it is missing.
+	p := &piece{path: file.Name(), object: "discovered"}
+	if s.uploadDiscovered(ctx, p) != p {
+		t.Errorf("an uploaded piece is not passed on to be indexed")
+	}
+	ioutil.WriteFile(file.Name(), []byte("mp4"), 0644)
+	s.uploadDiscovered(ctx, p)
+	if s.Progress().UploadedBytes != 3 {
+		t.Errorf("UploadedBytes = %d", s.Progress().UploadedBytes)
+	}
+	p.modified = true
+	s.uploadDiscovered(ctx, p)
+	if s.Progress().UploadedBytes != 6 {
+		t.Errorf("UploadedBytes = %d after a change", s.Progress().UploadedBytes)
+	}
+}
+
+// albumTags is the tags of a piece of an album without a picture.
+type albumTags struct {
+	tag.Metadata
+	album, artist string
+}
+
+func (t albumTags) Album() string               { return t.album }
+func (t albumTags) AlbumArtist() string         { return "" }
+func (t albumTags) Artist() string              { return t.artist }
+func (t albumTags) Raw() map[string]interface{} { return nil }
+
+type fakeAlbumProvider struct {
+	calls int
+}
+
+func (p *fakeAlbumProvider) Name() string { return "fake" }
+
+func (p *fakeAlbumProvider) FetchAlbum(ctx context.Context, releaseID, album, artist string) (*artwork.Image, error) {
+	p.calls++
+	if album != "Abbey Road" {
+		return nil, nil
+	}
+	return &artwork.Image{Data: []byte("png"), MIMEType: "image/png"}, nil
+}
+
+func TestFetchAlbumArt(t *testing.T) {
+	client := testutil.Storage(t)
+	provider := &fakeAlbumProvider{}
+	s := New(Options{AlbumArtProvider: provider})
+	arts := newAlbumArts(client.Bucket(benten.AlbumPictureBucket))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		p := s.uploadAlbumArt(ctx, arts, &piece{path: "/a/b.mp3", tags: albumTags{album: "Abbey Road", artist: "The Beatles"}})
+		if p.pictureHash == "" || p.pictureSource != "fake" {
+			t.Errorf("pictureHash = %q, pictureSource = %q", p.pictureHash, p.pictureSource)
+		}
+	}
+	p := s.uploadAlbumArt(ctx, arts, &piece{path: "/c/d.mp3", tags: albumTags{album: "Unknown", artist: "Nobody"}})
+	if p.pictureHash != "" || p.pictureSource != "" {
+		t.Errorf("pictureHash = %q, pictureSource = %q for an album without a picture", p.pictureHash, p.pictureSource)
+	}
+	if provider.calls != 2 {
+		t.Errorf("calls = %d, want one per album", provider.calls)
+	}
+	if s.Progress().UploadedBytes != 3 {
+		t.Errorf("UploadedBytes = %d", s.Progress().UploadedBytes)
+	}
+}
@@ -0,0 +1,279 @@
+// Package syncer implements the pipeline which watches a local music library
+// and synchronizes it with the cloud: it reads tags from audio files, uploads
+// album pictures and pieces, and maintains the search index.
+package syncer
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/artwork"
+)
+
+// Options configures a Syncer.
+type Options struct {
+	// ProjectID is the ID of the GCP project.
+	ProjectID string
+	// SubscriptionID is the ID of the Pub/Sub subscription delivering upload
+	// requests. Empty disables them.
+	SubscriptionID string
+	// UploadPieces makes the Syncer upload the contents of the pieces it
+	// discovers itself, named by Naming, so that no upload requests are
+	// needed.
+	UploadPieces bool
+	// Naming is the scheme naming the objects of pieces, which is recorded in
+	// their metadata. Defaults to benten.NamingHash.
+	Naming benten.NamingScheme
+	// Target is the root directory of the local library.
+	Target string
+	// Full makes the Syncer walk the whole Target before watching changes.
+	Full bool
+	// Logger is the logger used by the Syncer. Defaults to a logger writing to stderr.
+	Logger *log.Logger
+	// DebounceDuration is how long a file must stay untouched before it is synced.
+	// Defaults to 5 seconds.
+	DebounceDuration time.Duration
+	// QueueSize is the capacity of the queue between pipeline stages. Defaults to 16.
+	QueueSize int
+	// Concurrency configures the number of workers of each pipeline stage.
+	Concurrency Concurrency
+	// Notifiers receive a summary when the full scan finishes and when errors pile up.
+	Notifiers []Notifier
+	// ErrorThreshold is the number of new failures which triggers a
+	// notification. Zero disables error notifications.
+	ErrorThreshold int
+	// SearchIndex, if non-nil, is kept up to date in addition to the datastore
+	// n-gram index.
+	SearchIndex benten.SearchIndex
+	// Replica, if non-nil, receives a copy of every uploaded piece and album
+	// picture; see replicate.
+	Replica benten.BlobStore
+	// SearchCache, if non-nil, is invalidated whenever a piece changes. It
+	// should be shared with the server, such as a Redis cache; the server's
+	// in-memory cache relies on its TTL instead.
+	SearchCache benten.SearchCache
+	// Store, if non-nil, makes the Syncer standalone: the metadata is written
+	// to it instead of the datastore, and pieces and album pictures to Blobs
+	// instead of GCS, so that no cloud services are used. Upload requests,
+	// aliases and Replica are not supported then.
+	Store benten.MetadataStore
+	// Blobs receives pieces and album pictures in the standalone mode.
+	Blobs benten.BlobStore
+	// AlbumArtProvider, if non-nil, is asked for the pictures of albums with
+	// neither an embedded picture nor an AlbumArt file. The pictures are
+	// recorded with its name as their PictureSource.
+	AlbumArtProvider artwork.AlbumProvider
+	// FFprobe is the path of ffprobe, which makes the Syncer sync music
+	// videos (MP4, MKV and WebM files) too. Empty syncs MP4 files as audio,
+	// and skips the others.
+	FFprobe string
+	// FFmpeg is the path of ffmpeg, which makes the posters of videos. Empty
+	// leaves videos without posters.
+	FFmpeg string
+	// PathTemplates fill the tags missing in files from their paths; the
+	// first matching one is used. They also make the Syncer sync audio files
+	// without tags, which are skipped otherwise.
+	PathTemplates []*benten.PathTemplate
+	// HashCachePath is the file remembering the hashes of files, so that
+	// unchanged files are not read entirely on every scan. Empty keeps them
+	// only in memory.
+	HashCachePath string
+	// WriteBudget limits the writes to the datastore.
+	WriteBudget WriteBudget
+}
+
+// Syncer synchronizes a local library with the cloud.
+type Syncer struct {
+	opts     Options
+	logger   *log.Logger
+	progress progressCounter
+
+	datastoreClient *datastore.Client
+	storageClient   *storage.Client
+	pubsubClient    *pubsub.Client
+
+	aliasesMu  sync.Mutex
+	aliasTable benten.Aliases
+	// tagRewriter is guarded by aliasesMu too.
+	tagRewriter *benten.TagRewriter
+
+	// replications is nil unless Options.Replica is set.
+	replications chan replication
+
+	hashes  *hashCache
+	uploads uploadTracker
+	writes  *writeThrottle
+}
+
+// New creates a Syncer with the given options.
+func New(opts Options) *Syncer {
+	if opts.Logger == nil {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if opts.DebounceDuration == 0 {
+		opts.DebounceDuration = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 16
+	}
+	opts.Concurrency = opts.Concurrency.withDefaults()
+	if opts.Naming == "" {
+		opts.Naming = benten.NamingHash
+	}
+	if opts.Store != nil {
+		opts.UploadPieces = true
+	}
+	s := &Syncer{opts: opts, logger: opts.Logger, hashes: &hashCache{entries: make(map[string]hashEntry)}, writes: newWriteThrottle(opts.WriteBudget, opts.Logger)}
+	if opts.Replica != nil && opts.Store == nil {
+		s.replications = make(chan replication, opts.QueueSize)
+	}
+	return s
+}
+
+func (s *Syncer) connect(ctx context.Context) error {
+	var err error
+	if s.datastoreClient == nil {
+		s.datastoreClient, err = datastore.NewClient(ctx, s.opts.ProjectID, benten.ClientOptions()...)
+		if err != nil {
+			s.logger.Printf("Failed to create a datastore client: %v\n", err)
+			return err
+		}
+	}
+	if s.storageClient == nil {
+		s.storageClient, err = storage.NewClient(ctx, benten.StorageOptions()...)
+		if err != nil {
+			s.logger.Printf("Failed to create a storage client: %v\n", err)
+			return err
+		}
+	}
+	if s.pubsubClient == nil {
+		s.pubsubClient, err = pubsub.NewClient(ctx, s.opts.ProjectID, benten.ClientOptions()...)
+		if err != nil {
+			s.logger.Printf("Failed to create a pubsub client: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// aliasRefreshInterval is how often the artist aliases and the tag rules are
+// reloaded.
+const aliasRefreshInterval = 10 * time.Minute
+
+func (s *Syncer) aliases() benten.Aliases {
+	s.aliasesMu.Lock()
+	defer s.aliasesMu.Unlock()
+	return s.aliasTable
+}
+
+func (s *Syncer) loadAliases(ctx context.Context) {
+	aliases, err := benten.LoadAliases(ctx, s.datastoreClient)
+	if err != nil {
+		s.logger.Printf("Failed to load artist aliases: %v\n", err)
+		return
+	}
+	s.aliasesMu.Lock()
+	defer s.aliasesMu.Unlock()
+	s.aliasTable = aliases
+}
+
+func (s *Syncer) tagRules() *benten.TagRewriter {
+	s.aliasesMu.Lock()
+	defer s.aliasesMu.Unlock()
+	return s.tagRewriter
+}
+
+// loadTagRules loads the tag rules. The previous rules are kept on failure,
+// so that pieces are not synced with their tags unfixed.
+func (s *Syncer) loadTagRules(ctx context.Context) {
+	rules, err := benten.LoadTagRules(ctx, s.datastoreClient)
+	if err != nil {
+		s.logger.Printf("Failed to load the tag rules: %v\n", err)
+		return
+	}
+	rewriter, err := rules.Compile()
+	if err != nil {
+		s.logger.Printf("Failed to compile the tag rules: %v\n", err)
+		return
+	}
+	s.aliasesMu.Lock()
+	defer s.aliasesMu.Unlock()
+	s.tagRewriter = rewriter
+}
+
+func (s *Syncer) refreshAliases(ctx context.Context) {
+	ticker := time.NewTicker(aliasRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.loadAliases(ctx)
+			s.loadTagRules(ctx)
+		}
+	}
+}
+
+// Run runs the Syncer until `ctx` is done or an unrecoverable error happens.
+func (s *Syncer) Run(ctx context.Context) error {
+	standalone := s.opts.Store != nil
+	if !standalone {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if !standalone {
+		if _, err := benten.LoadIndexConfig(ctx, s.datastoreClient); err != nil {
+			s.logger.Printf("Failed to load the index config: %v\n", err)
+			return err
+		}
+	}
+	hashes, err := loadHashCache(s.opts.HashCachePath)
+	if err != nil {
+		s.logger.Printf("Failed to load the hash cache: %v\n", err)
+		return err
+	}
+	s.hashes = hashes
+	go s.saveHashes(ctx)
+	if !standalone {
+		s.loadAliases(ctx)
+		s.loadTagRules(ctx)
+		go s.refreshAliases(ctx)
+	}
+	if s.opts.SubscriptionID != "" && !standalone {
+		go s.uploadContents(ctx)
+	}
+	if s.replications != nil {
+		go s.replicate(ctx)
+	}
+	go s.watchProgress(ctx)
+	go s.logUploads(ctx)
+
+	// discover → debounce → read tags → upload art (→ upload piece) → index
+	discovered := s.startPipeline(ctx)
+	changed := make(chan string, s.opts.QueueSize)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.watch(ctx, discovered, changed)
+	}()
+	go debounce(ctx, changed, discovered, s.opts.DebounceDuration)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errs:
+		return err
+	}
+}
@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/yutakahirano/benten"
+)
+
+func (s *Syncer) uploadPiece(ctx context.Context, bucket *storage.BucketHandle, key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		s.logger.Printf("Failed to open %s: %v", path, err)
+		return err
+	}
+	defer file.Close()
+	total := int64(-1)
+	if fi, err := file.Stat(); err == nil {
+		total = fi.Size()
+	}
+	reader, done := s.uploads.start(key, path, total, file)
+	defer done()
+	object := bucket.Object(key)
+	writer := object.NewWriter(ctx)
+	n, err := io.Copy(writer, reader)
+	s.progress.update(func(p *Progress) { p.UploadedBytes += n })
+	if err != nil {
+		writer.Close()
+		s.logger.Printf("Failed to copy the contents of %s: %v", path, err)
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		s.logger.Printf("Failed to copy the contents of %s: %v", path, err)
+	}
+	return err
+}
+
+// uploadAttempts is the number of times an entry is tried before the message
+// is left to be redelivered.
+const uploadAttempts = 3
+
+// isUploaded returns true if the object `key` already exists with the same
+// contents as the file at `path`, comparing MD5 checksums.
+func (s *Syncer) isUploaded(ctx context.Context, bucket *storage.BucketHandle, key string, path string) (bool, error) {
+	attrs, err := bucket.Object(key).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(attrs.MD5) == 0 {
+		// Composite objects have no MD5.
+		return false, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), attrs.MD5), nil
+}
+
+// uploadEntry uploads the piece at `path` as `key` unless it is already
+// uploaded, trying up to uploadAttempts times.
+func (s *Syncer) uploadEntry(ctx context.Context, bucket *storage.BucketHandle, key string, path string) error {
+	uploaded, err := s.isUploaded(ctx, bucket, key, path)
+	if err != nil {
+		s.logger.Printf("Failed to check %s: %v", key, err)
+	}
+	if uploaded {
+		s.logger.Printf("Skipped %s, which is already uploaded from %s", key, path)
+		return nil
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = s.uploadPiece(ctx, bucket, key, path)
+		if err == nil || attempt == uploadAttempts || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Printf("Uploaded %s from %s", key, path)
+	s.enqueueReplication(ctx, replication{bucket: benten.PieceBucket, name: key, path: path})
+	return nil
+}
+
+// uploadDiscovered uploads the contents of `p` as `p.object` when it is
+// modified, or missing in the bucket, and passes `p` on to be indexed. The
+// object of an unmodified file is assumed to be up to date, so that scans
+// don't read all the files. Pieces failing to upload are not indexed.
+func (s *Syncer) uploadDiscovered(ctx context.Context, p *piece) *piece {
+	if s.opts.Store != nil {
+		if !s.storePiece(ctx, p) {
+			return nil
+		}
+		return p
+	}
+	bucket := s.storageClient.Bucket(benten.PieceBucket)
+	if !p.modified {
+		_, err := bucket.Object(p.object).Attrs(ctx)
+		if err == nil {
+			return p
+		}
+		if err != storage.ErrObjectNotExist {
+			s.logger.Printf("Failed to check %s: %v\n", p.object, err)
+		}
+	}
+	if err := s.uploadEntry(ctx, bucket, p.object, p.path); err != nil {
+		s.logger.Printf("Failed to upload %s: %v\n", p.path, err)
+		s.progress.update(func(p *Progress) { p.Failed++ })
+		return nil
+	}
+	return p
+}
+
+// uploadContentsInternal uploads all the entries of `m`, a
+// benten.UploadRequest. Entries failing are reported by an error after the
+// others are done, so that the redelivered message only uploads them again.
+// Malformed messages and those of unknown versions are dropped, since
+// redelivering them doesn't help.
+func (s *Syncer) uploadContentsInternal(ctx context.Context, m *pubsub.Message) error {
+	request, err := benten.ParseUploadRequest(m.Data)
+	if err != nil {
+		s.logger.Printf("Dropping the upload request %s: %v", m.ID, err)
+		return nil
+	}
+	bucket := s.storageClient.Bucket(benten.PieceBucket)
+	failed := 0
+	entries := request.Entries
+	for _, entry := range entries {
+		if err := s.uploadEntry(ctx, bucket, entry.Key, entry.Path); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to upload %d of %d entries", failed, len(entries))
+	}
+	return nil
+}
+
+// uploadContents uploads pieces requested via Pub/Sub until `ctx` is done.
+func (s *Syncer) uploadContents(ctx context.Context) {
+	sub := s.pubsubClient.Subscription(s.opts.SubscriptionID)
+	sub.ReceiveSettings.NumGoroutines = s.opts.Concurrency.UploadPiece
+	sub.ReceiveSettings.MaxOutstandingMessages = s.opts.Concurrency.UploadPiece
+	for ctx.Err() == nil {
+		err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+			err := s.uploadContentsInternal(ctx, m)
+			if err == nil {
+				m.Ack()
+			}
+		})
+		if err != nil {
+			s.logger.Printf("Failed to receive message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package syncer
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// uploadLogInterval is how often the uploads in flight are logged.
+const uploadLogInterval = 10 * time.Second
+
+// UploadProgress is the progress of an upload in flight.
+type UploadProgress struct {
+	// Name is the name of the object being written.
+	Name string
+	// Path is the local file being uploaded.
+	Path string
+	// Bytes is the number of bytes uploaded so far.
+	Bytes int64
+	// Total is the size of the file, or -1 if unknown.
+	Total   int64
+	Started time.Time
+	// Updated is when the last bytes were uploaded.
+	Updated time.Time
+}
+
+// BytesPerSecond returns the average throughput so far.
+func (u UploadProgress) BytesPerSecond(now time.Time) float64 {
+	elapsed := now.Sub(u.Started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(u.Bytes) / elapsed
+}
+
+// Percent returns how much of the file has been uploaded, or -1 if unknown.
+func (u UploadProgress) Percent() float64 {
+	if u.Total < 0 {
+		return -1
+	}
+	if u.Total == 0 {
+		return 100
+	}
+	return float64(u.Bytes) * 100 / float64(u.Total)
+}
+
+// uploadTracker keeps the progress of the uploads in flight.
+type uploadTracker struct {
+	mu      sync.Mutex
+	next    int
+	uploads map[int]*UploadProgress
+}
+
+// start registers an upload and returns a reader of `r` which records the
+// progress, and a function to call when the upload finishes.
+func (t *uploadTracker) start(name, path string, total int64, r io.Reader) (io.Reader, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.uploads == nil {
+		t.uploads = make(map[int]*UploadProgress)
+	}
+	id := t.next
+	t.next++
+	now := time.Now()
+	t.uploads[id] = &UploadProgress{Name: name, Path: path, Total: total, Started: now, Updated: now}
+	reader := &progressReader{r: r, tracker: t, id: id}
+	return reader, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.uploads, id)
+	}
+}
+
+func (t *uploadTracker) add(id int, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u, ok := t.uploads[id]; ok {
+		u.Bytes += n
+		u.Updated = time.Now()
+	}
+}
+
+// snapshot returns the uploads in flight, oldest first.
+func (t *uploadTracker) snapshot() []UploadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	uploads := make([]UploadProgress, 0, len(t.uploads))
+	for _, u := range t.uploads {
+		uploads = append(uploads, *u)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Started.Before(uploads[j].Started) })
+	return uploads
+}
+
+// progressReader records the bytes read from `r` as uploaded.
+type progressReader struct {
+	r       io.Reader
+	tracker *uploadTracker
+	id      int
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.tracker.add(r.id, int64(n))
+	}
+	return n, err
+}
+
+// Uploads returns the progress of the uploads in flight, oldest first.
+func (s *Syncer) Uploads() []UploadProgress {
+	return s.uploads.snapshot()
+}
+
+// logUploads logs the uploads in flight periodically, noting the stalled ones.
+func (s *Syncer) logUploads(ctx context.Context) {
+	ticker := time.NewTicker(uploadLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, u := range s.Uploads() {
+				if now.Sub(u.Updated) >= uploadLogInterval {
+					s.logger.Printf("Uploading %s stalled for %v at %d bytes\n", u.Path, now.Sub(u.Updated).Round(time.Second), u.Bytes)
+					continue
+				}
+				s.logger.Printf("Uploading %s: %d/%d bytes (%.0f%%, %.0f KiB/s)\n", u.Path, u.Bytes, u.Total, u.Percent(), u.BytesPerSecond(now)/1024)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package syncer
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestUploadTracker(t *testing.T) {
+	var tracker uploadTracker
+	reader, done := tracker.start("key", "a.mp3", 10, strings.NewReader("0123456789"))
+	buf := make([]byte, 4)
+	reader.Read(buf)
+	uploads := tracker.snapshot()
+	if len(uploads) != 1 || uploads[0].Bytes != 4 || uploads[0].Percent() != 40 {
+		t.Errorf("uploads = %+v", uploads)
+	}
+	ioutil.ReadAll(reader)
+	if uploads := tracker.snapshot(); uploads[0].Bytes != 10 {
+		t.Errorf("uploads = %+v", uploads)
+	}
+	done()
+	if uploads := tracker.snapshot(); len(uploads) != 0 {
+		t.Errorf("uploads = %+v after finishing", uploads)
+	}
+}
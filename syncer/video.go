@@ -0,0 +1,157 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// videoExtensions are the extensions of the music videos synced with
+// Options.FFprobe, and their file types.
+var videoExtensions = map[string]tag.FileType{
+	".mp4":  "MP4",
+	".m4v":  "MP4",
+	".mkv":  "MKV",
+	".webm": "WEBM",
+}
+
+// isVideo returns true if the file at `path` is synced as a video.
+func (s *Syncer) isVideo(path string) bool {
+	_, ok := videoExtensions[strings.ToLower(filepath.Ext(path))]
+	return ok && s.opts.FFprobe != ""
+}
+
+// probeTimeout bounds running ffprobe and ffmpeg on a file.
+const probeTimeout = time.Minute
+
+// The width of posters; the height keeps the aspect ratio.
+const posterWidth = 500
+
+// videoTags are the tags of a video read by ffprobe. It implements
+// tag.Metadata, so that videos go through the pipeline like audio files, with
+// the poster as the picture.
+type videoTags struct {
+	fileType tag.FileType
+	tags     map[string]string
+	duration time.Duration
+	poster   *tag.Picture
+}
+
+func (t *videoTags) Format() tag.Format          { return tag.UnknownFormat }
+func (t *videoTags) FileType() tag.FileType      { return t.fileType }
+func (t *videoTags) Title() string               { return t.tags["title"] }
+func (t *videoTags) Album() string               { return t.tags["album"] }
+func (t *videoTags) Artist() string              { return t.tags["artist"] }
+func (t *videoTags) AlbumArtist() string         { return t.tags["album_artist"] }
+func (t *videoTags) Composer() string            { return t.tags["composer"] }
+func (t *videoTags) Genre() string               { return t.tags["genre"] }
+func (t *videoTags) Lyrics() string              { return t.tags["lyrics"] }
+func (t *videoTags) Comment() string             { return t.tags["comment"] }
+func (t *videoTags) Picture() *tag.Picture       { return t.poster }
+func (t *videoTags) Track() (int, int)           { return splitNumber(t.tags["track"]) }
+func (t *videoTags) Disc() (int, int)            { return splitNumber(t.tags["disc"]) }
+func (t *videoTags) Raw() map[string]interface{} { return nil }
+
+func (t *videoTags) Year() int {
+	// "2019" or "2019-05-01".
+	date := t.tags["date"]
+	if len(date) > 4 {
+		date = date[:4]
+	}
+	year, _ := strconv.Atoi(date)
+	return year
+}
+
+// splitNumber parses "3/12" into 3 and 12.
+func splitNumber(s string) (int, int) {
+	parts := strings.SplitN(s, "/", 2)
+	n, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if len(parts) < 2 {
+		return n, 0
+	}
+	total, _ := strconv.Atoi(strings.TrimSpace(parts[1]))
+	return n, total
+}
+
+// parseProbe parses the output of `ffprobe -print_format json -show_format
+// -show_streams`. Files without video streams are rejected.
+func parseProbe(data []byte, fileType tag.FileType) (*videoTags, error) {
+	var probe struct {
+		Format struct {
+			Duration string            `json:"duration"`
+			Tags     map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	hasVideo := false
+	for _, stream := range probe.Streams {
+		// Cover art in audio files is a video stream too.
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic == 0 {
+			hasVideo = true
+		}
+	}
+	if !hasVideo {
+		return nil, fmt.Errorf("no video stream")
+	}
+	t := &videoTags{fileType: fileType, tags: make(map[string]string)}
+	// Matroska tags are upper case.
+	for name, value := range probe.Format.Tags {
+		t.tags[strings.ToLower(name)] = value
+	}
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		t.duration = time.Duration(seconds * float64(time.Second))
+	}
+	return t, nil
+}
+
+// probeVideo reads the tags of the video at `path` with ffprobe, and makes
+// the poster with ffmpeg if Options.FFmpeg is set.
+func (s *Syncer) probeVideo(ctx context.Context, path string) (*videoTags, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, s.opts.FFprobe, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %v", err)
+	}
+	t, err := parseProbe(out, videoExtensions[strings.ToLower(filepath.Ext(path))])
+	if err != nil {
+		return nil, err
+	}
+	if t.tags["title"] == "" {
+		t.tags["title"] = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if s.opts.FFmpeg == "" {
+		return t, nil
+	}
+	// A frame a tenth into the video, after the titles of most videos.
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.opts.FFmpeg, "-v", "error", "-ss", strconv.FormatFloat((t.duration/10).Seconds(), 'f', 3, 64),
+		"-i", path, "-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", posterWidth), "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Videos play without posters.
+		s.logger.Printf("Failed to make the poster of %s: %v: %s\n", path, err, strings.TrimSpace(stderr.String()))
+		return t, nil
+	}
+	if stdout.Len() > 0 {
+		t.poster = &tag.Picture{Ext: "jpg", MIMEType: "image/jpeg", Type: "Poster", Data: stdout.Bytes()}
+	}
+	return t, nil
+}
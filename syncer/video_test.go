@@ -0,0 +1,32 @@
+package syncer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	data := []byte(`{
+		"streams": [{"codec_type": "video", "disposition": {"attached_pic": 0}}, {"codec_type": "audio"}],
+		"format": {"duration": "215.500000", "tags": {"TITLE": "Song", "ARTIST": "Band", "track": "3/12", "date": "2019-05-01"}}
+	}`)
+	tags, err := parseProbe(data, "MKV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title() != "Song" || tags.Artist() != "Band" || tags.Year() != 2019 || tags.FileType() != "MKV" {
+		t.Errorf("tags = %+v", tags)
+	}
+	if track, total := tags.Track(); track != 3 || total != 12 {
+		t.Errorf("track = %d/%d", track, total)
+	}
+	if tags.duration != 215500*time.Millisecond {
+		t.Errorf("duration = %v", tags.duration)
+	}
+
+	// An M4A with cover art.
+	data = []byte(`{"streams": [{"codec_type": "audio"}, {"codec_type": "video", "disposition": {"attached_pic": 1}}], "format": {}}`)
+	if _, err := parseProbe(data, "MP4"); err == nil {
+		t.Errorf("parseProbe accepted an audio file")
+	}
+}
@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func (s *Syncer) addToWatcherRecursively(watcher *fsnotify.Watcher, path string) error {
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsDir() {
+			s.logger.Printf("Add %s to watcher\n", path)
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// walk sends all regular files under `path` to `ch`.
+func (s *Syncer) walk(ctx context.Context, path string, ch chan<- *piece) {
+	s.progress.update(func(p *Progress) { p.Started = time.Now() })
+	defer s.progress.update(func(p *Progress) { p.ScanDone = true })
+	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			s.logger.Printf("Found: %v\n", path)
+			s.progress.update(func(p *Progress) { p.Discovered++ })
+			select {
+			case ch <- &piece{path: path}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Printf("Error during filepath.Walk: %v\n", err)
+	}
+}
+
+// watch sends the names of the files written under the target directory to
+// `changed`. When the Full option is set, all the existing files are sent to
+// `discovered` first; they are not being updated so they need no debouncing.
+func (s *Syncer) watch(ctx context.Context, discovered chan<- *piece, changed chan<- string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.logger.Printf("Failed to create a Watcher: %v\n", err)
+		return err
+	}
+	defer watcher.Close()
+
+	if s.opts.Full {
+		s.walk(ctx, s.opts.Target, discovered)
+	}
+	err = s.addToWatcherRecursively(watcher, s.opts.Target)
+	if err != nil {
+		s.logger.Printf("Failed to watch %s: %v\n", s.opts.Target, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				continue
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				select {
+				case changed <- event.Name:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		case err := <-watcher.Errors:
+			s.logger.Printf("%v\n", err)
+		}
+	}
+}
+
+// debounce forwards file names from `in` to `out` once they have not been seen
+// for `duration`. We don't want to sync files that are being updated.
+// Receiving from `in` never blocks on `out`: settled names are queued until
+// the next stage accepts them, and a name seen again while queued is kept
+// queued only once.
+func debounce(ctx context.Context, in <-chan string, out chan<- *piece, duration time.Duration) {
+	pending := make(map[string]time.Time)
+	queued := make(map[string]struct{})
+	var ready []string
+	ticker := time.NewTicker(duration)
+	defer ticker.Stop()
+
+	for {
+		// Sending on a nil channel blocks forever, which disables the case.
+		var next chan<- *piece
+		var head *piece
+		if len(ready) > 0 {
+			next = out
+			head = &piece{path: ready[0]}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case filename := <-in:
+			if _, ok := queued[filename]; !ok {
+				pending[filename] = time.Now()
+			}
+		case now := <-ticker.C:
+			for name, timestamp := range pending {
+				if now.Sub(timestamp) >= duration {
+					delete(pending, name)
+					queued[name] = struct{}{}
+					ready = append(ready, name)
+				}
+			}
+		case next <- head:
+			delete(queued, ready[0])
+			ready = ready[1:]
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan string)
+	out := make(chan *piece)
+	duration := 20 * time.Millisecond
+	go debounce(ctx, in, out, duration)
+
+	in <- "a"
+	in <- "b"
+	in <- "a"
+
+	got := make(map[string]int)
+	timeout := time.After(10 * duration)
+	for len(got) < 2 {
+		select {
+		case p := <-out:
+			got[p.path]++
+		case <-timeout:
+			t.Fatalf("timed out: got = %v", got)
+		}
+	}
+	if got["a"] != 1 || got["b"] != 1 {
+		t.Errorf("got = %v", got)
+	}
+
+	select {
+	case p := <-out:
+		t.Errorf("unexpected name: %s", p.path)
+	case <-time.After(3 * duration):
+	}
+}
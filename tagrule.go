@@ -0,0 +1,148 @@
+package benten
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Types of TagRules.
+const (
+	// TagRuleReplace replaces the matches of the regular expression Find in
+	// Field with Replace, which may refer to submatches like $1.
+	TagRuleReplace = "replace"
+	// TagRuleArticle moves the leading article Find ("The" if empty) of Field
+	// to the end: "The Beatles" becomes "Beatles, The".
+	TagRuleArticle = "article"
+	// TagRuleMap replaces Field with Replace if it is Find ignoring case, such
+	// as to map genres.
+	TagRuleMap = "map"
+)
+
+// TagRule is a rewrite of a tag of pieces, such as fixing a misspelled
+// artist.
+type TagRule struct {
+	Type string
+	// Field is the field of Metadata, such as "Artist".
+	Field   string
+	Find    string
+	Replace string
+}
+
+// TagRules are the rules the syncer applies in order to the tags of every
+// piece it syncs, and the tag-rules job to the pieces already synced. They
+// are stored at TagRulesKey.
+type TagRules struct {
+	Rules   []TagRule
+	Updated time.Time
+}
+
+// tagRuleFields are the fields TagRules can rewrite.
+var tagRuleFields = map[string]func(m *Metadata) *string{
+	"Title":       func(m *Metadata) *string { return &m.Title },
+	"Album":       func(m *Metadata) *string { return &m.Album },
+	"Artist":      func(m *Metadata) *string { return &m.Artist },
+	"AlbumArtist": func(m *Metadata) *string { return &m.AlbumArtist },
+	"Composer":    func(m *Metadata) *string { return &m.Composer },
+	"Genre":       func(m *Metadata) *string { return &m.Genre },
+	"Comment":     func(m *Metadata) *string { return &m.Comment },
+}
+
+// TagRulesKey returns the key of the TagRules.
+func TagRulesKey() *datastore.Key {
+	return datastore.NameKey(TagRulesKind, "current", nil)
+}
+
+// LoadTagRules loads the stored TagRules, which are empty if none are stored.
+func LoadTagRules(ctx context.Context, client *datastore.Client) (TagRules, error) {
+	var rules TagRules
+	err := client.Get(ctx, TagRulesKey(), &rules)
+	if err == datastore.ErrNoSuchEntity {
+		return TagRules{}, nil
+	}
+	return rules, err
+}
+
+// Summary describes the rules for AuditLog.
+func (r TagRules) Summary() string {
+	var rules []string
+	for _, rule := range r.Rules {
+		rules = append(rules, fmt.Sprintf("%s %s %q -> %q", rule.Type, rule.Field, rule.Find, rule.Replace))
+	}
+	return strings.Join(rules, "; ")
+}
+
+// TagRewriter applies compiled TagRules. The nil TagRewriter changes nothing.
+type TagRewriter struct {
+	rules []compiledTagRule
+}
+
+type compiledTagRule struct {
+	field func(m *Metadata) *string
+	// re is nil for TagRuleMap.
+	re      *regexp.Regexp
+	find    string
+	replace string
+}
+
+// Compile validates the rules and returns their TagRewriter.
+func (r TagRules) Compile() (*TagRewriter, error) {
+	w := &TagRewriter{}
+	for i, rule := range r.Rules {
+		field, ok := tagRuleFields[rule.Field]
+		if !ok {
+			return nil, fmt.Errorf("rule %d: field (%v) is invalid", i, rule.Field)
+		}
+		c := compiledTagRule{field: field, find: rule.Find, replace: rule.Replace}
+		var err error
+		switch rule.Type {
+		case TagRuleReplace:
+			c.re, err = regexp.Compile(rule.Find)
+		case TagRuleArticle:
+			article := rule.Find
+			if article == "" {
+				article = "The"
+			}
+			c.re, err = regexp.Compile(`^(?i)(` + regexp.QuoteMeta(article) + `) (.+)$`)
+			c.replace = "${2}, ${1}"
+		case TagRuleMap:
+		default:
+			return nil, fmt.Errorf("rule %d: type (%v) is invalid", i, rule.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		w.rules = append(w.rules, c)
+	}
+	return w, nil
+}
+
+// Apply rewrites the tags of `m`, and returns true if any changed.
+func (w *TagRewriter) Apply(m *Metadata) bool {
+	if w == nil {
+		return false
+	}
+	changed := false
+	for _, rule := range w.rules {
+		field := rule.field(m)
+		value := *field
+		if rule.re != nil {
+			value = rule.re.ReplaceAllString(value, rule.replace)
+		} else if strings.EqualFold(value, rule.find) {
+			value = rule.replace
+		}
+		if value != *field {
+			*field = value
+			changed = true
+		}
+	}
+	if changed {
+		m.SetAlbumKey(nil)
+		m.SetNeedsReview()
+	}
+	return changed
+}
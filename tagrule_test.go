@@ -0,0 +1,43 @@
+package benten
+
+import "testing"
+
+func TestTagRules(t *testing.T) {
+	rules := TagRules{Rules: []TagRule{
+		{Type: TagRuleReplace, Field: "Artist", Find: `\s+feat\. .*$`, Replace: ""},
+		{Type: TagRuleArticle, Field: "Artist"},
+		{Type: TagRuleMap, Field: "Genre", Find: "hip hop", Replace: "Hip-Hop"},
+	}}
+	rewriter, err := rules.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Metadata{Artist: "The Roots feat. Erykah Badu", Album: "Things Fall Apart", Genre: "Hip Hop"}
+	if !rewriter.Apply(&m) {
+		t.Errorf("nothing changed")
+	}
+	if m.Artist != "Roots, The" || m.Genre != "Hip-Hop" {
+		t.Errorf("m = %+v", m)
+	}
+	if m.AlbumKey == "" {
+		t.Errorf("the album key is not set")
+	}
+	if rewriter.Apply(&m) {
+		t.Errorf("applied twice: %+v", m)
+	}
+
+	for _, rule := range []TagRule{
+		{Type: TagRuleReplace, Field: "Path", Find: "a"},
+		{Type: TagRuleReplace, Field: "Title", Find: "("},
+		{Type: "delete", Field: "Title"},
+	} {
+		if _, err := (TagRules{Rules: []TagRule{rule}}).Compile(); err == nil {
+			t.Errorf("%+v is compiled", rule)
+		}
+	}
+
+	var nilRewriter *TagRewriter
+	if nilRewriter.Apply(&m) {
+		t.Errorf("the nil rewriter changed %+v", m)
+	}
+}
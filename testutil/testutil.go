@@ -0,0 +1,115 @@
+// Package testutil provides the Cloud Datastore emulator and a fake GCS
+// server for tests which need the cloud services.
+//
+// Datastore tests run against the emulator given by DATASTORE_EMULATOR_HOST,
+// and are skipped when it is not set. Start one with
+//
+//	gcloud beta emulators datastore start --no-store-on-disk
+//	$(gcloud beta emulators datastore env-init)
+//
+// or call StartDatastoreEmulator from TestMain.
+package testutil
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/yutakahirano/benten"
+)
+
+var projectCount int64
+
+// StartDatastoreEmulator starts the Datastore emulator with gcloud and sets
+// DATASTORE_EMULATOR_HOST. The returned function stops the emulator.
+func StartDatastoreEmulator() (func(), error) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") != "" {
+		return func() {}, nil
+	}
+	cmd := exec.Command("gcloud", "beta", "emulators", "datastore", "start", "--no-store-on-disk", "--consistency=1.0")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	hosts := make(chan string, 1)
+	go func() {
+		// The emulator prints "export DATASTORE_EMULATOR_HOST=localhost:8081".
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.Index(line, "DATASTORE_EMULATOR_HOST="); i >= 0 {
+				hosts <- strings.TrimSpace(line[i+len("DATASTORE_EMULATOR_HOST="):])
+				break
+			}
+		}
+		for scanner.Scan() {
+		}
+	}()
+	select {
+	case host := <-hosts:
+		os.Setenv("DATASTORE_EMULATOR_HOST", host)
+		return stop, nil
+	case <-time.After(time.Minute):
+		stop()
+		return nil, fmt.Errorf("the datastore emulator didn't start")
+	}
+}
+
+// Datastore returns a client of the emulator for a project used only by `t`,
+// so that tests don't see each other's entities. It skips `t` when the
+// emulator is not available.
+func Datastore(t *testing.T) *datastore.Client {
+	t.Helper()
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST is not set")
+	}
+	projectID := fmt.Sprintf("test-%d-%d", os.Getpid(), atomic.AddInt64(&projectCount, 1))
+	client, err := datastore.NewClient(context.Background(), projectID)
+	if err != nil {
+		t.Fatalf("Failed to create a datastore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Storage starts a fake GCS server holding `objects` and the buckets used by
+// benten, and returns a client of it. The server stops when `t` finishes.
+func Storage(t *testing.T, objects ...fakestorage.Object) *storage.Client {
+	t.Helper()
+	server := fakestorage.NewServer(objects)
+	server.CreateBucket(benten.AlbumPictureBucket)
+	server.CreateBucket(benten.PieceBucket)
+	t.Cleanup(server.Stop)
+	return server.Client()
+}
+
+// PutPiece puts `metadata` as a piece and its index entity, and returns its key.
+func PutPiece(t *testing.T, client *datastore.Client, metadata benten.Metadata) *datastore.Key {
+	t.Helper()
+	ctx := context.Background()
+	key, err := client.Put(ctx, datastore.IncompleteKey(benten.PieceKind, nil), &metadata)
+	if err != nil {
+		t.Fatalf("Failed to put a piece: %v", err)
+	}
+	if err := benten.SpanIndex(ctx, client, &metadata, key, nil); err != nil {
+		t.Fatalf("Failed to span the index: %v", err)
+	}
+	return key
+}
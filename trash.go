@@ -0,0 +1,193 @@
+package benten
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// TrashRetention is how long trashed pieces are kept before PurgeTrash
+// deletes them.
+var TrashRetention = 30 * 24 * time.Hour
+
+// IsTrashed returns true if the piece has been moved to the trash.
+func (m *Metadata) IsTrashed() bool {
+	return !m.Deleted.IsZero()
+}
+
+// MaxTrashInTransaction is the number of pieces TrashPiecesInTransaction
+// takes, as each of them takes two of the 500 mutations of a commit.
+const MaxTrashInTransaction = 200
+
+// TrashPieceInTransaction moves the piece at `key` to the trash in `tx`, and
+// deletes its index so that it is no longer found. It does nothing if the
+// piece is already trashed.
+func TrashPieceInTransaction(tx *datastore.Transaction, key *datastore.Key) error {
+	return TrashPiecesInTransaction(tx, []*datastore.Key{key})
+}
+
+// TrashPiecesInTransaction is TrashPieceInTransaction for up to
+// MaxTrashInTransaction distinct pieces, read and written in batches.
+func TrashPiecesInTransaction(tx *datastore.Transaction, keys []*datastore.Key) error {
+	if len(keys) > MaxTrashInTransaction {
+		return fmt.Errorf("too many pieces to trash in a transaction (%d > %d)", len(keys), MaxTrashInTransaction)
+	}
+	pieces := make([]Metadata, len(keys))
+	if err := tx.GetMulti(keys, pieces); err != nil {
+		return err
+	}
+	now := time.Now()
+	var trashedKeys, indexKeys []*datastore.Key
+	var trashed []*Metadata
+	for i := range pieces {
+		if pieces[i].IsTrashed() {
+			continue
+		}
+		pieces[i].Deleted = now
+		pieces[i].Updated = now
+		pieces[i].Revision++
+		trashedKeys = append(trashedKeys, keys[i])
+		trashed = append(trashed, &pieces[i])
+		indexKeys = append(indexKeys, IndexKey(keys[i]))
+	}
+	if len(trashedKeys) == 0 {
+		return nil
+	}
+	if _, err := tx.PutMulti(trashedKeys, trashed); err != nil {
+		return err
+	}
+	return tx.DeleteMulti(indexKeys)
+}
+
+// TrashPiece moves the piece at `key` to the trash.
+func TrashPiece(ctx context.Context, client *datastore.Client, key *datastore.Key) error {
+	return TrashPieces(ctx, client, []*datastore.Key{key})
+}
+
+// TrashPieces moves the distinct pieces at `keys` to the trash, in as many
+// transactions as MaxTrashInTransaction needs. The pieces trashed by the
+// transactions committed before a failure stay in the trash.
+func TrashPieces(ctx context.Context, client *datastore.Client, keys []*datastore.Key) error {
+	for i := 0; i < len(keys); i += MaxTrashInTransaction {
+		end := i + MaxTrashInTransaction
+		if end > len(keys) {
+			end = len(keys)
+		}
+		_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			return TrashPiecesInTransaction(tx, keys[i:end])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestorePiece takes the piece at `key` out of the trash and rebuilds its
+// index in the same transaction.
+func RestorePiece(ctx context.Context, client *datastore.Client, key *datastore.Key, aliases Aliases) (*Metadata, error) {
+	var piece Metadata
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		if err := tx.Get(key, &piece); err != nil {
+			return err
+		}
+		if !piece.IsTrashed() {
+			return nil
+		}
+		piece.Deleted = time.Time{}
+		piece.Updated = time.Now()
+		piece.Revision++
+		if _, err := tx.Put(key, &piece); err != nil {
+			return err
+		}
+		return RespanIndexInTransaction(tx, &piece, key, aliases)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &piece, nil
+}
+
+// TrashQuery returns the query for the trashed pieces, oldest first.
+func TrashQuery() *datastore.Query {
+	return datastore.NewQuery(PieceKind).Filter("Deleted >", time.Time{}).Order("Deleted")
+}
+
+// TombstoneRetention is how long Tombstones are kept before PurgeTombstones
+// deletes them. Clients which haven't synced for longer need to list the
+// whole library again.
+var TombstoneRetention = 180 * 24 * time.Hour
+
+// Tombstone records that the piece Key (encoded) was purged, so that
+// incremental clients learn about deletions; see TombstoneKey. Hash and Path
+// are of the deleted piece, for the clients which keep pieces by them.
+type Tombstone struct {
+	Key     string
+	Hash    string
+	Path    string
+	Deleted time.Time
+}
+
+// TombstoneKey returns the key of the Tombstone of the piece at `key`.
+func TombstoneKey(key *datastore.Key) *datastore.Key {
+	return datastore.NameKey(TombstoneKind, key.Encode(), nil)
+}
+
+// PurgeTrash deletes at most `limit` pieces trashed before `before`, leaving
+// their Tombstones, and returns the number of deleted pieces.
+func PurgeTrash(ctx context.Context, client *datastore.Client, before time.Time, limit int) (int, error) {
+	query := datastore.NewQuery(PieceKind).Filter("Deleted >", time.Time{}).Filter("Deleted <", before).Limit(limit)
+	var pieces []Metadata
+	keys, err := client.GetAll(ctx, query, &pieces)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	now := time.Now()
+	tombstoneKeys := make([]*datastore.Key, len(keys))
+	tombstones := make([]Tombstone, len(keys))
+	for i, key := range keys {
+		tombstoneKeys[i] = TombstoneKey(key)
+		tombstones[i] = Tombstone{Key: key.Encode(), Hash: pieces[i].Hash, Path: pieces[i].Path, Deleted: now}
+	}
+	if _, err := client.PutMulti(ctx, tombstoneKeys, tombstones); err != nil {
+		return 0, err
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// PurgeTombstones deletes up to `limit` Tombstones older than `before`, and
+// returns how many it deleted.
+func PurgeTombstones(ctx context.Context, client *datastore.Client, before time.Time, limit int) (int, error) {
+	keys, err := client.GetAll(ctx, datastore.NewQuery(TombstoneKind).Filter("Deleted <", before).KeysOnly().Limit(limit), nil)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// loadUntrashed loads all the pieces not in the trash. The pieces synced
+// before the trash existed lack Deleted, so they are filtered here rather
+// than by the query.
+func loadUntrashed(ctx context.Context, client *datastore.Client) ([]*datastore.Key, []Metadata, error) {
+	var pieces []Metadata
+	keys, err := client.GetAll(ctx, datastore.NewQuery(PieceKind), &pieces)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := 0
+	for i := range pieces {
+		if !pieces[i].IsTrashed() {
+			keys[n], pieces[n] = keys[i], pieces[i]
+			n++
+		}
+	}
+	return keys[:n], pieces[:n], nil
+}
@@ -0,0 +1,124 @@
+package benten_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/yutakahirano/benten"
+	"github.com/yutakahirano/benten/testutil"
+)
+
+func TestTrash(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	key := testutil.PutPiece(t, client, benten.Metadata{Title: "The Wall", Artist: "Pink Floyd", Path: "Pink Floyd/The Wall.flac"})
+	index := benten.DatastoreIndex{Client: client}
+
+	if err := benten.TrashPiece(ctx, client, key); err != nil {
+		t.Fatal(err)
+	}
+	results, err := index.Search(ctx, "wall", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("a trashed piece is found: %v", results)
+	}
+	if n, err := benten.PurgeTrash(ctx, client, time.Now().Add(-time.Hour), 10); err != nil || n != 0 {
+		t.Errorf("purged %d, %v within the retention", n, err)
+	}
+
+	piece, err := benten.RestorePiece(ctx, client, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if piece.IsTrashed() {
+		t.Errorf("the restored piece is trashed")
+	}
+	results, err = index.Search(ctx, "wall", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("results = %v", results)
+	}
+
+	if err := benten.TrashPiece(ctx, client, key); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := benten.PurgeTrash(ctx, client, time.Now().Add(time.Hour), 10); err != nil || n != 1 {
+		t.Errorf("purged %d, %v", n, err)
+	}
+	var tombstone benten.Tombstone
+	if err := client.Get(ctx, benten.TombstoneKey(key), &tombstone); err != nil || tombstone.Key != key.Encode() || tombstone.Path != "Pink Floyd/The Wall.flac" {
+		t.Errorf("tombstone = %v, %v", tombstone, err)
+	}
+	if n, err := benten.PurgeTombstones(ctx, client, time.Now().Add(-time.Hour), 10); err != nil || n != 0 {
+		t.Errorf("purged %d tombstones, %v within the retention", n, err)
+	}
+	if n, err := benten.PurgeTombstones(ctx, client, time.Now().Add(time.Hour), 10); err != nil || n != 1 {
+		t.Errorf("purged %d tombstones, %v", n, err)
+	}
+}
+
+func TestTrashPieces(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	n := benten.MaxTrashInTransaction + 2
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = testutil.PutPiece(t, client, benten.Metadata{Title: fmt.Sprintf("Track %d", i), Path: fmt.Sprintf("Album/%03d.mp3", i)})
+	}
+	if err := benten.TrashPiece(ctx, client, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := benten.TrashPieces(ctx, client, keys); err != nil {
+		t.Fatal(err)
+	}
+	pieces := make([]benten.Metadata, n)
+	if err := client.GetMulti(ctx, keys, pieces); err != nil {
+		t.Fatal(err)
+	}
+	for i, piece := range pieces {
+		if !piece.IsTrashed() {
+			t.Errorf("piece %d is not trashed", i)
+		}
+	}
+	if pieces[0].Revision != pieces[1].Revision {
+		t.Errorf("the trashed piece is trashed again: revision %d != %d", pieces[0].Revision, pieces[1].Revision)
+	}
+	err := client.GetMulti(ctx, []*datastore.Key{benten.IndexKey(keys[1]), benten.IndexKey(keys[n-1])}, make([]benten.PieceIndex, 2))
+	if multi, ok := err.(datastore.MultiError); !ok || multi[0] != datastore.ErrNoSuchEntity || multi[1] != datastore.ErrNoSuchEntity {
+		t.Errorf("the index of the trashed pieces is left: %v", err)
+	}
+}
+
+func TestTrashedPiecesAreNotMatched(t *testing.T) {
+	client := testutil.Datastore(t)
+	ctx := context.Background()
+	metadata := benten.Metadata{Title: "Time", Artist: "Pink Floyd", Album: "The Dark Side of the Moon", Hash: "a", Path: "Pink Floyd/Time.flac"}
+	trashed := testutil.PutPiece(t, client, metadata)
+	if err := benten.TrashPiece(ctx, client, trashed); err != nil {
+		t.Fatal(err)
+	}
+	// The file is synced again.
+	synced := testutil.PutPiece(t, client, metadata)
+
+	groups, err := benten.LoadDuplicates(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("groups = %v", groups)
+	}
+	m, err := benten.LoadPieceMatcher(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := m.ByTags("time", "pink floyd", "the dark side of the moon"); !key.Equal(synced) {
+		t.Errorf("ByTags() = %v, want %v", key, synced)
+	}
+}
@@ -0,0 +1,76 @@
+package benten
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// UploadRequestVersion is the version of UploadRequest this package writes.
+const UploadRequestVersion = 1
+
+// ErrUnsupportedVersion is returned for messages of a version newer than
+// this package knows.
+var ErrUnsupportedVersion = errors.New("unsupported message version")
+
+// UploadRequest is the Pub/Sub message asking the syncer to upload pieces to
+// PieceBucket, encoded as JSON:
+//
+//	{"Version": 1, "Entries": [{"Path": "/music/a.mp3", "Key": "<object name>"}]}
+//
+// A bare array of entries is accepted as version 0, which publishers wrote
+// before versioning.
+type UploadRequest struct {
+	Version int
+	Entries []UploadEntry
+}
+
+// UploadEntry asks to upload a file.
+type UploadEntry struct {
+	// Path is the path of the file on the machine running the syncer.
+	Path string
+	// Key is the name of the object to write.
+	Key string
+}
+
+// NewUploadRequest returns the encoded request to upload `entries`.
+func NewUploadRequest(entries []UploadEntry) ([]byte, error) {
+	r := UploadRequest{Version: UploadRequestVersion, Entries: entries}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r)
+}
+
+// ParseUploadRequest decodes and validates an upload request. It returns
+// ErrUnsupportedVersion for newer versions.
+func ParseUploadRequest(data []byte) (*UploadRequest, error) {
+	var r UploadRequest
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &r.Entries); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Version > UploadRequestVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, r.Version)
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Validate returns an error if `r` is malformed.
+func (r *UploadRequest) Validate() error {
+	if r.Version < 0 {
+		return fmt.Errorf("invalid version: %d", r.Version)
+	}
+	for i, entry := range r.Entries {
+		if entry.Path == "" || entry.Key == "" {
+			return fmt.Errorf("entry %d lacks Path or Key", i)
+		}
+	}
+	return nil
+}
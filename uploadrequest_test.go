@@ -0,0 +1,29 @@
+package benten
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUploadRequest(t *testing.T) {
+	data, err := NewUploadRequest([]UploadEntry{{Path: "/a.mp3", Key: "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseUploadRequest(data)
+	if err != nil || r.Version != UploadRequestVersion || len(r.Entries) != 1 || r.Entries[0].Key != "k" {
+		t.Errorf("r = %+v, err = %v", r, err)
+	}
+
+	r, err = ParseUploadRequest([]byte(`[{"Path": "/a.mp3", "Key": "k"}]`))
+	if err != nil || r.Version != 0 || len(r.Entries) != 1 {
+		t.Errorf("r = %+v, err = %v for version 0", r, err)
+	}
+
+	if _, err := ParseUploadRequest([]byte(`{"Version": 2, "Entries": []}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("err = %v for version 2", err)
+	}
+	if _, err := ParseUploadRequest([]byte(`{"Version": 1, "Entries": [{"Path": "/a.mp3"}]}`)); err == nil {
+		t.Errorf("an entry without Key is accepted")
+	}
+}